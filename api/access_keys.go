@@ -0,0 +1,211 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	accessKeyIDPrefix = "FS"
+	accessKeyIDLength = 18
+	secretKeyLength   = 30
+)
+
+// ListUserAccessKeys godoc
+//
+//	@Summary		List user access keys
+//	@Description	List all S3 access keys of a user
+//	@Tags			access-keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Success		200			{array}	accessKeyInfo
+//	@Failure		400			"empty username"
+//	@Failure		500			"unable to retrieve user access keys"
+//	@Router			/users/{username}/access-keys [get]
+func ListUserAccessKeys(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var accessKeys []db.AccessKey
+	if err := dbConn.Where("username = ?", username).Order("created_at ASC").Find(&accessKeys).Error; err != nil {
+		slog.Error(
+			"unable to retrieve user access keys",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]accessKeyInfo, len(accessKeys))
+	for i, accessKey := range accessKeys {
+		list[i] = accessKeyInfo{
+			AccessKeyID: accessKey.AccessKeyID,
+			CreatedAt:   accessKey.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateUserAccessKey godoc
+//
+//	@Summary		Create user access key
+//	@Description	Create a new S3 access key for a user. The secret access key is only returned once.
+//	@Tags			access-keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string	true	"Username"
+//	@Success		201			{object}	createdAccessKeyResponse
+//	@Failure		400			"empty username"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to create user access key"
+//	@Router			/users/{username}/access-keys [post]
+func CreateUserAccessKey(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if err := dbConn.Where("username = ?", username).First(&db.User{}).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	accessKeyID, secretAccessKey, err := generateAccessKey()
+	if err != nil {
+		slog.Error(
+			"unable to generate access key",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	accessKey := db.AccessKey{
+		AccessKeyID:     accessKeyID,
+		Username:        username,
+		SecretAccessKey: secretAccessKey,
+	}
+
+	if err := dbConn.Create(&accessKey).Error; err != nil {
+		slog.Error(
+			"unable to create user access key",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	viewModel := createdAccessKeyResponse{
+		accessKeyInfo: accessKeyInfo{
+			AccessKeyID: accessKey.AccessKeyID,
+			CreatedAt:   accessKey.CreatedAt.Format(time.RFC3339),
+		},
+		SecretAccessKey: accessKey.SecretAccessKey,
+	}
+
+	c.JSON(http.StatusCreated, viewModel)
+}
+
+// DeleteUserAccessKey godoc
+//
+//	@Summary		Delete user access key
+//	@Description	Delete an S3 access key of a user
+//	@Tags			access-keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			username		path	string	true	"Username"
+//	@Param			access_key_id	path	string	true	"Access key ID"
+//	@Success		204				"access key deleted"
+//	@Failure		400				"empty username or access key ID"
+//	@Failure		404				"access key not found"
+//	@Failure		500				"unable to delete user access key"
+//	@Router			/users/{username}/access-keys/{access_key_id} [delete]
+func DeleteUserAccessKey(c *gin.Context) {
+	username := c.Param("username")
+	accessKeyID := c.Param("access_key_id")
+	if username == "" || accessKeyID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("access_key_id = ? AND username = ?", accessKeyID, username).Delete(&db.AccessKey{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to delete user access key",
+			slog.String("error", result.Error.Error()),
+			slog.String("access_key_id", accessKeyID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// generateAccessKey returns a new pair of access key ID and secret access
+// key in the formats similar to the ones of AWS.
+func generateAccessKey() (string, string, error) {
+	idBytes := make([]byte, accessKeyIDLength)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	id := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(idBytes)
+
+	secretBytes := make([]byte, secretKeyLength)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+
+	return accessKeyIDPrefix + id[:accessKeyIDLength], base64.RawStdEncoding.EncodeToString(secretBytes), nil
+}
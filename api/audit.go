@@ -0,0 +1,130 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// ListAuditEvents godoc
+//
+//	@Summary		List audit events
+//	@Description	Search the file operations requested by users with the latest operation first
+//	@Tags			audit
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	query		string	false	"Username"
+//	@Param			operation	query		string	false	"Operation such as read, write, rename or remove"
+//	@Param			path		query		string	false	"Prefix of paths"
+//	@Param			result		query		string	false	"Either success or failure"
+//	@Param			from		query		string	false	"Earliest time in RFC3339"
+//	@Param			to			query		string	false	"Time before which operations are requested in RFC3339"
+//	@Param			page		query		int		false	"Page number starting from 1"
+//	@Param			page_size	query		int		false	"Number of events in a page (maximum 1000)"
+//	@Success		200			{object}	auditEventList
+//	@Failure		400			"invalid filters"
+//	@Failure		500			"unable to retrieve audit events"
+//	@Router			/audit-events [get]
+func ListAuditEvents(c *gin.Context) {
+	var query auditEventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx := dbConn.Model(&db.AuditEvent{})
+	if query.Username != "" {
+		tx = tx.Where("username = ?", query.Username)
+	}
+	if query.Operation != "" {
+		tx = tx.Where("operation = ?", query.Operation)
+	}
+	if query.Path != "" {
+		tx = tx.Where("path LIKE ?", escapeLike(query.Path)+"%")
+	}
+	if query.Result != "" {
+		tx = tx.Where("result = ?", query.Result)
+	}
+	if query.From != "" {
+		from, err := time.Parse(time.RFC3339, query.From)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		tx = tx.Where("created_at >= ?", from)
+	}
+	if query.To != "" {
+		to, err := time.Parse(time.RFC3339, query.To)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		tx = tx.Where("created_at < ?", to)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		slog.Error(
+			"unable to count audit events",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var events []db.AuditEvent
+	err := tx.
+		Order("created_at DESC, id DESC").
+		Offset((query.Page - 1) * query.PageSize).
+		Limit(query.PageSize).
+		Find(&events).
+		Error
+	if err != nil {
+		slog.Error(
+			"unable to retrieve audit events",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]auditEventInfo, len(events))
+	for i, event := range events {
+		list[i] = auditEventInfo{
+			ID:            event.ID,
+			Username:      event.Username,
+			RemoteAddress: event.RemoteAddress,
+			Protocol:      event.Protocol,
+			Operation:     event.Operation,
+			Path:          event.Path,
+			Target:        event.Target,
+			Bytes:         event.Bytes,
+			Result:        event.Result,
+			Error:         event.Error,
+			CreatedAt:     event.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, auditEventList{
+		Events:   list,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	})
+}
+
+// escapeLike escapes the wildcards of a pattern of LIKE.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
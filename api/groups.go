@@ -0,0 +1,653 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListGroups godoc
+//
+//	@Summary		List groups
+//	@Description	List all groups
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}	groupInfo
+//	@Failure		500	"unable to retrieve groups"
+//	@Router			/groups [get]
+func ListGroups(c *gin.Context) {
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var groups []db.Group
+	if err := dbConn.Order("name ASC").Find(&groups).Error; err != nil {
+		slog.Error(
+			"unable to retrieve groups",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]groupInfo, len(groups))
+	for i, group := range groups {
+		list[i] = toGroupInfo(group)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateGroup godoc
+//
+//	@Summary		Create group
+//	@Description	Create a new group
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		createGroupRequest	true	"Group information"
+//	@Success		201		{object}	groupInfo
+//	@Failure		400		"invalid request"
+//	@Failure		409		"group already exists"
+//	@Failure		500		"unable to create group"
+//	@Router			/groups [post]
+func CreateGroup(c *gin.Context) {
+	var req createGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if exists, ok := recordExists(c, dbConn, &db.Group{}, "name = ?", req.Name); !ok {
+		return
+	} else if exists {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	group := db.Group{
+		Name: req.Name,
+	}
+	if err := dbConn.Create(&group).Error; err != nil {
+		slog.Error(
+			"unable to create group",
+			slog.String("error", err.Error()),
+			slog.String("group", req.Name),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, toGroupInfo(group))
+}
+
+// DeleteGroup godoc
+//
+//	@Summary		Delete group
+//	@Description	Delete a group along with its memberships and upload policy. A group which still owns shared folders cannot be deleted.
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path	string	true	"Group name"
+//	@Success		204		"group deleted"
+//	@Failure		400		"empty group name"
+//	@Failure		404		"group not found"
+//	@Failure		409		"group still owns shared folders"
+//	@Failure		500		"unable to delete group"
+//	@Router			/groups/{group} [delete]
+func DeleteGroup(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if exists, ok := recordExists(c, dbConn, &db.SharedFolder{}, "group_name = ?", groupName); !ok {
+		return
+	} else if exists {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	var rowsAffected int64
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_name = ?", groupName).Delete(&db.GroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_name = ?", groupName).Delete(&db.GroupUploadPolicy{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("name = ?", groupName).Delete(&db.Group{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		slog.Error(
+			"unable to delete group",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroupMembers godoc
+//
+//	@Summary		List group members
+//	@Description	List all members of a group
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path	string	true	"Group name"
+//	@Success		200		{array}	groupMemberInfo
+//	@Failure		400		"empty group name"
+//	@Failure		404		"group not found"
+//	@Failure		500		"unable to retrieve group members"
+//	@Router			/groups/{group}/members [get]
+func ListGroupMembers(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !groupExists(c, dbConn, groupName) {
+		return
+	}
+
+	var members []db.GroupMember
+	if err := dbConn.Where("group_name = ?", groupName).Order("username ASC").Find(&members).Error; err != nil {
+		slog.Error(
+			"unable to retrieve group members",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]groupMemberInfo, len(members))
+	for i, member := range members {
+		list[i] = toGroupMemberInfo(member)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// AddGroupMember godoc
+//
+//	@Summary		Add group member
+//	@Description	Add a user to a group. The shared folders of the group are available to the user in sessions started afterwards.
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path		string					true	"Group name"
+//	@Param			request	body		addGroupMemberRequest	true	"Member information"
+//	@Success		201		{object}	groupMemberInfo
+//	@Failure		400		"empty group name or invalid request"
+//	@Failure		404		"group or user not found"
+//	@Failure		409		"user is a member already"
+//	@Failure		500		"unable to add group member"
+//	@Router			/groups/{group}/members [post]
+func AddGroupMember(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req addGroupMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if req.Access == "" {
+		req.Access = db.GroupAccessReadWrite
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !groupExists(c, dbConn, groupName) {
+		return
+	}
+	if exists, ok := recordExists(c, dbConn, &db.User{}, "username = ?", req.Username); !ok {
+		return
+	} else if !exists {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if exists, ok := recordExists(c, dbConn, &db.GroupMember{}, "group_name = ? AND username = ?", groupName, req.Username); !ok {
+		return
+	} else if exists {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	member := db.GroupMember{
+		GroupName: groupName,
+		Username:  req.Username,
+		Access:    req.Access,
+	}
+	if err := dbConn.Create(&member).Error; err != nil {
+		slog.Error(
+			"unable to add group member",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+			slog.String("username", req.Username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, toGroupMemberInfo(member))
+}
+
+// UpdateGroupMember godoc
+//
+//	@Summary		Update group member
+//	@Description	Change the access of a member to the shared folders of a group. The change applies to sessions started afterwards.
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group		path		string						true	"Group name"
+//	@Param			username	path		string						true	"Username"
+//	@Param			request		body		updateGroupMemberRequest	true	"Member information"
+//	@Success		200			{object}	groupMemberInfo
+//	@Failure		400			"empty group name or username or invalid request"
+//	@Failure		404			"member not found"
+//	@Failure		500			"unable to update group member"
+//	@Router			/groups/{group}/members/{username} [patch]
+func UpdateGroupMember(c *gin.Context) {
+	groupName := c.Param("group")
+	username := c.Param("username")
+	if groupName == "" || username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req updateGroupMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Model(&db.GroupMember{}).
+		Where("group_name = ? AND username = ?", groupName, username).
+		Update("access", req.Access)
+	if result.Error != nil {
+		slog.Error(
+			"unable to update group member",
+			slog.String("error", result.Error.Error()),
+			slog.String("group", groupName),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, groupMemberInfo{
+		Username: username,
+		Access:   req.Access,
+	})
+}
+
+// RemoveGroupMember godoc
+//
+//	@Summary		Remove group member
+//	@Description	Remove a user from a group
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group		path	string	true	"Group name"
+//	@Param			username	path	string	true	"Username"
+//	@Success		204			"member removed"
+//	@Failure		400			"empty group name or username"
+//	@Failure		404			"member not found"
+//	@Failure		500			"unable to remove group member"
+//	@Router			/groups/{group}/members/{username} [delete]
+func RemoveGroupMember(c *gin.Context) {
+	groupName := c.Param("group")
+	username := c.Param("username")
+	if groupName == "" || username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("group_name = ? AND username = ?", groupName, username).Delete(&db.GroupMember{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to remove group member",
+			slog.String("error", result.Error.Error()),
+			slog.String("group", groupName),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListSharedFolders godoc
+//
+//	@Summary		List shared folders
+//	@Description	List all shared folders owned by a group
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path	string	true	"Group name"
+//	@Success		200		{array}	sharedFolderInfo
+//	@Failure		400		"empty group name"
+//	@Failure		404		"group not found"
+//	@Failure		500		"unable to retrieve shared folders"
+//	@Router			/groups/{group}/folders [get]
+func ListSharedFolders(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !groupExists(c, dbConn, groupName) {
+		return
+	}
+
+	var folders []db.SharedFolder
+	if err := dbConn.Where("group_name = ?", groupName).Order("name ASC").Find(&folders).Error; err != nil {
+		slog.Error(
+			"unable to retrieve shared folders",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]sharedFolderInfo, len(folders))
+	for i, folder := range folders {
+		list[i] = toSharedFolderInfo(folder)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateSharedFolder godoc
+//
+//	@Summary		Create shared folder
+//	@Description	Create a shared folder owned by a group. The folder is mounted at /shared/{name} for the members of the group.
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path		string						true	"Group name"
+//	@Param			request	body		createSharedFolderRequest	true	"Shared folder information"
+//	@Success		201		{object}	sharedFolderInfo
+//	@Failure		400		"empty group name or invalid request"
+//	@Failure		404		"group not found"
+//	@Failure		409		"shared folder already exists"
+//	@Failure		500		"unable to create shared folder"
+//	@Router			/groups/{group}/folders [post]
+func CreateSharedFolder(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req createSharedFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if err := storage.ValidateSharedFolderName(req.Name); err != nil {
+		slog.Warn(
+			"invalid shared folder name",
+			slog.String("name", req.Name),
+		)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	pathUsersDirectory := c.GetString("users_directory")
+	if pathUsersDirectory == "" {
+		slog.Error("unable to retrieve users directory")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !groupExists(c, dbConn, groupName) {
+		return
+	}
+	if exists, ok := recordExists(c, dbConn, &db.SharedFolder{}, "name = ?", req.Name); !ok {
+		return
+	} else if exists {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	if err := storage.CreateSharedFolder(pathUsersDirectory, req.Name); err != nil {
+		slog.Error(
+			"unable to create directory of shared folder",
+			slog.String("error", err.Error()),
+			slog.String("name", req.Name),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	folder := db.SharedFolder{
+		Name:      req.Name,
+		GroupName: groupName,
+	}
+	if err := dbConn.Create(&folder).Error; err != nil {
+		slog.Error(
+			"unable to create shared folder",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+			slog.String("name", req.Name),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, toSharedFolderInfo(folder))
+}
+
+// DeleteSharedFolder godoc
+//
+//	@Summary		Delete shared folder
+//	@Description	Delete a shared folder of a group along with its files
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path	string	true	"Group name"
+//	@Param			folder	path	string	true	"Shared folder name"
+//	@Success		204		"shared folder deleted"
+//	@Failure		400		"empty group name or shared folder name"
+//	@Failure		404		"shared folder not found"
+//	@Failure		500		"unable to delete shared folder"
+//	@Router			/groups/{group}/folders/{folder} [delete]
+func DeleteSharedFolder(c *gin.Context) {
+	groupName := c.Param("group")
+	folderName := c.Param("folder")
+	if groupName == "" || folderName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	pathUsersDirectory := c.GetString("users_directory")
+	if pathUsersDirectory == "" {
+		slog.Error("unable to retrieve users directory")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("name = ? AND group_name = ?", folderName, groupName).Delete(&db.SharedFolder{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to delete shared folder",
+			slog.String("error", result.Error.Error()),
+			slog.String("group", groupName),
+			slog.String("name", folderName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if err := storage.RemoveSharedFolder(pathUsersDirectory, folderName); err != nil {
+		slog.Error(
+			"unable to remove directory of shared folder",
+			slog.String("error", err.Error()),
+			slog.String("name", folderName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// groupExists checks if the group exists. In case the group does not exist
+// or it cannot be checked, the response is written and false is returned.
+func groupExists(c *gin.Context, dbConn *gorm.DB, groupName string) bool {
+	exists, ok := recordExists(c, dbConn, &db.Group{}, "name = ?", groupName)
+	if !ok {
+		return false
+	}
+	if !exists {
+		c.Status(http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// recordExists checks if a record matching the condition exists. In case of
+// failure, the response is written and false is returned as the second
+// value.
+func recordExists(c *gin.Context, dbConn *gorm.DB, model any, query string, args ...any) (bool, bool) {
+	var count int64
+	if err := dbConn.Model(model).Where(query, args...).Count(&count).Error; err != nil {
+		slog.Error(
+			"unable to retrieve record",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return false, false
+	}
+	return count > 0, true
+}
+
+func toGroupInfo(group db.Group) groupInfo {
+	return groupInfo{
+		Name:      group.Name,
+		CreatedAt: group.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func toGroupMemberInfo(member db.GroupMember) groupMemberInfo {
+	return groupMemberInfo{
+		Username: member.Username,
+		Access:   member.Access,
+	}
+}
+
+func toSharedFolderInfo(folder db.SharedFolder) sharedFolderInfo {
+	return sharedFolderInfo{
+		Name:      folder.Name,
+		Path:      path.Join(storage.SharedDirectory, folder.Name),
+		CreatedAt: folder.CreatedAt.Format(time.RFC3339),
+	}
+}
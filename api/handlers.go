@@ -7,6 +7,10 @@ import (
 	"time"
 
 	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/alexhokl/file-server/throttle"
+	"github.com/alexhokl/file-server/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/gliderlabs/ssh"
 	"gorm.io/gorm"
@@ -43,13 +47,13 @@ func ListUsers(c *gin.Context) {
 // CreateUser godoc
 //
 //	@Summary		Create user
-//	@Description	Create a new user
+//	@Description	Create a new user along with the home directory of the user provisioned from the skeleton directory of the server
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		createUserRequest	true	"User information"
 //	@Success		201		{object}	createdUserResponse
-//	@Failure		400		"empty username"
+//	@Failure		400		"empty or invalid username"
 //	@Failure		409		"username already exists"
 //	@Failure		500		"unable to create user"
 //	@Router			/users [post]
@@ -59,6 +63,14 @@ func CreateUser(c *gin.Context) {
 		c.Status(http.StatusBadRequest)
 		return
 	}
+	if err := storage.ValidateUsername(req.Username); err != nil {
+		slog.Warn(
+			"invalid username",
+			slog.String("username", req.Username),
+		)
+		c.Status(http.StatusBadRequest)
+		return
+	}
 
 	dbConn, ok := getDatabaseConnectionFromContext(c)
 	if !ok {
@@ -67,11 +79,54 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	pathUsersDirectory := c.GetString("users_directory")
+
+	if req.AccessMode == "" {
+		req.AccessMode = db.AccessModeReadWrite
+	}
+	if req.StorageBackend != "" {
+		if err := storage.ValidateBackend(req.StorageBackend); err != nil {
+			slog.Warn(
+				"invalid storage backend",
+				slog.String("error", err.Error()),
+				slog.String("username", req.Username),
+			)
+			c.Status(http.StatusBadRequest)
+			return
+		}
+	}
+
 	user := db.User{
-		Username: req.Username,
+		Username:          req.Username,
+		AccessMode:        req.AccessMode,
+		QuotaBytes:        req.QuotaBytes,
+		QuotaFiles:        req.QuotaFiles,
+		StorageBackend:    req.StorageBackend,
+		UploadRateLimit:   req.UploadRateLimit,
+		DownloadRateLimit: req.DownloadRateLimit,
 	}
 
-	if err := dbConn.Create(&user).Error; err != nil {
+	var deletedHome *db.DeletedHome
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		// the home directory of a user deleted earlier with the same username
+		// is taken along with its data key before a data key is created
+		var err error
+		if deletedHome, err = storage.TakeDeletedHome(tx, user.Username); err != nil {
+			return err
+		}
+		// files of the user are encrypted only if the user is created with
+		// encryption enabled
+		if storage.EncryptionEnabled() {
+			if err := storage.CreateDataKey(tx, user.Username); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		if err == gorm.ErrDuplicatedKey {
 			c.Status(http.StatusConflict)
 			return
@@ -86,23 +141,228 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	// the home directory is changed only once the user is created as the
+	// changes cannot be rolled back, and the user is removed again if the
+	// home directory cannot be prepared
+	if err := prepareHome(dbConn, pathUsersDirectory, user.Username, deletedHome); err != nil {
+		slog.Error(
+			"unable to prepare home directory",
+			slog.String("error", err.Error()),
+			slog.String("username", user.Username),
+		)
+		if err := undoCreateUser(dbConn, user.Username, deletedHome); err != nil {
+			slog.Error(
+				"unable to remove user created",
+				slog.String("error", err.Error()),
+				slog.String("username", user.Username),
+			)
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	err = webhook.Enqueue(dbConn, webhook.EventUserCreated, webhook.UserData{
+		Username:   user.Username,
+		AccessMode: user.AccessMode,
+	})
+	if err != nil {
+		slog.Error(
+			"unable to queue event of user created",
+			slog.String("error", err.Error()),
+			slog.String("username", user.Username),
+		)
+	}
+
 	viewModel := createdUserResponse{
-		Username: user.Username,
+		Username:   user.Username,
+		AccessMode: user.AccessMode,
 	}
 
 	c.JSON(http.StatusCreated, viewModel)
 }
 
+// prepareHome purges the home directory of a user deleted earlier with the
+// same username, if any, and provisions the home directory of a user
+// created.
+func prepareHome(dbConn *gorm.DB, pathUsersDirectory string, username string, deletedHome *db.DeletedHome) error {
+	if deletedHome != nil {
+		if err := storage.PurgeHome(pathUsersDirectory, *deletedHome); err != nil {
+			return err
+		}
+	}
+	return storage.ProvisionHome(dbConn, pathUsersDirectory, username)
+}
+
+// undoCreateUser removes a user created whose home directory cannot be
+// prepared. The data key is kept, like the data key of a user deleted, as it
+// may be the data key of a home directory kept. The home directory of a user
+// deleted earlier which was taken is recorded again to be purged in the
+// background along with the new data key as it may be partly purged.
+func undoCreateUser(dbConn *gorm.DB, username string, deletedHome *db.DeletedHome) error {
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("username = ?", username).Delete(&db.User{}).Error; err != nil {
+			return err
+		}
+		if deletedHome == nil {
+			return nil
+		}
+		deletedHome.PurgeAt = time.Now().UTC()
+		return tx.Create(deletedHome).Error
+	})
+}
+
+// GetUser godoc
+//
+//	@Summary		Get user
+//	@Description	Get the settings of a user along with the storage used by the user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string	true	"Username"
+//	@Success		200			{object}	userInfo
+//	@Failure		400			"empty username"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to retrieve user"
+//	@Router			/users/{username} [get]
+func GetUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var user db.User
+	if err := dbConn.Where("username = ?", username).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	writeUserInfo(c, user)
+}
+
+// UpdateUser godoc
+//
+//	@Summary		Update user
+//	@Description	Update the settings of a user. Only the fields specified are changed and the changes apply to sessions started afterwards except the bandwidth limits which apply to the sessions in progress as well.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Param			request		body		updateUserRequest	true	"User settings"
+//	@Success		200			{object}	userInfo
+//	@Failure		400			"empty username or invalid request"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to update user"
+//	@Router			/users/{username} [patch]
+func UpdateUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req updateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var user db.User
+	if err := dbConn.Where("username = ?", username).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if req.AccessMode != nil {
+		user.AccessMode = *req.AccessMode
+	}
+	if req.QuotaBytes != nil {
+		user.QuotaBytes = toQuotaLimit(*req.QuotaBytes)
+	}
+	if req.QuotaFiles != nil {
+		user.QuotaFiles = toQuotaLimit(*req.QuotaFiles)
+	}
+	if req.StorageBackend != nil {
+		if *req.StorageBackend != "" {
+			if err := storage.ValidateBackend(*req.StorageBackend); err != nil {
+				slog.Warn(
+					"invalid storage backend",
+					slog.String("error", err.Error()),
+					slog.String("username", username),
+				)
+				c.Status(http.StatusBadRequest)
+				return
+			}
+		}
+		user.StorageBackend = *req.StorageBackend
+	}
+	if req.UploadRateLimit != nil {
+		user.UploadRateLimit = *req.UploadRateLimit
+	}
+	if req.DownloadRateLimit != nil {
+		user.DownloadRateLimit = *req.DownloadRateLimit
+	}
+
+	if err := dbConn.Save(&user).Error; err != nil {
+		slog.Error(
+			"unable to update user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	throttle.SetUserLimits(user.Username, throttle.UserLimits(user))
+
+	writeUserInfo(c, user)
+}
+
 // DeleteUser godoc
 //
 //	@Summary		Delete user
-//	@Description	Delete a user
+//	@Description	Delete a user along with the credentials, tokens, access keys, shares, group memberships and upload policy of the user, and close the active sessions of the user. The home directory of the user is archived to a gzipped tarball in the archive directory of the server and purged after the grace period of the server (default), purged after the grace period, or kept. The data key of an encrypted user is deleted when the home directory is purged.
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
 //	@Param			username	path	string	true	"Username"
+//	@Param			home		query	string	false	"What to do with the home directory: archive (default), purge or keep"
 //	@Success		204			"user deleted"
-//	@Failure		400			"empty username"
+//	@Failure		400			"empty username or invalid home option"
 //	@Failure		404			"user not found"
 //	@Failure		500			"unable to delete user"
 //	@Router			/users/{username} [delete]
@@ -113,6 +373,12 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	var query deleteUserQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	dbConn, ok := getDatabaseConnectionFromContext(c)
 	if !ok {
 		slog.Error("unable to retrieve database connection")
@@ -120,12 +386,81 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := dbConn.Where("username = ?", username).Delete(&db.User{}).Error; err != nil {
+	var user db.User
+	if err := dbConn.Where("username = ?", username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.Status(http.StatusNotFound)
 			return
 		}
 
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// the home directory is archived while the user still exists as the data
+	// key of the user is needed
+	pathUsersDirectory := c.GetString("users_directory")
+	if query.Home == homeArchive {
+		pathArchive, err := storage.ArchiveHome(dbConn, pathUsersDirectory, username)
+		if err != nil {
+			slog.Error(
+				"unable to archive home directory",
+				slog.String("error", err.Error()),
+				slog.String("username", username),
+			)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		slog.Info(
+			"home directory archived",
+			slog.String("username", username),
+			slog.String("archive", pathArchive),
+		)
+	}
+
+	var purgeAt time.Time
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		shares := tx.Model(&db.Share{}).Select("id").Where("username = ?", username)
+		if err := tx.Where("share_id IN (?)", shares).Delete(&db.ShareAccess{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []any{
+			&db.Share{},
+			&db.UserCredential{},
+			&db.UserToken{},
+			&db.AccessKey{},
+			&db.GroupMember{},
+			&db.UserUploadPolicy{},
+		} {
+			if err := tx.Where("username = ?", username).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		// the data key is kept until the home directory is purged so that a
+		// home directory kept, or waiting for the purge, remains readable
+		if query.Home != homeKeep {
+			var err error
+			if purgeAt, err = storage.ScheduleHomePurge(tx, user); err != nil {
+				return err
+			}
+		}
+		result := tx.Where("username = ?", username).Delete(&db.User{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			// the user is deleted by another request in the meantime
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		slog.Error(
 			"unable to delete user",
 			slog.String("error", err.Error()),
@@ -134,6 +469,34 @@ func DeleteUser(c *gin.Context) {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	throttle.RemoveUserLimits(username)
+
+	count, err := session.CloseUser(username)
+	if err != nil {
+		slog.Error(
+			"unable to close sessions of user deleted",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+	}
+	slog.Info(
+		"user deleted",
+		slog.String("username", username),
+		slog.String("home", query.Home),
+		slog.Int("sessions_closed", count),
+	)
+
+	// the purge is retried later in the background if it fails as the user
+	// is deleted already
+	if query.Home != homeKeep && !purgeAt.After(time.Now()) {
+		if err := storage.PurgeDeletedHome(dbConn, pathUsersDirectory, username); err != nil {
+			slog.Error(
+				"unable to purge home directory",
+				slog.String("error", err.Error()),
+				slog.String("username", username),
+			)
+		}
+	}
 
 	c.Status(http.StatusNoContent)
 }
@@ -258,7 +621,17 @@ func CreateUserCredential(c *gin.Context) {
 		PublicKey: req.PublicKey,
 	}
 
-	if err := dbConn.Create(&credential).Error; err != nil {
+	err = dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&credential).Error; err != nil {
+			return err
+		}
+		return webhook.Enqueue(tx, webhook.EventCredentialAdded, webhook.CredentialData{
+			Username:     credential.Username,
+			CredentialID: credential.ID,
+			PublicKey:    credential.PublicKey,
+		})
+	})
+	if err != nil {
 		if err == gorm.ErrDuplicatedKey {
 			c.Status(http.StatusConflict)
 			return
@@ -327,3 +700,46 @@ func DeleteUserCredential(c *gin.Context) {
 		return
 	}
 }
+
+// writeUserInfo responds with the settings of the user along with the
+// storage used by the user.
+func writeUserInfo(c *gin.Context, user db.User) {
+	fileSystem, ok := getUserFileSystem(c, user.Username)
+	if !ok {
+		return
+	}
+	usage, err := fileSystem.QuotaUsage()
+	if err != nil {
+		slog.Error(
+			"unable to calculate usage of user",
+			slog.String("error", err.Error()),
+			slog.String("username", user.Username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	quota := fileSystem.Quota()
+	c.JSON(http.StatusOK, userInfo{
+		Username:          user.Username,
+		AccessMode:        user.AccessMode,
+		QuotaBytes:        quota.Bytes,
+		QuotaFiles:        quota.Files,
+		UsedBytes:         usage.Bytes,
+		UsedFiles:         usage.Files,
+		StorageBackend:    storage.UserBackend(user),
+		Encrypted:         fileSystem.Encrypted(),
+		UploadRateLimit:   user.UploadRateLimit,
+		DownloadRateLimit: user.DownloadRateLimit,
+	})
+}
+
+// toQuotaLimit returns the limit of a quota to be stored where a negative
+// value removes the limit of the user so that the default of the server
+// applies.
+func toQuotaLimit(value int64) *int64 {
+	if value < 0 {
+		return nil
+	}
+	return &value
+}
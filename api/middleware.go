@@ -3,7 +3,11 @@ package api
 import (
 	"log/slog"
 	"net/http"
+	"strings"
 
+	"github.com/alexhokl/file-server/audit"
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
 	"github.com/alexhokl/helper/database"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -53,3 +57,111 @@ func isAdmin(c *gin.Context) bool {
 	//TODO: implement
 	return true
 }
+
+func withUsersDirectory(pathUsersDirectory string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("users_directory", pathUsersDirectory)
+		c.Next()
+	}
+}
+
+func withAuditLogger(auditLogger *audit.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("audit_logger", auditLogger)
+		c.Next()
+	}
+}
+
+func getAuditLoggerFromContext(c *gin.Context) (*audit.Logger, bool) {
+	auditLogger, ok := c.Get("audit_logger")
+	if !ok {
+		return nil, false
+	}
+	logger, ok := auditLogger.(*audit.Logger)
+	return logger, ok
+}
+
+// getUserFileSystem returns the file system of the specified user with the
+// access mode of the user applied. In case of failure, the response is
+// written and false is returned.
+func getUserFileSystem(c *gin.Context, username string) (*storage.FileSystem, bool) {
+	pathUsersDirectory := c.GetString("users_directory")
+	if pathUsersDirectory == "" {
+		slog.Error("unable to retrieve users directory")
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	fileSystem, err := storage.OpenUserFileSystem(dbConn, pathUsersDirectory, username)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return nil, false
+		}
+
+		slog.Error(
+			"unable to get file system of user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return fileSystem, true
+}
+
+// requiredUserAuthentication authenticates a user with an access token of
+// the user. The token is accepted either as the password of HTTP basic
+// authentication or as a bearer token.
+func requiredUserAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dbConn, ok := getDatabaseConnectionFromContext(c)
+		if !ok {
+			slog.Error("unable to retrieve database connection")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		username, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			username = ""
+			secret, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if secret == "" {
+			c.Header("WWW-Authenticate", `Basic realm="file-server"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		token, err := db.FindUserToken(dbConn, username, secret)
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				slog.Error(
+					"unable to retrieve user token",
+					slog.String("error", err.Error()),
+				)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			slog.Warn(
+				"invalid user token",
+				slog.String("username", username),
+				slog.String("remote", c.ClientIP()),
+			)
+			c.Header("WWW-Authenticate", `Basic realm="file-server"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set("username", token.Username)
+		c.Next()
+	}
+}
@@ -3,6 +3,44 @@ package api
 type createUserRequest struct {
 	// Username is the username of the user
 	Username string `json:"username" binding:"required" example:"alice"`
+
+	// AccessMode is either read-write (default), read-only (download only) or write-only (upload drop box)
+	AccessMode string `json:"access_mode" binding:"omitempty,oneof=read-write read-only write-only" example:"read-write"`
+
+	// QuotaBytes is the maximum total size of files where zero means unlimited and the default of the server applies if it is not specified
+	QuotaBytes *int64 `json:"quota_bytes" binding:"omitempty,min=0" example:"10737418240"`
+
+	// QuotaFiles is the maximum number of files and directories where zero means unlimited and the default of the server applies if it is not specified
+	QuotaFiles *int64 `json:"quota_files" binding:"omitempty,min=0" example:"100000"`
+
+	// StorageBackend is where the files are stored and it is either local or s3 where the default of the server applies if it is not specified
+	StorageBackend string `json:"storage_backend" binding:"omitempty,oneof=local s3" example:"local"`
+
+	// UploadRateLimit is the maximum bytes per second of uploads of all SFTP sessions of the user where zero means unlimited
+	UploadRateLimit int64 `json:"upload_rate_limit" binding:"omitempty,min=0" example:"1048576"`
+
+	// DownloadRateLimit is the maximum bytes per second of downloads of all SFTP sessions of the user where zero means unlimited
+	DownloadRateLimit int64 `json:"download_rate_limit" binding:"omitempty,min=0" example:"5242880"`
+}
+
+type updateUserRequest struct {
+	// AccessMode is either read-write, read-only (download only) or write-only (upload drop box)
+	AccessMode *string `json:"access_mode" binding:"omitempty,oneof=read-write read-only write-only" example:"read-only"`
+
+	// QuotaBytes is the maximum total size of files where zero means unlimited and -1 restores the default of the server
+	QuotaBytes *int64 `json:"quota_bytes" binding:"omitempty,min=-1" example:"10737418240"`
+
+	// QuotaFiles is the maximum number of files and directories where zero means unlimited and -1 restores the default of the server
+	QuotaFiles *int64 `json:"quota_files" binding:"omitempty,min=-1" example:"100000"`
+
+	// StorageBackend is either local or s3 where an empty value restores the default of the server and existing files are not moved
+	StorageBackend *string `json:"storage_backend" binding:"omitempty,oneof='' local s3" example:"s3"`
+
+	// UploadRateLimit is the maximum bytes per second of uploads where zero means unlimited and it applies to the sessions in progress
+	UploadRateLimit *int64 `json:"upload_rate_limit" binding:"omitempty,min=0" example:"1048576"`
+
+	// DownloadRateLimit is the maximum bytes per second of downloads where zero means unlimited and it applies to the sessions in progress
+	DownloadRateLimit *int64 `json:"download_rate_limit" binding:"omitempty,min=0" example:"5242880"`
 }
 
 type createUserCredentialRequest struct {
@@ -24,9 +62,56 @@ type createUserCredentialResponse struct {
 	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
 }
 
+// ways of handling the home directory of a user deleted
+const (
+	homeArchive = "archive"
+	homePurge   = "purge"
+	homeKeep    = "keep"
+)
+
+type deleteUserQuery struct {
+	// Home is what to do with the home directory of the user, either archive (default), purge or keep
+	Home string `form:"home,default=archive" binding:"oneof=archive purge keep" example:"archive"`
+}
+
 type createdUserResponse struct {
 	// Username is the username of the user
 	Username string `json:"username" example:"alice"`
+
+	// AccessMode is either read-write, read-only or write-only
+	AccessMode string `json:"access_mode" example:"read-write"`
+}
+
+type userInfo struct {
+	// Username is the username of the user
+	Username string `json:"username" example:"alice"`
+
+	// AccessMode is either read-write, read-only or write-only
+	AccessMode string `json:"access_mode" example:"read-write"`
+
+	// QuotaBytes is the maximum total size of files applied to the user where zero means unlimited
+	QuotaBytes int64 `json:"quota_bytes" example:"10737418240"`
+
+	// QuotaFiles is the maximum number of files and directories applied to the user where zero means unlimited
+	QuotaFiles int64 `json:"quota_files" example:"100000"`
+
+	// UsedBytes is the total size of the files of the user
+	UsedBytes int64 `json:"used_bytes" example:"52428800"`
+
+	// UsedFiles is the number of files and directories of the user
+	UsedFiles int64 `json:"used_files" example:"120"`
+
+	// StorageBackend is where the files of the user are stored
+	StorageBackend string `json:"storage_backend" example:"local"`
+
+	// Encrypted is whether the content of the files of the user is encrypted at rest
+	Encrypted bool `json:"encrypted" example:"true"`
+
+	// UploadRateLimit is the maximum bytes per second of uploads where zero means unlimited
+	UploadRateLimit int64 `json:"upload_rate_limit" example:"1048576"`
+
+	// DownloadRateLimit is the maximum bytes per second of downloads where zero means unlimited
+	DownloadRateLimit int64 `json:"download_rate_limit" example:"5242880"`
 }
 
 type credentialInfo struct {
@@ -36,3 +121,479 @@ type credentialInfo struct {
 	// PublicKey is the public key of the user
 	PublicKey string `json:"public_key" example:"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"`
 }
+
+type createShareRequest struct {
+	// Path is the path of the file or directory to be shared, relative to the home directory of the user
+	Path string `json:"path" binding:"required" example:"/reports/2024.pdf"`
+
+	// Mode is either read-only (downloads) or upload-only (drop box of a directory)
+	Mode string `json:"mode" binding:"omitempty,oneof=read-only upload-only" example:"read-only"`
+
+	// ExpiresInSeconds is the number of seconds before the share expires
+	ExpiresInSeconds int `json:"expires_in_seconds" binding:"required,gt=0" example:"86400"`
+
+	// MaxDownloads is the maximum number of downloads allowed and 0 means unlimited
+	MaxDownloads int `json:"max_downloads" binding:"gte=0" example:"3"`
+
+	// Password is the optional password required to access the share
+	Password string `json:"password" example:"s3cret"`
+}
+
+type shareInfo struct {
+	// ID is the ID of the share
+	ID uint `json:"id" example:"10"`
+
+	// Path is the path of the shared file or directory
+	Path string `json:"path" example:"/reports/2024.pdf"`
+
+	// Mode is either read-only or upload-only
+	Mode string `json:"mode" example:"read-only"`
+
+	// ExpiresAt is the time when the share expires and it has the format of RFC3339
+	ExpiresAt string `json:"expires_at" example:"2024-01-02T00:00:00Z"`
+
+	// MaxDownloads is the maximum number of downloads allowed and 0 means unlimited
+	MaxDownloads int `json:"max_downloads" example:"3"`
+
+	// DownloadCount is the number of downloads so far
+	DownloadCount int `json:"download_count" example:"1"`
+
+	// HasPassword indicates whether a password is required to access the share
+	HasPassword bool `json:"has_password" example:"true"`
+
+	// CreatedAt is the time when the share is created and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type createdShareResponse struct {
+	shareInfo
+
+	// Token is the secret token of the share and it is not retrievable afterwards
+	Token string `json:"token" example:"kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"`
+
+	// URL is the path of the share link relative to the API server
+	URL string `json:"url" example:"/s/kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"`
+}
+
+type shareAccessInfo struct {
+	// ID is the ID of the access record
+	ID uint `json:"id" example:"10"`
+
+	// RemoteAddress is the IP address of the client
+	RemoteAddress string `json:"remote_address" example:"203.0.113.10"`
+
+	// Operation is either access, download or upload
+	Operation string `json:"operation" example:"download"`
+
+	// Path is the path relative to the share
+	Path string `json:"path" example:"/"`
+
+	// StatusCode is the HTTP status code of the response
+	StatusCode int `json:"status_code" example:"200"`
+
+	// CreatedAt is the time of the access and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type sharedEntry struct {
+	// Name is the name of the file or directory
+	Name string `json:"name" example:"2024.pdf"`
+
+	// Size is the size of the file in bytes
+	Size int64 `json:"size" example:"1024"`
+
+	// IsDirectory indicates whether the entry is a directory
+	IsDirectory bool `json:"is_directory" example:"false"`
+
+	// ModifiedAt is the time of last modification and it has the format of RFC3339
+	ModifiedAt string `json:"modified_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type createUserTokenRequest struct {
+	// Name is a description of the token
+	Name string `json:"name" binding:"required" example:"laptop"`
+
+	// ExpiresInSeconds is the number of seconds before the token expires and 0 means never
+	ExpiresInSeconds int `json:"expires_in_seconds" binding:"gte=0" example:"2592000"`
+}
+
+type tokenInfo struct {
+	// ID is the ID of the token
+	ID uint `json:"id" example:"10"`
+
+	// Name is a description of the token
+	Name string `json:"name" example:"laptop"`
+
+	// ExpiresAt is the time when the token expires and it has the format of RFC3339; it is empty if the token never expires
+	ExpiresAt string `json:"expires_at" example:"2024-02-01T00:00:00Z"`
+
+	// CreatedAt is the time when the token is created and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type createdUserTokenResponse struct {
+	tokenInfo
+
+	// Token is the secret of the token and it is not retrievable afterwards
+	Token string `json:"token" example:"kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"`
+}
+
+type accessKeyInfo struct {
+	// AccessKeyID is the ID of the S3 access key
+	AccessKeyID string `json:"access_key_id" example:"FSQ2M5XK7VJ4N3B6C8D2E"`
+
+	// CreatedAt is the time when the access key is created and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type createdAccessKeyResponse struct {
+	accessKeyInfo
+
+	// SecretAccessKey is the secret of the access key and it is not retrievable afterwards
+	SecretAccessKey string `json:"secret_access_key" example:"wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"`
+}
+
+type createGroupRequest struct {
+	// Name is the name of the group
+	Name string `json:"name" binding:"required" example:"engineering"`
+}
+
+type groupInfo struct {
+	// Name is the name of the group
+	Name string `json:"name" example:"engineering"`
+
+	// CreatedAt is the time when the group is created and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type addGroupMemberRequest struct {
+	// Username is the username of the user to be added to the group
+	Username string `json:"username" binding:"required" example:"alice"`
+
+	// Access is either read-only or read-write (default) and it applies to all the shared folders of the group
+	Access string `json:"access" binding:"omitempty,oneof=read-only read-write" example:"read-write"`
+}
+
+type updateGroupMemberRequest struct {
+	// Access is either read-only or read-write and it applies to all the shared folders of the group
+	Access string `json:"access" binding:"required,oneof=read-only read-write" example:"read-only"`
+}
+
+type groupMemberInfo struct {
+	// Username is the username of the member
+	Username string `json:"username" example:"alice"`
+
+	// Access is either read-only or read-write
+	Access string `json:"access" example:"read-write"`
+}
+
+type createSharedFolderRequest struct {
+	// Name is the name of the shared folder and it is unique across groups
+	Name string `json:"name" binding:"required" example:"project-x"`
+}
+
+type sharedFolderInfo struct {
+	// Name is the name of the shared folder
+	Name string `json:"name" example:"project-x"`
+
+	// Path is the virtual path where the shared folder is mounted for the members of the group
+	Path string `json:"path" example:"/shared/project-x"`
+
+	// CreatedAt is the time when the shared folder is created and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type versionQuery struct {
+	// Path is the path of the file
+	Path string `form:"path" binding:"required" example:"/reports/2024.pdf"`
+}
+
+type listVersionsQuery struct {
+	// Path is the path of the file where versions of all files are listed if it is not specified
+	Path string `form:"path" example:"/reports/2024.pdf"`
+}
+
+type versionInfo struct {
+	// Path is the path of the file
+	Path string `json:"path" example:"/reports/2024.pdf"`
+
+	// VersionID identifies the version among the versions of the file
+	VersionID string `json:"version_id" example:"20240101T000000.000000000Z"`
+
+	// Size is the size of the content of the version in bytes
+	Size int64 `json:"size" example:"1048576"`
+
+	// CreatedAt is the time when the version is kept and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+
+	// Deleted indicates whether the file no longer exists
+	Deleted bool `json:"deleted" example:"false"`
+}
+
+type auditEventQuery struct {
+	// Username is the user who requested the operations
+	Username string `form:"username" example:"alice"`
+
+	// Operation is the operation such as read, write, rename or remove
+	Operation string `form:"operation" example:"write"`
+
+	// Path is the prefix of the paths of the operations
+	Path string `form:"path" example:"/reports/"`
+
+	// Result is either success or failure
+	Result string `form:"result" binding:"omitempty,oneof=success failure" example:"failure"`
+
+	// From is the earliest time of the operations and it has the format of RFC3339
+	From string `form:"from" example:"2024-01-01T00:00:00Z"`
+
+	// To is the time before which the operations are requested and it has the format of RFC3339
+	To string `form:"to" example:"2024-02-01T00:00:00Z"`
+
+	// Page is the page number starting from 1
+	Page int `form:"page,default=1" binding:"min=1" example:"1"`
+
+	// PageSize is the number of events in a page
+	PageSize int `form:"page_size,default=50" binding:"min=1,max=1000" example:"50"`
+}
+
+type auditEventInfo struct {
+	// ID is the ID of the event
+	ID uint `json:"id" example:"10"`
+
+	// Username is the user who requested the operation
+	Username string `json:"username" example:"alice"`
+
+	// RemoteAddress is the address of the client
+	RemoteAddress string `json:"remote_address" example:"203.0.113.10:52144"`
+
+	// Protocol is the protocol of the request
+	Protocol string `json:"protocol" example:"sftp"`
+
+	// Operation is the operation such as read, write, rename or remove
+	Operation string `json:"operation" example:"write"`
+
+	// Path is the path of the file or directory
+	Path string `json:"path" example:"/reports/2024.pdf"`
+
+	// Target is the new path of a rename or the target of a link
+	Target string `json:"target,omitempty" example:"/archive/2024.pdf"`
+
+	// Bytes is the number of bytes read or written
+	Bytes int64 `json:"bytes" example:"1048576"`
+
+	// Result is either success or failure
+	Result string `json:"result" example:"success"`
+
+	// Error is the error of a failed operation
+	Error string `json:"error,omitempty" example:"permission denied"`
+
+	// CreatedAt is the time of the operation and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type auditEventList struct {
+	// Events are the events of the page with the latest event first
+	Events []auditEventInfo `json:"events"`
+
+	// Page is the page number starting from 1
+	Page int `json:"page" example:"1"`
+
+	// PageSize is the number of events in a page
+	PageSize int `json:"page_size" example:"50"`
+
+	// Total is the number of events matching the filters
+	Total int64 `json:"total" example:"1200"`
+}
+
+type createWebhookRequest struct {
+	// URL is the HTTP or HTTPS endpoint receiving the events
+	URL string `json:"url" binding:"required,http_url" example:"https://example.com/hooks/file-server"`
+
+	// Events are the types of events sent to the webhook
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=file.uploaded file.deleted user.created credential.added" example:"file.uploaded,file.deleted"`
+
+	// Secret is the key signing the payloads where a random secret is generated if it is not specified
+	Secret string `json:"secret" binding:"omitempty,min=16" example:"8a5c7f2e4b9d1a6c3e0f"`
+}
+
+type webhookInfo struct {
+	// ID is the ID of the webhook
+	ID uint `json:"id" example:"1"`
+
+	// URL is the endpoint receiving the events
+	URL string `json:"url" example:"https://example.com/hooks/file-server"`
+
+	// Events are the types of events sent to the webhook
+	Events []string `json:"events" example:"file.uploaded,file.deleted"`
+
+	// CreatedAt is the time of creation of the webhook and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type createdWebhookResponse struct {
+	webhookInfo
+
+	// Secret is the key signing the payloads and it is only shown once
+	Secret string `json:"secret" example:"8a5c7f2e4b9d1a6c3e0f"`
+}
+
+type webhookDeliveryQuery struct {
+	// Status is either pending, delivered or failed
+	Status string `form:"status" binding:"omitempty,oneof=pending delivered failed" example:"failed"`
+
+	// Page is the page number starting from 1
+	Page int `form:"page,default=1" binding:"min=1" example:"1"`
+
+	// PageSize is the number of deliveries in a page
+	PageSize int `form:"page_size,default=50" binding:"min=1,max=1000" example:"50"`
+}
+
+type webhookDeliveryInfo struct {
+	// ID is the ID of the delivery which is sent in the X-FileServer-Delivery header
+	ID uint `json:"id" example:"10"`
+
+	// Event is the type of the event
+	Event string `json:"event" example:"file.uploaded"`
+
+	// Payload is the body sent to the webhook
+	Payload string `json:"payload" example:"{\"event\":\"file.uploaded\",\"created_at\":\"2024-01-01T00:00:00Z\",\"data\":{\"username\":\"alice\",\"path\":\"/reports/2024.pdf\",\"size\":1048576}}"`
+
+	// Status is either pending, delivered or failed
+	Status string `json:"status" example:"delivered"`
+
+	// Attempts is the number of attempts made
+	Attempts int `json:"attempts" example:"1"`
+
+	// LastStatusCode is the HTTP status code of the latest attempt where zero means no response
+	LastStatusCode int `json:"last_status_code" example:"200"`
+
+	// LastError is the error of the latest attempt
+	LastError string `json:"last_error,omitempty" example:"unexpected status code 503"`
+
+	// NextAttemptAt is the time of the next attempt of a pending delivery and it has the format of RFC3339
+	NextAttemptAt string `json:"next_attempt_at,omitempty" example:"2024-01-01T00:00:30Z"`
+
+	// DeliveredAt is the time of the successful attempt and it has the format of RFC3339
+	DeliveredAt string `json:"delivered_at,omitempty" example:"2024-01-01T00:00:01Z"`
+
+	// CreatedAt is the time of the event and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type webhookDeliveryList struct {
+	// Deliveries are the deliveries of the page with the latest delivery first
+	Deliveries []webhookDeliveryInfo `json:"deliveries"`
+
+	// Page is the page number starting from 1
+	Page int `json:"page" example:"1"`
+
+	// PageSize is the number of deliveries in a page
+	PageSize int `json:"page_size" example:"50"`
+
+	// Total is the number of deliveries matching the filters
+	Total int64 `json:"total" example:"1200"`
+}
+
+type sessionInfo struct {
+	// ID is the ID of the session
+	ID string `json:"id" example:"9f86d081884c7d65"`
+
+	// Kind is either connection (SSH connection), sftp (SFTP subsystem), command (command such as scp or rsync) or ftps (FTPS session)
+	Kind string `json:"kind" example:"sftp"`
+
+	// ConnectionID is the ID of the SSH connection of an SFTP or command session
+	ConnectionID string `json:"connection_id,omitempty" example:"2c26b46b68ffc68f"`
+
+	// Username is the user of the session which is empty for a connection not authenticated yet
+	Username string `json:"username" example:"alice"`
+
+	// RemoteAddress is the address of the client
+	RemoteAddress string `json:"remote_address" example:"203.0.113.10:52144"`
+
+	// Command is the command executed by a command session
+	Command string `json:"command,omitempty" example:"scp"`
+
+	// StartedAt is the time the session started and it has the format of RFC3339
+	StartedAt string `json:"started_at" example:"2024-01-01T00:00:00Z"`
+
+	// BytesIn is the number of bytes received from the client
+	BytesIn int64 `json:"bytes_in" example:"1048576"`
+
+	// BytesOut is the number of bytes sent to the client
+	BytesOut int64 `json:"bytes_out" example:"2048"`
+
+	// OpenFiles are the paths of the files open in an SFTP session
+	OpenFiles []string `json:"open_files" example:"/reports/2024.pdf"`
+}
+
+type uploadPolicyRequest struct {
+	// AllowedExtensions are the only extensions of files which can be uploaded where every extension is allowed if it is empty
+	AllowedExtensions []string `json:"allowed_extensions" example:"pdf,csv"`
+
+	// DeniedExtensions are the extensions of files which cannot be uploaded
+	DeniedExtensions []string `json:"denied_extensions" example:"exe,bat"`
+
+	// AllowedMimeTypes are the only MIME types, detected from the content, of files which can be uploaded where every type is allowed if it is empty
+	AllowedMimeTypes []string `json:"allowed_mime_types" example:"application/pdf,text/*"`
+
+	// DeniedMimeTypes are the MIME types, detected from the content, of files which cannot be uploaded
+	DeniedMimeTypes []string `json:"denied_mime_types" example:"application/x-msdownload"`
+
+	// MaxFileSize is the maximum size of a file in bytes where zero means unlimited
+	MaxFileSize int64 `json:"max_file_size" binding:"min=0" example:"104857600"`
+}
+
+type uploadPolicyInfo struct {
+	uploadPolicyRequest
+
+	// UpdatedAt is the time the policy is last changed and it has the format of RFC3339
+	UpdatedAt string `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type quarantineQuery struct {
+	// Username is the user who uploaded the files
+	Username string `form:"username" example:"alice"`
+
+	// Page is the page number starting from 1
+	Page int `form:"page,default=1" binding:"min=1" example:"1"`
+
+	// PageSize is the number of files in a page
+	PageSize int `form:"page_size,default=50" binding:"min=1,max=1000" example:"50"`
+}
+
+type quarantinedFileInfo struct {
+	// ID is the ID of the quarantined file
+	ID string `json:"id" example:"6f1ed002ab5595859014ebf0951522d9"`
+
+	// Username is the user who uploaded the file
+	Username string `json:"username" example:"alice"`
+
+	// Path is the path the file is uploaded to and released to
+	Path string `json:"path" example:"/incoming/invoice.pdf"`
+
+	// Size is the size of the file in bytes
+	Size int64 `json:"size" example:"68"`
+
+	// Signature is the name of the malware found
+	Signature string `json:"signature,omitempty" example:"Eicar-Test-Signature"`
+
+	// Error is the reason the file cannot be scanned
+	Error string `json:"error,omitempty" example:"dial unix /run/clamav/clamd.ctl: connect: no such file or directory"`
+
+	// CreatedAt is the time the file is moved to quarantine and it has the format of RFC3339
+	CreatedAt string `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+type quarantinedFileList struct {
+	// Files are the quarantined files in the page
+	Files []quarantinedFileInfo `json:"files"`
+
+	// Page is the page number starting from 1
+	Page int `json:"page" example:"1"`
+
+	// PageSize is the number of files in a page
+	PageSize int `json:"page_size" example:"50"`
+
+	// Total is the number of files matching the filters
+	Total int64 `json:"total" example:"3"`
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetUserUploadPolicy godoc
+//
+//	@Summary		Get user upload policy
+//	@Description	Get the upload policy of a user
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string	true	"Username"
+//	@Success		200			{object}	uploadPolicyInfo
+//	@Failure		400			"empty username"
+//	@Failure		404			"user or policy not found"
+//	@Failure		500			"unable to retrieve upload policy"
+//	@Router			/users/{username}/upload-policy [get]
+func GetUserUploadPolicy(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var policy db.UserUploadPolicy
+	if err := dbConn.Where("username = ?", username).First(&policy).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve upload policy",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, toUploadPolicyInfo(policy.UploadRules, policy.UpdatedAt))
+}
+
+// SetUserUploadPolicy godoc
+//
+//	@Summary		Set user upload policy
+//	@Description	Create or replace the upload policy of a user. A file has to be allowed by the policy of the server, the policies of the groups of the user and the policy of the user. The change applies to sessions started afterwards.
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Param			request		body		uploadPolicyRequest	true	"Upload policy"
+//	@Success		200			{object}	uploadPolicyInfo
+//	@Failure		400			"empty username or invalid request"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to save upload policy"
+//	@Router			/users/{username}/upload-policy [put]
+func SetUserUploadPolicy(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	rules, ok := bindUploadRules(c)
+	if !ok {
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if exists, ok := recordExists(c, dbConn, &db.User{}, "username = ?", username); !ok {
+		return
+	} else if !exists {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	var policy db.UserUploadPolicy
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("username = ?", username).Limit(1).Find(&policy)
+		if result.Error != nil {
+			return result.Error
+		}
+		policy.UploadRules = rules
+		if result.RowsAffected == 0 {
+			policy.Username = username
+			return tx.Create(&policy).Error
+		}
+		return tx.Save(&policy).Error
+	})
+	if err != nil {
+		slog.Error(
+			"unable to save upload policy",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, toUploadPolicyInfo(policy.UploadRules, policy.UpdatedAt))
+}
+
+// DeleteUserUploadPolicy godoc
+//
+//	@Summary		Delete user upload policy
+//	@Description	Delete the upload policy of a user. The change applies to sessions started afterwards.
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Success		204			"upload policy deleted"
+//	@Failure		400			"empty username"
+//	@Failure		404			"policy not found"
+//	@Failure		500			"unable to delete upload policy"
+//	@Router			/users/{username}/upload-policy [delete]
+func DeleteUserUploadPolicy(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("username = ?", username).Delete(&db.UserUploadPolicy{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to delete upload policy",
+			slog.String("error", result.Error.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetGroupUploadPolicy godoc
+//
+//	@Summary		Get group upload policy
+//	@Description	Get the upload policy applied to the members of a group
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path		string	true	"Group name"
+//	@Success		200		{object}	uploadPolicyInfo
+//	@Failure		400		"empty group name"
+//	@Failure		404		"group or policy not found"
+//	@Failure		500		"unable to retrieve upload policy"
+//	@Router			/groups/{group}/upload-policy [get]
+func GetGroupUploadPolicy(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var policy db.GroupUploadPolicy
+	if err := dbConn.Where("group_name = ?", groupName).First(&policy).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve upload policy",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, toUploadPolicyInfo(policy.UploadRules, policy.UpdatedAt))
+}
+
+// SetGroupUploadPolicy godoc
+//
+//	@Summary		Set group upload policy
+//	@Description	Create or replace the upload policy applied to the members of a group. The change applies to sessions started afterwards.
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path		string				true	"Group name"
+//	@Param			request	body		uploadPolicyRequest	true	"Upload policy"
+//	@Success		200		{object}	uploadPolicyInfo
+//	@Failure		400		"empty group name or invalid request"
+//	@Failure		404		"group not found"
+//	@Failure		500		"unable to save upload policy"
+//	@Router			/groups/{group}/upload-policy [put]
+func SetGroupUploadPolicy(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	rules, ok := bindUploadRules(c)
+	if !ok {
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !groupExists(c, dbConn, groupName) {
+		return
+	}
+
+	var policy db.GroupUploadPolicy
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_name = ?", groupName).Limit(1).Find(&policy)
+		if result.Error != nil {
+			return result.Error
+		}
+		policy.UploadRules = rules
+		if result.RowsAffected == 0 {
+			policy.GroupName = groupName
+			return tx.Create(&policy).Error
+		}
+		return tx.Save(&policy).Error
+	})
+	if err != nil {
+		slog.Error(
+			"unable to save upload policy",
+			slog.String("error", err.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, toUploadPolicyInfo(policy.UploadRules, policy.UpdatedAt))
+}
+
+// DeleteGroupUploadPolicy godoc
+//
+//	@Summary		Delete group upload policy
+//	@Description	Delete the upload policy applied to the members of a group. The change applies to sessions started afterwards.
+//	@Tags			policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			group	path	string	true	"Group name"
+//	@Success		204		"upload policy deleted"
+//	@Failure		400		"empty group name"
+//	@Failure		404		"policy not found"
+//	@Failure		500		"unable to delete upload policy"
+//	@Router			/groups/{group}/upload-policy [delete]
+func DeleteGroupUploadPolicy(c *gin.Context) {
+	groupName := c.Param("group")
+	if groupName == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("group_name = ?", groupName).Delete(&db.GroupUploadPolicy{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to delete upload policy",
+			slog.String("error", result.Error.Error()),
+			slog.String("group", groupName),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// bindUploadRules binds and validates an upload policy of the request. In
+// case the policy is invalid, the response is written and false is returned.
+func bindUploadRules(c *gin.Context) (db.UploadRules, bool) {
+	var req uploadPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return db.UploadRules{}, false
+	}
+	policy := storage.UploadPolicy{
+		AllowedExtensions: req.AllowedExtensions,
+		DeniedExtensions:  req.DeniedExtensions,
+		AllowedMimeTypes:  req.AllowedMimeTypes,
+		DeniedMimeTypes:   req.DeniedMimeTypes,
+		MaxFileSize:       req.MaxFileSize,
+	}
+	if err := policy.Validate(); err != nil {
+		slog.Warn(
+			"invalid upload policy",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusBadRequest)
+		return db.UploadRules{}, false
+	}
+	return policy.Rules(), true
+}
+
+func toUploadPolicyInfo(rules db.UploadRules, updatedAt time.Time) uploadPolicyInfo {
+	policy := storage.UploadPolicyFromRules(rules)
+	return uploadPolicyInfo{
+		uploadPolicyRequest: uploadPolicyRequest{
+			AllowedExtensions: emptyIfNil(policy.AllowedExtensions),
+			DeniedExtensions:  emptyIfNil(policy.DeniedExtensions),
+			AllowedMimeTypes:  emptyIfNil(policy.AllowedMimeTypes),
+			DeniedMimeTypes:   emptyIfNil(policy.DeniedMimeTypes),
+			MaxFileSize:       policy.MaxFileSize,
+		},
+		UpdatedAt: updatedAt.Format(time.RFC3339),
+	}
+}
+
+func emptyIfNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
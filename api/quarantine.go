@@ -0,0 +1,247 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListQuarantinedFiles godoc
+//
+//	@Summary		List quarantined files
+//	@Description	List the files uploaded which are moved to quarantine as they are found infected or they cannot be scanned, with the latest file first
+//	@Tags			quarantine
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	query		string	false	"Username"
+//	@Param			page		query		int		false	"Page number starting from 1"
+//	@Param			page_size	query		int		false	"Number of files in a page (maximum 1000)"
+//	@Success		200			{object}	quarantinedFileList
+//	@Failure		400			"invalid filters"
+//	@Failure		500			"unable to retrieve quarantined files"
+//	@Router			/quarantine [get]
+func ListQuarantinedFiles(c *gin.Context) {
+	var query quarantineQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx := dbConn.Model(&db.QuarantinedFile{})
+	if query.Username != "" {
+		tx = tx.Where("username = ?", query.Username)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		slog.Error(
+			"unable to count quarantined files",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var files []db.QuarantinedFile
+	err := tx.
+		Order("created_at DESC, id ASC").
+		Offset((query.Page - 1) * query.PageSize).
+		Limit(query.PageSize).
+		Find(&files).
+		Error
+	if err != nil {
+		slog.Error(
+			"unable to retrieve quarantined files",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]quarantinedFileInfo, len(files))
+	for i, file := range files {
+		list[i] = quarantinedFileInfo{
+			ID:        file.ID,
+			Username:  file.Username,
+			Path:      file.Path,
+			Size:      file.Size,
+			Signature: file.Signature,
+			Error:     file.Error,
+			CreatedAt: file.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, quarantinedFileList{
+		Files:    list,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	})
+}
+
+// ReleaseQuarantinedFile godoc
+//
+//	@Summary		Release quarantined file
+//	@Description	Move a quarantined file back to the path it is uploaded to without scanning it again. The file counts towards the quota of the user and it cannot replace an existing file.
+//	@Tags			quarantine
+//	@Accept			json
+//	@Produce		json
+//	@Param			quarantine_id	path	string	true	"Quarantined file ID"
+//	@Success		204				"file released"
+//	@Failure		400				"empty ID"
+//	@Failure		403				"the user cannot write, the quota of the user is exceeded or the file breaks an upload policy"
+//	@Failure		404				"quarantined file or user not found"
+//	@Failure		409				"a file exists at the path"
+//	@Failure		500				"unable to release file"
+//	@Router			/quarantine/{quarantine_id}/release [post]
+func ReleaseQuarantinedFile(c *gin.Context) {
+	file, dbConn, ok := getQuarantinedFile(c)
+	if !ok {
+		return
+	}
+
+	fileSystem, ok := getUserFileSystem(c, file.Username)
+	if !ok {
+		return
+	}
+	if err := fileSystem.ReleaseQuarantined(file.ID, file.Path); err != nil {
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			c.Status(http.StatusConflict)
+		case errors.Is(err, fs.ErrNotExist):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, fs.ErrPermission), errors.Is(err, storage.ErrQuotaExceeded), errors.Is(err, storage.ErrPolicyViolation):
+			c.Status(http.StatusForbidden)
+		default:
+			slog.Error(
+				"unable to release quarantined file",
+				slog.String("error", err.Error()),
+				slog.String("id", file.ID),
+			)
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+	if !deleteQuarantinedFileRecord(c, dbConn, file, "quarantine-release") {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteQuarantinedFile godoc
+//
+//	@Summary		Delete quarantined file
+//	@Description	Delete a quarantined file permanently
+//	@Tags			quarantine
+//	@Accept			json
+//	@Produce		json
+//	@Param			quarantine_id	path	string	true	"Quarantined file ID"
+//	@Success		204				"file deleted"
+//	@Failure		400				"empty ID"
+//	@Failure		404				"quarantined file not found"
+//	@Failure		500				"unable to delete file"
+//	@Router			/quarantine/{quarantine_id} [delete]
+func DeleteQuarantinedFile(c *gin.Context) {
+	file, dbConn, ok := getQuarantinedFile(c)
+	if !ok {
+		return
+	}
+
+	pathUsersDirectory := c.GetString("users_directory")
+	fileSystem, err := storage.OpenUserFileSystem(dbConn, pathUsersDirectory, file.Username)
+	if err == nil {
+		err = fileSystem.RemoveQuarantined(file.ID, file.Path)
+	} else if err == gorm.ErrRecordNotFound {
+		// the file is removed along with the home directory of the user
+		err = nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error(
+			"unable to delete quarantined file",
+			slog.String("error", err.Error()),
+			slog.String("id", file.ID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !deleteQuarantinedFileRecord(c, dbConn, file, "quarantine-delete") {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getQuarantinedFile returns the quarantined file of the request. In case of
+// failure, the response is written and false is returned.
+func getQuarantinedFile(c *gin.Context) (db.QuarantinedFile, *gorm.DB, bool) {
+	id := c.Param("quarantine_id")
+	if id == "" {
+		c.Status(http.StatusBadRequest)
+		return db.QuarantinedFile{}, nil, false
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return db.QuarantinedFile{}, nil, false
+	}
+
+	var file db.QuarantinedFile
+	if err := dbConn.Where("id = ?", id).First(&file).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return db.QuarantinedFile{}, nil, false
+		}
+
+		slog.Error(
+			"unable to retrieve quarantined file",
+			slog.String("error", err.Error()),
+			slog.String("id", id),
+		)
+		c.Status(http.StatusInternalServerError)
+		return db.QuarantinedFile{}, nil, false
+	}
+	return file, dbConn, true
+}
+
+// deleteQuarantinedFileRecord deletes the record of a quarantined file which
+// is released or deleted and records the operation in the audit log. In case
+// of failure, the response is written and false is returned.
+func deleteQuarantinedFileRecord(c *gin.Context, dbConn *gorm.DB, file db.QuarantinedFile, operation string) bool {
+	if err := dbConn.Where("id = ?", file.ID).Delete(&db.QuarantinedFile{}).Error; err != nil {
+		slog.Error(
+			"unable to delete record of quarantined file",
+			slog.String("error", err.Error()),
+			slog.String("id", file.ID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return false
+	}
+	if auditLogger, ok := getAuditLoggerFromContext(c); ok {
+		auditLogger.Record(db.AuditEvent{
+			Username:      file.Username,
+			RemoteAddress: c.Request.RemoteAddr,
+			Protocol:      "api",
+			Operation:     operation,
+			Path:          file.Path,
+			Bytes:         file.Size,
+		})
+	}
+	return true
+}
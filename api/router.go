@@ -4,11 +4,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"github.com/alexhokl/file-server/docs"
+	"github.com/alexhokl/file-server/audit"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func GetRouter(dialector gorm.Dialector) (*gin.Engine, error) {
+func GetRouter(dialector gorm.Dialector, pathUsersDirectory string, auditLogger *audit.Logger) (*gin.Engine, error) {
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
@@ -22,8 +23,10 @@ func GetRouter(dialector gorm.Dialector) (*gin.Engine, error) {
 	// User APIs
 	users := r.Group("/users", requiredAdminAccess(), withDatabaseConnection(dialector))
 	users.GET("", ListUsers)
-	users.POST("", CreateUser)
-	users.DELETE("/:username", DeleteUser)
+	users.POST("", withUsersDirectory(pathUsersDirectory), CreateUser)
+	users.GET("/:username", withUsersDirectory(pathUsersDirectory), GetUser)
+	users.PATCH("/:username", withUsersDirectory(pathUsersDirectory), UpdateUser)
+	users.DELETE("/:username", withUsersDirectory(pathUsersDirectory), DeleteUser)
 
 	// User credential APIs
 	userCredentials := users.Group("/:username/credentials")
@@ -31,5 +34,97 @@ func GetRouter(dialector gorm.Dialector) (*gin.Engine, error) {
 	userCredentials.POST("", CreateUserCredential)
 	userCredentials.DELETE("/:credential_id", DeleteUserCredential)
 
+	// User token APIs
+	userTokens := users.Group("/:username/tokens")
+	userTokens.GET("", ListUserTokens)
+	userTokens.POST("", CreateUserToken)
+	userTokens.DELETE("/:token_id", DeleteUserToken)
+
+	// User S3 access key APIs
+	userAccessKeys := users.Group("/:username/access-keys")
+	userAccessKeys.GET("", ListUserAccessKeys)
+	userAccessKeys.POST("", CreateUserAccessKey)
+	userAccessKeys.DELETE("/:access_key_id", DeleteUserAccessKey)
+
+	// User share APIs
+	userShares := users.Group("/:username/shares", withUsersDirectory(pathUsersDirectory))
+	userShares.GET("", ListShares)
+	userShares.POST("", CreateShare)
+	userShares.DELETE("/:share_id", DeleteShare)
+	userShares.GET("/:share_id/accesses", ListShareAccesses)
+
+	// User upload policy APIs
+	users.GET("/:username/upload-policy", GetUserUploadPolicy)
+	users.PUT("/:username/upload-policy", SetUserUploadPolicy)
+	users.DELETE("/:username/upload-policy", DeleteUserUploadPolicy)
+
+	// User file version APIs
+	userVersions := users.Group("/:username/versions", withUsersDirectory(pathUsersDirectory))
+	userVersions.GET("", ListVersions)
+	userVersions.POST("/:version_id/restore", RestoreVersion)
+	userVersions.DELETE("/:version_id", DeleteVersion)
+	users.GET("/:username/trash", withUsersDirectory(pathUsersDirectory), ListTrash)
+
+	// Audit APIs
+	auditEvents := r.Group("/audit-events", requiredAdminAccess(), withDatabaseConnection(dialector))
+	auditEvents.GET("", ListAuditEvents)
+
+	// Quarantine APIs
+	quarantine := r.Group("/quarantine", requiredAdminAccess(), withDatabaseConnection(dialector), withUsersDirectory(pathUsersDirectory), withAuditLogger(auditLogger))
+	quarantine.GET("", ListQuarantinedFiles)
+	quarantine.POST("/:quarantine_id/release", ReleaseQuarantinedFile)
+	quarantine.DELETE("/:quarantine_id", DeleteQuarantinedFile)
+
+	// Session APIs
+	sessions := r.Group("/sessions", requiredAdminAccess())
+	sessions.GET("", ListSessions)
+	sessions.DELETE("/:session_id", CloseSession)
+
+	// Webhook APIs
+	webhooks := r.Group("/webhooks", requiredAdminAccess(), withDatabaseConnection(dialector))
+	webhooks.GET("", ListWebhooks)
+	webhooks.POST("", CreateWebhook)
+	webhooks.DELETE("/:webhook_id", DeleteWebhook)
+	webhooks.GET("/:webhook_id/deliveries", ListWebhookDeliveries)
+
+	// Group APIs
+	groups := r.Group("/groups", requiredAdminAccess(), withDatabaseConnection(dialector))
+	groups.GET("", ListGroups)
+	groups.POST("", CreateGroup)
+	groups.DELETE("/:group", DeleteGroup)
+
+	// Group member APIs
+	groupMembers := groups.Group("/:group/members")
+	groupMembers.GET("", ListGroupMembers)
+	groupMembers.POST("", AddGroupMember)
+	groupMembers.PATCH("/:username", UpdateGroupMember)
+	groupMembers.DELETE("/:username", RemoveGroupMember)
+
+	// Group upload policy APIs
+	groups.GET("/:group/upload-policy", GetGroupUploadPolicy)
+	groups.PUT("/:group/upload-policy", SetGroupUploadPolicy)
+	groups.DELETE("/:group/upload-policy", DeleteGroupUploadPolicy)
+
+	// Shared folder APIs
+	sharedFolders := groups.Group("/:group/folders", withUsersDirectory(pathUsersDirectory))
+	sharedFolders.GET("", ListSharedFolders)
+	sharedFolders.POST("", CreateSharedFolder)
+	sharedFolders.DELETE("/:folder", DeleteSharedFolder)
+
+	// Public share links
+	shares := r.Group("/s", withDatabaseConnection(dialector), withUsersDirectory(pathUsersDirectory))
+	shares.GET("/:token", DownloadShare)
+	shares.GET("/:token/*path", DownloadShare)
+	shares.HEAD("/:token", DownloadShare)
+	shares.HEAD("/:token/*path", DownloadShare)
+	shares.PUT("/:token/*path", UploadShare)
+
+	// WebDAV access to home directories
+	dav := r.Group(webdavPrefix, withDatabaseConnection(dialector), requiredUserAuthentication(), withUsersDirectory(pathUsersDirectory))
+	for _, method := range webdavMethods {
+		dav.Handle(method, "", ServeWebDAV)
+		dav.Handle(method, "/*path", ServeWebDAV)
+	}
+
 	return r, nil
 }
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/session"
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions godoc
+//
+//	@Summary		List sessions
+//	@Description	List the active SSH connections along with their SFTP and command sessions, and the active FTPS sessions, in the order they started
+//	@Tags			sessions
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}	sessionInfo
+//	@Router			/sessions [get]
+func ListSessions(c *gin.Context) {
+	sessions := session.List()
+	list := make([]sessionInfo, len(sessions))
+	for i, s := range sessions {
+		list[i] = sessionInfo{
+			ID:            s.ID,
+			Kind:          s.Kind,
+			ConnectionID:  s.ConnectionID,
+			Username:      s.Username,
+			RemoteAddress: s.RemoteAddress,
+			Command:       s.Command,
+			StartedAt:     s.StartedAt.Format(time.RFC3339),
+			BytesIn:       s.BytesIn,
+			BytesOut:      s.BytesOut,
+			OpenFiles:     s.OpenFiles,
+		}
+		if list[i].OpenFiles == nil {
+			list[i].OpenFiles = []string{}
+		}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CloseSession godoc
+//
+//	@Summary		Close session
+//	@Description	Close an active session forcibly where closing a connection closes all the sessions of the connection
+//	@Tags			sessions
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path	string	true	"Session ID"
+//	@Success		204			"session closed"
+//	@Failure		400			"empty session ID"
+//	@Failure		404			"session not found"
+//	@Failure		500			"unable to close session"
+//	@Router			/sessions/{session_id} [delete]
+func CloseSession(c *gin.Context) {
+	sessionID := c.Param("session_id")
+	if sessionID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if err := session.Close(sessionID); err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to close session",
+			slog.String("error", err.Error()),
+			slog.String("session_id", sessionID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	slog.Info("session closed", slog.String("session_id", sessionID))
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,646 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ListShares godoc
+//
+//	@Summary		List shares
+//	@Description	List all share links of a user
+//	@Tags			shares
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Success		200			{array}	shareInfo
+//	@Failure		400			"empty username"
+//	@Failure		500			"unable to retrieve shares"
+//	@Router			/users/{username}/shares [get]
+func ListShares(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var shares []db.Share
+	if err := dbConn.Where("username = ?", username).Order("id ASC").Find(&shares).Error; err != nil {
+		slog.Error(
+			"unable to retrieve shares",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]shareInfo, len(shares))
+	for i, share := range shares {
+		list[i] = toShareInfo(share)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateShare godoc
+//
+//	@Summary		Create share
+//	@Description	Create a share link of a file or a directory in the home directory of a user
+//	@Tags			shares
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Param			request		body		createShareRequest	true	"Share information"
+//	@Success		201			{object}	createdShareResponse
+//	@Failure		400			"invalid request or path does not exist"
+//	@Failure		403			"share mode is not allowed by the access mode of the user"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to create share"
+//	@Router			/users/{username}/shares [post]
+func CreateShare(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req createShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = db.ShareModeReadOnly
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if err := dbConn.Where("username = ?", username).First(&db.User{}).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	fileSystem, ok := getUserFileSystem(c, username)
+	if !ok {
+		return
+	}
+
+	sharePath := storage.CleanPath(req.Path)
+	info, err := fileSystem.Stat(sharePath)
+	if err != nil {
+		slog.Warn(
+			"unable to find path to be shared",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+			slog.String("path", sharePath),
+		)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if req.Mode == db.ShareModeUploadOnly && !info.IsDir() {
+		slog.Warn(
+			"upload-only share must be a directory",
+			slog.String("username", username),
+			slog.String("path", sharePath),
+		)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if (req.Mode == db.ShareModeReadOnly && !fileSystem.CanRead()) || (req.Mode == db.ShareModeUploadOnly && !fileSystem.CanWrite()) {
+		slog.Warn(
+			"share mode is not allowed by access mode of user",
+			slog.String("username", username),
+			slog.String("mode", req.Mode),
+		)
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		slog.Error(
+			"unable to generate share token",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	share := db.Share{
+		TokenHash:    db.HashToken(token),
+		Username:     username,
+		Path:         sharePath,
+		Mode:         req.Mode,
+		ExpiresAt:    time.Now().Add(time.Duration(req.ExpiresInSeconds) * time.Second),
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.Error(
+				"unable to hash share password",
+				slog.String("error", err.Error()),
+			)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	if err := dbConn.Create(&share).Error; err != nil {
+		slog.Error(
+			"unable to create share",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+			slog.String("path", sharePath),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	viewModel := createdShareResponse{
+		shareInfo: toShareInfo(share),
+		Token:     token,
+		URL:       fmt.Sprintf("/s/%s", token),
+	}
+
+	c.JSON(http.StatusCreated, viewModel)
+}
+
+// DeleteShare godoc
+//
+//	@Summary		Delete share
+//	@Description	Delete a share link of a user
+//	@Tags			shares
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			share_id	path	string	true	"Share ID"
+//	@Success		204			"share deleted"
+//	@Failure		400			"empty username or share ID"
+//	@Failure		404			"share not found"
+//	@Failure		500			"unable to delete share"
+//	@Router			/users/{username}/shares/{share_id} [delete]
+func DeleteShare(c *gin.Context) {
+	username := c.Param("username")
+	shareID := c.Param("share_id")
+	if username == "" || shareID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		var share db.Share
+		if err := tx.Where("id = ? AND username = ?", shareID, username).First(&share).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("share_id = ?", share.ID).Delete(&db.ShareAccess{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&share).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to delete share",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+			slog.String("share_id", shareID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListShareAccesses godoc
+//
+//	@Summary		List share accesses
+//	@Description	List the access log of a share link
+//	@Tags			shares
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			share_id	path	string	true	"Share ID"
+//	@Success		200			{array}	shareAccessInfo
+//	@Failure		400			"empty username or share ID"
+//	@Failure		404			"share not found"
+//	@Failure		500			"unable to retrieve share accesses"
+//	@Router			/users/{username}/shares/{share_id}/accesses [get]
+func ListShareAccesses(c *gin.Context) {
+	username := c.Param("username")
+	shareID := c.Param("share_id")
+	if username == "" || shareID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var share db.Share
+	if err := dbConn.Where("id = ? AND username = ?", shareID, username).First(&share).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve share",
+			slog.String("error", err.Error()),
+			slog.String("share_id", shareID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var accesses []db.ShareAccess
+	if err := dbConn.Where("share_id = ?", share.ID).Order("id ASC").Find(&accesses).Error; err != nil {
+		slog.Error(
+			"unable to retrieve share accesses",
+			slog.String("error", err.Error()),
+			slog.String("share_id", shareID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]shareAccessInfo, len(accesses))
+	for i, access := range accesses {
+		list[i] = shareAccessInfo{
+			ID:            access.ID,
+			RemoteAddress: access.RemoteAddress,
+			Operation:     access.Operation,
+			Path:          access.Path,
+			StatusCode:    access.StatusCode,
+			CreatedAt:     access.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// DownloadShare godoc
+//
+//	@Summary		Download from share
+//	@Description	Download a shared file or list a shared directory. Password protected shares require HTTP basic authentication with the password of the share. Only the downloads of whole files count towards the download limit, rather than HEAD requests or range requests.
+//	@Tags			shares
+//	@Produce		octet-stream
+//	@Produce		json
+//	@Param			token	path		string	true	"Share token"
+//	@Param			path	path		string	false	"Path relative to the shared directory"
+//	@Success		200		{array}		sharedEntry
+//	@Failure		401		"password required"
+//	@Failure		403		"share does not allow downloads"
+//	@Failure		404		"share or file not found"
+//	@Failure		410		"share expired or download limit reached"
+//	@Failure		500		"unable to serve file"
+//	@Router			/s/{token}/{path} [get]
+//	@Router			/s/{token}/{path} [head]
+func DownloadShare(c *gin.Context) {
+	dbConn, share, relativePath, ok := getAuthorizedShare(c)
+	if !ok {
+		return
+	}
+
+	statusCode := serveShare(c, dbConn, share, relativePath)
+	recordShareAccess(dbConn, c, share, "download", relativePath, statusCode)
+}
+
+// UploadShare godoc
+//
+//	@Summary		Upload to share
+//	@Description	Upload a file to an upload-only shared directory. Existing files are never overwritten. Password protected shares require HTTP basic authentication with the password of the share.
+//	@Tags			shares
+//	@Accept			octet-stream
+//	@Param			token	path	string	true	"Share token"
+//	@Param			path	path	string	true	"Path of the file relative to the shared directory"
+//	@Success		201		"file uploaded"
+//	@Failure		400		"invalid path"
+//	@Failure		401		"password required"
+//	@Failure		403		"share does not allow uploads"
+//	@Failure		404		"share not found"
+//	@Failure		409		"file already exists"
+//	@Failure		410		"share expired"
+//	@Failure		500		"unable to save file"
+//	@Router			/s/{token}/{path} [put]
+func UploadShare(c *gin.Context) {
+	dbConn, share, relativePath, ok := getAuthorizedShare(c)
+	if !ok {
+		return
+	}
+
+	statusCode := receiveShare(c, share, relativePath)
+	recordShareAccess(dbConn, c, share, "upload", relativePath, statusCode)
+}
+
+// getAuthorizedShare loads the share of the token in the request and
+// verifies its expiry and password. In case of failure, the response is
+// written and false is returned.
+func getAuthorizedShare(c *gin.Context) (*gorm.DB, *db.Share, string, bool) {
+	token := c.Param("token")
+	if token == "" {
+		c.Status(http.StatusNotFound)
+		return nil, nil, "", false
+	}
+	relativePath := storage.CleanPath(c.Param("path"))
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return nil, nil, "", false
+	}
+
+	var share db.Share
+	if err := dbConn.Where("token_hash = ?", db.HashToken(token)).First(&share).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return nil, nil, "", false
+		}
+
+		slog.Error(
+			"unable to retrieve share",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return nil, nil, "", false
+	}
+
+	if time.Now().After(share.ExpiresAt) {
+		c.Status(http.StatusGone)
+		recordShareAccess(dbConn, c, &share, "access", relativePath, http.StatusGone)
+		return nil, nil, "", false
+	}
+
+	if share.PasswordHash != "" {
+		_, password, _ := c.Request.BasicAuth()
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			c.Header("WWW-Authenticate", `Basic realm="share"`)
+			c.Status(http.StatusUnauthorized)
+			recordShareAccess(dbConn, c, &share, "access", relativePath, http.StatusUnauthorized)
+			return nil, nil, "", false
+		}
+	}
+
+	return dbConn, &share, relativePath, true
+}
+
+func serveShare(c *gin.Context, dbConn *gorm.DB, share *db.Share, relativePath string) int {
+	if share.Mode != db.ShareModeReadOnly {
+		c.Status(http.StatusForbidden)
+		return http.StatusForbidden
+	}
+
+	fileSystem, ok := getUserFileSystem(c, share.Username)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	info, err := fileSystem.Stat(share.Path)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	if !info.IsDir() && relativePath != "/" {
+		c.Status(http.StatusNotFound)
+		return http.StatusNotFound
+	}
+
+	filePath := path.Join(share.Path, relativePath)
+	info, err = fileSystem.Stat(filePath)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return http.StatusNotFound
+	}
+
+	if info.IsDir() {
+		entries, err := fileSystem.ReadDir(filePath)
+		if errors.Is(err, fs.ErrPermission) {
+			c.Status(http.StatusForbidden)
+			return http.StatusForbidden
+		}
+		if err != nil {
+			slog.Error(
+				"unable to list shared directory",
+				slog.String("error", err.Error()),
+				slog.Uint64("share_id", uint64(share.ID)),
+				slog.String("path", filePath),
+			)
+			c.Status(http.StatusInternalServerError)
+			return http.StatusInternalServerError
+		}
+		list := make([]sharedEntry, len(entries))
+		for i, entry := range entries {
+			list[i] = sharedEntry{
+				Name:        entry.Name(),
+				Size:        entry.Size(),
+				IsDirectory: entry.IsDir(),
+				ModifiedAt:  entry.ModTime().Format(time.RFC3339),
+			}
+		}
+		c.JSON(http.StatusOK, list)
+		return http.StatusOK
+	}
+
+	// the access mode of the user is checked before a download is counted
+	if !fileSystem.CanRead() {
+		c.Status(http.StatusForbidden)
+		return http.StatusForbidden
+	}
+
+	// only a download of the whole file is counted so that HEAD requests and
+	// resumed downloads do not use up the downloads of the share
+	if c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" {
+		result := dbConn.Model(&db.Share{}).
+			Where("id = ? AND (max_downloads = 0 OR download_count < max_downloads)", share.ID).
+			Update("download_count", gorm.Expr("download_count + 1"))
+		if result.Error != nil {
+			slog.Error(
+				"unable to update download count of share",
+				slog.String("error", result.Error.Error()),
+				slog.Uint64("share_id", uint64(share.ID)),
+			)
+			c.Status(http.StatusInternalServerError)
+			return http.StatusInternalServerError
+		}
+		if result.RowsAffected == 0 {
+			c.Status(http.StatusGone)
+			return http.StatusGone
+		}
+	} else if share.MaxDownloads > 0 && share.DownloadCount >= share.MaxDownloads {
+		c.Status(http.StatusGone)
+		return http.StatusGone
+	}
+
+	file, err := fileSystem.Open(filePath)
+	if err != nil {
+		slog.Error(
+			"unable to open shared file",
+			slog.String("error", err.Error()),
+			slog.Uint64("share_id", uint64(share.ID)),
+			slog.String("path", filePath),
+		)
+		c.Status(http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+	return c.Writer.Status()
+}
+
+func receiveShare(c *gin.Context, share *db.Share, relativePath string) int {
+	if share.Mode != db.ShareModeUploadOnly {
+		c.Status(http.StatusForbidden)
+		return http.StatusForbidden
+	}
+	if relativePath == "/" {
+		c.Status(http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+
+	fileSystem, ok := getUserFileSystem(c, share.Username)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	filePath := path.Join(share.Path, relativePath)
+	file, err := fileSystem.CreateExclusive(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			c.Status(http.StatusConflict)
+			return http.StatusConflict
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			c.Status(http.StatusBadRequest)
+			return http.StatusBadRequest
+		}
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, storage.ErrPolicyViolation) {
+			c.Status(http.StatusForbidden)
+			return http.StatusForbidden
+		}
+
+		slog.Error(
+			"unable to create shared file",
+			slog.String("error", err.Error()),
+			slog.Uint64("share_id", uint64(share.ID)),
+			slog.String("path", filePath),
+		)
+		c.Status(http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+
+	_, err = io.Copy(file, c.Request.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, storage.ErrPolicyViolation) || errors.Is(err, storage.ErrQuarantined) {
+		// the file has been removed already
+		c.Status(http.StatusForbidden)
+		return http.StatusForbidden
+	}
+	if err != nil {
+		slog.Error(
+			"unable to save shared file",
+			slog.String("error", err.Error()),
+			slog.Uint64("share_id", uint64(share.ID)),
+			slog.String("path", filePath),
+		)
+		if removeErr := fileSystem.Remove(filePath); removeErr != nil {
+			slog.Error(
+				"unable to remove incomplete shared file",
+				slog.String("error", removeErr.Error()),
+				slog.String("path", filePath),
+			)
+		}
+		c.Status(http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+
+	c.Status(http.StatusCreated)
+	return http.StatusCreated
+}
+
+func recordShareAccess(dbConn *gorm.DB, c *gin.Context, share *db.Share, operation string, relativePath string, statusCode int) {
+	access := db.ShareAccess{
+		ShareID:       share.ID,
+		RemoteAddress: c.ClientIP(),
+		Operation:     operation,
+		Path:          relativePath,
+		StatusCode:    statusCode,
+	}
+	if err := dbConn.Create(&access).Error; err != nil {
+		slog.Error(
+			"unable to record share access",
+			slog.String("error", err.Error()),
+			slog.Uint64("share_id", uint64(share.ID)),
+		)
+	}
+}
+
+func toShareInfo(share db.Share) shareInfo {
+	return shareInfo{
+		ID:            share.ID,
+		Path:          share.Path,
+		Mode:          share.Mode,
+		ExpiresAt:     share.ExpiresAt.Format(time.RFC3339),
+		MaxDownloads:  share.MaxDownloads,
+		DownloadCount: share.DownloadCount,
+		HasPassword:   share.PasswordHash != "",
+		CreatedAt:     share.CreatedAt.Format(time.RFC3339),
+	}
+}
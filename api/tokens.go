@@ -0,0 +1,215 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const tokenLength = 32
+
+// ListUserTokens godoc
+//
+//	@Summary		List user tokens
+//	@Description	List all access tokens of a user
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Success		200			{array}	tokenInfo
+//	@Failure		400			"empty username"
+//	@Failure		500			"unable to retrieve user tokens"
+//	@Router			/users/{username}/tokens [get]
+func ListUserTokens(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var tokens []db.UserToken
+	if err := dbConn.Where("username = ?", username).Order("id ASC").Find(&tokens).Error; err != nil {
+		slog.Error(
+			"unable to retrieve user tokens",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]tokenInfo, len(tokens))
+	for i, token := range tokens {
+		list[i] = toTokenInfo(token)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateUserToken godoc
+//
+//	@Summary		Create user token
+//	@Description	Create a new access token for a user. The token is only returned once and it is used as the password of HTTP basic authentication (or as a bearer token) for WebDAV and as the password of FTPS.
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string					true	"Username"
+//	@Param			request		body		createUserTokenRequest	true	"Token information"
+//	@Success		201			{object}	createdUserTokenResponse
+//	@Failure		400			"empty username or invalid request"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to create user token"
+//	@Router			/users/{username}/tokens [post]
+func CreateUserToken(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var req createUserTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if err := dbConn.Where("username = ?", username).First(&db.User{}).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve user",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error(
+			"unable to generate token",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	token := db.UserToken{
+		Username:  username,
+		Name:      req.Name,
+		TokenHash: db.HashToken(secret),
+	}
+	if req.ExpiresInSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInSeconds) * time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := dbConn.Create(&token).Error; err != nil {
+		slog.Error(
+			"unable to create user token",
+			slog.String("error", err.Error()),
+			slog.String("username", username),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	viewModel := createdUserTokenResponse{
+		tokenInfo: toTokenInfo(token),
+		Token:     secret,
+	}
+
+	c.JSON(http.StatusCreated, viewModel)
+}
+
+// DeleteUserToken godoc
+//
+//	@Summary		Delete user token
+//	@Description	Delete an access token of a user
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			token_id	path	string	true	"Token ID"
+//	@Success		204			"token deleted"
+//	@Failure		400			"empty username or token ID"
+//	@Failure		404			"token not found"
+//	@Failure		500			"unable to delete user token"
+//	@Router			/users/{username}/tokens/{token_id} [delete]
+func DeleteUserToken(c *gin.Context) {
+	username := c.Param("username")
+	tokenID := c.Param("token_id")
+	if username == "" || tokenID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	result := dbConn.Where("id = ? AND username = ?", tokenID, username).Delete(&db.UserToken{})
+	if result.Error != nil {
+		slog.Error(
+			"unable to delete user token",
+			slog.String("error", result.Error.Error()),
+			slog.String("token_id", tokenID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func generateToken() (string, error) {
+	buf := make([]byte, tokenLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func toTokenInfo(token db.UserToken) tokenInfo {
+	info := tokenInfo{
+		ID:        token.ID,
+		Name:      token.Name,
+		CreatedAt: token.CreatedAt.Format(time.RFC3339),
+	}
+	if token.ExpiresAt != nil {
+		info.ExpiresAt = token.ExpiresAt.Format(time.RFC3339)
+	}
+	return info
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ListVersions godoc
+//
+//	@Summary		List versions
+//	@Description	List the previous versions of a file, or of all files, of a user with the newest version of a file first
+//	@Tags			versions
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			path		query	string	false	"Path of the file"
+//	@Success		200			{array}	versionInfo
+//	@Failure		400			"empty username or versioning is not enabled"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to retrieve versions"
+//	@Router			/users/{username}/versions [get]
+func ListVersions(c *gin.Context) {
+	var query listVersionsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	fileSystem, ok := getVersionedFileSystem(c)
+	if !ok {
+		return
+	}
+
+	versions, err := fileSystem.ListVersions(query.Path)
+	if err != nil {
+		slog.Error(
+			"unable to retrieve versions",
+			slog.String("error", err.Error()),
+			slog.String("username", c.Param("username")),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toVersionInfos(versions))
+}
+
+// ListTrash godoc
+//
+//	@Summary		List recycle bin
+//	@Description	List the versions of files of a user which have been removed or renamed
+//	@Tags			versions
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Success		200			{array}	versionInfo
+//	@Failure		400			"empty username or versioning is not enabled"
+//	@Failure		404			"user not found"
+//	@Failure		500			"unable to retrieve versions"
+//	@Router			/users/{username}/trash [get]
+func ListTrash(c *gin.Context) {
+	fileSystem, ok := getVersionedFileSystem(c)
+	if !ok {
+		return
+	}
+
+	versions, err := fileSystem.ListTrash()
+	if err != nil {
+		slog.Error(
+			"unable to retrieve versions",
+			slog.String("error", err.Error()),
+			slog.String("username", c.Param("username")),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, toVersionInfos(versions))
+}
+
+// RestoreVersion godoc
+//
+//	@Summary		Restore version
+//	@Description	Restore a version of a file where the current content of the file, if any, is kept as a version
+//	@Tags			versions
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			version_id	path	string	true	"Version ID"
+//	@Param			path		query	string	true	"Path of the file"
+//	@Success		204			"version restored"
+//	@Failure		400			"invalid request or versioning is not enabled"
+//	@Failure		403			"the user cannot write or the quota of the user is exceeded"
+//	@Failure		404			"user or version not found"
+//	@Failure		409			"a directory exists at the path"
+//	@Failure		500			"unable to restore version"
+//	@Router			/users/{username}/versions/{version_id}/restore [post]
+func RestoreVersion(c *gin.Context) {
+	var query versionQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	fileSystem, ok := getVersionedFileSystem(c)
+	if !ok {
+		return
+	}
+
+	err := fileSystem.RestoreVersion(query.Path, c.Param("version_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, fs.ErrPermission), errors.Is(err, storage.ErrQuotaExceeded):
+			c.Status(http.StatusForbidden)
+		case errors.Is(err, syscall.EISDIR):
+			c.Status(http.StatusConflict)
+		default:
+			slog.Error(
+				"unable to restore version",
+				slog.String("error", err.Error()),
+				slog.String("username", c.Param("username")),
+				slog.String("path", query.Path),
+			)
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteVersion godoc
+//
+//	@Summary		Delete version
+//	@Description	Delete a version of a file permanently
+//	@Tags			versions
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path	string	true	"Username"
+//	@Param			version_id	path	string	true	"Version ID"
+//	@Param			path		query	string	true	"Path of the file"
+//	@Success		204			"version deleted"
+//	@Failure		400			"invalid request or versioning is not enabled"
+//	@Failure		404			"user or version not found"
+//	@Failure		500			"unable to delete version"
+//	@Router			/users/{username}/versions/{version_id} [delete]
+func DeleteVersion(c *gin.Context) {
+	var query versionQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	fileSystem, ok := getVersionedFileSystem(c)
+	if !ok {
+		return
+	}
+
+	if err := fileSystem.DeleteVersion(query.Path, c.Param("version_id")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		slog.Error(
+			"unable to delete version",
+			slog.String("error", err.Error()),
+			slog.String("username", c.Param("username")),
+			slog.String("path", query.Path),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getVersionedFileSystem returns the file system of the user in the path if
+// versions of files are kept. In case of failure, the response is written
+// and false is returned.
+func getVersionedFileSystem(c *gin.Context) (*storage.FileSystem, bool) {
+	username := c.Param("username")
+	if username == "" {
+		c.Status(http.StatusBadRequest)
+		return nil, false
+	}
+	fileSystem, ok := getUserFileSystem(c, username)
+	if !ok {
+		return nil, false
+	}
+	if !fileSystem.Versioning() {
+		c.Status(http.StatusBadRequest)
+		return nil, false
+	}
+	return fileSystem, true
+}
+
+func toVersionInfos(versions []storage.Version) []versionInfo {
+	list := make([]versionInfo, len(versions))
+	for i, version := range versions {
+		list[i] = versionInfo{
+			Path:      version.Path,
+			VersionID: version.ID,
+			Size:      version.Size,
+			CreatedAt: version.CreatedAt.Format(time.RFC3339),
+			Deleted:   version.Deleted,
+		}
+	}
+	return list
+}
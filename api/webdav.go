@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/alexhokl/file-server/storage"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+const webdavPrefix = "/dav"
+
+var webdavMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodDelete,
+	"PROPFIND",
+	"PROPPATCH",
+	"MKCOL",
+	"COPY",
+	"MOVE",
+	"LOCK",
+	"UNLOCK",
+}
+
+var (
+	webdavLockSystems      = map[string]webdav.LockSystem{}
+	webdavLockSystemsMutex sync.Mutex
+)
+
+// ServeWebDAV serves the home directory of the authenticated user with the
+// WebDAV protocol.
+func ServeWebDAV(c *gin.Context) {
+	username := c.GetString("username")
+
+	fileSystem, ok := getUserFileSystem(c, username)
+	if !ok {
+		return
+	}
+	// the file system denies the operations as well but the WebDAV handler
+	// does not report such failures as forbidden
+	if !isWebDAVMethodAllowed(fileSystem, c.Request.Method) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: &webdavFileSystem{fileSystem: fileSystem},
+		LockSystem: getWebDAVLockSystem(username),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Warn(
+					"webdav request failed",
+					slog.String("error", err.Error()),
+					slog.String("user", username),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+				)
+			}
+		},
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
+}
+
+// isWebDAVMethodAllowed checks the method against the access mode of the
+// user.
+func isWebDAVMethodAllowed(fileSystem *storage.FileSystem, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return fileSystem.CanRead()
+	case http.MethodPut, http.MethodDelete, "PROPPATCH", "MKCOL", "MOVE":
+		return fileSystem.CanWrite()
+	case "COPY":
+		return fileSystem.CanRead() && fileSystem.CanWrite()
+	}
+	return true
+}
+
+// getWebDAVLockSystem returns the lock system of the specified user so that
+// locks of different users never conflict with each other.
+func getWebDAVLockSystem(username string) webdav.LockSystem {
+	webdavLockSystemsMutex.Lock()
+	defer webdavLockSystemsMutex.Unlock()
+
+	lockSystem, ok := webdavLockSystems[username]
+	if !ok {
+		lockSystem = webdav.NewMemLS()
+		webdavLockSystems[username] = lockSystem
+	}
+	return lockSystem
+}
+
+// webdavFileSystem implements webdav.FileSystem with the jailed file system
+// of a user.
+type webdavFileSystem struct {
+	fileSystem *storage.FileSystem
+}
+
+func (fs *webdavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.fileSystem.Mkdir(name, perm)
+}
+
+func (fs *webdavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	return fs.fileSystem.OpenFile(name, flag, perm)
+}
+
+func (fs *webdavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return fs.fileSystem.RemoveAll(name)
+}
+
+func (fs *webdavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.fileSystem.Rename(oldName, newName)
+}
+
+func (fs *webdavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.fileSystem.Stat(name)
+}
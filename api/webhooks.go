@@ -0,0 +1,285 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/webhook"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListWebhooks godoc
+//
+//	@Summary		List webhooks
+//	@Description	List the webhooks notified of events
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}	webhookInfo
+//	@Failure		500	"unable to retrieve webhooks"
+//	@Router			/webhooks [get]
+func ListWebhooks(c *gin.Context) {
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var webhooks []db.Webhook
+	if err := dbConn.Order("id ASC").Find(&webhooks).Error; err != nil {
+		slog.Error(
+			"unable to retrieve webhooks",
+			slog.String("error", err.Error()),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]webhookInfo, len(webhooks))
+	for i, w := range webhooks {
+		list[i] = toWebhookInfo(w)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateWebhook godoc
+//
+//	@Summary		Create webhook
+//	@Description	Create a webhook receiving the specified types of events (file.uploaded, file.deleted, user.created and credential.added) where each request is signed with the secret in the X-FileServer-Signature header
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		createWebhookRequest	true	"Webhook"
+//	@Success		201		{object}	createdWebhookResponse
+//	@Failure		400		"invalid request"
+//	@Failure		500		"unable to create webhook"
+//	@Router			/webhooks [post]
+func CreateWebhook(c *gin.Context) {
+	var req createWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Warn("bad request")
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateToken(); err != nil {
+			slog.Error(
+				"unable to generate webhook secret",
+				slog.String("error", err.Error()),
+			)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w := db.Webhook{
+		URL:    req.URL,
+		Events: webhook.JoinEvents(req.Events),
+		Secret: secret,
+	}
+	if err := dbConn.Create(&w).Error; err != nil {
+		slog.Error(
+			"unable to create webhook",
+			slog.String("error", err.Error()),
+			slog.String("url", req.URL),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdWebhookResponse{
+		webhookInfo: toWebhookInfo(w),
+		Secret:      secret,
+	})
+}
+
+// DeleteWebhook godoc
+//
+//	@Summary		Delete webhook
+//	@Description	Delete a webhook along with its deliveries
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook_id	path	string	true	"Webhook ID"
+//	@Success		204			"webhook deleted"
+//	@Failure		400			"empty webhook ID"
+//	@Failure		404			"webhook not found"
+//	@Failure		500			"unable to delete webhook"
+//	@Router			/webhooks/{webhook_id} [delete]
+func DeleteWebhook(c *gin.Context) {
+	webhookID := c.Param("webhook_id")
+	if webhookID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		var w db.Webhook
+		if err := tx.Where("id = ?", webhookID).First(&w).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id = ?", w.ID).Delete(&db.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&w).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to delete webhook",
+			slog.String("error", err.Error()),
+			slog.String("webhook_id", webhookID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries godoc
+//
+//	@Summary		List webhook deliveries
+//	@Description	List the delivery history of a webhook with the latest delivery first
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook_id	path		string	true	"Webhook ID"
+//	@Param			status		query		string	false	"Either pending, delivered or failed"
+//	@Param			page		query		int		false	"Page number starting from 1"
+//	@Param			page_size	query		int		false	"Number of deliveries in a page (maximum 1000)"
+//	@Success		200			{object}	webhookDeliveryList
+//	@Failure		400			"invalid filters"
+//	@Failure		404			"webhook not found"
+//	@Failure		500			"unable to retrieve webhook deliveries"
+//	@Router			/webhooks/{webhook_id}/deliveries [get]
+func ListWebhookDeliveries(c *gin.Context) {
+	webhookID := c.Param("webhook_id")
+	if webhookID == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	var query webhookDeliveryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	dbConn, ok := getDatabaseConnectionFromContext(c)
+	if !ok {
+		slog.Error("unable to retrieve database connection")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var w db.Webhook
+	if err := dbConn.Where("id = ?", webhookID).First(&w).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		slog.Error(
+			"unable to retrieve webhook",
+			slog.String("error", err.Error()),
+			slog.String("webhook_id", webhookID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx := dbConn.Model(&db.WebhookDelivery{}).Where("webhook_id = ?", w.ID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		slog.Error(
+			"unable to count webhook deliveries",
+			slog.String("error", err.Error()),
+			slog.String("webhook_id", webhookID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var deliveries []db.WebhookDelivery
+	err := tx.
+		Order("id DESC").
+		Offset((query.Page - 1) * query.PageSize).
+		Limit(query.PageSize).
+		Find(&deliveries).
+		Error
+	if err != nil {
+		slog.Error(
+			"unable to retrieve webhook deliveries",
+			slog.String("error", err.Error()),
+			slog.String("webhook_id", webhookID),
+		)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]webhookDeliveryInfo, len(deliveries))
+	for i, delivery := range deliveries {
+		list[i] = webhookDeliveryInfo{
+			ID:             delivery.ID,
+			Event:          delivery.Event,
+			Payload:        string(delivery.Payload),
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+		}
+		if delivery.Status == db.WebhookDeliveryPending {
+			list[i].NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+		}
+		if delivery.DeliveredAt != nil {
+			list[i].DeliveredAt = delivery.DeliveredAt.Format(time.RFC3339)
+		}
+	}
+
+	c.JSON(http.StatusOK, webhookDeliveryList{
+		Deliveries: list,
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		Total:      total,
+	})
+}
+
+func toWebhookInfo(w db.Webhook) webhookInfo {
+	return webhookInfo{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    webhook.SplitEvents(w),
+		CreatedAt: w.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// Logger records the file operations requested by users in the database
+// and, if a log file is specified, as JSON lines in the file.
+type Logger struct {
+	dbConn *gorm.DB
+	mutex  sync.Mutex
+	file   *os.File
+}
+
+// entry is an event written to the log file.
+type entry struct {
+	ID            uint      `json:"id"`
+	Time          time.Time `json:"time"`
+	Username      string    `json:"username"`
+	RemoteAddress string    `json:"remote_address"`
+	Protocol      string    `json:"protocol"`
+	Operation     string    `json:"operation"`
+	Path          string    `json:"path"`
+	Target        string    `json:"target,omitempty"`
+	Bytes         int64     `json:"bytes"`
+	Result        string    `json:"result"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// NewLogger returns a logger recording events in the database. Events are
+// also appended to the log file if its path is not empty.
+func NewLogger(dbConn *gorm.DB, pathLogFile string) (*Logger, error) {
+	logger := &Logger{dbConn: dbConn}
+	if pathLogFile != "" {
+		file, err := os.OpenFile(pathLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		logger.file = file
+	}
+	return logger, nil
+}
+
+// NewFileLogger returns a logger recording events only in the log file, such
+// as in tests.
+func NewFileLogger(pathLogFile string) (*Logger, error) {
+	return NewLogger(nil, pathLogFile)
+}
+
+// Record records an event. A failure to record is logged rather than
+// failing the operation of the user.
+func (l *Logger) Record(event db.AuditEvent) {
+	event.CreatedAt = time.Now().UTC()
+	if event.Result == "" {
+		event.Result = db.AuditResultSuccess
+		if event.Error != "" {
+			event.Result = db.AuditResultFailure
+		}
+	}
+	if l.dbConn != nil {
+		if err := l.dbConn.Create(&event).Error; err != nil {
+			slog.Error(
+				"unable to record audit event",
+				slog.String("error", err.Error()),
+				slog.String("user", event.Username),
+				slog.String("operation", event.Operation),
+				slog.String("path", event.Path),
+			)
+		}
+	}
+	if l.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(entry{
+		ID:            event.ID,
+		Time:          event.CreatedAt,
+		Username:      event.Username,
+		RemoteAddress: event.RemoteAddress,
+		Protocol:      event.Protocol,
+		Operation:     event.Operation,
+		Path:          event.Path,
+		Target:        event.Target,
+		Bytes:         event.Bytes,
+		Result:        event.Result,
+		Error:         event.Error,
+	})
+	if err != nil {
+		slog.Error("unable to encode audit event", slog.String("error", err.Error()))
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		slog.Error("unable to write audit log", slog.String("error", err.Error()))
+	}
+}
+
+// Close closes the log file.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
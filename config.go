@@ -2,8 +2,17 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/ftp"
+	"github.com/alexhokl/file-server/handler"
+	"github.com/alexhokl/file-server/scan"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/alexhokl/file-server/throttle"
 	"github.com/alexhokl/helper/iohelper"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
@@ -14,6 +23,24 @@ type FileServerConfiguration struct {
 	Users               map[string][]string
 	SSHServerPort       int
 	APIServerPort       int
+	S3ServerPort        int
+	S3MultipartMaxAge   time.Duration
+	FTPSServerPort      int
+	FTPS                ftp.Config
+	RsyncPath           string
+	DefaultQuota        storage.Quota
+	Backends            storage.BackendConfig
+	MasterKey           []byte
+	Versioning          storage.VersioningConfig
+	UploadPolicy        storage.UploadPolicy
+	Scan                storage.ScanConfig
+	AtomicUploads       bool
+	AtomicUploadMaxAge  time.Duration
+	SymlinkPolicy       storage.SymlinkPolicy
+	Home                storage.HomeConfig
+	AuditLogFile        string
+	RateLimits          throttle.Limits
+	ConnectionLimits    handler.ConnectionLimits
 	PathUsersDirectory  string
 	AdministrativeUsers []string
 }
@@ -31,10 +58,206 @@ func getConfiguration(dbConn *gorm.DB) (*FileServerConfiguration, error) {
 	if apiPort <= 0 {
 		return nil, fmt.Errorf("API server port is invalid: %d", apiPort)
 	}
+	s3Port := viper.GetInt("s3_port")
+	if s3Port < 0 {
+		return nil, fmt.Errorf("S3 server port is invalid: %d", s3Port)
+	}
+	s3MultipartMaxAge := viper.GetDuration("s3_multipart_max_age")
+	if s3MultipartMaxAge < 0 {
+		return nil, fmt.Errorf("maximum age of multipart uploads is invalid: %s", s3MultipartMaxAge)
+	}
+	if s3MultipartMaxAge == 0 {
+		s3MultipartMaxAge = 24 * time.Hour
+	}
+	ftpsPort := viper.GetInt("ftps_port")
+	if ftpsPort < 0 {
+		return nil, fmt.Errorf("FTPS server port is invalid: %d", ftpsPort)
+	}
+	var ftpsConfig ftp.Config
+	if ftpsPort > 0 {
+		ftpsConfig.CertificateFile = viper.GetString("ftps_certificate_file")
+		if !iohelper.IsFileExist(ftpsConfig.CertificateFile) {
+			return nil, fmt.Errorf("FTPS certificate file does not exist: %s", ftpsConfig.CertificateFile)
+		}
+		ftpsConfig.KeyFile = viper.GetString("ftps_key_file")
+		if !iohelper.IsFileExist(ftpsConfig.KeyFile) {
+			return nil, fmt.Errorf("FTPS key file does not exist: %s", ftpsConfig.KeyFile)
+		}
+		ftpsConfig.ClientCAFile = viper.GetString("ftps_client_ca_file")
+		if ftpsConfig.ClientCAFile != "" && !iohelper.IsFileExist(ftpsConfig.ClientCAFile) {
+			return nil, fmt.Errorf("FTPS client CA file does not exist: %s", ftpsConfig.ClientCAFile)
+		}
+		passivePortRange := viper.GetString("ftps_passive_port_range")
+		if passivePortRange == "" {
+			passivePortRange = "50000-50100"
+		}
+		start, end, err := ftp.ParsePortRange(passivePortRange)
+		if err != nil {
+			return nil, fmt.Errorf("FTPS passive port range is invalid: %w", err)
+		}
+		ftpsConfig.PassivePortStart = start
+		ftpsConfig.PassivePortEnd = end
+		ftpsConfig.PublicHost = viper.GetString("ftps_public_host")
+	}
+	rsyncPath := viper.GetString("rsync_path")
+	if rsyncPath != "" && !iohelper.IsFileExist(rsyncPath) {
+		return nil, fmt.Errorf("rsync executable does not exist: %s", rsyncPath)
+	}
+	defaultQuota := storage.Quota{
+		Bytes: viper.GetInt64("default_quota_bytes"),
+		Files: viper.GetInt64("default_quota_files"),
+	}
+	if defaultQuota.Bytes < 0 {
+		return nil, fmt.Errorf("default quota of bytes is invalid: %d", defaultQuota.Bytes)
+	}
+	if defaultQuota.Files < 0 {
+		return nil, fmt.Errorf("default quota of files is invalid: %d", defaultQuota.Files)
+	}
+	backends := storage.BackendConfig{
+		Default: viper.GetString("storage_backend"),
+		ObjectStore: storage.ObjectStoreConfig{
+			Endpoint:        viper.GetString("s3_backend_endpoint"),
+			Bucket:          viper.GetString("s3_backend_bucket"),
+			Region:          viper.GetString("s3_backend_region"),
+			AccessKeyID:     viper.GetString("s3_backend_access_key_id"),
+			SecretAccessKey: viper.GetString("s3_backend_secret_access_key"),
+			Prefix:          viper.GetString("s3_backend_prefix"),
+		},
+	}
+	if backends.Default == "" {
+		backends.Default = storage.BackendLocal
+	}
+	if backends.ObjectStore.Endpoint != "" && backends.ObjectStore.Bucket == "" {
+		return nil, fmt.Errorf("bucket of s3 storage backend is not set")
+	}
+	var masterKey []byte
+	if pathMasterKey := viper.GetString("encryption_master_key_file"); pathMasterKey != "" {
+		key, err := storage.LoadMasterKey(pathMasterKey)
+		if err != nil {
+			return nil, err
+		}
+		masterKey = key
+	}
+	versioning := storage.VersioningConfig{
+		Enabled:     viper.GetBool("versioning_enabled"),
+		MaxVersions: viper.GetInt("versioning_max_versions"),
+		MaxAge:      viper.GetDuration("versioning_max_age"),
+	}
+	if versioning.MaxVersions < 0 {
+		return nil, fmt.Errorf("maximum number of versions is invalid: %d", versioning.MaxVersions)
+	}
+	if versioning.MaxAge < 0 {
+		return nil, fmt.Errorf("maximum age of versions is invalid: %s", versioning.MaxAge)
+	}
+	uploadPolicy := storage.UploadPolicy{
+		AllowedExtensions: viper.GetStringSlice("upload_allowed_extensions"),
+		DeniedExtensions:  viper.GetStringSlice("upload_denied_extensions"),
+		AllowedMimeTypes:  viper.GetStringSlice("upload_allowed_mime_types"),
+		DeniedMimeTypes:   viper.GetStringSlice("upload_denied_mime_types"),
+		MaxFileSize:       viper.GetInt64("upload_max_file_size"),
+	}
+	if err := uploadPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("upload policy is invalid: %w", err)
+	}
+	scanConfig := storage.ScanConfig{
+		Timeout: viper.GetDuration("scan_timeout"),
+	}
+	clamdSocket := viper.GetString("scan_clamd_socket")
+	scanCommand := viper.GetStringSlice("scan_command")
+	if clamdSocket != "" && len(scanCommand) > 0 {
+		return nil, fmt.Errorf("only one of clamd socket and scan command can be set")
+	}
+	if clamdSocket != "" {
+		scanConfig.Scanner = scan.NewClamdScanner(clamdSocket)
+	}
+	if len(scanCommand) > 0 {
+		scanConfig.Scanner = scan.NewCommandScanner(scanCommand)
+	}
+	if scanConfig.Timeout < 0 {
+		return nil, fmt.Errorf("scan timeout is invalid: %s", scanConfig.Timeout)
+	}
+	if scanConfig.Timeout == 0 {
+		scanConfig.Timeout = 5 * time.Minute
+	}
+	atomicUploads := viper.GetBool("sftp_atomic_uploads")
+	atomicUploadMaxAge := viper.GetDuration("sftp_atomic_upload_max_age")
+	if atomicUploadMaxAge < 0 {
+		return nil, fmt.Errorf("maximum age of temporary files of uploads is invalid: %s", atomicUploadMaxAge)
+	}
+	if atomicUploadMaxAge == 0 {
+		atomicUploadMaxAge = 24 * time.Hour
+	}
+	symlinkPolicy, err := storage.ParseSymlinkPolicy(viper.GetString("symlink_policy"))
+	if err != nil {
+		return nil, err
+	}
+	homeConfig := storage.HomeConfig{
+		SkeletonDirectory: viper.GetString("home_skeleton_directory"),
+		DirectoryMode:     0o755,
+		UID:               -1,
+		GID:               -1,
+		ArchiveDirectory:  viper.GetString("home_archive_directory"),
+	}
+	if homeConfig.SkeletonDirectory != "" && !iohelper.IsDirectoryExist(homeConfig.SkeletonDirectory) {
+		return nil, fmt.Errorf("home skeleton directory does not exist: %s", homeConfig.SkeletonDirectory)
+	}
+	if directoryMode := viper.GetString("home_directory_mode"); directoryMode != "" {
+		mode, err := strconv.ParseUint(directoryMode, 8, 32)
+		if err != nil || mode&^uint64(os.ModePerm) != 0 {
+			return nil, fmt.Errorf("home directory mode is invalid: %s", directoryMode)
+		}
+		homeConfig.DirectoryMode = os.FileMode(mode)
+	}
+	if viper.IsSet("home_directory_uid") {
+		homeConfig.UID = viper.GetInt("home_directory_uid")
+	}
+	if viper.IsSet("home_directory_gid") {
+		homeConfig.GID = viper.GetInt("home_directory_gid")
+	}
+	homeConfig.PurgeGracePeriod = 7 * 24 * time.Hour
+	if viper.IsSet("home_purge_grace_period") {
+		homeConfig.PurgeGracePeriod = viper.GetDuration("home_purge_grace_period")
+	}
+	if homeConfig.PurgeGracePeriod < 0 {
+		return nil, fmt.Errorf("grace period of purges of home directories is invalid: %s", homeConfig.PurgeGracePeriod)
+	}
+	connectionLimits := handler.ConnectionLimits{
+		MaxConnections:        viper.GetInt("max_connections"),
+		MaxConnectionsPerUser: viper.GetInt("max_connections_per_user"),
+		MaxConnectionsPerIP:   viper.GetInt("max_connections_per_ip"),
+	}
+	if connectionLimits.MaxConnections < 0 {
+		return nil, fmt.Errorf("maximum number of connections is invalid: %d", connectionLimits.MaxConnections)
+	}
+	if connectionLimits.MaxConnectionsPerUser < 0 {
+		return nil, fmt.Errorf("maximum number of connections per user is invalid: %d", connectionLimits.MaxConnectionsPerUser)
+	}
+	if connectionLimits.MaxConnectionsPerIP < 0 {
+		return nil, fmt.Errorf("maximum number of connections per IP address is invalid: %d", connectionLimits.MaxConnectionsPerIP)
+	}
+	maxStartups, err := handler.ParseMaxStartups(viper.GetString("max_startups"))
+	if err != nil {
+		return nil, err
+	}
+	connectionLimits.MaxStartups = maxStartups
+	auditLogFile := viper.GetString("audit_log_file")
+	rateLimits := throttle.Limits{
+		Upload:   viper.GetInt64("upload_rate_limit"),
+		Download: viper.GetInt64("download_rate_limit"),
+	}
+	if rateLimits.Upload < 0 {
+		return nil, fmt.Errorf("upload rate limit is invalid: %d", rateLimits.Upload)
+	}
+	if rateLimits.Download < 0 {
+		return nil, fmt.Errorf("download rate limit is invalid: %d", rateLimits.Download)
+	}
 	pathUsersDirectory := viper.GetString("path_users_directory")
 	if !iohelper.IsDirectoryExist(pathUsersDirectory) {
 		return nil, fmt.Errorf("path users directory does not exist: %s", pathUsersDirectory)
 	}
+	if homeConfig.ArchiveDirectory == "" {
+		homeConfig.ArchiveDirectory = filepath.Join(pathUsersDirectory, ".archive")
+	}
 	administrativeUsers := viper.GetStringSlice("administrative_users")
 	if len(administrativeUsers) == 0 {
 		return nil, fmt.Errorf("administrative users are not set")
@@ -47,6 +270,24 @@ func getConfiguration(dbConn *gorm.DB) (*FileServerConfiguration, error) {
 		HostKeyFile:         pathHostKey,
 		SSHServerPort:       serverPort,
 		APIServerPort:       apiPort,
+		S3ServerPort:        s3Port,
+		S3MultipartMaxAge:   s3MultipartMaxAge,
+		FTPSServerPort:      ftpsPort,
+		FTPS:                ftpsConfig,
+		RsyncPath:           rsyncPath,
+		DefaultQuota:        defaultQuota,
+		Backends:            backends,
+		MasterKey:           masterKey,
+		Versioning:          versioning,
+		UploadPolicy:        uploadPolicy,
+		Scan:                scanConfig,
+		AtomicUploads:       atomicUploads,
+		AtomicUploadMaxAge:  atomicUploadMaxAge,
+		SymlinkPolicy:       symlinkPolicy,
+		Home:                homeConfig,
+		AuditLogFile:        auditLogFile,
+		RateLimits:          rateLimits,
+		ConnectionLimits:    connectionLimits,
 		Users:               map[string][]string{},
 		PathUsersDirectory:  pathUsersDirectory,
 		AdministrativeUsers: administrativeUsers,
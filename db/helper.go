@@ -13,5 +13,108 @@ func Migrate(db *gorm.DB) error {
 	if err != nil {
 		return err
 	}
+	// shares kept their tokens in plain before the hashes of the tokens are
+	// kept
+	if db.Migrator().HasTable(&Share{}) && db.Migrator().HasColumn(&Share{}, "token") {
+		err = migrateShareTokens(db)
+		if err != nil {
+			return err
+		}
+	}
+	err = db.AutoMigrate(&Share{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&ShareAccess{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&UserToken{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&AccessKey{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&Group{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&GroupMember{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&SharedFolder{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&UserDataKey{})
+	if err != nil {
+		return err
+	}
+	// data keys referred to users before they are kept beyond their users
+	if db.Migrator().HasConstraint(&UserDataKey{}, "fk_user_data_keys_user") {
+		err = db.Migrator().DropConstraint(&UserDataKey{}, "fk_user_data_keys_user")
+		if err != nil {
+			return err
+		}
+	}
+	err = db.AutoMigrate(&UserUploadPolicy{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&GroupUploadPolicy{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&AuditEvent{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&QuarantinedFile{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&DeletedHome{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&Webhook{})
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&WebhookDelivery{})
+	if err != nil {
+		return err
+	}
 	return nil
 }
+
+// migrateShareTokens replaces the tokens of the shares with the hashes of
+// them so that the links of the shares keep working.
+func migrateShareTokens(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().RenameColumn(&Share{}, "token", "token_hash"); err != nil {
+			return err
+		}
+		if tx.Migrator().HasIndex(&Share{}, "idx_shares_token") {
+			if err := tx.Migrator().DropIndex(&Share{}, "idx_shares_token"); err != nil {
+				return err
+			}
+		}
+		var shares []Share
+		if err := tx.Select("id", "token_hash").Find(&shares).Error; err != nil {
+			return err
+		}
+		for _, share := range shares {
+			err := tx.Model(&Share{}).
+				Where("id = ?", share.ID).
+				Update("token_hash", HashToken(share.TokenHash)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -3,9 +3,30 @@ package db
 import "time"
 
 type User struct {
-	Username string `gorm:"primary_key;unique;not null"`
+	Username   string `gorm:"primary_key;unique;not null"`
+	AccessMode string `gorm:"not null;default:read-write"`
+
+	// QuotaBytes and QuotaFiles are the limits of storage of the user where
+	// nil means the default of the server and zero means unlimited
+	QuotaBytes *int64
+	QuotaFiles *int64
+
+	// StorageBackend is where the files of the user are stored where empty
+	// means the default backend of the server
+	StorageBackend string
+
+	// UploadRateLimit and DownloadRateLimit are the bytes per second of the
+	// transfers of the user where zero means unlimited
+	UploadRateLimit   int64 `gorm:"not null;default:0"`
+	DownloadRateLimit int64 `gorm:"not null;default:0"`
 }
 
+const (
+	AccessModeReadWrite = "read-write"
+	AccessModeReadOnly  = "read-only"
+	AccessModeWriteOnly = "write-only"
+)
+
 type UserCredential struct {
 	ID        uint      `gorm:"primarykey"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
@@ -13,3 +34,197 @@ type UserCredential struct {
 	PublicKey string    `gorm:"uniqueIndex:idx_uniq_credential_name,priority:2;not null"`
 	User      User      `gorm:"foreignKey:Username"`
 }
+
+type Share struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	TokenHash     string    `gorm:"uniqueIndex;not null"`
+	Username      string    `gorm:"index;not null"`
+	Path          string    `gorm:"not null"`
+	Mode          string    `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+	MaxDownloads  int       `gorm:"not null;default:0"`
+	DownloadCount int       `gorm:"not null;default:0"`
+	PasswordHash  string
+	User          User `gorm:"foreignKey:Username"`
+}
+
+type ShareAccess struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	ShareID       uint      `gorm:"index;not null"`
+	RemoteAddress string    `gorm:"not null"`
+	Operation     string    `gorm:"not null"`
+	Path          string    `gorm:"not null"`
+	StatusCode    int       `gorm:"not null"`
+	Share         Share     `gorm:"foreignKey:ShareID"`
+}
+
+const (
+	ShareModeReadOnly   = "read-only"
+	ShareModeUploadOnly = "upload-only"
+)
+
+type UserToken struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	Username  string    `gorm:"index;not null"`
+	Name      string    `gorm:"not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt *time.Time
+	User      User `gorm:"foreignKey:Username"`
+}
+
+type AccessKey struct {
+	AccessKeyID     string    `gorm:"primary_key;unique;not null"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	Username        string    `gorm:"index;not null"`
+	SecretAccessKey string    `gorm:"not null"`
+	User            User      `gorm:"foreignKey:Username"`
+}
+
+type Group struct {
+	Name      string    `gorm:"primary_key;unique;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+type GroupMember struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	GroupName string    `gorm:"uniqueIndex:idx_uniq_group_member,priority:1;not null"`
+	Username  string    `gorm:"uniqueIndex:idx_uniq_group_member,priority:2;index;not null"`
+	Access    string    `gorm:"not null"`
+	Group     Group     `gorm:"foreignKey:GroupName"`
+	User      User      `gorm:"foreignKey:Username"`
+}
+
+const (
+	GroupAccessReadOnly  = "read-only"
+	GroupAccessReadWrite = "read-write"
+)
+
+type SharedFolder struct {
+	Name      string    `gorm:"primary_key;unique;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	GroupName string    `gorm:"index;not null"`
+	Group     Group     `gorm:"foreignKey:GroupName"`
+}
+
+// UserDataKey is the key encrypting the files of a user. It is stored
+// wrapped by the master key of the server which is identified by
+// MasterKeyID. It does not refer to the user as it is kept after the user is
+// deleted until the home directory of the user is purged.
+type UserDataKey struct {
+	Username    string    `gorm:"primary_key;not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	WrappedKey  []byte    `gorm:"not null"`
+	MasterKeyID string    `gorm:"not null"`
+}
+
+// UploadRules restricts the files uploaded where the lists are
+// comma-separated and empty lists and a zero size do not restrict anything.
+type UploadRules struct {
+	AllowedExtensions string
+	DeniedExtensions  string
+	AllowedMimeTypes  string
+	DeniedMimeTypes   string
+	MaxFileSize       int64 `gorm:"not null;default:0"`
+}
+
+// UserUploadPolicy is the upload policy of a user.
+type UserUploadPolicy struct {
+	Username    string    `gorm:"primary_key;not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	UploadRules `gorm:"embedded"`
+	User        User `gorm:"foreignKey:Username"`
+}
+
+// GroupUploadPolicy is the upload policy applied to the members of a group.
+type GroupUploadPolicy struct {
+	GroupName   string    `gorm:"primary_key;not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	UploadRules `gorm:"embedded"`
+	Group       Group `gorm:"foreignKey:GroupName"`
+}
+
+// AuditEvent is a file operation requested by a user. It does not refer to
+// the user so that the events are kept after the user is deleted.
+type AuditEvent struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+	Username      string    `gorm:"index;not null"`
+	RemoteAddress string    `gorm:"not null"`
+	Protocol      string    `gorm:"not null"`
+	Operation     string    `gorm:"index;not null"`
+	Path          string    `gorm:"not null"`
+	Target        string
+	Bytes         int64  `gorm:"not null;default:0"`
+	Result        string `gorm:"not null"`
+	Error         string
+}
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// QuarantinedFile is a file uploaded which is moved to quarantine as it is
+// found infected or it cannot be scanned. It does not refer to the user so
+// that the file can be reviewed after the user is deleted.
+type QuarantinedFile struct {
+	ID        string    `gorm:"primary_key;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+	Username  string    `gorm:"index;not null"`
+	Path      string    `gorm:"not null"`
+	Size      int64     `gorm:"not null;default:0"`
+	Signature string
+	Error     string
+}
+
+// DeletedHome is the home directory of a user deleted which is purged once
+// PurgeAt has passed. It does not refer to the user as the user no longer
+// exists and StorageBackend is kept to find the files.
+type DeletedHome struct {
+	Username       string    `gorm:"primary_key;not null"`
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	StorageBackend string
+	PurgeAt        time.Time `gorm:"index;not null"`
+}
+
+// Webhook is an endpoint notified of the events it subscribes to where
+// Events is a comma-separated list of event types. Payloads are signed with
+// Secret.
+type Webhook struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	URL       string    `gorm:"not null"`
+	Events    string    `gorm:"not null"`
+	Secret    string    `gorm:"not null"`
+}
+
+// WebhookDelivery is an event queued for delivery to a webhook along with
+// the result of the latest attempt.
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primarykey"`
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+	WebhookID      uint      `gorm:"index;not null"`
+	Event          string    `gorm:"not null"`
+	Payload        []byte    `gorm:"not null"`
+	Status         string    `gorm:"index:idx_webhook_delivery_queue,priority:1;not null"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index:idx_webhook_delivery_queue,priority:2;not null"`
+	LastStatusCode int       `gorm:"not null;default:0"`
+	LastError      string
+	DeliveredAt    *time.Time
+	Webhook        Webhook `gorm:"foreignKey:WebhookID"`
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FindUserToken returns the unexpired token matching the specified secret.
+// If username is not empty, the token must belong to the user.
+func FindUserToken(dbConn *gorm.DB, username string, secret string) (*UserToken, error) {
+	query := dbConn.Where("token_hash = ?", HashToken(secret))
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	var token UserToken
+	if err := query.First(&token).Error; err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &token, nil
+}
+
+// HashToken returns the hash of a token to be stored. As tokens are random,
+// a fast hash is sufficient and it allows tokens to be looked up.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
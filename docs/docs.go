@@ -15,9 +15,9 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/users": {
+        "/audit-events": {
             "get": {
-                "description": "List all users",
+                "description": "Search the file operations requested by users with the latest operation first",
                 "consumes": [
                     "application/json"
                 ],
@@ -25,23 +25,105 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "audit"
+                ],
+                "summary": "List audit events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operation such as read, write, rename or remove",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Prefix of paths",
+                        "name": "path",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Either success or failure",
+                        "name": "result",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Earliest time in RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Time before which operations are requested in RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number starting from 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of events in a page (maximum 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
                 ],
-                "summary": "List users",
                 "responses": {
                     "200": {
-                        "description": "list of user names",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.auditEventList"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid filters"
+                    },
+                    "500": {
+                        "description": "unable to retrieve audit events"
+                    }
+                }
+            }
+        },
+        "/groups": {
+            "get": {
+                "description": "List all groups",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "groups"
+                ],
+                "summary": "List groups",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "type": "string"
+                                "$ref": "#/definitions/api.groupInfo"
                             }
                         }
+                    },
+                    "500": {
+                        "description": "unable to retrieve groups"
                     }
                 }
             },
             "post": {
-                "description": "Create a new user",
+                "description": "Create a new group",
                 "consumes": [
                     "application/json"
                 ],
@@ -49,17 +131,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "groups"
                 ],
-                "summary": "Create user",
+                "summary": "Create group",
                 "parameters": [
                     {
-                        "description": "User information",
+                        "description": "Group information",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.createUserRequest"
+                            "$ref": "#/definitions/api.createGroupRequest"
                         }
                     }
                 ],
@@ -67,24 +149,24 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/api.createdUserResponse"
+                            "$ref": "#/definitions/api.groupInfo"
                         }
                     },
                     "400": {
-                        "description": "empty username"
+                        "description": "invalid request"
                     },
                     "409": {
-                        "description": "username already exists"
+                        "description": "group already exists"
                     },
                     "500": {
-                        "description": "unable to create user"
+                        "description": "unable to create group"
                     }
                 }
             }
         },
-        "/users/{username}": {
+        "/groups/{group}": {
             "delete": {
-                "description": "Delete a user",
+                "description": "Delete a group along with its memberships and upload policy. A group which still owns shared folders cannot be deleted.",
                 "consumes": [
                     "application/json"
                 ],
@@ -92,37 +174,40 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "groups"
                 ],
-                "summary": "Delete user",
+                "summary": "Delete group",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Username",
-                        "name": "username",
+                        "description": "Group name",
+                        "name": "group",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "204": {
-                        "description": "user deleted"
+                        "description": "group deleted"
                     },
                     "400": {
-                        "description": "empty username"
+                        "description": "empty group name"
                     },
                     "404": {
-                        "description": "user not found"
+                        "description": "group not found"
+                    },
+                    "409": {
+                        "description": "group still owns shared folders"
                     },
                     "500": {
-                        "description": "unable to delete user"
+                        "description": "unable to delete group"
                     }
                 }
             }
         },
-        "/users/{username}/credentials": {
+        "/groups/{group}/folders": {
             "get": {
-                "description": "List all credentials of a user",
+                "description": "List all shared folders owned by a group",
                 "consumes": [
                     "application/json"
                 ],
@@ -130,14 +215,14 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "credentials"
+                    "groups"
                 ],
-                "summary": "List user credentials",
+                "summary": "List shared folders",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Username",
-                        "name": "username",
+                        "description": "Group name",
+                        "name": "group",
                         "in": "path",
                         "required": true
                     }
@@ -148,23 +233,23 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/api.credentialInfo"
+                                "$ref": "#/definitions/api.sharedFolderInfo"
                             }
                         }
                     },
                     "400": {
-                        "description": "empty username"
+                        "description": "empty group name"
                     },
                     "404": {
-                        "description": "user not found"
+                        "description": "group not found"
                     },
                     "500": {
-                        "description": "unable to retrieve user credentials"
+                        "description": "unable to retrieve shared folders"
                     }
                 }
             },
             "post": {
-                "description": "Create a new credential for a user",
+                "description": "Create a shared folder owned by a group. The folder is mounted at /shared/{name} for the members of the group.",
                 "consumes": [
                     "application/json"
                 ],
@@ -172,24 +257,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "credentials"
+                    "groups"
                 ],
-                "summary": "Create user credential",
+                "summary": "Create shared folder",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Username",
-                        "name": "username",
+                        "description": "Group name",
+                        "name": "group",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Credential information",
+                        "description": "Shared folder information",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.createUserCredentialRequest"
+                            "$ref": "#/definitions/api.createSharedFolderRequest"
                         }
                     }
                 ],
@@ -197,27 +282,27 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/api.createUserCredentialResponse"
+                            "$ref": "#/definitions/api.sharedFolderInfo"
                         }
                     },
                     "400": {
-                        "description": "empty username or invalid public key"
+                        "description": "empty group name or invalid request"
                     },
                     "404": {
-                        "description": "user not found"
+                        "description": "group not found"
                     },
                     "409": {
-                        "description": "public key already exists"
+                        "description": "shared folder already exists"
                     },
                     "500": {
-                        "description": "unable to create user credential"
+                        "description": "unable to create shared folder"
                     }
                 }
             }
         },
-        "/users/{username}/credentials/{credential_id}": {
+        "/groups/{group}/folders/{folder}": {
             "delete": {
-                "description": "Delete a credential of a user",
+                "description": "Delete a shared folder of a group along with its files",
                 "consumes": [
                     "application/json"
                 ],
@@ -225,116 +310,3180 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "credentials"
+                    "groups"
                 ],
-                "summary": "Delete user credential",
+                "summary": "Delete shared folder",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Username",
-                        "name": "username",
+                        "description": "Group name",
+                        "name": "group",
                         "in": "path",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Credential ID",
-                        "name": "credential_id",
+                        "description": "Shared folder name",
+                        "name": "folder",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "204": {
-                        "description": "credential deleted"
+                        "description": "shared folder deleted"
                     },
                     "400": {
-                        "description": "empty username or credential ID"
+                        "description": "empty group name or shared folder name"
                     },
                     "404": {
-                        "description": "credential not found"
+                        "description": "shared folder not found"
                     },
                     "500": {
-                        "description": "unable to delete user credential"
+                        "description": "unable to delete shared folder"
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "api.createUserCredentialRequest": {
-            "type": "object",
-            "required": [
-                "public_key"
-            ],
-            "properties": {
-                "public_key": {
-                    "description": "PublicKey is the public key of the user",
-                    "type": "string",
-                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
-                }
-            }
-        },
-        "api.createUserCredentialResponse": {
-            "type": "object",
-            "properties": {
-                "created_at": {
-                    "description": "CreatedAt is the time when the user credential is added and it has the format of RFC3339",
-                    "type": "string",
-                    "example": "2024-01-01T00:00:00Z"
-                },
-                "id": {
-                    "description": "ID is the ID of the user credential created",
-                    "type": "integer",
-                    "example": 10
-                },
-                "public_key": {
-                    "description": "PublicKey is the public key of the user",
-                    "type": "string",
-                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
-                },
-                "username": {
-                    "description": "Username is the username of the user",
-                    "type": "string",
-                    "example": "alice"
-                }
-            }
         },
-        "api.createUserRequest": {
-            "type": "object",
-            "required": [
-                "username"
-            ],
-            "properties": {
-                "username": {
-                    "description": "Username is the username of the user",
-                    "type": "string",
-                    "example": "alice"
+        "/groups/{group}/members": {
+            "get": {
+                "description": "List all members of a group",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "groups"
+                ],
+                "summary": "List group members",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.groupMemberInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty group name"
+                    },
+                    "404": {
+                        "description": "group not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve group members"
+                    }
                 }
-            }
-        },
-        "api.createdUserResponse": {
-            "type": "object",
-            "properties": {
-                "username": {
-                    "description": "Username is the username of the user",
-                    "type": "string",
-                    "example": "alice"
+            },
+            "post": {
+                "description": "Add a user to a group. The shared folders of the group are available to the user in sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "groups"
+                ],
+                "summary": "Add group member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Member information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.addGroupMemberRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.groupMemberInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty group name or invalid request"
+                    },
+                    "404": {
+                        "description": "group or user not found"
+                    },
+                    "409": {
+                        "description": "user is a member already"
+                    },
+                    "500": {
+                        "description": "unable to add group member"
+                    }
                 }
             }
         },
-        "api.credentialInfo": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "description": "ID is the ID of the user credential",
-                    "type": "integer",
-                    "example": 10
-                },
-                "public_key": {
-                    "description": "PublicKey is the public key of the user",
-                    "type": "string",
-                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
+        "/groups/{group}/members/{username}": {
+            "delete": {
+                "description": "Remove a user from a group",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "groups"
+                ],
+                "summary": "Remove group member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "member removed"
+                    },
+                    "400": {
+                        "description": "empty group name or username"
+                    },
+                    "404": {
+                        "description": "member not found"
+                    },
+                    "500": {
+                        "description": "unable to remove group member"
+                    }
+                }
+            },
+            "patch": {
+                "description": "Change the access of a member to the shared folders of a group. The change applies to sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "groups"
+                ],
+                "summary": "Update group member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Member information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.updateGroupMemberRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.groupMemberInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty group name or username or invalid request"
+                    },
+                    "404": {
+                        "description": "member not found"
+                    },
+                    "500": {
+                        "description": "unable to update group member"
+                    }
+                }
+            }
+        },
+        "/groups/{group}/upload-policy": {
+            "get": {
+                "description": "Get the upload policy applied to the members of a group",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Get group upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty group name"
+                    },
+                    "404": {
+                        "description": "group or policy not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve upload policy"
+                    }
+                }
+            },
+            "put": {
+                "description": "Create or replace the upload policy applied to the members of a group. The change applies to sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Set group upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Upload policy",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty group name or invalid request"
+                    },
+                    "404": {
+                        "description": "group not found"
+                    },
+                    "500": {
+                        "description": "unable to save upload policy"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete the upload policy applied to the members of a group. The change applies to sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Delete group upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "upload policy deleted"
+                    },
+                    "400": {
+                        "description": "empty group name"
+                    },
+                    "404": {
+                        "description": "policy not found"
+                    },
+                    "500": {
+                        "description": "unable to delete upload policy"
+                    }
+                }
+            }
+        },
+        "/quarantine": {
+            "get": {
+                "description": "List the files uploaded which are moved to quarantine as they are found infected or they cannot be scanned, with the latest file first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quarantine"
+                ],
+                "summary": "List quarantined files",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number starting from 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of files in a page (maximum 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.quarantinedFileList"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid filters"
+                    },
+                    "500": {
+                        "description": "unable to retrieve quarantined files"
+                    }
+                }
+            }
+        },
+        "/quarantine/{quarantine_id}": {
+            "delete": {
+                "description": "Delete a quarantined file permanently",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quarantine"
+                ],
+                "summary": "Delete quarantined file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Quarantined file ID",
+                        "name": "quarantine_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "file deleted"
+                    },
+                    "400": {
+                        "description": "empty ID"
+                    },
+                    "404": {
+                        "description": "quarantined file not found"
+                    },
+                    "500": {
+                        "description": "unable to delete file"
+                    }
+                }
+            }
+        },
+        "/quarantine/{quarantine_id}/release": {
+            "post": {
+                "description": "Move a quarantined file back to the path it is uploaded to without scanning it again. The file counts towards the quota of the user and it cannot replace an existing file.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quarantine"
+                ],
+                "summary": "Release quarantined file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Quarantined file ID",
+                        "name": "quarantine_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "file released"
+                    },
+                    "400": {
+                        "description": "empty ID"
+                    },
+                    "403": {
+                        "description": "the user cannot write, the quota of the user is exceeded or the file breaks an upload policy"
+                    },
+                    "404": {
+                        "description": "quarantined file or user not found"
+                    },
+                    "409": {
+                        "description": "a file exists at the path"
+                    },
+                    "500": {
+                        "description": "unable to release file"
+                    }
+                }
+            }
+        },
+        "/s/{token}/{path}": {
+            "get": {
+                "description": "Download a shared file or list a shared directory. Password protected shares require HTTP basic authentication with the password of the share. Only the downloads of whole files count towards the download limit, rather than HEAD requests or range requests.",
+                "produces": [
+                    "application/octet-stream",
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "Download from share",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Share token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path relative to the shared directory",
+                        "name": "path",
+                        "in": "path"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.sharedEntry"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "password required"
+                    },
+                    "403": {
+                        "description": "share does not allow downloads"
+                    },
+                    "404": {
+                        "description": "share or file not found"
+                    },
+                    "410": {
+                        "description": "share expired or download limit reached"
+                    },
+                    "500": {
+                        "description": "unable to serve file"
+                    }
+                }
+            },
+            "put": {
+                "description": "Upload a file to an upload-only shared directory. Existing files are never overwritten. Password protected shares require HTTP basic authentication with the password of the share.",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "Upload to share",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Share token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the file relative to the shared directory",
+                        "name": "path",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "file uploaded"
+                    },
+                    "400": {
+                        "description": "invalid path"
+                    },
+                    "401": {
+                        "description": "password required"
+                    },
+                    "403": {
+                        "description": "share does not allow uploads"
+                    },
+                    "404": {
+                        "description": "share not found"
+                    },
+                    "409": {
+                        "description": "file already exists"
+                    },
+                    "410": {
+                        "description": "share expired"
+                    },
+                    "500": {
+                        "description": "unable to save file"
+                    }
+                }
+            },
+            "head": {
+                "description": "Download a shared file or list a shared directory. Password protected shares require HTTP basic authentication with the password of the share. Only the downloads of whole files count towards the download limit, rather than HEAD requests or range requests.",
+                "produces": [
+                    "application/octet-stream",
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "Download from share",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Share token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path relative to the shared directory",
+                        "name": "path",
+                        "in": "path"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.sharedEntry"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "password required"
+                    },
+                    "403": {
+                        "description": "share does not allow downloads"
+                    },
+                    "404": {
+                        "description": "share or file not found"
+                    },
+                    "410": {
+                        "description": "share expired or download limit reached"
+                    },
+                    "500": {
+                        "description": "unable to serve file"
+                    }
+                }
+            }
+        },
+        "/sessions": {
+            "get": {
+                "description": "List the active SSH connections along with their SFTP and command sessions, and the active FTPS sessions, in the order they started",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "List sessions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.sessionInfo"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/{session_id}": {
+            "delete": {
+                "description": "Close an active session forcibly where closing a connection closes all the sessions of the connection",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Close session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "session_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "session closed"
+                    },
+                    "400": {
+                        "description": "empty session ID"
+                    },
+                    "404": {
+                        "description": "session not found"
+                    },
+                    "500": {
+                        "description": "unable to close session"
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "description": "List all users",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List users",
+                "responses": {
+                    "200": {
+                        "description": "list of user names",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new user along with the home directory of the user provisioned from the skeleton directory of the server",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create user",
+                "parameters": [
+                    {
+                        "description": "User information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.createUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createdUserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "empty or invalid username"
+                    },
+                    "409": {
+                        "description": "username already exists"
+                    },
+                    "500": {
+                        "description": "unable to create user"
+                    }
+                }
+            }
+        },
+        "/users/{username}": {
+            "get": {
+                "description": "Get the settings of a user along with the storage used by the user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.userInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve user"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a user along with the credentials, tokens, access keys, shares, group memberships and upload policy of the user, and close the active sessions of the user. The home directory of the user is archived to a gzipped tarball in the archive directory of the server and purged after the grace period of the server (default), purged after the grace period, or kept. The data key of an encrypted user is deleted when the home directory is purged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "What to do with the home directory: archive (default), purge or keep",
+                        "name": "home",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "user deleted"
+                    },
+                    "400": {
+                        "description": "empty username or invalid home option"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to delete user"
+                    }
+                }
+            },
+            "patch": {
+                "description": "Update the settings of a user. Only the fields specified are changed and the changes apply to sessions started afterwards except the bandwidth limits which apply to the sessions in progress as well.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "User settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.updateUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.userInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or invalid request"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to update user"
+                    }
+                }
+            }
+        },
+        "/users/{username}/access-keys": {
+            "get": {
+                "description": "List all S3 access keys of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access-keys"
+                ],
+                "summary": "List user access keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.accessKeyInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "500": {
+                        "description": "unable to retrieve user access keys"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new S3 access key for a user. The secret access key is only returned once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access-keys"
+                ],
+                "summary": "Create user access key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createdAccessKeyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to create user access key"
+                    }
+                }
+            }
+        },
+        "/users/{username}/access-keys/{access_key_id}": {
+            "delete": {
+                "description": "Delete an S3 access key of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access-keys"
+                ],
+                "summary": "Delete user access key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Access key ID",
+                        "name": "access_key_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "access key deleted"
+                    },
+                    "400": {
+                        "description": "empty username or access key ID"
+                    },
+                    "404": {
+                        "description": "access key not found"
+                    },
+                    "500": {
+                        "description": "unable to delete user access key"
+                    }
+                }
+            }
+        },
+        "/users/{username}/credentials": {
+            "get": {
+                "description": "List all credentials of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "credentials"
+                ],
+                "summary": "List user credentials",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.credentialInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve user credentials"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new credential for a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "credentials"
+                ],
+                "summary": "Create user credential",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Credential information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.createUserCredentialRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createUserCredentialResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or invalid public key"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "409": {
+                        "description": "public key already exists"
+                    },
+                    "500": {
+                        "description": "unable to create user credential"
+                    }
+                }
+            }
+        },
+        "/users/{username}/credentials/{credential_id}": {
+            "delete": {
+                "description": "Delete a credential of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "credentials"
+                ],
+                "summary": "Delete user credential",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Credential ID",
+                        "name": "credential_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "credential deleted"
+                    },
+                    "400": {
+                        "description": "empty username or credential ID"
+                    },
+                    "404": {
+                        "description": "credential not found"
+                    },
+                    "500": {
+                        "description": "unable to delete user credential"
+                    }
+                }
+            }
+        },
+        "/users/{username}/shares": {
+            "get": {
+                "description": "List all share links of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "List shares",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.shareInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "500": {
+                        "description": "unable to retrieve shares"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a share link of a file or a directory in the home directory of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "Create share",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Share information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.createShareRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createdShareResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request or path does not exist"
+                    },
+                    "403": {
+                        "description": "share mode is not allowed by the access mode of the user"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to create share"
+                    }
+                }
+            }
+        },
+        "/users/{username}/shares/{share_id}": {
+            "delete": {
+                "description": "Delete a share link of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "Delete share",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Share ID",
+                        "name": "share_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "share deleted"
+                    },
+                    "400": {
+                        "description": "empty username or share ID"
+                    },
+                    "404": {
+                        "description": "share not found"
+                    },
+                    "500": {
+                        "description": "unable to delete share"
+                    }
+                }
+            }
+        },
+        "/users/{username}/shares/{share_id}/accesses": {
+            "get": {
+                "description": "List the access log of a share link",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shares"
+                ],
+                "summary": "List share accesses",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Share ID",
+                        "name": "share_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.shareAccessInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or share ID"
+                    },
+                    "404": {
+                        "description": "share not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve share accesses"
+                    }
+                }
+            }
+        },
+        "/users/{username}/tokens": {
+            "get": {
+                "description": "List all access tokens of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "List user tokens",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.tokenInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "500": {
+                        "description": "unable to retrieve user tokens"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new access token for a user. The token is only returned once and it is used as the password of HTTP basic authentication (or as a bearer token) for WebDAV and as the password of FTPS.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Create user token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Token information",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.createUserTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createdUserTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or invalid request"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to create user token"
+                    }
+                }
+            }
+        },
+        "/users/{username}/tokens/{token_id}": {
+            "delete": {
+                "description": "Delete an access token of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Delete user token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Token ID",
+                        "name": "token_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "token deleted"
+                    },
+                    "400": {
+                        "description": "empty username or token ID"
+                    },
+                    "404": {
+                        "description": "token not found"
+                    },
+                    "500": {
+                        "description": "unable to delete user token"
+                    }
+                }
+            }
+        },
+        "/users/{username}/trash": {
+            "get": {
+                "description": "List the versions of files of a user which have been removed or renamed",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "List recycle bin",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.versionInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or versioning is not enabled"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve versions"
+                    }
+                }
+            }
+        },
+        "/users/{username}/upload-policy": {
+            "get": {
+                "description": "Get the upload policy of a user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Get user upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "404": {
+                        "description": "user or policy not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve upload policy"
+                    }
+                }
+            },
+            "put": {
+                "description": "Create or replace the upload policy of a user. A file has to be allowed by the policy of the server, the policies of the groups of the user and the policy of the user. The change applies to sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Set user upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Upload policy",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.uploadPolicyInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or invalid request"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to save upload policy"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete the upload policy of a user. The change applies to sessions started afterwards.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policies"
+                ],
+                "summary": "Delete user upload policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "upload policy deleted"
+                    },
+                    "400": {
+                        "description": "empty username"
+                    },
+                    "404": {
+                        "description": "policy not found"
+                    },
+                    "500": {
+                        "description": "unable to delete upload policy"
+                    }
+                }
+            }
+        },
+        "/users/{username}/versions": {
+            "get": {
+                "description": "List the previous versions of a file, or of all files, of a user with the newest version of a file first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "List versions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the file",
+                        "name": "path",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.versionInfo"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "empty username or versioning is not enabled"
+                    },
+                    "404": {
+                        "description": "user not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve versions"
+                    }
+                }
+            }
+        },
+        "/users/{username}/versions/{version_id}": {
+            "delete": {
+                "description": "Delete a version of a file permanently",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Delete version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version ID",
+                        "name": "version_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the file",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "version deleted"
+                    },
+                    "400": {
+                        "description": "invalid request or versioning is not enabled"
+                    },
+                    "404": {
+                        "description": "user or version not found"
+                    },
+                    "500": {
+                        "description": "unable to delete version"
+                    }
+                }
+            }
+        },
+        "/users/{username}/versions/{version_id}/restore": {
+            "post": {
+                "description": "Restore a version of a file where the current content of the file, if any, is kept as a version",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "versions"
+                ],
+                "summary": "Restore version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version ID",
+                        "name": "version_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Path of the file",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "version restored"
+                    },
+                    "400": {
+                        "description": "invalid request or versioning is not enabled"
+                    },
+                    "403": {
+                        "description": "the user cannot write or the quota of the user is exceeded"
+                    },
+                    "404": {
+                        "description": "user or version not found"
+                    },
+                    "409": {
+                        "description": "a directory exists at the path"
+                    },
+                    "500": {
+                        "description": "unable to restore version"
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "description": "List the webhooks notified of events",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.webhookInfo"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "unable to retrieve webhooks"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a webhook receiving the specified types of events (file.uploaded, file.deleted, user.created and credential.added) where each request is signed with the secret in the X-FileServer-Signature header",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Create webhook",
+                "parameters": [
+                    {
+                        "description": "Webhook",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.createWebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.createdWebhookResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request"
+                    },
+                    "500": {
+                        "description": "unable to create webhook"
+                    }
+                }
+            }
+        },
+        "/webhooks/{webhook_id}": {
+            "delete": {
+                "description": "Delete a webhook along with its deliveries",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "webhook_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "webhook deleted"
+                    },
+                    "400": {
+                        "description": "empty webhook ID"
+                    },
+                    "404": {
+                        "description": "webhook not found"
+                    },
+                    "500": {
+                        "description": "unable to delete webhook"
+                    }
+                }
+            }
+        },
+        "/webhooks/{webhook_id}/deliveries": {
+            "get": {
+                "description": "List the delivery history of a webhook with the latest delivery first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhook deliveries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "webhook_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Either pending, delivered or failed",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number starting from 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of deliveries in a page (maximum 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.webhookDeliveryList"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid filters"
+                    },
+                    "404": {
+                        "description": "webhook not found"
+                    },
+                    "500": {
+                        "description": "unable to retrieve webhook deliveries"
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.accessKeyInfo": {
+            "type": "object",
+            "properties": {
+                "access_key_id": {
+                    "description": "AccessKeyID is the ID of the S3 access key",
+                    "type": "string",
+                    "example": "FSQ2M5XK7VJ4N3B6C8D2E"
+                },
+                "created_at": {
+                    "description": "CreatedAt is the time when the access key is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                }
+            }
+        },
+        "api.addGroupMemberRequest": {
+            "type": "object",
+            "required": [
+                "username"
+            ],
+            "properties": {
+                "access": {
+                    "description": "Access is either read-only or read-write (default) and it applies to all the shared folders of the group",
+                    "type": "string",
+                    "enum": [
+                        "read-only",
+                        "read-write"
+                    ],
+                    "example": "read-write"
+                },
+                "username": {
+                    "description": "Username is the username of the user to be added to the group",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.auditEventInfo": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "description": "Bytes is the number of bytes read or written",
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "created_at": {
+                    "description": "CreatedAt is the time of the operation and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "error": {
+                    "description": "Error is the error of a failed operation",
+                    "type": "string",
+                    "example": "permission denied"
+                },
+                "id": {
+                    "description": "ID is the ID of the event",
+                    "type": "integer",
+                    "example": 10
+                },
+                "operation": {
+                    "description": "Operation is the operation such as read, write, rename or remove",
+                    "type": "string",
+                    "example": "write"
+                },
+                "path": {
+                    "description": "Path is the path of the file or directory",
+                    "type": "string",
+                    "example": "/reports/2024.pdf"
+                },
+                "protocol": {
+                    "description": "Protocol is the protocol of the request",
+                    "type": "string",
+                    "example": "sftp"
+                },
+                "remote_address": {
+                    "description": "RemoteAddress is the address of the client",
+                    "type": "string",
+                    "example": "203.0.113.10:52144"
+                },
+                "result": {
+                    "description": "Result is either success or failure",
+                    "type": "string",
+                    "example": "success"
+                },
+                "target": {
+                    "description": "Target is the new path of a rename or the target of a link",
+                    "type": "string",
+                    "example": "/archive/2024.pdf"
+                },
+                "username": {
+                    "description": "Username is the user who requested the operation",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.auditEventList": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "description": "Events are the events of the page with the latest event first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.auditEventInfo"
+                    }
+                },
+                "page": {
+                    "description": "Page is the page number starting from 1",
+                    "type": "integer",
+                    "example": 1
+                },
+                "page_size": {
+                    "description": "PageSize is the number of events in a page",
+                    "type": "integer",
+                    "example": 50
+                },
+                "total": {
+                    "description": "Total is the number of events matching the filters",
+                    "type": "integer",
+                    "example": 1200
+                }
+            }
+        },
+        "api.createGroupRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "description": "Name is the name of the group",
+                    "type": "string",
+                    "example": "engineering"
+                }
+            }
+        },
+        "api.createShareRequest": {
+            "type": "object",
+            "required": [
+                "expires_in_seconds",
+                "path"
+            ],
+            "properties": {
+                "expires_in_seconds": {
+                    "description": "ExpiresInSeconds is the number of seconds before the share expires",
+                    "type": "integer",
+                    "example": 86400
+                },
+                "max_downloads": {
+                    "description": "MaxDownloads is the maximum number of downloads allowed and 0 means unlimited",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 3
+                },
+                "mode": {
+                    "description": "Mode is either read-only (downloads) or upload-only (drop box of a directory)",
+                    "type": "string",
+                    "enum": [
+                        "read-only",
+                        "upload-only"
+                    ],
+                    "example": "read-only"
+                },
+                "password": {
+                    "description": "Password is the optional password required to access the share",
+                    "type": "string",
+                    "example": "s3cret"
+                },
+                "path": {
+                    "description": "Path is the path of the file or directory to be shared, relative to the home directory of the user",
+                    "type": "string",
+                    "example": "/reports/2024.pdf"
+                }
+            }
+        },
+        "api.createSharedFolderRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "description": "Name is the name of the shared folder and it is unique across groups",
+                    "type": "string",
+                    "example": "project-x"
+                }
+            }
+        },
+        "api.createUserCredentialRequest": {
+            "type": "object",
+            "required": [
+                "public_key"
+            ],
+            "properties": {
+                "public_key": {
+                    "description": "PublicKey is the public key of the user",
+                    "type": "string",
+                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
+                }
+            }
+        },
+        "api.createUserCredentialResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the user credential is added and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "id": {
+                    "description": "ID is the ID of the user credential created",
+                    "type": "integer",
+                    "example": 10
+                },
+                "public_key": {
+                    "description": "PublicKey is the public key of the user",
+                    "type": "string",
+                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
+                },
+                "username": {
+                    "description": "Username is the username of the user",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.createUserRequest": {
+            "type": "object",
+            "required": [
+                "username"
+            ],
+            "properties": {
+                "access_mode": {
+                    "description": "AccessMode is either read-write (default), read-only (download only) or write-only (upload drop box)",
+                    "type": "string",
+                    "enum": [
+                        "read-write",
+                        "read-only",
+                        "write-only"
+                    ],
+                    "example": "read-write"
+                },
+                "download_rate_limit": {
+                    "description": "DownloadRateLimit is the maximum bytes per second of downloads of all SFTP sessions of the user where zero means unlimited",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 5242880
+                },
+                "quota_bytes": {
+                    "description": "QuotaBytes is the maximum total size of files where zero means unlimited and the default of the server applies if it is not specified",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 10737418240
+                },
+                "quota_files": {
+                    "description": "QuotaFiles is the maximum number of files and directories where zero means unlimited and the default of the server applies if it is not specified",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 100000
+                },
+                "storage_backend": {
+                    "description": "StorageBackend is where the files are stored and it is either local or s3 where the default of the server applies if it is not specified",
+                    "type": "string",
+                    "enum": [
+                        "local",
+                        "s3"
+                    ],
+                    "example": "local"
+                },
+                "upload_rate_limit": {
+                    "description": "UploadRateLimit is the maximum bytes per second of uploads of all SFTP sessions of the user where zero means unlimited",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1048576
+                },
+                "username": {
+                    "description": "Username is the username of the user",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.createUserTokenRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "expires_in_seconds": {
+                    "description": "ExpiresInSeconds is the number of seconds before the token expires and 0 means never",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 2592000
+                },
+                "name": {
+                    "description": "Name is a description of the token",
+                    "type": "string",
+                    "example": "laptop"
+                }
+            }
+        },
+        "api.createWebhookRequest": {
+            "type": "object",
+            "required": [
+                "events",
+                "url"
+            ],
+            "properties": {
+                "events": {
+                    "description": "Events are the types of events sent to the webhook",
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "file.uploaded",
+                        "file.deleted"
+                    ]
+                },
+                "secret": {
+                    "description": "Secret is the key signing the payloads where a random secret is generated if it is not specified",
+                    "type": "string",
+                    "minLength": 16,
+                    "example": "8a5c7f2e4b9d1a6c3e0f"
+                },
+                "url": {
+                    "description": "URL is the HTTP or HTTPS endpoint receiving the events",
+                    "type": "string",
+                    "example": "https://example.com/hooks/file-server"
+                }
+            }
+        },
+        "api.createdAccessKeyResponse": {
+            "type": "object",
+            "properties": {
+                "access_key_id": {
+                    "description": "AccessKeyID is the ID of the S3 access key",
+                    "type": "string",
+                    "example": "FSQ2M5XK7VJ4N3B6C8D2E"
+                },
+                "created_at": {
+                    "description": "CreatedAt is the time when the access key is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "secret_access_key": {
+                    "description": "SecretAccessKey is the secret of the access key and it is not retrievable afterwards",
+                    "type": "string",
+                    "example": "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+                }
+            }
+        },
+        "api.createdShareResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the share is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "download_count": {
+                    "description": "DownloadCount is the number of downloads so far",
+                    "type": "integer",
+                    "example": 1
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the time when the share expires and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-02T00:00:00Z"
+                },
+                "has_password": {
+                    "description": "HasPassword indicates whether a password is required to access the share",
+                    "type": "boolean",
+                    "example": true
+                },
+                "id": {
+                    "description": "ID is the ID of the share",
+                    "type": "integer",
+                    "example": 10
+                },
+                "max_downloads": {
+                    "description": "MaxDownloads is the maximum number of downloads allowed and 0 means unlimited",
+                    "type": "integer",
+                    "example": 3
+                },
+                "mode": {
+                    "description": "Mode is either read-only or upload-only",
+                    "type": "string",
+                    "example": "read-only"
+                },
+                "path": {
+                    "description": "Path is the path of the shared file or directory",
+                    "type": "string",
+                    "example": "/reports/2024.pdf"
+                },
+                "token": {
+                    "description": "Token is the secret token of the share and it is not retrievable afterwards",
+                    "type": "string",
+                    "example": "kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"
+                },
+                "url": {
+                    "description": "URL is the path of the share link relative to the API server",
+                    "type": "string",
+                    "example": "/s/kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"
+                }
+            }
+        },
+        "api.createdUserResponse": {
+            "type": "object",
+            "properties": {
+                "access_mode": {
+                    "description": "AccessMode is either read-write, read-only or write-only",
+                    "type": "string",
+                    "example": "read-write"
+                },
+                "username": {
+                    "description": "Username is the username of the user",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.createdUserTokenResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the token is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the time when the token expires and it has the format of RFC3339; it is empty if the token never expires",
+                    "type": "string",
+                    "example": "2024-02-01T00:00:00Z"
+                },
+                "id": {
+                    "description": "ID is the ID of the token",
+                    "type": "integer",
+                    "example": 10
+                },
+                "name": {
+                    "description": "Name is a description of the token",
+                    "type": "string",
+                    "example": "laptop"
+                },
+                "token": {
+                    "description": "Token is the secret of the token and it is not retrievable afterwards",
+                    "type": "string",
+                    "example": "kP3x9Qe2Zr7LmT0vYb4N1sWc8FhJ6uAd5GiOqRt2Xy0"
+                }
+            }
+        },
+        "api.createdWebhookResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time of creation of the webhook and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "events": {
+                    "description": "Events are the types of events sent to the webhook",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "file.uploaded",
+                        "file.deleted"
+                    ]
+                },
+                "id": {
+                    "description": "ID is the ID of the webhook",
+                    "type": "integer",
+                    "example": 1
+                },
+                "secret": {
+                    "description": "Secret is the key signing the payloads and it is only shown once",
+                    "type": "string",
+                    "example": "8a5c7f2e4b9d1a6c3e0f"
+                },
+                "url": {
+                    "description": "URL is the endpoint receiving the events",
+                    "type": "string",
+                    "example": "https://example.com/hooks/file-server"
+                }
+            }
+        },
+        "api.credentialInfo": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "ID is the ID of the user credential",
+                    "type": "integer",
+                    "example": 10
+                },
+                "public_key": {
+                    "description": "PublicKey is the public key of the user",
+                    "type": "string",
+                    "example": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDZ cardno:000607000043"
+                }
+            }
+        },
+        "api.groupInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the group is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "name": {
+                    "description": "Name is the name of the group",
+                    "type": "string",
+                    "example": "engineering"
+                }
+            }
+        },
+        "api.groupMemberInfo": {
+            "type": "object",
+            "properties": {
+                "access": {
+                    "description": "Access is either read-only or read-write",
+                    "type": "string",
+                    "example": "read-write"
+                },
+                "username": {
+                    "description": "Username is the username of the member",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.quarantinedFileInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time the file is moved to quarantine and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "error": {
+                    "description": "Error is the reason the file cannot be scanned",
+                    "type": "string",
+                    "example": "dial unix /run/clamav/clamd.ctl: connect: no such file or directory"
+                },
+                "id": {
+                    "description": "ID is the ID of the quarantined file",
+                    "type": "string",
+                    "example": "6f1ed002ab5595859014ebf0951522d9"
+                },
+                "path": {
+                    "description": "Path is the path the file is uploaded to and released to",
+                    "type": "string",
+                    "example": "/incoming/invoice.pdf"
+                },
+                "signature": {
+                    "description": "Signature is the name of the malware found",
+                    "type": "string",
+                    "example": "Eicar-Test-Signature"
+                },
+                "size": {
+                    "description": "Size is the size of the file in bytes",
+                    "type": "integer",
+                    "example": 68
+                },
+                "username": {
+                    "description": "Username is the user who uploaded the file",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.quarantinedFileList": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "description": "Files are the quarantined files in the page",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.quarantinedFileInfo"
+                    }
+                },
+                "page": {
+                    "description": "Page is the page number starting from 1",
+                    "type": "integer",
+                    "example": 1
+                },
+                "page_size": {
+                    "description": "PageSize is the number of files in a page",
+                    "type": "integer",
+                    "example": 50
+                },
+                "total": {
+                    "description": "Total is the number of files matching the filters",
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "api.sessionInfo": {
+            "type": "object",
+            "properties": {
+                "bytes_in": {
+                    "description": "BytesIn is the number of bytes received from the client",
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "bytes_out": {
+                    "description": "BytesOut is the number of bytes sent to the client",
+                    "type": "integer",
+                    "example": 2048
+                },
+                "command": {
+                    "description": "Command is the command executed by a command session",
+                    "type": "string",
+                    "example": "scp"
+                },
+                "connection_id": {
+                    "description": "ConnectionID is the ID of the SSH connection of an SFTP or command session",
+                    "type": "string",
+                    "example": "2c26b46b68ffc68f"
+                },
+                "id": {
+                    "description": "ID is the ID of the session",
+                    "type": "string",
+                    "example": "9f86d081884c7d65"
+                },
+                "kind": {
+                    "description": "Kind is either connection (SSH connection), sftp (SFTP subsystem), command (command such as scp or rsync) or ftps (FTPS session)",
+                    "type": "string",
+                    "example": "sftp"
+                },
+                "open_files": {
+                    "description": "OpenFiles are the paths of the files open in an SFTP session",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "/reports/2024.pdf"
+                    ]
+                },
+                "remote_address": {
+                    "description": "RemoteAddress is the address of the client",
+                    "type": "string",
+                    "example": "203.0.113.10:52144"
+                },
+                "started_at": {
+                    "description": "StartedAt is the time the session started and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "username": {
+                    "description": "Username is the user of the session which is empty for a connection not authenticated yet",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.shareAccessInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time of the access and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "id": {
+                    "description": "ID is the ID of the access record",
+                    "type": "integer",
+                    "example": 10
+                },
+                "operation": {
+                    "description": "Operation is either access, download or upload",
+                    "type": "string",
+                    "example": "download"
+                },
+                "path": {
+                    "description": "Path is the path relative to the share",
+                    "type": "string",
+                    "example": "/"
+                },
+                "remote_address": {
+                    "description": "RemoteAddress is the IP address of the client",
+                    "type": "string",
+                    "example": "203.0.113.10"
+                },
+                "status_code": {
+                    "description": "StatusCode is the HTTP status code of the response",
+                    "type": "integer",
+                    "example": 200
+                }
+            }
+        },
+        "api.shareInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the share is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "download_count": {
+                    "description": "DownloadCount is the number of downloads so far",
+                    "type": "integer",
+                    "example": 1
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the time when the share expires and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-02T00:00:00Z"
+                },
+                "has_password": {
+                    "description": "HasPassword indicates whether a password is required to access the share",
+                    "type": "boolean",
+                    "example": true
+                },
+                "id": {
+                    "description": "ID is the ID of the share",
+                    "type": "integer",
+                    "example": 10
+                },
+                "max_downloads": {
+                    "description": "MaxDownloads is the maximum number of downloads allowed and 0 means unlimited",
+                    "type": "integer",
+                    "example": 3
+                },
+                "mode": {
+                    "description": "Mode is either read-only or upload-only",
+                    "type": "string",
+                    "example": "read-only"
+                },
+                "path": {
+                    "description": "Path is the path of the shared file or directory",
+                    "type": "string",
+                    "example": "/reports/2024.pdf"
+                }
+            }
+        },
+        "api.sharedEntry": {
+            "type": "object",
+            "properties": {
+                "is_directory": {
+                    "description": "IsDirectory indicates whether the entry is a directory",
+                    "type": "boolean",
+                    "example": false
+                },
+                "modified_at": {
+                    "description": "ModifiedAt is the time of last modification and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "name": {
+                    "description": "Name is the name of the file or directory",
+                    "type": "string",
+                    "example": "2024.pdf"
+                },
+                "size": {
+                    "description": "Size is the size of the file in bytes",
+                    "type": "integer",
+                    "example": 1024
+                }
+            }
+        },
+        "api.sharedFolderInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the shared folder is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "name": {
+                    "description": "Name is the name of the shared folder",
+                    "type": "string",
+                    "example": "project-x"
+                },
+                "path": {
+                    "description": "Path is the virtual path where the shared folder is mounted for the members of the group",
+                    "type": "string",
+                    "example": "/shared/project-x"
+                }
+            }
+        },
+        "api.tokenInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the token is created and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is the time when the token expires and it has the format of RFC3339; it is empty if the token never expires",
+                    "type": "string",
+                    "example": "2024-02-01T00:00:00Z"
+                },
+                "id": {
+                    "description": "ID is the ID of the token",
+                    "type": "integer",
+                    "example": 10
+                },
+                "name": {
+                    "description": "Name is a description of the token",
+                    "type": "string",
+                    "example": "laptop"
+                }
+            }
+        },
+        "api.updateGroupMemberRequest": {
+            "type": "object",
+            "required": [
+                "access"
+            ],
+            "properties": {
+                "access": {
+                    "description": "Access is either read-only or read-write and it applies to all the shared folders of the group",
+                    "type": "string",
+                    "enum": [
+                        "read-only",
+                        "read-write"
+                    ],
+                    "example": "read-only"
+                }
+            }
+        },
+        "api.updateUserRequest": {
+            "type": "object",
+            "properties": {
+                "access_mode": {
+                    "description": "AccessMode is either read-write, read-only (download only) or write-only (upload drop box)",
+                    "type": "string",
+                    "enum": [
+                        "read-write",
+                        "read-only",
+                        "write-only"
+                    ],
+                    "example": "read-only"
+                },
+                "download_rate_limit": {
+                    "description": "DownloadRateLimit is the maximum bytes per second of downloads where zero means unlimited and it applies to the sessions in progress",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 5242880
+                },
+                "quota_bytes": {
+                    "description": "QuotaBytes is the maximum total size of files where zero means unlimited and -1 restores the default of the server",
+                    "type": "integer",
+                    "minimum": -1,
+                    "example": 10737418240
+                },
+                "quota_files": {
+                    "description": "QuotaFiles is the maximum number of files and directories where zero means unlimited and -1 restores the default of the server",
+                    "type": "integer",
+                    "minimum": -1,
+                    "example": 100000
+                },
+                "storage_backend": {
+                    "description": "StorageBackend is either local or s3 where an empty value restores the default of the server and existing files are not moved",
+                    "type": "string",
+                    "enum": [
+                        "",
+                        "local",
+                        "s3"
+                    ],
+                    "example": "s3"
+                },
+                "upload_rate_limit": {
+                    "description": "UploadRateLimit is the maximum bytes per second of uploads where zero means unlimited and it applies to the sessions in progress",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1048576
+                }
+            }
+        },
+        "api.uploadPolicyInfo": {
+            "type": "object",
+            "properties": {
+                "allowed_extensions": {
+                    "description": "AllowedExtensions are the only extensions of files which can be uploaded where every extension is allowed if it is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "pdf",
+                        "csv"
+                    ]
+                },
+                "allowed_mime_types": {
+                    "description": "AllowedMimeTypes are the only MIME types, detected from the content, of files which can be uploaded where every type is allowed if it is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "application/pdf",
+                        "text/*"
+                    ]
+                },
+                "denied_extensions": {
+                    "description": "DeniedExtensions are the extensions of files which cannot be uploaded",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "exe",
+                        "bat"
+                    ]
+                },
+                "denied_mime_types": {
+                    "description": "DeniedMimeTypes are the MIME types, detected from the content, of files which cannot be uploaded",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "application/x-msdownload"
+                    ]
+                },
+                "max_file_size": {
+                    "description": "MaxFileSize is the maximum size of a file in bytes where zero means unlimited",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 104857600
+                },
+                "updated_at": {
+                    "description": "UpdatedAt is the time the policy is last changed and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                }
+            }
+        },
+        "api.uploadPolicyRequest": {
+            "type": "object",
+            "properties": {
+                "allowed_extensions": {
+                    "description": "AllowedExtensions are the only extensions of files which can be uploaded where every extension is allowed if it is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "pdf",
+                        "csv"
+                    ]
+                },
+                "allowed_mime_types": {
+                    "description": "AllowedMimeTypes are the only MIME types, detected from the content, of files which can be uploaded where every type is allowed if it is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "application/pdf",
+                        "text/*"
+                    ]
+                },
+                "denied_extensions": {
+                    "description": "DeniedExtensions are the extensions of files which cannot be uploaded",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "exe",
+                        "bat"
+                    ]
+                },
+                "denied_mime_types": {
+                    "description": "DeniedMimeTypes are the MIME types, detected from the content, of files which cannot be uploaded",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "application/x-msdownload"
+                    ]
+                },
+                "max_file_size": {
+                    "description": "MaxFileSize is the maximum size of a file in bytes where zero means unlimited",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 104857600
+                }
+            }
+        },
+        "api.userInfo": {
+            "type": "object",
+            "properties": {
+                "access_mode": {
+                    "description": "AccessMode is either read-write, read-only or write-only",
+                    "type": "string",
+                    "example": "read-write"
+                },
+                "download_rate_limit": {
+                    "description": "DownloadRateLimit is the maximum bytes per second of downloads where zero means unlimited",
+                    "type": "integer",
+                    "example": 5242880
+                },
+                "encrypted": {
+                    "description": "Encrypted is whether the content of the files of the user is encrypted at rest",
+                    "type": "boolean",
+                    "example": true
+                },
+                "quota_bytes": {
+                    "description": "QuotaBytes is the maximum total size of files applied to the user where zero means unlimited",
+                    "type": "integer",
+                    "example": 10737418240
+                },
+                "quota_files": {
+                    "description": "QuotaFiles is the maximum number of files and directories applied to the user where zero means unlimited",
+                    "type": "integer",
+                    "example": 100000
+                },
+                "storage_backend": {
+                    "description": "StorageBackend is where the files of the user are stored",
+                    "type": "string",
+                    "example": "local"
+                },
+                "upload_rate_limit": {
+                    "description": "UploadRateLimit is the maximum bytes per second of uploads where zero means unlimited",
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "used_bytes": {
+                    "description": "UsedBytes is the total size of the files of the user",
+                    "type": "integer",
+                    "example": 52428800
+                },
+                "used_files": {
+                    "description": "UsedFiles is the number of files and directories of the user",
+                    "type": "integer",
+                    "example": 120
+                },
+                "username": {
+                    "description": "Username is the username of the user",
+                    "type": "string",
+                    "example": "alice"
+                }
+            }
+        },
+        "api.versionInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time when the version is kept and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "deleted": {
+                    "description": "Deleted indicates whether the file no longer exists",
+                    "type": "boolean",
+                    "example": false
+                },
+                "path": {
+                    "description": "Path is the path of the file",
+                    "type": "string",
+                    "example": "/reports/2024.pdf"
+                },
+                "size": {
+                    "description": "Size is the size of the content of the version in bytes",
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "version_id": {
+                    "description": "VersionID identifies the version among the versions of the file",
+                    "type": "string",
+                    "example": "20240101T000000.000000000Z"
+                }
+            }
+        },
+        "api.webhookDeliveryInfo": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "description": "Attempts is the number of attempts made",
+                    "type": "integer",
+                    "example": 1
+                },
+                "created_at": {
+                    "description": "CreatedAt is the time of the event and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "delivered_at": {
+                    "description": "DeliveredAt is the time of the successful attempt and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:01Z"
+                },
+                "event": {
+                    "description": "Event is the type of the event",
+                    "type": "string",
+                    "example": "file.uploaded"
+                },
+                "id": {
+                    "description": "ID is the ID of the delivery which is sent in the X-FileServer-Delivery header",
+                    "type": "integer",
+                    "example": 10
+                },
+                "last_error": {
+                    "description": "LastError is the error of the latest attempt",
+                    "type": "string",
+                    "example": "unexpected status code 503"
+                },
+                "last_status_code": {
+                    "description": "LastStatusCode is the HTTP status code of the latest attempt where zero means no response",
+                    "type": "integer",
+                    "example": 200
+                },
+                "next_attempt_at": {
+                    "description": "NextAttemptAt is the time of the next attempt of a pending delivery and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:30Z"
+                },
+                "payload": {
+                    "description": "Payload is the body sent to the webhook",
+                    "type": "string",
+                    "example": "{\"event\":\"file.uploaded\",\"created_at\":\"2024-01-01T00:00:00Z\",\"data\":{\"username\":\"alice\",\"path\":\"/reports/2024.pdf\",\"size\":1048576}}"
+                },
+                "status": {
+                    "description": "Status is either pending, delivered or failed",
+                    "type": "string",
+                    "example": "delivered"
+                }
+            }
+        },
+        "api.webhookDeliveryList": {
+            "type": "object",
+            "properties": {
+                "deliveries": {
+                    "description": "Deliveries are the deliveries of the page with the latest delivery first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.webhookDeliveryInfo"
+                    }
+                },
+                "page": {
+                    "description": "Page is the page number starting from 1",
+                    "type": "integer",
+                    "example": 1
+                },
+                "page_size": {
+                    "description": "PageSize is the number of deliveries in a page",
+                    "type": "integer",
+                    "example": 50
+                },
+                "total": {
+                    "description": "Total is the number of deliveries matching the filters",
+                    "type": "integer",
+                    "example": 1200
+                }
+            }
+        },
+        "api.webhookInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "CreatedAt is the time of creation of the webhook and it has the format of RFC3339",
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00Z"
+                },
+                "events": {
+                    "description": "Events are the types of events sent to the webhook",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "file.uploaded",
+                        "file.deleted"
+                    ]
+                },
+                "id": {
+                    "description": "ID is the ID of the webhook",
+                    "type": "integer",
+                    "example": 1
+                },
+                "url": {
+                    "description": "URL is the endpoint receiving the events",
+                    "type": "string",
+                    "example": "https://example.com/hooks/file-server"
                 }
             }
         }
@@ -0,0 +1,349 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dataConnectionTimeout = 30 * time.Second
+	maxPassivePortTries   = 16
+)
+
+func (s *session) handlePASV(arg string) {
+	ip := s.server.publicIP
+	if ip == nil {
+		if addr, ok := s.conn.LocalAddr().(*net.TCPAddr); ok {
+			ip = addr.IP.To4()
+		}
+	}
+	if ip == nil {
+		s.reply(425, "PASV is not available over IPv6, use EPSV")
+		return
+	}
+	port, err := s.listenPassive()
+	if err != nil {
+		s.logger.Error(
+			"unable to listen for passive data connection",
+			slog.String("error", err.Error()),
+		)
+		s.reply(425, "Unable to open passive connection")
+		return
+	}
+	s.reply(227, fmt.Sprintf(
+		"Entering Passive Mode (%d,%d,%d,%d,%d,%d)",
+		ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff,
+	))
+}
+
+func (s *session) handleEPSV(arg string) {
+	if strings.EqualFold(arg, "ALL") {
+		s.reply(200, "EPSV ALL accepted")
+		return
+	}
+	port, err := s.listenPassive()
+	if err != nil {
+		s.logger.Error(
+			"unable to listen for passive data connection",
+			slog.String("error", err.Error()),
+		)
+		s.reply(425, "Unable to open passive connection")
+		return
+	}
+	s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+}
+
+// handlePORT rejects active mode as the server would otherwise be able to
+// be used to connect to arbitrary hosts.
+func (s *session) handlePORT(arg string) {
+	s.reply(502, "Active mode is not supported, use PASV or EPSV")
+}
+
+// listenPassive listens on a random port of the passive port range and
+// returns the port.
+func (s *session) listenPassive() (int, error) {
+	s.closePassive()
+
+	start := s.server.config.PassivePortStart
+	count := s.server.config.PassivePortEnd - start + 1
+	var lastErr error
+	for i := 0; i < maxPassivePortTries; i++ {
+		port := start + rand.IntN(count)
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.mu.Lock()
+		s.passive = listener
+		s.mu.Unlock()
+		return port, nil
+	}
+	return 0, lastErr
+}
+
+func (s *session) closePassive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+}
+
+// openDataConnection accepts the data connection of the client on the
+// passive listener. Only connections from the address of the control
+// connection are accepted and the data connection is protected by TLS.
+func (s *session) openDataConnection() (net.Conn, error) {
+	s.mu.Lock()
+	listener := s.passive
+	s.mu.Unlock()
+	if listener == nil {
+		return nil, fmt.Errorf("no passive connection")
+	}
+	// the listener is kept as the passive listener while connections are
+	// accepted so that closing the session stops accepting
+	defer s.closePassive()
+
+	if tcpListener, ok := listener.(*net.TCPListener); ok {
+		if err := tcpListener.SetDeadline(time.Now().Add(dataConnectionTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.Equal(s.remoteIP) {
+			s.logger.Warn(
+				"rejected data connection from a different address",
+				slog.String("data_remote", conn.RemoteAddr().String()),
+			)
+			conn.Close()
+			continue
+		}
+
+		tlsConn := tls.Server(conn, s.server.tlsConfig)
+		if err := tlsConn.SetDeadline(time.Now().Add(dataConnectionTimeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// transfer sends data to the client over a data connection and replies the
+// result of the transfer on the control connection.
+func (s *session) transfer(send func(conn io.Writer) (int64, error)) (int64, error) {
+	conn, err := s.startTransfer()
+	if err != nil {
+		return 0, err
+	}
+	written, err := send(conn)
+	s.tracked.AddBytesOut(int(written))
+	return written, s.finishTransfer(conn, err)
+}
+
+// receive receives data from the client over a data connection and replies
+// the result of the transfer on the control connection.
+func (s *session) receive(read func(conn io.Reader) (int64, error)) (int64, error) {
+	conn, err := s.startTransfer()
+	if err != nil {
+		return 0, err
+	}
+	written, err := read(conn)
+	s.tracked.AddBytesIn(int(written))
+	return written, s.finishTransfer(conn, err)
+}
+
+func (s *session) startTransfer() (net.Conn, error) {
+	if !s.protected {
+		s.reply(521, "PROT P is required for data connections")
+		return nil, fmt.Errorf("data connection is not protected")
+	}
+	s.mu.Lock()
+	hasPassive := s.passive != nil
+	s.mu.Unlock()
+	if !hasPassive {
+		s.reply(425, "Use PASV or EPSV first")
+		return nil, fmt.Errorf("no passive connection")
+	}
+
+	s.reply(150, "Opening data connection")
+	conn, err := s.openDataConnection()
+	if err != nil {
+		s.reply(425, "Unable to open data connection")
+		return nil, err
+	}
+
+	// the data connection is closed along with the session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	s.data = conn
+	return conn, nil
+}
+
+func (s *session) finishTransfer(conn net.Conn, err error) error {
+	s.mu.Lock()
+	s.data = nil
+	s.mu.Unlock()
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.reply(426, "Connection closed, transfer aborted")
+		return err
+	}
+	s.reply(226, "Transfer complete")
+	return nil
+}
+
+func (s *session) handleLIST(arg string) {
+	s.list("LIST", arg, formatListLine)
+}
+
+func (s *session) handleNLST(arg string) {
+	s.list("NLST", arg, func(info os.FileInfo) string {
+		return info.Name()
+	})
+}
+
+func (s *session) handleMLSD(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Stat(name)
+	if err == nil && !info.IsDir() {
+		err = errNotDirectory
+	}
+	if err != nil {
+		s.recordOperation("MLSD", name, "", 0, err)
+		if errors.Is(err, errNotDirectory) {
+			s.reply(501, "Not a directory")
+		} else {
+			s.replyError(err)
+		}
+		return
+	}
+	s.list("MLSD", arg, func(info os.FileInfo) string {
+		return formatFacts(info) + " " + info.Name()
+	})
+}
+
+func (s *session) handleMLST(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Stat(name)
+	s.recordOperation("MLST", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.replyMultiline(250, "Listing "+name, []string{formatFacts(info) + " " + name}, "End")
+}
+
+// list sends the entries of a directory, or the file itself, with the
+// specified format over a data connection.
+func (s *session) list(command string, arg string, format func(info os.FileInfo) string) {
+	name := s.resolvePath(stripListOptions(arg))
+	written, err := s.listFiles(name, format)
+	s.recordOperation(command, name, "", written, err)
+}
+
+// listFiles sends the formatted entries of a directory, or the file itself,
+// and returns the number of bytes sent.
+func (s *session) listFiles(name string, format func(info os.FileInfo) string) (int64, error) {
+	info, err := s.fileSystem.Stat(name)
+	if err != nil {
+		s.replyError(err)
+		return 0, err
+	}
+
+	entries := []os.FileInfo{info}
+	if info.IsDir() {
+		entries, err = s.fileSystem.ReadDir(name)
+		if err != nil {
+			s.replyError(err)
+			return 0, err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(format(entry))
+		b.WriteString("\r\n")
+	}
+
+	return s.transfer(func(conn io.Writer) (int64, error) {
+		n, err := io.WriteString(conn, b.String())
+		return int64(n), err
+	})
+}
+
+// stripListOptions removes options like "-la" which are sent by some clients
+// as if LIST were ls.
+func stripListOptions(arg string) string {
+	fields := strings.Fields(arg)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+		fields = fields[1:]
+	}
+	return strings.Join(fields, " ")
+}
+
+// formatListLine formats a file in the way of "ls -l" which is understood by
+// most of the clients.
+func formatListLine(info os.FileInfo) string {
+	mode := []byte(info.Mode().Perm().String())
+	switch {
+	case info.IsDir():
+		mode[0] = 'd'
+	case info.Mode()&os.ModeSymlink != 0:
+		mode[0] = 'l'
+	}
+
+	modTime := info.ModTime()
+	timestamp := modTime.Format("Jan _2 15:04")
+	if time.Since(modTime) > 180*24*time.Hour || modTime.After(time.Now()) {
+		timestamp = modTime.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, info.Size(), timestamp, info.Name())
+}
+
+// formatFacts formats the facts of a file defined by RFC 3659.
+func formatFacts(info os.FileInfo) string {
+	fileType := "file"
+	perm := "adfrw"
+	if info.IsDir() {
+		fileType = "dir"
+		perm = "cdeflmp"
+	}
+	return fmt.Sprintf(
+		"type=%s;size=%d;modify=%s;perm=%s;",
+		fileType,
+		info.Size(),
+		info.ModTime().UTC().Format(modificationTimeFmt),
+		perm,
+	)
+}
@@ -0,0 +1,192 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexhokl/file-server/audit"
+	"gorm.io/gorm"
+)
+
+// Config is the configuration of the FTPS server.
+type Config struct {
+	// CertificateFile is the path to the PEM encoded certificate of the server
+	CertificateFile string
+
+	// KeyFile is the path to the PEM encoded private key of the server
+	KeyFile string
+
+	// ClientCAFile is the optional path to PEM encoded certificates of the
+	// certificate authorities trusted to issue client certificates. Client
+	// certificate authentication is disabled if it is empty.
+	ClientCAFile string
+
+	// PassivePortStart and PassivePortEnd define the range of ports used
+	// for passive data connections
+	PassivePortStart int
+	PassivePortEnd   int
+
+	// PublicHost is the optional IPv4 address announced in replies of PASV
+	PublicHost string
+}
+
+// Server is an FTP server which requires TLS (explicit FTPS) and serves the
+// jailed home directories of users.
+type Server struct {
+	dbConn             *gorm.DB
+	pathUsersDirectory string
+	auditLogger        *audit.Logger
+	config             Config
+	tlsConfig          *tls.Config
+	publicIP           net.IP
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*session]struct{}
+	closed   bool
+}
+
+// NewServer returns an FTPS server serving the home directories under the
+// specified users directory where the file operations of users are recorded
+// in the audit log.
+func NewServer(dbConn *gorm.DB, pathUsersDirectory string, auditLogger *audit.Logger, config Config) (*Server, error) {
+	certificate, err := tls.LoadX509KeyPair(config.CertificateFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load FTPS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read FTPS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in FTPS client CA file: %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if config.PassivePortStart <= 0 || config.PassivePortEnd < config.PassivePortStart || config.PassivePortEnd > 65535 {
+		return nil, fmt.Errorf("invalid passive port range: %d-%d", config.PassivePortStart, config.PassivePortEnd)
+	}
+
+	var publicIP net.IP
+	if config.PublicHost != "" {
+		publicIP = net.ParseIP(config.PublicHost).To4()
+		if publicIP == nil {
+			return nil, fmt.Errorf("public host is not an IPv4 address: %s", config.PublicHost)
+		}
+	}
+
+	return &Server{
+		dbConn:             dbConn,
+		pathUsersDirectory: pathUsersDirectory,
+		auditLogger:        auditLogger,
+		config:             config,
+		tlsConfig:          tlsConfig,
+		publicIP:           publicIP,
+		sessions:           map[*session]struct{}{},
+	}, nil
+}
+
+// ParsePortRange parses a port range in the format of "50000-50100".
+func ParsePortRange(value string) (int, int, error) {
+	startValue, endValue, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range: %s", value)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(startValue))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range: %s", value)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endValue))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range: %s", value)
+	}
+	return start, end, nil
+}
+
+// ListenAndServe listens on the specified address and serves FTP sessions
+// until the server is shut down.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return net.ErrClosed
+			}
+			slog.Error(
+				"unable to accept FTP connection",
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+
+		sess := newSession(s, conn)
+		if !s.trackSession(sess, true) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.trackSession(sess, false)
+			sess.serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting new connections and closes all sessions.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for sess := range s.sessions {
+		sess.close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Server) trackSession(sess *session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		s.sessions[sess] = struct{}{}
+	} else {
+		delete(s.sessions, sess)
+	}
+	return true
+}
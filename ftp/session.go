@@ -0,0 +1,773 @@
+package ftp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	tracking "github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	errNotRegularFile = errors.New("not a regular file")
+	errNotDirectory   = errors.New("not a directory")
+)
+
+const (
+	idleTimeout         = 5 * time.Minute
+	maxCommandLength    = 4096
+	modificationTimeFmt = "20060102150405"
+)
+
+// session is a control connection of an FTP client.
+type session struct {
+	server *Server
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+
+	remoteIP      net.IP
+	remoteAddress string
+	tlsEnabled    bool
+	protected     bool
+
+	user       string
+	fileSystem *storage.FileSystem
+	tracked    *tracking.Session
+	cwd        string
+	renameFrom string
+	restOffset int64
+	passive    net.Listener
+	data       net.Conn
+}
+
+// commandHandler handles a command with its argument.
+type commandHandler struct {
+	handle func(s *session, arg string)
+
+	// requiresLogin indicates the command is only available to users logged
+	// in
+	requiresLogin bool
+}
+
+var commands = map[string]commandHandler{
+	"AUTH": {handle: (*session).handleAUTH},
+	"PBSZ": {handle: (*session).handlePBSZ},
+	"PROT": {handle: (*session).handlePROT},
+	"USER": {handle: (*session).handleUSER},
+	"PASS": {handle: (*session).handlePASS},
+	"SYST": {handle: (*session).handleSYST},
+	"FEAT": {handle: (*session).handleFEAT},
+	"OPTS": {handle: (*session).handleOPTS},
+	"NOOP": {handle: (*session).handleNOOP},
+	"QUIT": {handle: (*session).handleQUIT},
+	"ABOR": {handle: (*session).handleABOR},
+	"TYPE": {handle: (*session).handleTYPE, requiresLogin: true},
+	"MODE": {handle: (*session).handleMODE, requiresLogin: true},
+	"STRU": {handle: (*session).handleSTRU, requiresLogin: true},
+	"PWD":  {handle: (*session).handlePWD, requiresLogin: true},
+	"XPWD": {handle: (*session).handlePWD, requiresLogin: true},
+	"CWD":  {handle: (*session).handleCWD, requiresLogin: true},
+	"XCWD": {handle: (*session).handleCWD, requiresLogin: true},
+	"CDUP": {handle: (*session).handleCDUP, requiresLogin: true},
+	"XCUP": {handle: (*session).handleCDUP, requiresLogin: true},
+	"PASV": {handle: (*session).handlePASV, requiresLogin: true},
+	"EPSV": {handle: (*session).handleEPSV, requiresLogin: true},
+	"PORT": {handle: (*session).handlePORT, requiresLogin: true},
+	"EPRT": {handle: (*session).handlePORT, requiresLogin: true},
+	"LIST": {handle: (*session).handleLIST, requiresLogin: true},
+	"NLST": {handle: (*session).handleNLST, requiresLogin: true},
+	"MLSD": {handle: (*session).handleMLSD, requiresLogin: true},
+	"MLST": {handle: (*session).handleMLST, requiresLogin: true},
+	"REST": {handle: (*session).handleREST, requiresLogin: true},
+	"RETR": {handle: (*session).handleRETR, requiresLogin: true},
+	"STOR": {handle: (*session).handleSTOR, requiresLogin: true},
+	"APPE": {handle: (*session).handleAPPE, requiresLogin: true},
+	"ALLO": {handle: (*session).handleALLO, requiresLogin: true},
+	"DELE": {handle: (*session).handleDELE, requiresLogin: true},
+	"MKD":  {handle: (*session).handleMKD, requiresLogin: true},
+	"XMKD": {handle: (*session).handleMKD, requiresLogin: true},
+	"RMD":  {handle: (*session).handleRMD, requiresLogin: true},
+	"XRMD": {handle: (*session).handleRMD, requiresLogin: true},
+	"RNFR": {handle: (*session).handleRNFR, requiresLogin: true},
+	"RNTO": {handle: (*session).handleRNTO, requiresLogin: true},
+	"SIZE": {handle: (*session).handleSIZE, requiresLogin: true},
+	"MDTM": {handle: (*session).handleMDTM, requiresLogin: true},
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	var remoteIP net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = addr.IP
+	}
+	return &session{
+		server:        server,
+		conn:          conn,
+		reader:        bufio.NewReaderSize(conn, maxCommandLength),
+		remoteIP:      remoteIP,
+		remoteAddress: conn.RemoteAddr().String(),
+		cwd:           "/",
+		logger: slog.With(
+			slog.String("remote", conn.RemoteAddr().String()),
+			slog.String("local", conn.LocalAddr().String()),
+		),
+	}
+}
+
+func (s *session) serve() {
+	s.logger.Info("ftp session started")
+	defer s.logger.Info("ftp session completed")
+	defer s.close()
+	defer func() {
+		if s.tracked != nil {
+			s.tracked.Unregister()
+		}
+	}()
+
+	s.reply(220, "file-server FTP service ready, AUTH TLS is required")
+
+	for {
+		line, err := s.readCommand()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				s.logger.Warn(
+					"unable to read FTP command",
+					slog.String("error", err.Error()),
+				)
+			}
+			return
+		}
+		if line == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(line, " ")
+		name = strings.ToUpper(name)
+		command, ok := commands[name]
+		if !ok {
+			s.reply(502, "Command not implemented")
+			continue
+		}
+		if command.requiresLogin && s.fileSystem == nil {
+			s.reply(530, "Not logged in")
+			continue
+		}
+		command.handle(s, arg)
+		if name == "QUIT" {
+			return
+		}
+		if name != "RNFR" {
+			s.renameFrom = ""
+		}
+		if name != "REST" {
+			s.restOffset = 0
+		}
+	}
+}
+
+func (s *session) readCommand() (string, error) {
+	if err := s.conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return "", err
+	}
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) > maxCommandLength {
+		return "", fmt.Errorf("command too long")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (s *session) reply(code int, message string) {
+	if _, err := fmt.Fprintf(s.conn, "%d %s\r\n", code, message); err != nil {
+		s.logger.Warn(
+			"unable to write FTP reply",
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// replyMultiline writes a reply with several lines where lines other than the
+// first one are indented by a space.
+func (s *session) replyMultiline(code int, first string, lines []string, last string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d-%s\r\n", code, first)
+	for _, line := range lines {
+		fmt.Fprintf(&b, " %s\r\n", line)
+	}
+	fmt.Fprintf(&b, "%d %s\r\n", code, last)
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		s.logger.Warn(
+			"unable to write FTP reply",
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (s *session) close() {
+	s.closePassive()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.conn.Close()
+	if s.data != nil {
+		s.data.Close()
+	}
+}
+
+// Close closes the control connection along with the data connection in
+// progress, if any, so that the session can be closed forcibly through the
+// session registry.
+func (s *session) Close() error {
+	s.close()
+	return nil
+}
+
+func (s *session) handleAUTH(arg string) {
+	mechanism := strings.ToUpper(arg)
+	if mechanism != "TLS" && mechanism != "TLS-C" && mechanism != "SSL" {
+		s.reply(504, "Only AUTH TLS is supported")
+		return
+	}
+	if s.tlsEnabled {
+		s.reply(503, "TLS is already enabled")
+		return
+	}
+	s.reply(234, "Proceed with TLS negotiation")
+
+	tlsConn := tls.Server(s.conn, s.server.tlsConfig)
+	if err := tlsConn.SetDeadline(time.Now().Add(idleTimeout)); err != nil {
+		s.close()
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		s.logger.Warn(
+			"unable to complete TLS handshake",
+			slog.String("error", err.Error()),
+		)
+		s.close()
+		return
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		s.close()
+		return
+	}
+
+	s.mu.Lock()
+	s.conn = tlsConn
+	s.mu.Unlock()
+	s.reader = bufio.NewReaderSize(tlsConn, maxCommandLength)
+	s.tlsEnabled = true
+}
+
+func (s *session) handlePBSZ(arg string) {
+	if !s.tlsEnabled {
+		s.reply(503, "PBSZ requires AUTH TLS")
+		return
+	}
+	s.reply(200, "PBSZ=0")
+}
+
+func (s *session) handlePROT(arg string) {
+	if !s.tlsEnabled {
+		s.reply(503, "PROT requires AUTH TLS")
+		return
+	}
+	switch strings.ToUpper(arg) {
+	case "P":
+		s.protected = true
+		s.reply(200, "Protection level set to Private")
+	case "C":
+		s.reply(536, "Data connections must be protected")
+	default:
+		s.reply(504, "Protection level not supported")
+	}
+}
+
+func (s *session) handleUSER(arg string) {
+	if !s.tlsEnabled {
+		s.reply(530, "AUTH TLS is required before login")
+		return
+	}
+	if s.fileSystem != nil {
+		s.reply(503, "Already logged in")
+		return
+	}
+	s.user = arg
+	if storage.ValidateUsername(arg) != nil {
+		s.reply(331, "Password required")
+		return
+	}
+
+	// a client certificate issued to the user is accepted in place of a
+	// password
+	if tlsConn, ok := s.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		if len(state.VerifiedChains) > 0 && state.PeerCertificates[0].Subject.CommonName == arg {
+			if s.login("certificate") {
+				s.reply(232, "User logged in, authorized by client certificate")
+			} else {
+				s.reply(530, "Login incorrect")
+			}
+			return
+		}
+	}
+
+	s.reply(331, "Password required")
+}
+
+func (s *session) handlePASS(arg string) {
+	if !s.tlsEnabled {
+		s.reply(530, "AUTH TLS is required before login")
+		return
+	}
+	if s.fileSystem != nil {
+		s.reply(503, "Already logged in")
+		return
+	}
+	if s.user == "" {
+		s.reply(503, "Login with USER first")
+		return
+	}
+	if storage.ValidateUsername(s.user) != nil {
+		s.reply(530, "Login incorrect")
+		return
+	}
+
+	if _, err := db.FindUserToken(s.server.dbConn, s.user, arg); err != nil {
+		if err != gorm.ErrRecordNotFound {
+			s.logger.Error(
+				"unable to retrieve user token",
+				slog.String("error", err.Error()),
+				slog.String("user", s.user),
+			)
+		}
+		s.logger.Warn("ftp login failed", slog.String("user", s.user))
+		s.reply(530, "Login incorrect")
+		return
+	}
+	if !s.login("password") {
+		s.reply(530, "Login incorrect")
+		return
+	}
+	s.reply(230, "User logged in")
+}
+
+// login opens the home directory of the user after the user has been
+// authenticated with the specified method.
+func (s *session) login(method string) bool {
+	fileSystem, err := storage.OpenUserFileSystem(s.server.dbConn, s.server.pathUsersDirectory, s.user)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			s.logger.Error(
+				"unable to open user directory",
+				slog.String("error", err.Error()),
+				slog.String("user", s.user),
+			)
+		}
+		return false
+	}
+
+	s.loggedIn(fileSystem, method)
+	return true
+}
+
+// loggedIn serves the home directory of the user and tracks the session
+// until it ends so that it can be listed and closed along with the other
+// sessions of the user.
+func (s *session) loggedIn(fileSystem *storage.FileSystem, method string) {
+	s.fileSystem = fileSystem
+	s.tracked = tracking.Register(tracking.KindFTPS, "", s.user, s.remoteAddress, s)
+	s.logger = s.logger.With(slog.String("user", s.user))
+	s.logger.Info("ftp login succeeded", slog.String("method", method))
+}
+
+func (s *session) handleSYST(arg string) {
+	s.reply(215, "UNIX Type: L8")
+}
+
+func (s *session) handleFEAT(arg string) {
+	s.replyMultiline(211, "Features:", []string{
+		"AUTH TLS",
+		"PBSZ",
+		"PROT",
+		"EPSV",
+		"MDTM",
+		"MLST type*;size*;modify*;perm*;",
+		"REST STREAM",
+		"SIZE",
+		"UTF8",
+	}, "End")
+}
+
+func (s *session) handleOPTS(arg string) {
+	if strings.EqualFold(arg, "UTF8 ON") {
+		s.reply(200, "UTF8 is always on")
+		return
+	}
+	s.reply(501, "Option not supported")
+}
+
+func (s *session) handleNOOP(arg string) {
+	s.reply(200, "OK")
+}
+
+func (s *session) handleQUIT(arg string) {
+	s.reply(221, "Goodbye")
+}
+
+func (s *session) handleABOR(arg string) {
+	s.closePassive()
+	s.reply(226, "No transfer in progress")
+}
+
+func (s *session) handleTYPE(arg string) {
+	switch strings.ToUpper(arg) {
+	case "I", "L 8":
+		s.reply(200, "Type set to I")
+	case "A", "A N":
+		// files are always transferred as they are stored
+		s.reply(200, "Type set to A")
+	default:
+		s.reply(504, "Type not supported")
+	}
+}
+
+func (s *session) handleMODE(arg string) {
+	if strings.ToUpper(arg) != "S" {
+		s.reply(504, "Only stream mode is supported")
+		return
+	}
+	s.reply(200, "Mode set to S")
+}
+
+func (s *session) handleSTRU(arg string) {
+	if strings.ToUpper(arg) != "F" {
+		s.reply(504, "Only file structure is supported")
+		return
+	}
+	s.reply(200, "Structure set to F")
+}
+
+func (s *session) handlePWD(arg string) {
+	s.reply(257, quotePath(s.cwd)+" is the current directory")
+}
+
+func (s *session) handleCWD(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Stat(name)
+	s.recordOperation("CWD", name, "", 0, err)
+	if err != nil || !info.IsDir() {
+		s.reply(550, "No such directory")
+		return
+	}
+	s.cwd = name
+	s.reply(250, "Directory changed to "+name)
+}
+
+func (s *session) handleCDUP(arg string) {
+	s.handleCWD("..")
+}
+
+func (s *session) handleREST(arg string) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		s.reply(501, "Invalid offset")
+		return
+	}
+	s.restOffset = offset
+	s.reply(350, fmt.Sprintf("Restarting at %d", offset))
+}
+
+func (s *session) handleRETR(arg string) {
+	name := s.resolvePath(arg)
+	written, err := s.retrieve(name)
+	s.recordOperation("RETR", name, "", written, err)
+}
+
+// retrieve sends the content of a file from the offset set by REST and
+// returns the number of bytes sent.
+func (s *session) retrieve(name string) (int64, error) {
+	file, err := s.fileSystem.Open(name)
+	if err != nil {
+		s.replyError(err)
+		return 0, err
+	}
+	defer file.Close()
+	s.tracked.OpenFile(name)
+	defer s.tracked.CloseFile(name)
+	info, err := file.Stat()
+	if err != nil {
+		s.replyError(err)
+		return 0, err
+	}
+	if info.IsDir() {
+		s.reply(550, "Not a regular file")
+		return 0, errNotRegularFile
+	}
+	if s.restOffset > 0 {
+		if _, err := file.Seek(s.restOffset, io.SeekStart); err != nil {
+			s.replyError(err)
+			return 0, err
+		}
+	}
+
+	return s.transfer(func(conn io.Writer) (int64, error) {
+		return io.Copy(conn, file)
+	})
+}
+
+func (s *session) handleSTOR(arg string) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if s.restOffset == 0 {
+		flag |= os.O_TRUNC
+	}
+	s.store("STOR", arg, flag, s.restOffset)
+}
+
+func (s *session) handleAPPE(arg string) {
+	s.store("APPE", arg, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0)
+}
+
+func (s *session) store(command string, arg string, flag int, offset int64) {
+	name := s.resolvePath(arg)
+	written, err := s.storeFile(name, flag, offset)
+	s.recordOperation(command, name, "", written, err)
+}
+
+// storeFile receives the content of a file written from the specified
+// offset and returns the number of bytes received.
+func (s *session) storeFile(name string, flag int, offset int64) (int64, error) {
+	file, err := s.fileSystem.OpenFile(name, flag, 0o644)
+	if err != nil {
+		s.replyError(err)
+		return 0, err
+	}
+	defer file.Close()
+	s.tracked.OpenFile(name)
+	defer s.tracked.CloseFile(name)
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			s.replyError(err)
+			return 0, err
+		}
+	}
+
+	return s.receive(func(conn io.Reader) (int64, error) {
+		return io.Copy(file, conn)
+	})
+}
+
+func (s *session) handleALLO(arg string) {
+	s.reply(202, "No storage allocation necessary")
+}
+
+func (s *session) handleDELE(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Lstat(name)
+	if err == nil && info.IsDir() {
+		s.recordOperation("DELE", name, "", 0, errNotRegularFile)
+		s.reply(550, "Not a regular file")
+		return
+	}
+	if err == nil {
+		err = s.fileSystem.Remove(name)
+	}
+	s.recordOperation("DELE", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(250, "File removed")
+}
+
+func (s *session) handleMKD(arg string) {
+	name := s.resolvePath(arg)
+	err := s.fileSystem.Mkdir(name, 0o755)
+	s.recordOperation("MKD", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(257, quotePath(name)+" created")
+}
+
+func (s *session) handleRMD(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Lstat(name)
+	if err == nil && !info.IsDir() {
+		s.recordOperation("RMD", name, "", 0, errNotDirectory)
+		s.reply(550, "Not a directory")
+		return
+	}
+	if err == nil {
+		err = s.fileSystem.Remove(name)
+	}
+	s.recordOperation("RMD", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(250, "Directory removed")
+}
+
+func (s *session) handleRNFR(arg string) {
+	name := s.resolvePath(arg)
+	if _, err := s.fileSystem.Lstat(name); err != nil {
+		s.replyError(err)
+		return
+	}
+	s.renameFrom = name
+	s.reply(350, "Ready for destination name")
+}
+
+func (s *session) handleRNTO(arg string) {
+	if s.renameFrom == "" {
+		s.reply(503, "RNFR is required first")
+		return
+	}
+	name := s.resolvePath(arg)
+	err := s.fileSystem.Rename(s.renameFrom, name)
+	s.recordOperation("RNTO", s.renameFrom, name, 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(250, "File renamed")
+}
+
+func (s *session) handleSIZE(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Stat(name)
+	s.recordOperation("SIZE", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	if info.IsDir() {
+		s.reply(550, "Not a regular file")
+		return
+	}
+	s.reply(213, strconv.FormatInt(info.Size(), 10))
+}
+
+func (s *session) handleMDTM(arg string) {
+	name := s.resolvePath(arg)
+	info, err := s.fileSystem.Stat(name)
+	s.recordOperation("MDTM", name, "", 0, err)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(213, info.ModTime().UTC().Format(modificationTimeFmt))
+}
+
+// resolvePath returns the virtual path of the specified argument relative to
+// the current directory.
+func (s *session) resolvePath(arg string) string {
+	if path.IsAbs(arg) {
+		return storage.CleanPath(arg)
+	}
+	return storage.CleanPath(path.Join(s.cwd, arg))
+}
+
+func (s *session) replyError(err error) {
+	var violation *storage.PolicyViolationError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.reply(550, "No such file or directory")
+	case errors.Is(err, os.ErrPermission):
+		s.reply(550, "Permission denied")
+	case errors.Is(err, os.ErrExist):
+		s.reply(550, "File exists")
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		s.reply(552, "Exceeded storage allocation")
+	case errors.As(err, &violation):
+		s.reply(553, "Upload policy violation: "+violation.Reason)
+	case errors.Is(err, storage.ErrQuarantined):
+		s.reply(550, "File moved to quarantine")
+	default:
+		s.reply(451, "Requested action aborted, local error in processing")
+	}
+}
+
+// auditOperations are the operations recorded in the audit log for the
+// commands, named like the operations of the other protocols.
+var auditOperations = map[string]string{
+	"RETR": "read",
+	"STOR": "write",
+	"APPE": "write",
+	"DELE": "remove",
+	"MKD":  "mkdir",
+	"RMD":  "rmdir",
+	"RNTO": "rename",
+	"LIST": "list",
+	"NLST": "list",
+	"MLSD": "list",
+	"MLST": "stat",
+	"SIZE": "stat",
+	"MDTM": "stat",
+	"CWD":  "stat",
+}
+
+// recordOperation logs a file operation of the user and records it in the
+// audit log, in the same way as the other protocols do. The target is the
+// new path of a rename.
+func (s *session) recordOperation(command string, name string, target string, size int64, err error) {
+	attrs := []any{
+		slog.String("command", command),
+		slog.String("path", name),
+		slog.Bool("success", err == nil),
+	}
+	if target != "" {
+		attrs = append(attrs, slog.String("target", target))
+	}
+	if size > 0 {
+		attrs = append(attrs, slog.Int64("bytes", size))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	s.logger.Info("ftp operation", attrs...)
+
+	event := db.AuditEvent{
+		Username:      s.user,
+		RemoteAddress: s.remoteAddress,
+		Protocol:      "ftps",
+		Operation:     auditOperations[command],
+		Path:          name,
+		Target:        target,
+		Bytes:         size,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.server.auditLogger.Record(event)
+}
+
+// quotePath returns a path quoted in the way of RFC 959 where double quotes
+// are doubled.
+func quotePath(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
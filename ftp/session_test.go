@@ -0,0 +1,289 @@
+package ftp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/file-server/audit"
+	tracking "github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+)
+
+// testClient sends FTP commands to a session served over a loopback
+// connection.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestServer returns a server with a self-signed certificate recording
+// audit events to the specified file.
+func newTestServer(t *testing.T, pathAuditLog string) *Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLogger, err := audit.NewFileLogger(pathAuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLogger.Close() })
+
+	return &Server{
+		auditLogger: auditLogger,
+		config: Config{
+			PassivePortStart: 40000,
+			PassivePortEnd:   44999,
+		},
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{certificate}, PrivateKey: key}},
+			MinVersion:   tls.VersionTLS12,
+		},
+		sessions: map[*session]struct{}{},
+	}
+}
+
+// newTestClient serves a session of alice logged in to the specified file
+// system. The control connection is not protected by TLS as the commands
+// rather than TLS are tested.
+func newTestClient(t *testing.T, server *Server, fileSystem *storage.FileSystem) (*testClient, <-chan struct{}) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess := newSession(server, serverConn)
+	sess.tlsEnabled = true
+	sess.protected = true
+	sess.user = "alice"
+	sess.loggedIn(fileSystem, "test")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sess.serve()
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	c.expect(220)
+	return c, done
+}
+
+// expect reads a reply and fails the test if the code of the reply is not
+// the specified one.
+func (c *testClient) expect(code int) string {
+	c.t.Helper()
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, strconv.Itoa(code)+" ") {
+		c.t.Fatalf("reply = %q, expected %d", line, code)
+	}
+	return line
+}
+
+func (c *testClient) command(code int, format string, args ...any) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, format+"\r\n", args...); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.expect(code)
+}
+
+// openData opens a passive data connection and starts the specified
+// transfer command on it.
+func (c *testClient) openData(format string, args ...any) *tls.Conn {
+	c.t.Helper()
+	reply := c.command(229, "EPSV")
+	port := strings.TrimSuffix(reply[strings.Index(reply, "|||")+3:], "|)")
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.command(150, format, args...)
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		c.t.Fatal(err)
+	}
+	return tlsConn
+}
+
+func readAuditLog(t *testing.T, pathAuditLog string) []map[string]any {
+	t.Helper()
+	content, err := os.ReadFile(pathAuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestSessionRecordsOperations(t *testing.T) {
+	pathAuditLog := filepath.Join(t.TempDir(), "audit.log")
+	server := newTestServer(t, pathAuditLog)
+	fileSystem := storage.NewBackendFileSystem(storage.NewMemoryBackend())
+	c, _ := newTestClient(t, server, fileSystem)
+
+	c.command(257, "MKD docs")
+	conn := c.openData("STOR docs/a.txt")
+	if _, err := io.WriteString(conn, "content"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	c.expect(226)
+	c.command(350, "RNFR docs/a.txt")
+	c.command(250, "RNTO docs/b.txt")
+	conn = c.openData("RETR /docs/b.txt")
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	c.expect(226)
+	c.command(550, "DELE docs")
+	c.command(221, "QUIT")
+
+	expected := []struct {
+		operation string
+		path      string
+		target    string
+		bytes     float64
+		result    string
+	}{
+		{"mkdir", "/docs", "", 0, "success"},
+		{"write", "/docs/a.txt", "", 7, "success"},
+		{"rename", "/docs/a.txt", "/docs/b.txt", 0, "success"},
+		{"read", "/docs/b.txt", "", 7, "success"},
+		{"remove", "/docs", "", 0, "failure"},
+	}
+	events := readAuditLog(t, pathAuditLog)
+	if len(events) != len(expected) {
+		t.Fatalf("number of events = %d, expected %d", len(events), len(expected))
+	}
+	for i, e := range expected {
+		event := events[i]
+		if event["protocol"] != "ftps" || event["username"] != "alice" {
+			t.Errorf("event %d is recorded as %v of %v", i, event["protocol"], event["username"])
+		}
+		target, _ := event["target"].(string)
+		if event["operation"] != e.operation || event["path"] != e.path || target != e.target ||
+			event["bytes"] != e.bytes || event["result"] != e.result {
+			t.Errorf("event %d = %v, expected %+v", i, event, e)
+		}
+	}
+}
+
+// findSession returns the FTPS session of the specified user, if any.
+func findSession(username string) (tracking.Info, bool) {
+	for _, info := range tracking.List() {
+		if info.Kind == tracking.KindFTPS && info.Username == username {
+			return info, true
+		}
+	}
+	return tracking.Info{}, false
+}
+
+func TestSessionClosedWithSessionsOfUser(t *testing.T) {
+	server := newTestServer(t, filepath.Join(t.TempDir(), "audit.log"))
+	fileSystem := storage.NewBackendFileSystem(storage.NewMemoryBackend())
+	file, err := fileSystem.OpenFile("/large", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(make([]byte, 16<<20)); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	c, done := newTestClient(t, server, fileSystem)
+
+	// the transfer stalls as the client stops reading
+	conn := c.openData("RETR /large")
+	if _, err := io.ReadFull(conn, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := findSession("alice")
+	if !ok {
+		t.Fatal("session is not tracked")
+	}
+	if !slices.Equal(info.OpenFiles, []string{"/large"}) {
+		t.Errorf("open files = %v", info.OpenFiles)
+	}
+
+	// as the sessions of a deleted user are closed
+	count, err := tracking.CloseUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("number of sessions closed = %d", count)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not closed")
+	}
+	if _, ok := findSession("alice"); ok {
+		t.Error("session is still tracked after it is closed")
+	}
+	for _, r := range []net.Conn{conn, c.conn} {
+		if err := r.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, r); errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("connection %s is not closed", r.LocalAddr())
+		}
+	}
+}
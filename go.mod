@@ -6,6 +6,7 @@ require (
 	github.com/gliderlabs/ssh v0.3.7
 	github.com/pkg/sftp v1.13.6
 	github.com/swaggo/swag v1.16.2
+	golang.org/x/net v0.26.0
 )
 
 require (
@@ -48,7 +49,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
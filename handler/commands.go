@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+// commandContext is the environment of a command executed in an SSH
+// session. Commands are implemented in Go and only have access to the jailed
+// file system of the user.
+type commandContext struct {
+	ctx        context.Context
+	username   string
+	fileSystem *storage.FileSystem
+	rsyncPath  string
+	args       []string
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	logger     *slog.Logger
+}
+
+// command runs with the context and returns the exit status.
+type command func(c *commandContext) int
+
+// commands are the commands which can be executed through SSH.
+var commands = map[string]command{
+	"scp":       runSCPCommand,
+	"sha256sum": runSHA256Sum,
+	"md5sum":    runMD5Sum,
+	"du":        runDu,
+	"df":        runDf,
+	"ls":        runLs,
+	"whoami":    runWhoami,
+	"rsync":     runRsync,
+}
+
+func runSCPCommand(c *commandContext) int {
+	return runSCP(c.fileSystem, c.args, readWriter{c.stdin, c.stdout}, c.stderr, c.logger)
+}
+
+func runSHA256Sum(c *commandContext) int {
+	return runChecksum(c, "sha256sum", sha256.New)
+}
+
+func runMD5Sum(c *commandContext) int {
+	return runChecksum(c, "md5sum", md5.New)
+}
+
+// runChecksum prints checksums in the format of coreutils so that the output
+// can be compared with the one generated locally.
+func runChecksum(c *commandContext, name string, newHash func() hash.Hash) int {
+	_, operands, err := parseFlags(c.args, "")
+	if err != nil {
+		return c.fail(name, err)
+	}
+	if len(operands) == 0 {
+		operands = []string{"-"}
+	}
+
+	status := 0
+	for _, operand := range operands {
+		h := newHash()
+		if operand == "-" {
+			if _, err := io.Copy(h, c.stdin); err != nil {
+				status = c.fail(name, fmt.Errorf("-: %s", describeError(err)))
+				continue
+			}
+		} else if err := hashFile(c.fileSystem, operand, h); err != nil {
+			status = c.fail(name, fmt.Errorf("%s: %s", operand, describeError(err)))
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%s  %s\n", hex.EncodeToString(h.Sum(nil)), operand)
+	}
+	return status
+}
+
+func hashFile(fileSystem *storage.FileSystem, name string, h hash.Hash) error {
+	file, err := fileSystem.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return syscall.EISDIR
+	}
+	_, err = io.Copy(h, file)
+	return err
+}
+
+// runDu prints the usage of directories in the way of du where sizes are
+// apparent sizes of files.
+func runDu(c *commandContext) int {
+	flags, operands, err := parseFlags(c.args, "sahbk")
+	if err != nil {
+		return c.fail("du", err)
+	}
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+	format := formatKilobytes
+	if flags['h'] {
+		format = formatHumanReadable
+	} else if flags['b'] {
+		format = formatBytes
+	}
+
+	status := 0
+	for _, operand := range operands {
+		name := storage.CleanPath(operand)
+		info, err := c.fileSystem.Lstat(name)
+		if err != nil {
+			status = c.fail("du", fmt.Errorf("cannot access '%s': %s", operand, describeError(err)))
+			continue
+		}
+
+		// directories are printed after all of their children like du does
+		var visit func(current string, info os.FileInfo) int64
+		visit = func(current string, info os.FileInfo) int64 {
+			var total int64
+			if info.IsDir() {
+				entries, err := c.fileSystem.ReadDir(current)
+				if err != nil {
+					status = c.fail("du", fmt.Errorf("cannot read directory '%s': %s", displayPath(operand, name, current), describeError(err)))
+				}
+				for _, entry := range entries {
+					total += visit(path.Join(current, entry.Name()), entry)
+				}
+			} else if info.Mode().IsRegular() {
+				total = info.Size()
+			}
+			if current == name || (!flags['s'] && (info.IsDir() || flags['a'])) {
+				fmt.Fprintf(c.stdout, "%s\t%s\n", format(total), displayPath(operand, name, current))
+			}
+			return total
+		}
+		visit(name, info)
+	}
+	return status
+}
+
+// runDf prints the space available to the user.
+func runDf(c *commandContext) int {
+	flags, operands, err := parseFlags(c.args, "hk")
+	if err != nil {
+		return c.fail("df", err)
+	}
+	if len(operands) > 0 {
+		return c.fail("df", fmt.Errorf("paths are not supported"))
+	}
+
+	// the size is the quota of the user if there is one
+	capacity, err := c.fileSystem.Capacity()
+	if err != nil {
+		return c.fail("df", fmt.Errorf("unable to retrieve disk space: %s", describeError(err)))
+	}
+
+	used := capacity.Bytes
+	available := capacity.FreeBytes
+	total := capacity.TotalBytes
+	format := formatKilobytes
+	header := "1K-blocks"
+	if flags['h'] {
+		format = formatHumanReadable
+		header = "Size"
+	}
+	percentage := 0
+	if total > 0 {
+		percentage = int((used*100 + total - 1) / total)
+	}
+
+	fmt.Fprintf(c.stdout, "Filesystem %10s %10s %10s Use%% Mounted on\n", header, "Used", "Available")
+	fmt.Fprintf(c.stdout, "home       %10s %10s %10s %3d%% /\n", format(total), format(used), format(available), percentage)
+	return 0
+}
+
+// runLs lists directories in the way of ls when its output is not a
+// terminal.
+func runLs(c *commandContext) int {
+	flags, operands, err := parseFlags(c.args, "laAdh1")
+	if err != nil {
+		return c.fail("ls", err)
+	}
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+
+	status := 0
+	var files []os.FileInfo
+	var directories []string
+	for _, operand := range operands {
+		info, err := c.fileSystem.Lstat(operand)
+		if err != nil {
+			status = c.fail("ls", fmt.Errorf("cannot access '%s': %s", operand, describeError(err)))
+			continue
+		}
+		if info.IsDir() && !flags['d'] {
+			directories = append(directories, operand)
+			continue
+		}
+		files = append(files, renamedFileInfo{FileInfo: info, name: operand})
+	}
+
+	c.printEntries(files, flags)
+	for i, directory := range directories {
+		entries, err := c.fileSystem.ReadDir(directory)
+		if err != nil {
+			status = c.fail("ls", fmt.Errorf("cannot open directory '%s': %s", directory, describeError(err)))
+			continue
+		}
+		var visible []os.FileInfo
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") && !flags['a'] && !flags['A'] {
+				continue
+			}
+			visible = append(visible, entry)
+		}
+		sort.Slice(visible, func(i, j int) bool {
+			return visible[i].Name() < visible[j].Name()
+		})
+
+		if len(operands) > 1 {
+			if i > 0 || len(files) > 0 {
+				fmt.Fprintln(c.stdout)
+			}
+			fmt.Fprintf(c.stdout, "%s:\n", directory)
+		}
+		if flags['l'] {
+			var total int64
+			for _, entry := range visible {
+				total += (entry.Size() + 1023) / 1024
+			}
+			fmt.Fprintf(c.stdout, "total %d\n", total)
+		}
+		c.printEntries(visible, flags)
+	}
+	return status
+}
+
+func (c *commandContext) printEntries(entries []os.FileInfo, flags map[rune]bool) {
+	for _, entry := range entries {
+		if !flags['l'] {
+			fmt.Fprintln(c.stdout, entry.Name())
+			continue
+		}
+		size := formatBytes(entry.Size())
+		if flags['h'] {
+			size = formatHumanReadable(entry.Size())
+		}
+		timestamp := entry.ModTime().Format("Jan _2 15:04")
+		if time.Since(entry.ModTime()) > 180*24*time.Hour || entry.ModTime().After(time.Now()) {
+			timestamp = entry.ModTime().Format("Jan _2  2006")
+		}
+		fmt.Fprintf(c.stdout, "%s 1 %s %s %8s %s %s\n", formatMode(entry.Mode()), c.username, c.username, size, timestamp, entry.Name())
+	}
+}
+
+func runWhoami(c *commandContext) int {
+	if len(c.args) > 0 {
+		return c.fail("whoami", fmt.Errorf("extra operand '%s'", c.args[0]))
+	}
+	fmt.Fprintln(c.stdout, c.username)
+	return 0
+}
+
+// fail prints the error of a command to stderr and returns the exit status
+// of a failed command.
+func (c *commandContext) fail(name string, err error) int {
+	fmt.Fprintf(c.stderr, "%s: %s\n", name, err)
+	return 1
+}
+
+// parseFlags parses single letter flags which can be combined like "-la".
+func parseFlags(args []string, allowed string) (map[rune]bool, []string, error) {
+	flags := map[rune]bool{}
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			operands = append(operands, arg)
+			continue
+		}
+		for _, flag := range arg[1:] {
+			if !strings.ContainsRune(allowed, flag) {
+				return nil, nil, fmt.Errorf("invalid option -- '%c'", flag)
+			}
+			flags[flag] = true
+		}
+	}
+	return flags, operands, nil
+}
+
+// displayPath returns a path found below an operand in the way it is
+// written by the user.
+func displayPath(operand string, name string, current string) string {
+	if current == name {
+		return operand
+	}
+	relative := strings.TrimPrefix(strings.TrimPrefix(current, name), "/")
+	return strings.TrimSuffix(operand, "/") + "/" + relative
+}
+
+func formatKilobytes(size int64) string {
+	return fmt.Sprintf("%d", (size+1023)/1024)
+}
+
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%d", size)
+}
+
+func formatHumanReadable(size int64) string {
+	const units = "KMGTPE"
+	if size < 1024 {
+		return fmt.Sprintf("%d", size)
+	}
+	value := float64(size)
+	unit := -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%c", value, units[unit])
+	}
+	return fmt.Sprintf("%.0f%c", value, units[unit])
+}
+
+func formatMode(mode os.FileMode) string {
+	s := []byte(mode.Perm().String())
+	switch {
+	case mode.IsDir():
+		s[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		s[0] = 'l'
+	}
+	return string(s)
+}
+
+// renamedFileInfo is the information of a file shown with the path given by
+// the user.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i renamedFileInfo) Name() string {
+	return i.name
+}
+
+// readWriter combines the input and the output of a session.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
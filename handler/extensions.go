@@ -0,0 +1,566 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// SFTP packet types used by extensionStream
+	sshFxpVersion       = 2
+	sshFxpOpen          = 3
+	sshFxpClose         = 4
+	sshFxpRead          = 5
+	sshFxpWrite         = 6
+	sshFxpFstat         = 8
+	sshFxpFsetstat      = 10
+	sshFxpReaddir       = 12
+	sshFxpStatus        = 101
+	sshFxpHandle        = 102
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+
+	// SFTP status codes
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxOpUnsupported    = 8
+
+	// maxPacketLength is the maximum length of a packet accepted by the
+	// request server of pkg/sftp
+	maxPacketLength = 256 * 1024
+
+	// maxReadLength is the maximum length of data the request server of
+	// pkg/sftp returns for a read
+	maxReadLength = 32 * 1024
+
+	// maxWriteLength is the maximum length of data of a write which fits in
+	// a packet along with the handle
+	maxWriteLength = maxPacketLength - 1024
+
+	// copyDataBufferSize is the size of the chunks copied by copy-data
+	copyDataBufferSize = 32 * 1024
+)
+
+// extensions are the SFTP extensions served by extensionStream which are
+// advertised along with the ones of pkg/sftp.
+var extensions = [][2]string{
+	{"copy-data", "1"},
+	{"fsync@openssh.com", "1"},
+	{"limits@openssh.com", "1"},
+}
+
+// handleTable keeps the files opened by the request handler by the handles
+// the request server returns for them, so that the extensions working on
+// handles can be served outside of the request server. It also tracks the
+// requests on the handles which the request server has not answered yet so
+// that the extensions see the files as the requests before them left them.
+//
+// Open requests are passed to the request server one at a time so that the
+// file opened by the request handler is the file of the open request in
+// progress, and the handle in the response to the request is the handle of
+// the file.
+type handleTable struct {
+	mutex sync.Mutex
+
+	// changed is signalled when a request is answered or an extension is
+	// done with its handles
+	changed *sync.Cond
+
+	files map[string]*auditedFile
+
+	// opening is whether an open request is in progress, openingID is the
+	// ID of it and openingFile is the file opened for it, if any
+	opening     bool
+	openingID   uint32
+	openingFile *auditedFile
+
+	// outstanding are the handles of the requests passed to the request
+	// server by the IDs of the requests and pending is the number of them
+	// on every handle
+	outstanding map[uint32]string
+	pending     map[string]int
+
+	// busy is the number of extensions working on every handle
+	busy map[string]int
+}
+
+func newHandleTable() *handleTable {
+	t := &handleTable{
+		files:       map[string]*auditedFile{},
+		outstanding: map[uint32]string{},
+		pending:     map[string]int{},
+		busy:        map[string]int{},
+	}
+	t.changed = sync.NewCond(&t.mutex)
+	return t
+}
+
+// open records an open request passed to the request server once the open
+// request before it is answered.
+func (t *handleTable) open(id uint32) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.opening {
+		t.changed.Wait()
+	}
+	t.opening = true
+	t.openingID = id
+	t.openingFile = nil
+}
+
+// opened records a file opened by the request handler for the open request
+// in progress.
+func (t *handleTable) opened(file *auditedFile) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.opening {
+		t.openingFile = file
+	}
+}
+
+// closed forgets a file closed.
+func (t *handleTable) closed(file *auditedFile) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.files[file.handle] == file {
+		delete(t.files, file.handle)
+	}
+}
+
+// get returns the file of the specified handle.
+func (t *handleTable) get(handle string) (*auditedFile, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	file, ok := t.files[handle]
+	return file, ok
+}
+
+// request records a request on a handle passed to the request server. A
+// request on a handle an extension works on waits until the extension is
+// done so that the requests on a handle are not reordered.
+func (t *handleTable) request(id uint32, handle string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.busy[handle] > 0 {
+		t.changed.Wait()
+	}
+	t.outstanding[id] = handle
+	t.pending[handle]++
+}
+
+// response records the response of the request server to a request where
+// the handle is the handle returned, if any.
+func (t *handleTable) response(packetType byte, id uint32, handle string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.opening && id == t.openingID {
+		if packetType == sshFxpHandle && t.openingFile != nil {
+			t.openingFile.handle = handle
+			t.files[handle] = t.openingFile
+		}
+		t.opening = false
+		t.openingFile = nil
+		t.changed.Broadcast()
+		return
+	}
+	requested, ok := t.outstanding[id]
+	if !ok {
+		return
+	}
+	delete(t.outstanding, id)
+	if t.pending[requested]--; t.pending[requested] == 0 {
+		delete(t.pending, requested)
+	}
+	t.changed.Broadcast()
+}
+
+// acquire marks the handles as used by an extension so that the requests
+// on them received later wait for the extension.
+func (t *handleTable) acquire(handles ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, handle := range handles {
+		t.busy[handle]++
+	}
+}
+
+// wait waits until the requests on the handles received before have been
+// answered.
+func (t *handleTable) wait(handles ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for slices.ContainsFunc(handles, func(handle string) bool { return t.pending[handle] > 0 }) {
+		t.changed.Wait()
+	}
+}
+
+// release marks the handles as no longer used by an extension.
+func (t *handleTable) release(handles ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, handle := range handles {
+		if t.busy[handle]--; t.busy[handle] == 0 {
+			delete(t.busy, handle)
+		}
+	}
+	t.changed.Broadcast()
+}
+
+// extensionStream serves the SFTP extensions which the request server of
+// pkg/sftp does not implement, copy-data, fsync@openssh.com and
+// limits@openssh.com, by answering their requests before they reach the
+// request server and by adding them to the extensions the request server
+// advertises. The extensions work on the files opened by the request handler
+// so the jail, the quota and the access of the user apply as usual.
+// copy-data and fsync@openssh.com are served in the background so that a
+// long copy does not hold up the requests on other handles.
+type extensionStream struct {
+	conn    io.ReadWriteCloser
+	handler *requestHandler
+
+	// incoming is the rest of the packet being read by the request server
+	incoming []byte
+
+	// writeMutex keeps the packets sent whole as both the request server and
+	// the extensions send responses
+	writeMutex sync.Mutex
+
+	// outgoing is the part of the packet being written by the request
+	// server which is not complete yet
+	outgoing []byte
+}
+
+func newExtensionStream(conn io.ReadWriteCloser, handler *requestHandler) io.ReadWriteCloser {
+	return &extensionStream{conn: conn, handler: handler}
+}
+
+func (s *extensionStream) Read(p []byte) (int, error) {
+	for len(s.incoming) == 0 {
+		packet, err := readPacket(s.conn)
+		if err != nil {
+			return 0, err
+		}
+		served, err := s.serve(packet)
+		if err != nil {
+			return 0, err
+		}
+		if !served {
+			s.incoming = packet
+		}
+	}
+	n := copy(p, s.incoming)
+	s.incoming = s.incoming[n:]
+	return n, nil
+}
+
+func (s *extensionStream) Write(p []byte) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.outgoing = append(s.outgoing, p...)
+	for len(s.outgoing) >= 4 {
+		length := int(binary.BigEndian.Uint32(s.outgoing)) + 4
+		if len(s.outgoing) < length {
+			break
+		}
+		packet := s.sent(s.outgoing[:length])
+		if _, err := s.conn.Write(packet); err != nil {
+			return 0, err
+		}
+		s.outgoing = s.outgoing[length:]
+	}
+	if len(s.outgoing) == 0 {
+		s.outgoing = nil
+	}
+	return len(p), nil
+}
+
+func (s *extensionStream) Close() error {
+	return s.conn.Close()
+}
+
+// serve answers a request of the extensions and returns whether the request
+// is served. Other requests are tracked and passed to the request server.
+func (s *extensionStream) serve(packet []byte) (bool, error) {
+	if len(packet) < 9 {
+		return false, nil
+	}
+	id := binary.BigEndian.Uint32(packet[5:])
+	data := packet[9:]
+	switch packet[4] {
+	case sshFxpOpen:
+		s.handler.handles.open(id)
+	case sshFxpClose, sshFxpRead, sshFxpWrite, sshFxpFstat, sshFxpFsetstat, sshFxpReaddir:
+		if handle, _, ok := readString(data); ok {
+			s.handler.handles.request(id, handle)
+		}
+	case sshFxpExtended:
+		name, data, ok := readString(data)
+		if !ok {
+			return false, nil
+		}
+		switch name {
+		case "copy-data":
+			request, err := parseCopyData(data)
+			if err != nil {
+				return true, s.writeStatus(id, err)
+			}
+			s.serveInBackground(id, []string{request.readHandle, request.writeHandle}, func() error {
+				return s.copyData(request)
+			})
+			return true, nil
+		case "fsync@openssh.com":
+			handle, _, ok := readString(data)
+			if !ok {
+				return true, s.writeStatus(id, sftp.ErrSSHFxBadMessage)
+			}
+			s.serveInBackground(id, []string{handle}, func() error {
+				return s.fsync(handle)
+			})
+			return true, nil
+		case "limits@openssh.com":
+			return true, s.writeLimits(id)
+		}
+	}
+	return false, nil
+}
+
+// serveInBackground answers a request of an extension working on the
+// specified handles once the requests on the handles received before it are
+// answered. The requests on other handles are served in the meantime while
+// the requests on the handles received after it wait for it.
+func (s *extensionStream) serveInBackground(id uint32, handles []string, serve func() error) {
+	s.handler.handles.acquire(handles...)
+	go func() {
+		defer s.handler.handles.release(handles...)
+		s.handler.handles.wait(handles...)
+		// the session ends anyway if the connection is broken
+		_ = s.writeStatus(id, serve())
+	}()
+}
+
+// sent tracks a packet sent by the request server and returns the packet to
+// be sent in place of it.
+func (s *extensionStream) sent(packet []byte) []byte {
+	if len(packet) < 9 {
+		return packet
+	}
+	switch packet[4] {
+	case sshFxpVersion:
+		packet = append([]byte(nil), packet...)
+		for _, extension := range extensions {
+			packet = appendString(packet, extension[0])
+			packet = appendString(packet, extension[1])
+		}
+		binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	default:
+		var handle string
+		if packet[4] == sshFxpHandle {
+			handle, _, _ = readString(packet[9:])
+		}
+		s.handler.handles.response(packet[4], binary.BigEndian.Uint32(packet[5:]), handle)
+	}
+	return packet
+}
+
+// copyDataRequest is a request of copy-data where a length of zero copies up
+// to the end of the file read.
+type copyDataRequest struct {
+	readHandle  string
+	readOffset  uint64
+	readLength  uint64
+	writeHandle string
+	writeOffset uint64
+}
+
+func parseCopyData(data []byte) (copyDataRequest, error) {
+	var request copyDataRequest
+	var ok, ok2, ok3, ok4, ok5 bool
+	request.readHandle, data, ok = readString(data)
+	request.readOffset, data, ok2 = readUint64(data)
+	request.readLength, data, ok3 = readUint64(data)
+	request.writeHandle, data, ok4 = readString(data)
+	request.writeOffset, _, ok5 = readUint64(data)
+	if !ok || !ok2 || !ok3 || !ok4 || !ok5 {
+		return copyDataRequest{}, sftp.ErrSSHFxBadMessage
+	}
+	return request, nil
+}
+
+// copyData copies data between two open files in response to copy-data.
+func (s *extensionStream) copyData(request copyDataRequest) error {
+	source, ok := s.handler.handles.get(request.readHandle)
+	if !ok {
+		return os.ErrInvalid
+	}
+	destination, ok := s.handler.handles.get(request.writeHandle)
+	if !ok {
+		return os.ErrInvalid
+	}
+	if !source.request.Pflags().Read || !destination.request.Pflags().Write {
+		return os.ErrPermission
+	}
+	readOffset := int64(request.readOffset)
+	readLength := int64(request.readLength)
+	writeOffset := int64(request.writeOffset)
+	if readOffset < 0 || writeOffset < 0 || readLength < 0 {
+		return os.ErrInvalid
+	}
+	// a file cannot be copied over itself
+	if request.readHandle == request.writeHandle && (readLength == 0 || (request.writeOffset < request.readOffset+request.readLength && request.readOffset < request.writeOffset+request.readLength)) {
+		return os.ErrInvalid
+	}
+
+	var copied int64
+	err := copyRange(destination, writeOffset, source, readOffset, readLength, &copied)
+	s.handler.recordOperation("copy-data", source.request.Filepath, destination.request.Filepath, copied, err)
+	return err
+}
+
+// copyRange copies the specified range of the source to the offset of the
+// destination. The number of bytes copied is counted as the copy goes.
+func copyRange(destination io.WriterAt, writeOffset int64, source io.ReaderAt, readOffset int64, length int64, copied *int64) error {
+	buffer := make([]byte, copyDataBufferSize)
+	for length == 0 || *copied < length {
+		chunk := buffer
+		if length > 0 {
+			chunk = buffer[:min(int64(len(buffer)), length-*copied)]
+		}
+		n, err := source.ReadAt(chunk, readOffset+*copied)
+		if n > 0 {
+			if _, err := destination.WriteAt(chunk[:n], writeOffset+*copied); err != nil {
+				return err
+			}
+			*copied += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsync flushes an open file to the storage in response to
+// fsync@openssh.com.
+func (s *extensionStream) fsync(handle string) error {
+	file, ok := s.handler.handles.get(handle)
+	if !ok {
+		return os.ErrInvalid
+	}
+	err := file.Sync()
+	s.handler.recordOperation("fsync", file.request.Filepath, "", 0, err)
+	return err
+}
+
+// writeLimits sends the limits of the server in response to
+// limits@openssh.com where the number of open handles is not limited.
+func (s *extensionStream) writeLimits(id uint32) error {
+	packet := newPacket(sshFxpExtendedReply, id)
+	packet = binary.BigEndian.AppendUint64(packet, maxPacketLength)
+	packet = binary.BigEndian.AppendUint64(packet, maxReadLength)
+	packet = binary.BigEndian.AppendUint64(packet, maxWriteLength)
+	packet = binary.BigEndian.AppendUint64(packet, 0)
+	return s.writePacket(packet)
+}
+
+// writeStatus sends the status of a request with the result of the
+// request.
+func (s *extensionStream) writeStatus(id uint32, err error) error {
+	packet := newPacket(sshFxpStatus, id)
+	packet = binary.BigEndian.AppendUint32(packet, statusCode(err))
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	packet = appendString(packet, message)
+	packet = appendString(packet, "")
+	return s.writePacket(packet)
+}
+
+func (s *extensionStream) writePacket(packet []byte) error {
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+// statusCode returns the SFTP status code of the result of a request.
+func statusCode(err error) uint32 {
+	switch {
+	case err == nil:
+		return sshFxOk
+	case errors.Is(err, io.EOF):
+		return sshFxEOF
+	case errors.Is(err, os.ErrNotExist):
+		return sshFxNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return sshFxPermissionDenied
+	case errors.Is(err, sftp.ErrSSHFxBadMessage):
+		return uint32(sftp.ErrSSHFxBadMessage)
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return sshFxOpUnsupported
+	}
+	return sshFxFailure
+}
+
+// readPacket reads a whole packet including its length.
+func readPacket(r io.Reader) ([]byte, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	// the request server refuses packets which are too long
+	size := binary.BigEndian.Uint32(length)
+	if size > maxPacketLength {
+		return length, nil
+	}
+	packet := make([]byte, 4+size)
+	copy(packet, length)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// newPacket returns a packet of the specified type and request ID without
+// the length set.
+func newPacket(packetType byte, id uint32) []byte {
+	packet := make([]byte, 4, 64)
+	packet = append(packet, packetType)
+	return binary.BigEndian.AppendUint32(packet, id)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	length := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(length) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+length]), b[4+length:], true
+}
+
+func readUint64(b []byte) (uint64, []byte, bool) {
+	if len(b) < 8 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint64(b), b[8:], true
+}
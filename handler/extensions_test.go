@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexhokl/file-server/audit"
+	"github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/pkg/sftp"
+)
+
+const (
+	// SFTP packet type of the first request
+	sshFxpInit = 1
+
+	// SFTP open flags
+	sshFxfRead  = 0x01
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+)
+
+// testClient sends SFTP packets to a request server wrapped by
+// extensionStream over a pipe.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newTestClient(t *testing.T, fileSystem *storage.FileSystem) *testClient {
+	t.Helper()
+	auditLogger, err := audit.NewFileLogger(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLogger.Close() })
+
+	client, server := net.Pipe()
+	tracked := session.Register(session.KindSFTP, "test", "alice", "pipe", server)
+	t.Cleanup(tracked.Unregister)
+	h := newRequestHandler(fileSystem, auditLogger, tracked, "alice", "pipe", false)
+	requestServer := sftp.NewRequestServer(newExtensionStream(server, h), h.handlers())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		requestServer.Serve()
+		requestServer.Close()
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	c := &testClient{t: t, conn: client}
+	c.send(binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, sshFxpInit}, 3))
+	if packet := c.receive(); packet[4] != sshFxpVersion {
+		t.Fatalf("response to init = %d", packet[4])
+	}
+	return c
+}
+
+// send sends a packet. It may be called while the responses are received
+// as the pipe is not buffered.
+func (c *testClient) send(packet []byte) {
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	if _, err := c.conn.Write(packet); err != nil {
+		c.t.Error(err)
+	}
+}
+
+func (c *testClient) receive() []byte {
+	c.t.Helper()
+	packet, err := readPacket(c.conn)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return packet
+}
+
+// receiveAll returns the responses to the specified number of requests by
+// the IDs of the requests.
+func (c *testClient) receiveAll(count int) map[uint32][]byte {
+	c.t.Helper()
+	responses := map[uint32][]byte{}
+	for range count {
+		packet := c.receive()
+		responses[binary.BigEndian.Uint32(packet[5:])] = packet
+	}
+	return responses
+}
+
+func (c *testClient) sendOpen(id uint32, name string, flags uint32) {
+	packet := newPacket(sshFxpOpen, id)
+	packet = appendString(packet, name)
+	packet = binary.BigEndian.AppendUint32(packet, flags)
+	packet = binary.BigEndian.AppendUint32(packet, 0)
+	c.send(packet)
+}
+
+func (c *testClient) sendWrite(id uint32, handle string, offset uint64, content []byte) {
+	packet := newPacket(sshFxpWrite, id)
+	packet = appendString(packet, handle)
+	packet = binary.BigEndian.AppendUint64(packet, offset)
+	packet = appendString(packet, string(content))
+	c.send(packet)
+}
+
+func (c *testClient) sendCopyData(id uint32, readHandle string, writeHandle string) {
+	packet := newPacket(sshFxpExtended, id)
+	packet = appendString(packet, "copy-data")
+	packet = appendString(packet, readHandle)
+	packet = binary.BigEndian.AppendUint64(packet, 0)
+	packet = binary.BigEndian.AppendUint64(packet, 0)
+	packet = appendString(packet, writeHandle)
+	packet = binary.BigEndian.AppendUint64(packet, 0)
+	c.send(packet)
+}
+
+func (c *testClient) sendClose(id uint32, handle string) {
+	c.send(appendString(newPacket(sshFxpClose, id), handle))
+}
+
+// handle returns the handle in a response to an open request.
+func (c *testClient) handle(packet []byte) string {
+	c.t.Helper()
+	if packet[4] != sshFxpHandle {
+		c.t.Fatalf("response to open = %d", packet[4])
+	}
+	handle, _, _ := readString(packet[9:])
+	return handle
+}
+
+// status returns the status code in a response.
+func (c *testClient) status(packet []byte) uint32 {
+	c.t.Helper()
+	if packet[4] != sshFxpStatus {
+		c.t.Fatalf("response = %d", packet[4])
+	}
+	return binary.BigEndian.Uint32(packet[9:])
+}
+
+func readTestFile(t *testing.T, fileSystem *storage.FileSystem, name string) []byte {
+	t.Helper()
+	file, err := fileSystem.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestExtensionStreamCopyData(t *testing.T) {
+	fileSystem := storage.NewBackendFileSystem(storage.NewMemoryBackend())
+	c := newTestClient(t, fileSystem)
+
+	// the files are opened without waiting for the handles so that the
+	// handles are told apart only by the responses
+	go func() {
+		c.sendOpen(1, "/a", sshFxfRead|sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+		c.sendOpen(2, "/b", sshFxfRead|sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+		c.sendOpen(3, "/c", sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+	}()
+	responses := c.receiveAll(3)
+	a, b, copied := c.handle(responses[1]), c.handle(responses[2]), c.handle(responses[3])
+
+	// copy-data is sent right after the writes before it are sent so that
+	// it is served only after they are done
+	contentA := bytes.Repeat([]byte("a"), 30000)
+	contentB := bytes.Repeat([]byte("b"), 300000)
+	writes := len(contentB) / 30000
+	go func() {
+		c.sendWrite(10, a, 0, contentA)
+		for i := range writes {
+			c.sendWrite(uint32(11+i), b, uint64(i*30000), contentB[i*30000:(i+1)*30000])
+		}
+		c.sendCopyData(100, b, copied)
+		c.sendCopyData(101, copied, a)
+		c.sendClose(102, a)
+		c.sendClose(103, b)
+		c.sendClose(104, copied)
+	}()
+	responses = c.receiveAll(1 + writes + 5)
+
+	for id, response := range responses {
+		if id == 101 {
+			continue
+		}
+		if code := c.status(response); code != sshFxOk {
+			t.Errorf("status of request %d = %d", id, code)
+		}
+	}
+	// a file opened only for writing cannot be read
+	if code := c.status(responses[101]); code != sshFxPermissionDenied {
+		t.Errorf("status of copy from file opened for writing = %d", code)
+	}
+	if !bytes.Equal(readTestFile(t, fileSystem, "/c"), contentB) {
+		t.Error("content copied differs from the content of the file read")
+	}
+	if !bytes.Equal(readTestFile(t, fileSystem, "/a"), contentA) {
+		t.Error("content of the file read is changed")
+	}
+}
+
+func TestExtensionStreamClosedHandles(t *testing.T) {
+	fileSystem := storage.NewBackendFileSystem(storage.NewMemoryBackend())
+	c := newTestClient(t, fileSystem)
+
+	go func() {
+		c.sendOpen(1, "/a", sshFxfRead|sshFxfWrite|sshFxfCreat)
+		c.sendOpen(2, "/missing/b", sshFxfRead|sshFxfWrite|sshFxfCreat)
+		c.sendOpen(3, "/c", sshFxfRead|sshFxfWrite|sshFxfCreat)
+	}()
+	responses := c.receiveAll(3)
+	if code := c.status(responses[2]); code != sshFxNoSuchFile {
+		t.Fatalf("status of open in missing directory = %d", code)
+	}
+	a, copied := c.handle(responses[1]), c.handle(responses[3])
+	go func() {
+		c.sendWrite(4, a, 0, []byte("content"))
+		c.sendClose(5, a)
+		c.sendCopyData(6, a, copied)
+	}()
+	responses = c.receiveAll(3)
+
+	if code := c.status(responses[6]); code != sshFxFailure {
+		t.Errorf("status of copy from closed handle = %d", code)
+	}
+	if _, err := fileSystem.Stat("/missing/b"); !os.IsNotExist(err) {
+		t.Errorf("file opened in missing directory: %v", err)
+	}
+}
@@ -3,14 +3,23 @@ package handler
 import (
 	"io"
 	"log/slog"
-	"path/filepath"
 
-	"github.com/alexhokl/helper/iohelper"
+	"github.com/alexhokl/file-server/audit"
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/alexhokl/file-server/throttle"
 	"github.com/gliderlabs/ssh"
 	"github.com/pkg/sftp"
+	"gorm.io/gorm"
 )
 
-func GetFileSessionHandler(pathUsersDirectory string) func(ssh.Session) {
+// GetFileSessionHandler returns the handler of the SFTP subsystem where every
+// request is recorded with the audit logger and the transfers are limited by
+// the bandwidth limits of the user and of the server. With atomic uploads,
+// files are written to hidden temporary files and renamed into place when
+// they are closed successfully.
+func GetFileSessionHandler(dbConn *gorm.DB, pathUsersDirectory string, auditLogger *audit.Logger, atomicUploads bool) func(ssh.Session) {
 	return func(sess ssh.Session) {
 		logger := slog.With(
 			slog.String("user", sess.User()),
@@ -19,31 +28,35 @@ func GetFileSessionHandler(pathUsersDirectory string) func(ssh.Session) {
 		)
 		logger.Info("file session started")
 
-		homePath := filepath.Clean(filepath.Join(pathUsersDirectory, sess.User()))
-		if !iohelper.IsDirectoryExist(homePath) {
-			err := iohelper.CreateDirectory(homePath)
-			if err != nil {
-				logger.Error(
-					"unable to create user directory",
-					slog.String("error", err.Error()),
-				)
-				return
-			}
+		fileSystem, err := storage.OpenUserFileSystem(dbConn, pathUsersDirectory, sess.User())
+		if err != nil {
+			logger.Error(
+				"unable to open user directory",
+				slog.String("error", err.Error()),
+			)
+			return
 		}
 
-		debugStream := io.Discard
-		serverOptions := []sftp.ServerOption{
-			sftp.WithDebug(debugStream),
-			sftp.WithServerWorkingDirectory(homePath),
-		}
-		server, err := sftp.NewServer(
-			sess,
-			serverOptions...,
-		)
-		if err != nil {
-			logger.Error("sftp server init error", slog.String("error", err.Error()))
+		// the limits of the user are shared by the sessions of the user and
+		// changes through the API apply to the sessions in progress
+		var user db.User
+		if err := dbConn.Where("username = ?", sess.User()).First(&user).Error; err != nil {
+			logger.Error(
+				"unable to retrieve user",
+				slog.String("error", err.Error()),
+			)
 			return
 		}
+		throttle.SetUserLimits(user.Username, throttle.UserLimits(user))
+
+		tracked := session.Register(session.KindSFTP, connectionID(sess.Context()), sess.User(), sess.RemoteAddr().String(), sess)
+		defer tracked.Unregister()
+
+		h := newRequestHandler(fileSystem, auditLogger, tracked, sess.User(), sess.RemoteAddr().String(), atomicUploads)
+		server := sftp.NewRequestServer(
+			newExtensionStream(throttle.NewStream(sess.Context(), session.NewStream(sess, tracked), user.Username), h),
+			h.handlers(),
+		)
 		if err := server.Serve(); err == io.EOF {
 			if err := server.Close(); err != nil {
 				logger.Error(
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexhokl/file-server/session"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ConnectionLimits are the maximum numbers of concurrent SSH connections
+// where zero means unlimited.
+type ConnectionLimits struct {
+	// MaxConnections is the number of connections of the server
+	MaxConnections int
+
+	// MaxConnectionsPerUser is the number of authenticated connections of a
+	// user which are counted once they open a channel
+	MaxConnectionsPerUser int
+
+	// MaxConnectionsPerIP is the number of connections from an IP address
+	MaxConnectionsPerIP int
+
+	// MaxStartups limits the connections which are not authenticated yet,
+	// including the ones authenticated which have not opened a channel
+	MaxStartups MaxStartups
+}
+
+// MaxStartups limits the connections which are not authenticated yet like
+// MaxStartups of OpenSSH. Beyond Start connections, a new connection is
+// refused with a probability of Rate percent which increases linearly to
+// 100 percent at Full connections.
+type MaxStartups struct {
+	Start int
+	Rate  int
+	Full  int
+}
+
+// ParseMaxStartups parses MaxStartups in the format of either "start" or
+// "start:rate:full" such as "10:30:100". An empty string means unlimited.
+func ParseMaxStartups(s string) (MaxStartups, error) {
+	if s == "" {
+		return MaxStartups{}, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return MaxStartups{}, fmt.Errorf("invalid max startups: %s", s)
+	}
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return MaxStartups{}, fmt.Errorf("invalid max startups: %s", s)
+		}
+		values[i] = value
+	}
+	if len(values) == 1 {
+		return MaxStartups{Start: values[0], Rate: 100, Full: values[0]}, nil
+	}
+	startups := MaxStartups{Start: values[0], Rate: values[1], Full: values[2]}
+	if startups.Start == 0 || startups.Rate > 100 || startups.Full < startups.Start {
+		return MaxStartups{}, fmt.Errorf("invalid max startups: %s", s)
+	}
+	return startups, nil
+}
+
+// refuse returns whether a new connection is refused with the specified
+// number of connections which are not authenticated yet.
+func (m MaxStartups) refuse(startups int) bool {
+	if m.Start == 0 || startups < m.Start {
+		return false
+	}
+	if startups >= m.Full {
+		return true
+	}
+	rate := m.Rate + (100-m.Rate)*(startups-m.Start)/(m.Full-m.Start)
+	return rand.IntN(100) < rate
+}
+
+// connectionKey is the key of the limited connection in the context of a
+// connection.
+var connectionKey = &struct{ name string }{"limited-connection"}
+
+// ConnectionLimiter counts the SSH connections and refuses the ones beyond
+// the limits. The connections accepted are tracked in the session registry.
+type ConnectionLimiter struct {
+	limits ConnectionLimits
+
+	mutex    sync.Mutex
+	total    int
+	startups int
+	perIP    map[string]int
+	perUser  map[string]int
+}
+
+// NewConnectionLimiter returns a limiter of the specified limits.
+func NewConnectionLimiter(limits ConnectionLimits) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		limits:  limits,
+		perIP:   map[string]int{},
+		perUser: map[string]int{},
+	}
+}
+
+// ConnCallback is the ssh.ConnCallback accepting a new connection if it is
+// within the limits of the server, of the IP address and of connections
+// which are not authenticated yet. A refused connection is told the reason
+// before the SSH version exchange, which is allowed by RFC 4253, and it is
+// closed.
+func (l *ConnectionLimiter) ConnCallback(ctx ssh.Context, conn net.Conn) net.Conn {
+	ip := remoteIP(conn.RemoteAddr())
+
+	l.mutex.Lock()
+	var reason string
+	switch {
+	case l.limits.MaxConnections > 0 && l.total >= l.limits.MaxConnections:
+		reason = "too many connections"
+	case l.limits.MaxConnectionsPerIP > 0 && l.perIP[ip] >= l.limits.MaxConnectionsPerIP:
+		reason = "too many connections from the address"
+	case l.limits.MaxStartups.refuse(l.startups):
+		reason = "too many unauthenticated connections"
+	default:
+		l.total++
+		l.startups++
+		l.perIP[ip]++
+	}
+	l.mutex.Unlock()
+
+	if reason != "" {
+		slog.Warn(
+			"connection refused",
+			slog.String("reason", reason),
+			slog.String("remote", conn.RemoteAddr().String()),
+		)
+		_, _ = io.WriteString(conn, "Connection refused: "+reason+"\r\n")
+		return nil
+	}
+
+	limited := &limitedConn{Conn: conn, limiter: l, ip: ip}
+	limited.session = session.Register(session.KindConnection, "", "", conn.RemoteAddr().String(), limited)
+	ctx.SetValue(connectionKey, limited)
+	return limited
+}
+
+// BannerHandler is the ssh.BannerHandler telling a user, who has as many
+// connections as allowed, the reason of the authentication failure which
+// follows.
+func (l *ConnectionLimiter) BannerHandler(ctx ssh.Context) string {
+	if l.limits.MaxConnectionsPerUser == 0 {
+		return ""
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.perUser[ctx.User()] >= l.limits.MaxConnectionsPerUser {
+		return fmt.Sprintf("Too many connections of user %s\r\n", ctx.User())
+	}
+	return ""
+}
+
+// ChannelHandler returns the ssh.ChannelHandler which counts the connection
+// of a channel as a connection of the user before the channel is handled by
+// the next handler. Channels are opened only after the handshake completes,
+// unlike the public key callback which is also called for keys the client
+// does not prove to hold. A connection of a user who has as many connections
+// as allowed already is closed.
+func (l *ConnectionLimiter) ChannelHandler(next ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		if !l.establish(ctx) {
+			_ = newChan.Reject(gossh.ResourceShortage, "too many connections of the user")
+			_ = conn.Close()
+			return
+		}
+		next(srv, conn, newChan, ctx)
+	}
+}
+
+// establish counts the connection of the context as a connection of the
+// authenticated user, unless it is counted already, and it returns false if
+// the user has as many connections as allowed already.
+func (l *ConnectionLimiter) establish(ctx ssh.Context) bool {
+	limited, ok := ctx.Value(connectionKey).(*limitedConn)
+	if !ok {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// every channel of a connection is opened by the same user
+	if limited.user != "" {
+		return true
+	}
+	if l.limits.MaxConnectionsPerUser > 0 && l.perUser[ctx.User()] >= l.limits.MaxConnectionsPerUser {
+		slog.Warn(
+			"connection refused",
+			slog.String("reason", "too many connections of the user"),
+			slog.String("user", ctx.User()),
+			slog.String("remote", ctx.RemoteAddr().String()),
+		)
+		return false
+	}
+	limited.user = ctx.User()
+	limited.session.SetUsername(limited.user)
+	l.perUser[limited.user]++
+	l.startups--
+	return true
+}
+
+// release removes a closed connection from the counts.
+func (l *ConnectionLimiter) release(limited *limitedConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total--
+	decrement(l.perIP, limited.ip)
+	if limited.user != "" {
+		decrement(l.perUser, limited.user)
+	} else {
+		l.startups--
+	}
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// connectionID returns the ID of the connection of the context in the
+// session registry.
+func connectionID(ctx ssh.Context) string {
+	if limited, ok := ctx.Value(connectionKey).(*limitedConn); ok {
+		return limited.session.ID()
+	}
+	return ""
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// limitedConn is a connection counted by a ConnectionLimiter until it is
+// closed.
+type limitedConn struct {
+	net.Conn
+	limiter *ConnectionLimiter
+	ip      string
+
+	// user is the authenticated user which is guarded by the mutex of the
+	// limiter
+	user string
+
+	session   *session.Session
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.session.AddBytesIn(n)
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.session.AddBytesOut(n)
+	return n, err
+}
+
+func (c *limitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.session.Unregister()
+		c.limiter.release(c)
+	})
+	return c.Conn.Close()
+}
@@ -5,28 +5,95 @@ import (
 	"io"
 	"log/slog"
 
+	"github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
 	"github.com/gliderlabs/ssh"
+	"gorm.io/gorm"
 )
 
-func HandleNormalSession(sess ssh.Session) {
-	logger := slog.With(
-		slog.String("user", sess.User()),
-		slog.String("remote", sess.RemoteAddr().String()),
-		slog.String("local", sess.LocalAddr().String()),
-	)
-	logger.Info("normal session")
-	_, err := io.WriteString(
-		sess,
-		fmt.Sprintf(
-			"Hi %s! You have successfully authenticated, but file server does not provide shell access.\n",
-			sess.User(),
-		),
-	)
-	if err != nil {
-		slog.Error(
-			"unable to serve response",
+// GetNormalSessionHandler returns the handler of sessions other than the
+// SFTP subsystem. Shell access is not provided and only the built-in commands
+// are executed, without a shell, against the jailed file system of the user.
+// rsync is only available if the path to the rsync executable is specified.
+func GetNormalSessionHandler(dbConn *gorm.DB, pathUsersDirectory string, rsyncPath string) func(ssh.Session) {
+	return func(sess ssh.Session) {
+		logger := slog.With(
+			slog.String("user", sess.User()),
+			slog.String("remote", sess.RemoteAddr().String()),
+			slog.String("local", sess.LocalAddr().String()),
+		)
+
+		command := sess.Command()
+		if len(command) == 0 {
+			logger.Info("normal session")
+			_, err := io.WriteString(
+				sess,
+				fmt.Sprintf(
+					"Hi %s! You have successfully authenticated, but file server does not provide shell access.\n",
+					sess.User(),
+				),
+			)
+			if err != nil {
+				slog.Error(
+					"unable to serve response",
+					slog.String("error", err.Error()),
+				)
+			}
+			return
+		}
+
+		logger = logger.With(slog.String("command", sess.RawCommand()))
+		run, ok := commands[command[0]]
+		if !ok {
+			logger.Warn("command refused")
+			exit(sess, logger, refuseCommand(sess, command[0]))
+			return
+		}
+
+		logger.Info("command session started")
+		fileSystem, err := storage.OpenUserFileSystem(dbConn, pathUsersDirectory, sess.User())
+		if err != nil {
+			logger.Error(
+				"unable to open user directory",
+				slog.String("error", err.Error()),
+			)
+			exit(sess, logger, 1)
+			return
+		}
+
+		tracked := session.Register(session.KindCommand, connectionID(sess.Context()), sess.User(), sess.RemoteAddr().String(), sess)
+		tracked.SetCommand(command[0])
+		defer tracked.Unregister()
+		stream := session.NewStream(sess, tracked)
+
+		status := run(&commandContext{
+			ctx:        sess.Context(),
+			username:   sess.User(),
+			fileSystem: fileSystem,
+			rsyncPath:  rsyncPath,
+			args:       command[1:],
+			stdin:      stream,
+			stdout:     stream,
+			stderr:     sess.Stderr(),
+			logger:     logger,
+		})
+		logger.Info("command session completed", slog.Int("status", status))
+		exit(sess, logger, status)
+	}
+}
+
+// refuseCommand tells the client the command is not available and returns
+// the exit status of the command.
+func refuseCommand(sess ssh.Session, name string) int {
+	fmt.Fprintf(sess.Stderr(), "%s: command not allowed\n", name)
+	return 126
+}
+
+func exit(sess ssh.Session, logger *slog.Logger, status int) {
+	if err := sess.Exit(status); err != nil {
+		logger.Error(
+			"unable to send exit status",
 			slog.String("error", err.Error()),
 		)
-		return
 	}
 }
@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/audit"
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/session"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/pkg/sftp"
+)
+
+const (
+	// statVFSBlockSize is the block size reported by StatVFS
+	statVFSBlockSize = 4096
+
+	// statVFSReadOnly is SSH2_FXE_STATVFS_ST_RDONLY of statvfs@openssh.com
+	statVFSReadOnly = 0x1
+)
+
+// requestHandler serves SFTP requests of a user with the jailed file system
+// of the user. Every request is recorded in the audit log and the files open
+// are tracked in the session. With atomic uploads, files written from scratch
+// are written to temporary files which are renamed into place only when the
+// transfers complete.
+type requestHandler struct {
+	fileSystem    *storage.FileSystem
+	auditLogger   *audit.Logger
+	session       *session.Session
+	username      string
+	remoteAddress string
+	atomicUploads bool
+
+	// handles are the files open by the handles of the request server
+	handles *handleTable
+}
+
+func newRequestHandler(fileSystem *storage.FileSystem, auditLogger *audit.Logger, session *session.Session, username string, remoteAddress string, atomicUploads bool) *requestHandler {
+	return &requestHandler{
+		fileSystem:    fileSystem,
+		auditLogger:   auditLogger,
+		session:       session,
+		username:      username,
+		remoteAddress: remoteAddress,
+		atomicUploads: atomicUploads,
+		handles:       newHandleTable(),
+	}
+}
+
+func (h *requestHandler) handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+func (h *requestHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	file, err := h.fileSystem.Open(r.Filepath)
+	if err != nil {
+		h.record(r, 0, err)
+		return nil, err
+	}
+	return h.newAuditedFile(r, file), nil
+}
+
+func (h *requestHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.openFile(r)
+}
+
+func (h *requestHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return h.openFile(r)
+}
+
+func (h *requestHandler) openFile(r *sftp.Request) (storage.File, error) {
+	open := h.fileSystem.OpenFile
+	if h.atomicUploads {
+		open = h.fileSystem.OpenAtomic
+	}
+	file, err := open(r.Filepath, toOpenFlag(r.Pflags()), 0o644)
+	if err != nil {
+		h.record(r, 0, err)
+		return nil, err
+	}
+	return h.newAuditedFile(r, file), nil
+}
+
+func (h *requestHandler) Filecmd(r *sftp.Request) error {
+	err := h.filecmd(r)
+	h.record(r, 0, err)
+	return err
+}
+
+func (h *requestHandler) filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Rename":
+		// unlike posix-rename, SFTP rename does not replace an existing file
+		if _, err := h.fileSystem.Lstat(r.Target); err == nil {
+			return os.ErrExist
+		}
+		return h.fileSystem.Rename(r.Filepath, r.Target)
+	case "Rmdir":
+		info, err := h.fileSystem.Lstat(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return h.fileSystem.Remove(r.Filepath)
+	case "Remove":
+		info, err := h.fileSystem.Lstat(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return syscall.EISDIR
+		}
+		return h.fileSystem.Remove(r.Filepath)
+	case "Mkdir":
+		return h.fileSystem.Mkdir(r.Filepath, 0o755)
+	case "Link":
+		return h.fileSystem.Link(r.Filepath, r.Target)
+	case "Symlink":
+		// r.Filepath is the target and r.Target is the path of the link
+		return h.fileSystem.Symlink(r.Filepath, r.Target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *requestHandler) PosixRename(r *sftp.Request) error {
+	err := h.fileSystem.Rename(r.Filepath, r.Target)
+	h.record(r, 0, err)
+	return err
+}
+
+// StatVFS reports the storage of the user, which is limited by the quota of
+// the user, in response to statvfs@openssh.com so that "df" of sftp shows
+// the space left to the user.
+func (h *requestHandler) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+	capacity, err := h.fileSystem.Capacity()
+	h.record(r, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	stat := &sftp.StatVFS{
+		Bsize:   statVFSBlockSize,
+		Frsize:  statVFSBlockSize,
+		Blocks:  uint64(capacity.TotalBytes) / statVFSBlockSize,
+		Bfree:   uint64(capacity.FreeBytes) / statVFSBlockSize,
+		Bavail:  uint64(capacity.FreeBytes) / statVFSBlockSize,
+		Files:   uint64(capacity.TotalFiles),
+		Ffree:   uint64(capacity.FreeFiles),
+		Favail:  uint64(capacity.FreeFiles),
+		Namemax: 255,
+	}
+	if !h.fileSystem.CanWrite() {
+		stat.Flag |= statVFSReadOnly
+	}
+	return stat, nil
+}
+
+func (h *requestHandler) setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if err := h.fileSystem.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := h.fileSystem.Chmod(r.Filepath, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		accessTime := time.Unix(int64(attrs.Atime), 0)
+		modificationTime := time.Unix(int64(attrs.Mtime), 0)
+		if err := h.fileSystem.Chtimes(r.Filepath, accessTime, modificationTime); err != nil {
+			return err
+		}
+	}
+	// ownership of files is managed by the server and it cannot be changed
+	return nil
+}
+
+func (h *requestHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	lister, err := h.filelist(r)
+	h.record(r, 0, err)
+	return lister, err
+}
+
+func (h *requestHandler) filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := h.fileSystem.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerat(entries), nil
+	case "Stat":
+		info, err := h.fileSystem.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerat{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *requestHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.fileSystem.Lstat(r.Filepath)
+	h.record(r, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return listerat{info}, nil
+}
+
+func (h *requestHandler) Readlink(name string) (string, error) {
+	target, err := h.fileSystem.Readlink(name)
+	h.recordOperation("readlink", name, "", 0, err)
+	return target, err
+}
+
+func (h *requestHandler) RealPath(name string) (string, error) {
+	return storage.CleanPath(name), nil
+}
+
+// record records a request in the audit log.
+func (h *requestHandler) record(r *sftp.Request, bytes int64, err error) {
+	name, target := r.Filepath, r.Target
+	// r.Filepath of Symlink is the target and r.Target is the path of the
+	// link
+	if r.Method == "Symlink" {
+		name, target = r.Target, r.Filepath
+	}
+	h.recordOperation(auditOperation(r), name, target, bytes, err)
+}
+
+func (h *requestHandler) recordOperation(operation string, name string, target string, bytes int64, err error) {
+	event := db.AuditEvent{
+		Username:      h.username,
+		RemoteAddress: h.remoteAddress,
+		Protocol:      "sftp",
+		Operation:     operation,
+		Path:          storage.CleanPath(name),
+		Bytes:         bytes,
+	}
+	if target != "" {
+		event.Target = storage.CleanPath(target)
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	h.auditLogger.Record(event)
+}
+
+// auditOperation returns the name of the operation of a request in the audit
+// log where files opened for writing are recorded as writes.
+func auditOperation(r *sftp.Request) string {
+	switch r.Method {
+	case "Get":
+		return "read"
+	case "Put":
+		return "write"
+	case "Open":
+		if r.Pflags().Write {
+			return "write"
+		}
+		return "read"
+	case "PosixRename":
+		return "posix-rename"
+	case "StatVFS":
+		return "statvfs"
+	}
+	return strings.ToLower(r.Method)
+}
+
+// auditedFile is a file opened by a request which counts the bytes
+// transferred and records the request in the audit log when it is closed.
+// The file is listed as open in the session until then.
+type auditedFile struct {
+	storage.File
+	handler *requestHandler
+	request *sftp.Request
+
+	// handle is the handle of the request server for the file which is
+	// kept by the handle table
+	handle string
+
+	mutex sync.Mutex
+	bytes int64
+	err   error
+}
+
+func (h *requestHandler) newAuditedFile(r *sftp.Request, file storage.File) *auditedFile {
+	h.session.OpenFile(storage.CleanPath(r.Filepath))
+	audited := &auditedFile{
+		File:    file,
+		handler: h,
+		request: r,
+	}
+	h.handles.opened(audited)
+	return audited
+}
+
+func (f *auditedFile) ReadAt(p []byte, offset int64) (int, error) {
+	n, err := f.File.ReadAt(p, offset)
+	f.count(n, err)
+	return n, err
+}
+
+func (f *auditedFile) WriteAt(p []byte, offset int64) (int, error) {
+	n, err := f.File.WriteAt(p, offset)
+	f.count(n, err)
+	// a file missing a part is not an upload completed
+	if err != nil {
+		f.abort()
+	}
+	return n, err
+}
+
+// TransferError is called by the server with the error of a transfer which
+// is not completed, such as when the connection is lost, before the file is
+// closed.
+func (f *auditedFile) TransferError(err error) {
+	f.count(0, err)
+	f.abort()
+}
+
+// abort marks the transfer as failed so that an atomic upload is discarded
+// when the file is closed.
+func (f *auditedFile) abort() {
+	if file, ok := f.File.(interface{ Abort() }); ok {
+		file.Abort()
+	}
+}
+
+func (f *auditedFile) count(n int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bytes += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) && f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *auditedFile) Close() error {
+	f.handler.handles.closed(f)
+	err := f.File.Close()
+	f.handler.session.CloseFile(storage.CleanPath(f.request.Filepath))
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	result := f.err
+	if result == nil {
+		result = err
+	}
+	f.handler.record(f.request, f.bytes, result)
+	return err
+}
+
+func toOpenFlag(pflags sftp.FileOpenFlags) int {
+	var flag int
+	switch {
+	case pflags.Read && pflags.Write:
+		flag = os.O_RDWR
+	case pflags.Write:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
+	}
+	// O_APPEND is not used as it conflicts with WriteAt
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	return flag
+}
+
+// listerat implements sftp.ListerAt with a slice of file information.
+type listerat []os.FileInfo
+
+func (l listerat) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(list, l[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+// rsyncShortOptions are the single letter options accepted from rsync
+// clients. Options which follow symbolic links (-L, -k, -K), read file names
+// from the connection (-s) or take paths on the server (-T, -B) are not
+// accepted.
+const rsyncShortOptions = "vqcrlptogDxHSzIudmniOJWRbAXCyEF"
+
+// rsyncLongOptions are the long options accepted from rsync clients. Options
+// ending with "=" take a value.
+var rsyncLongOptions = []string{
+	"--sender",
+	"--delete",
+	"--delete-before",
+	"--delete-during",
+	"--delete-delay",
+	"--delete-after",
+	"--delete-excluded",
+	"--checksum",
+	"--partial",
+	"--inplace",
+	"--append",
+	"--append-verify",
+	"--existing",
+	"--ignore-existing",
+	"--ignore-errors",
+	"--ignore-times",
+	"--size-only",
+	"--force",
+	"--numeric-ids",
+	"--safe-links",
+	"--munge-links",
+	"--timeout=",
+	"--contimeout=",
+	"--bwlimit=",
+	"--max-delete=",
+	"--max-size=",
+	"--min-size=",
+	"--modify-window=",
+	"--checksum-choice=",
+	"--compress-choice=",
+	"--compress-level=",
+	"--log-format=",
+	"--out-format=",
+	"--info=",
+	"--debug=",
+	"--chmod=",
+}
+
+// runRsync runs the server side of rsync with the rsync executable where
+// the options of the client are checked against the allowed ones and paths
+// are confined to the home directory of the user.
+func runRsync(c *commandContext) int {
+	if c.rsyncPath == "" {
+		fmt.Fprintln(c.stderr, "rsync: command not available")
+		return 127
+	}
+	// the rsync executable can only work with files on the local disk
+	if c.fileSystem.Root() == "" {
+		fmt.Fprintln(c.stderr, "rsync: not supported by the storage backend")
+		return 1
+	}
+
+	args, err := parseRsyncArgs(c.fileSystem, c.args)
+	if err != nil {
+		return c.fail("rsync", err)
+	}
+	// the receiving side reads existing files to find the differences and
+	// it needs both read and write access
+	sender := slices.Contains(args, "--sender")
+	if !c.fileSystem.CanRead() || (!sender && !c.fileSystem.CanWrite()) {
+		return c.fail("rsync", os.ErrPermission)
+	}
+	// files written by rsync cannot be scanned and quarantined files are
+	// kept in the home directory
+	if c.fileSystem.ScansUploads() {
+		return c.fail("rsync", errors.New("not available while files are scanned for malware"))
+	}
+	// files written by rsync cannot be checked against upload policies
+	if !sender && c.fileSystem.HasUploadPolicy() {
+		return c.fail("rsync", errors.New("uploads are restricted by an upload policy"))
+	}
+	// files overwritten or deleted by rsync cannot be kept as versions
+	if !sender && c.fileSystem.Versioning() {
+		return c.fail("rsync", errors.New("uploads are not available while versions of files are kept"))
+	}
+	// files written by rsync are not counted as they are written and the
+	// quota can only be checked before the transfer starts
+	if !sender {
+		if err := checkQuota(c.fileSystem); err != nil {
+			return c.fail("rsync", errors.New(describeError(err)))
+		}
+		defer func() {
+			if err := c.fileSystem.RefreshUsage(); err != nil {
+				c.logger.Error(
+					"unable to refresh usage",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+	}
+
+	cmd := exec.CommandContext(c.ctx, c.rsyncPath, args...)
+	cmd.Dir = c.fileSystem.Root()
+	cmd.Env = []string{"PATH=/usr/bin:/bin"}
+	cmd.Stdin = c.stdin
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+		c.logger.Error(
+			"unable to run rsync",
+			slog.String("error", err.Error()),
+		)
+		return 1
+	}
+	return 0
+}
+
+// checkQuota fails with storage.ErrQuotaExceeded if the user has used up the
+// quota already.
+func checkQuota(fileSystem *storage.FileSystem) error {
+	quota := fileSystem.Quota()
+	if quota.Bytes == 0 && quota.Files == 0 {
+		return nil
+	}
+	usage, err := fileSystem.QuotaUsage()
+	if err != nil {
+		return err
+	}
+	if (quota.Bytes > 0 && usage.Bytes >= quota.Bytes) || (quota.Files > 0 && usage.Files >= quota.Files) {
+		return storage.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// parseRsyncArgs validates the arguments of "rsync --server" sent by a
+// client and returns the arguments to run rsync with.
+func parseRsyncArgs(fileSystem *storage.FileSystem, args []string) ([]string, error) {
+	if len(args) == 0 || args[0] != "--server" {
+		return nil, fmt.Errorf("only the server mode of rsync is supported")
+	}
+
+	// received symbolic links cannot be used to escape from the home
+	// directory as they are munged
+	result := []string{"--server", "--munge-links"}
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			if !isAllowedRsyncLongOption(arg) {
+				return nil, fmt.Errorf("option is not allowed: %s", arg)
+			}
+		} else if err := validateRsyncShortOptions(arg[1:]); err != nil {
+			return nil, err
+		}
+		result = append(result, arg)
+	}
+
+	// the first argument after the options is always "." which separates
+	// the options from the paths
+	if i >= len(args) || args[i] != "." {
+		return nil, fmt.Errorf("invalid arguments")
+	}
+	result = append(result, ".")
+	paths := args[i+1:]
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("path is missing")
+	}
+	for _, p := range paths {
+		localPath, err := toRsyncPath(fileSystem, p)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, localPath)
+	}
+	return result, nil
+}
+
+func isAllowedRsyncLongOption(arg string) bool {
+	for _, option := range rsyncLongOptions {
+		if strings.HasSuffix(option, "=") {
+			if strings.HasPrefix(arg, option) {
+				return true
+			}
+		} else if arg == option {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRsyncShortOptions checks a group of single letter options where
+// "e" is followed by the capabilities of the client rather than options.
+func validateRsyncShortOptions(options string) error {
+	for _, option := range options {
+		if option == 'e' {
+			return nil
+		}
+		if !strings.ContainsRune(rsyncShortOptions, option) {
+			return fmt.Errorf("option is not allowed: -%c", option)
+		}
+	}
+	return nil
+}
+
+// toRsyncPath returns the path, relative to the home directory, of a path
+// sent by a client. The symbolic links of the path must be allowed by the
+// symlink policy.
+func toRsyncPath(fileSystem *storage.FileSystem, p string) (string, error) {
+	// the path is not expanded by a shell
+	if p == "~" {
+		p = "/"
+	} else if strings.HasPrefix(p, "~/") {
+		p = p[1:]
+	}
+
+	name := storage.CleanPath(p)
+	if err := fileSystem.CheckSymlinks("rsync", name); err != nil {
+		return "", fmt.Errorf("%s: %s", p, describeError(err))
+	}
+
+	localPath := "." + name
+	if name == "/" {
+		localPath = "."
+	}
+	// a trailing slash of a source has a meaning to rsync
+	if strings.HasSuffix(p, "/") && name != "/" {
+		localPath += "/"
+	}
+	return localPath, nil
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+func TestRunRsyncRefusesUploadsWhileVersioning(t *testing.T) {
+	storage.SetVersioningConfig(storage.VersioningConfig{Enabled: true})
+	t.Cleanup(func() {
+		storage.SetVersioningConfig(storage.VersioningConfig{})
+	})
+	fileSystem, err := storage.NewFileSystem(t.TempDir(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stderr string
+	}{
+		{
+			name:   "upload",
+			args:   []string{"--server", "-vlogDtpre.iLsfxCIvu", "--delete", ".", "/"},
+			status: 1,
+			stderr: "uploads are not available while versions of files are kept",
+		},
+		{
+			name:   "download",
+			args:   []string{"--server", "--sender", "-vlogDtpre.iLsfxCIvu", ".", "/"},
+			status: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			c := &commandContext{
+				ctx:        context.Background(),
+				username:   "alice",
+				fileSystem: fileSystem,
+				// the transfer itself is not of interest
+				rsyncPath: "true",
+				args:      test.args,
+				stdin:     strings.NewReader(""),
+				stdout:    io.Discard,
+				stderr:    &stderr,
+				logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			if status := runRsync(c); status != test.status {
+				t.Fatalf("status = %d, want %d: %s", status, test.status, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), test.stderr) {
+				t.Errorf("stderr = %q, want %q", stderr.String(), test.stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+const (
+	scpOK      = 0
+	scpWarning = 1
+	scpError   = 2
+)
+
+// scpOptions are the options of the remote side of scp.
+type scpOptions struct {
+	// sink indicates files are copied to the server (-t)
+	sink bool
+
+	// source indicates files are copied from the server (-f)
+	source bool
+
+	recursive   bool
+	preserve    bool
+	targetIsDir bool
+	paths       []string
+}
+
+// parseSCPOptions parses the arguments of a scp command which the scp client
+// executes on the server.
+func parseSCPOptions(args []string) (*scpOptions, error) {
+	options := &scpOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			options.paths = append(options.paths, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			options.paths = append(options.paths, arg)
+			continue
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 't':
+				options.sink = true
+			case 'f':
+				options.source = true
+			case 'r':
+				options.recursive = true
+			case 'p':
+				options.preserve = true
+			case 'd':
+				options.targetIsDir = true
+			case 'v', 'q':
+			default:
+				return nil, fmt.Errorf("unsupported option: -%c", flag)
+			}
+		}
+	}
+	if options.sink == options.source {
+		return nil, fmt.Errorf("exactly one of -t and -f is required")
+	}
+	if len(options.paths) == 0 {
+		return nil, fmt.Errorf("path is missing")
+	}
+	if options.sink && len(options.paths) > 1 {
+		return nil, fmt.Errorf("only one target is allowed")
+	}
+	return options, nil
+}
+
+// scpSession runs the remote side of the scp protocol with the jailed file
+// system of a user.
+type scpSession struct {
+	fileSystem *storage.FileSystem
+	options    *scpOptions
+	reader     *bufio.Reader
+	writer     io.Writer
+	logger     *slog.Logger
+
+	// failed indicates some of the files could not be copied
+	failed bool
+}
+
+// runSCP runs the scp command with the specified arguments and returns the
+// exit status of the command.
+func runSCP(fileSystem *storage.FileSystem, args []string, rw io.ReadWriter, stderr io.Writer, logger *slog.Logger) int {
+	options, err := parseSCPOptions(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "scp: %s\n", err)
+		return 1
+	}
+
+	s := &scpSession{
+		fileSystem: fileSystem,
+		options:    options,
+		reader:     bufio.NewReader(rw),
+		writer:     rw,
+		logger:     logger,
+	}
+	if options.sink {
+		err = s.sink(options.paths[0])
+	} else {
+		err = s.source(options.paths)
+	}
+	if err != nil {
+		if err != io.EOF {
+			logger.Warn(
+				"scp completed with error",
+				slog.String("error", err.Error()),
+			)
+		}
+		return 1
+	}
+	if s.failed {
+		return 1
+	}
+	return 0
+}
+
+// source sends the specified files to the client.
+func (s *scpSession) source(paths []string) error {
+	if err := s.readAck(); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		name := storage.CleanPath(p)
+		info, err := s.fileSystem.Stat(name)
+		if err != nil {
+			if err := s.sendWarning(fmt.Sprintf("%s: No such file or directory", p)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.sendEntry(name, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scpSession) sendEntry(name string, info os.FileInfo) error {
+	if info.IsDir() && !s.options.recursive {
+		return s.sendWarning(fmt.Sprintf("%s: not a regular file", path.Base(name)))
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return s.sendWarning(fmt.Sprintf("%s: not a regular file", path.Base(name)))
+	}
+
+	if s.options.preserve {
+		modificationTime := info.ModTime().Unix()
+		if err := s.sendCommand(fmt.Sprintf("T%d 0 %d 0\n", modificationTime, modificationTime)); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		return s.sendDirectory(name, info)
+	}
+	return s.sendFile(name, info)
+}
+
+func (s *scpSession) sendDirectory(name string, info os.FileInfo) error {
+	entries, err := s.fileSystem.ReadDir(name)
+	if err != nil {
+		return s.sendWarning(fmt.Sprintf("%s: %s", path.Base(name), describeError(err)))
+	}
+	if err := s.sendCommand(fmt.Sprintf("D%04o 0 %s\n", info.Mode().Perm(), scpBaseName(name))); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := s.sendEntry(path.Join(name, entry.Name()), entry); err != nil {
+			return err
+		}
+	}
+	return s.sendCommand("E\n")
+}
+
+func (s *scpSession) sendFile(name string, info os.FileInfo) error {
+	file, err := s.fileSystem.Open(name)
+	if err != nil {
+		return s.sendWarning(fmt.Sprintf("%s: %s", path.Base(name), describeError(err)))
+	}
+	defer file.Close()
+
+	if err := s.sendCommand(fmt.Sprintf("C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(name))); err != nil {
+		return err
+	}
+
+	// the size has been announced and exactly the same number of bytes has
+	// to be sent even if the file cannot be read completely
+	written, copyErr := io.CopyN(s.writer, file, info.Size())
+	if copyErr != nil {
+		if _, err := io.CopyN(s.writer, zeroReader{}, info.Size()-written); err != nil {
+			return err
+		}
+	}
+	s.logOperation("download", name, written, copyErr)
+	if copyErr != nil {
+		if err := s.sendWarning(fmt.Sprintf("%s: %s", path.Base(name), describeError(copyErr))); err != nil {
+			return err
+		}
+		return s.readAck()
+	}
+	if _, err := s.writer.Write([]byte{scpOK}); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+// sink receives files from the client and stores them at the target.
+func (s *scpSession) sink(target string) error {
+	target = storage.CleanPath(target)
+	targetInfo, err := s.fileSystem.Stat(target)
+	targetIsDir := err == nil && targetInfo.IsDir()
+	if s.options.targetIsDir && !targetIsDir {
+		return s.sendError(fmt.Sprintf("%s: Not a directory", target))
+	}
+
+	if err := s.sendAck(); err != nil {
+		return err
+	}
+
+	// directories is the stack of directories being received where the
+	// first one is the target
+	directories := []scpDirectory{{name: target}}
+	var times *scpTimes
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fmt.Errorf("empty scp command")
+		}
+
+		current := directories[len(directories)-1].name
+		switch line[0] {
+		case scpWarning, scpError:
+			s.logger.Warn("scp client reported error", slog.String("message", line[1:]))
+			s.failed = true
+			if line[0] == scpError {
+				return nil
+			}
+		case 'T':
+			times, err = parseSCPTimes(line[1:])
+			if err != nil {
+				return s.sendError(err.Error())
+			}
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+		case 'C':
+			mode, size, name, err := parseSCPEntry(line[1:])
+			if err != nil {
+				return s.sendError(err.Error())
+			}
+			destination := current
+			if len(directories) > 1 || targetIsDir {
+				destination = path.Join(current, name)
+			}
+			if err := s.receiveFile(destination, mode, size, times); err != nil {
+				return err
+			}
+			times = nil
+		case 'D':
+			if !s.options.recursive {
+				return s.sendError("received directory without -r")
+			}
+			mode, _, name, err := parseSCPEntry(line[1:])
+			if err != nil {
+				return s.sendError(err.Error())
+			}
+			destination := current
+			if len(directories) > 1 || targetIsDir {
+				destination = path.Join(current, name)
+			}
+			if err := s.makeDirectory(destination, mode); err != nil {
+				return s.sendError(fmt.Sprintf("%s: %s", name, describeError(err)))
+			}
+			directories = append(directories, scpDirectory{name: destination, times: times})
+			times = nil
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+		case 'E':
+			if len(directories) == 1 {
+				return s.sendError("unexpected end of directory")
+			}
+			directory := directories[len(directories)-1]
+			directories = directories[:len(directories)-1]
+			// times of a directory are set after its files are received as
+			// creating files changes the modification time
+			if directory.times != nil {
+				if err := s.fileSystem.Chtimes(directory.name, directory.times.accessTime, directory.times.modificationTime); err != nil {
+					return s.sendError(fmt.Sprintf("%s: %s", path.Base(directory.name), describeError(err)))
+				}
+			}
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+		default:
+			return s.sendError(fmt.Sprintf("unexpected command: %q", line))
+		}
+	}
+}
+
+func (s *scpSession) makeDirectory(name string, mode os.FileMode) error {
+	info, err := s.fileSystem.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return errors.New("Not a directory")
+		}
+		return nil
+	}
+	return s.fileSystem.Mkdir(name, mode|0o700)
+}
+
+func (s *scpSession) receiveFile(name string, mode os.FileMode, size int64, times *scpTimes) error {
+	if info, err := s.fileSystem.Stat(name); err == nil && info.IsDir() {
+		return s.sendWarning(fmt.Sprintf("%s: Is a directory", path.Base(name)))
+	}
+	file, err := s.fileSystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0o600)
+	if err != nil {
+		s.logOperation("upload", name, 0, err)
+		return s.sendWarning(fmt.Sprintf("%s: %s", path.Base(name), describeError(err)))
+	}
+	if err := s.sendAck(); err != nil {
+		file.Close()
+		return err
+	}
+
+	written, writeErr := io.CopyN(file, s.reader, size)
+	if writeErr != nil && written < size {
+		// keeps the protocol in sync when the file cannot be written
+		if _, err := io.CopyN(io.Discard, s.reader, size-written); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr == nil {
+		writeErr = s.fileSystem.Chmod(name, mode)
+	}
+	if writeErr == nil && times != nil {
+		writeErr = s.fileSystem.Chtimes(name, times.accessTime, times.modificationTime)
+	}
+	s.logOperation("upload", name, written, writeErr)
+
+	if err := s.readAck(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return s.sendWarning(fmt.Sprintf("%s: %s", path.Base(name), describeError(writeErr)))
+	}
+	return s.sendAck()
+}
+
+// sendCommand sends a protocol line to the client and waits for its
+// acknowledgement.
+func (s *scpSession) sendCommand(command string) error {
+	if _, err := io.WriteString(s.writer, command); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+func (s *scpSession) sendAck() error {
+	_, err := s.writer.Write([]byte{scpOK})
+	return err
+}
+
+// sendWarning reports an error of a single file which does not stop the
+// transfer of the other files.
+func (s *scpSession) sendWarning(message string) error {
+	s.failed = true
+	_, err := fmt.Fprintf(s.writer, "%cscp: %s\n", scpWarning, message)
+	return err
+}
+
+// sendError reports an error which stops the transfer.
+func (s *scpSession) sendError(message string) error {
+	s.failed = true
+	if _, err := fmt.Fprintf(s.writer, "%cscp: %s\n", scpError, message); err != nil {
+		return err
+	}
+	return errors.New(message)
+}
+
+func (s *scpSession) readAck() error {
+	code, err := s.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch code {
+	case scpOK:
+		return nil
+	case scpWarning, scpError:
+		message, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("scp client reported error: %s", strings.TrimSpace(message))
+	}
+	return fmt.Errorf("unexpected scp response: %d", code)
+}
+
+func (s *scpSession) logOperation(operation string, name string, size int64, err error) {
+	attrs := []any{
+		slog.String("command", "scp"),
+		slog.String("operation", operation),
+		slog.String("path", name),
+		slog.Int64("bytes", size),
+		slog.Bool("success", err == nil),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	s.logger.Info("scp operation", attrs...)
+}
+
+// scpDirectory is a directory being received.
+type scpDirectory struct {
+	name  string
+	times *scpTimes
+}
+
+// scpTimes is the access and modification times sent with the T command.
+type scpTimes struct {
+	modificationTime time.Time
+	accessTime       time.Time
+}
+
+func parseSCPTimes(value string) (*scpTimes, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid times: %q", value)
+	}
+	modificationTime, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid times: %q", value)
+	}
+	accessTime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid times: %q", value)
+	}
+	return &scpTimes{
+		modificationTime: time.Unix(modificationTime, 0),
+		accessTime:       time.Unix(accessTime, 0),
+	}, nil
+}
+
+// parseSCPEntry parses the mode, size and name of the C and D commands.
+func parseSCPEntry(value string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(value, " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid entry: %q", value)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid mode: %q", fields[0])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("invalid size: %q", fields[1])
+	}
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return 0, 0, "", fmt.Errorf("invalid name: %q", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+// scpBaseName returns the name of a directory sent to the client where the
+// root of the file system does not have a name.
+func scpBaseName(name string) string {
+	if name == "/" {
+		return "."
+	}
+	return path.Base(name)
+}
+
+func describeError(err error) string {
+	var violation *storage.PolicyViolationError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "No such file or directory"
+	case errors.Is(err, os.ErrPermission):
+		return "Permission denied"
+	case errors.Is(err, os.ErrExist):
+		return "File exists"
+	case errors.Is(err, syscall.EISDIR):
+		return "Is a directory"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "Not a directory"
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return "Disk quota exceeded"
+	case errors.As(err, &violation):
+		return "Upload policy violation: " + violation.Reason
+	case errors.Is(err, storage.ErrQuarantined):
+		return "File moved to quarantine"
+	}
+	return "Failure"
+}
+
+// zeroReader pads a file which cannot be read completely.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,16 +15,29 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/alexhokl/file-server/api"
+	"github.com/alexhokl/file-server/audit"
 	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/ftp"
 	"github.com/alexhokl/file-server/handler"
+	"github.com/alexhokl/file-server/s3"
+	"github.com/alexhokl/file-server/scan"
+	"github.com/alexhokl/file-server/storage"
+	"github.com/alexhokl/file-server/throttle"
+	"github.com/alexhokl/file-server/webhook"
 	"github.com/alexhokl/helper/cli"
 	"github.com/alexhokl/helper/database"
 	"github.com/gliderlabs/ssh"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 const SHUTDOWN_TIMEOUT_IN_SECONDS = 10
 const HTTP_SERVER_READ_HEADER_TIMEOUT_IN_SECONDS = 5
+const VERSION_CLEANUP_INTERVAL = time.Hour
+const TEMP_FILE_CLEANUP_INTERVAL = time.Hour
+const HOME_PURGE_INTERVAL = time.Hour
+const STAGED_CLEANUP_INTERVAL = time.Hour
+const WEBHOOK_DELIVERY_INTERVAL = 5 * time.Second
 
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -69,6 +84,17 @@ func main() {
 	}
 	slog.Info("database migration completed")
 
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if err := rotateMasterKey(dbConn, os.Args[2:]); err != nil {
+			slog.Error(
+				"unable to rotate master key",
+				slog.String("error", err.Error()),
+			)
+			os.Exit(1)
+		}
+		return
+	}
+
 	config, err := getConfiguration(dbConn)
 	if err != nil {
 		slog.Error(
@@ -78,6 +104,44 @@ func main() {
 		os.Exit(1)
 	}
 
+	storage.SetDefaultQuota(config.DefaultQuota)
+	storage.SetBackendConfig(config.Backends)
+	storage.SetMasterKey(config.MasterKey)
+	storage.SetVersioningConfig(config.Versioning)
+	storage.SetDefaultUploadPolicy(config.UploadPolicy)
+	storage.SetHomeConfig(config.Home)
+	storage.SetSymlinkPolicy(config.SymlinkPolicy)
+	storage.SetFileEventHandler(webhook.FileEventHandler(dbConn))
+	throttle.SetServerLimits(config.RateLimits)
+	if err := storage.ValidateBackend(config.Backends.Default); err != nil {
+		slog.Error(
+			"invalid storage backend",
+			slog.String("error", err.Error()),
+		)
+		os.Exit(1)
+	}
+
+	auditLogger, err := audit.NewLogger(dbConn, config.AuditLogFile)
+	if err != nil {
+		slog.Error(
+			"unable to open audit log file",
+			slog.String("error", err.Error()),
+			slog.String("file", config.AuditLogFile),
+		)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := auditLogger.Close(); err != nil {
+			slog.Error(
+				"unable to close audit log file",
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+
+	storage.SetScanConfig(config.Scan)
+	storage.SetQuarantineHandler(scan.QuarantineHandler(dbConn, auditLogger))
+
 	privateKeyBytes, err := os.ReadFile(config.HostKeyFile)
 	if err != nil {
 		slog.Error(
@@ -106,14 +170,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	connectionLimiter := handler.NewConnectionLimiter(config.ConnectionLimits)
 	server := ssh.Server{
 		Addr:    fmt.Sprintf(":%d", config.SSHServerPort),
-		Handler: handler.HandleNormalSession,
+		Handler: handler.GetNormalSessionHandler(dbConn, config.PathUsersDirectory, config.RsyncPath),
 		SubsystemHandlers: map[string]ssh.SubsystemHandler{
-			"sftp": handler.GetFileSessionHandler(config.PathUsersDirectory),
+			"sftp": handler.GetFileSessionHandler(dbConn, config.PathUsersDirectory, auditLogger, config.AtomicUploads),
 		},
 		PublicKeyHandler: getPublicKeyHandler(config.Users),
 		HostSigners:      []ssh.Signer{hostkey},
+		ConnCallback:     connectionLimiter.ConnCallback,
+		BannerHandler:    connectionLimiter.BannerHandler,
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session": connectionLimiter.ChannelHandler(ssh.DefaultSessionHandler),
+		},
 	}
 
 	go func() {
@@ -128,7 +198,7 @@ func main() {
 		}
 	}()
 
-	apiRouter, err := api.GetRouter(dialector)
+	apiRouter, err := api.GetRouter(dialector, config.PathUsersDirectory, auditLogger)
 	if err != nil {
 		slog.Error(
 			"unable to get API router",
@@ -149,6 +219,54 @@ func main() {
 		}
 	}()
 
+	var s3Server *http.Server
+	if config.S3ServerPort > 0 {
+		s3Server = &http.Server{
+			Addr:              fmt.Sprintf(":%d", config.S3ServerPort),
+			Handler:           s3.NewServer(dbConn, config.PathUsersDirectory),
+			ReadHeaderTimeout: HTTP_SERVER_READ_HEADER_TIMEOUT_IN_SECONDS * time.Second,
+		}
+
+		go func() {
+			slog.Info("starting S3 server", slog.String("addr", s3Server.Addr))
+			if err := s3Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("unable to start S3 server", slog.String("error", err.Error()))
+			}
+		}()
+	}
+
+	var ftpsServer *ftp.Server
+	if config.FTPSServerPort > 0 {
+		ftpsServer, err = ftp.NewServer(dbConn, config.PathUsersDirectory, auditLogger, config.FTPS)
+		if err != nil {
+			slog.Error(
+				"unable to create FTPS server",
+				slog.String("error", err.Error()),
+			)
+			os.Exit(1)
+		}
+		ftpsAddr := fmt.Sprintf(":%d", config.FTPSServerPort)
+
+		go func() {
+			slog.Info("starting FTPS server", slog.String("addr", ftpsAddr))
+			if err := ftpsServer.ListenAndServe(ftpsAddr); err != nil && !errors.Is(err, net.ErrClosed) {
+				slog.Error("unable to start FTPS server", slog.String("error", err.Error()))
+			}
+		}()
+	}
+
+	if config.Versioning.Enabled {
+		go runVersionCleanup(ctx, dbConn, config.PathUsersDirectory)
+	}
+	if config.AtomicUploads {
+		go runTempFileCleanup(ctx, dbConn, config.PathUsersDirectory, config.AtomicUploadMaxAge)
+	}
+	if config.S3ServerPort > 0 {
+		go runStagedCleanup(ctx, dbConn, config.PathUsersDirectory, config.S3MultipartMaxAge)
+	}
+	go runHomePurge(ctx, dbConn, config.PathUsersDirectory)
+	go runWebhookDelivery(ctx, dbConn)
+
 	<-ctx.Done()
 
 	stop()
@@ -168,6 +286,22 @@ func main() {
 			slog.String("error", err.Error()),
 		)
 	}
+	if s3Server != nil {
+		if err := s3Server.Shutdown(ctx); err != nil {
+			slog.Error(
+				"S3 server forced to shutdown",
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+	if ftpsServer != nil {
+		if err := ftpsServer.Shutdown(ctx); err != nil {
+			slog.Error(
+				"FTPS server forced to shutdown",
+				slog.String("error", err.Error()),
+			)
+		}
+	}
 
 	slog.Info("Server exiting")
 }
@@ -196,3 +330,105 @@ func getPublicKeyHandler(users map[string][]string) ssh.PublicKeyHandler {
 		return false
 	}
 }
+
+// runVersionCleanup removes versions of files beyond the retention
+// periodically until the context is done.
+func runVersionCleanup(ctx context.Context, dbConn *gorm.DB, pathUsersDirectory string) {
+	ticker := time.NewTicker(VERSION_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := storage.CleanupVersions(dbConn, pathUsersDirectory); err != nil {
+			slog.Error(
+				"unable to clean up versions",
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runTempFileCleanup removes temporary files left by atomic uploads which
+// have not been changed for the maximum age periodically until the context is
+// done.
+func runTempFileCleanup(ctx context.Context, dbConn *gorm.DB, pathUsersDirectory string, maxAge time.Duration) {
+	ticker := time.NewTicker(TEMP_FILE_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := storage.CleanupTempFiles(dbConn, pathUsersDirectory, maxAge); err != nil {
+			slog.Error(
+				"unable to clean up temporary files",
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runStagedCleanup removes the parts of multipart uploads which have not
+// been changed for the specified duration periodically until the context is
+// done.
+func runStagedCleanup(ctx context.Context, dbConn *gorm.DB, pathUsersDirectory string, maxAge time.Duration) {
+	ticker := time.NewTicker(STAGED_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := storage.CleanupStaged(dbConn, pathUsersDirectory, maxAge); err != nil {
+			slog.Error(
+				"unable to clean up staged uploads",
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runHomePurge purges the home directories of users deleted whose grace
+// period has passed periodically until the context is done.
+func runHomePurge(ctx context.Context, dbConn *gorm.DB, pathUsersDirectory string) {
+	ticker := time.NewTicker(HOME_PURGE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := storage.PurgeDeletedHomes(dbConn, pathUsersDirectory); err != nil {
+			slog.Error(
+				"unable to purge home directories of users deleted",
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWebhookDelivery delivers the queued events to webhooks periodically
+// until the context is done.
+func runWebhookDelivery(ctx context.Context, dbConn *gorm.DB) {
+	ticker := time.NewTicker(WEBHOOK_DELIVERY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := webhook.DeliverPending(ctx, dbConn); err != nil {
+			slog.Error(
+				"unable to deliver webhook events",
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
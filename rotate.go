@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/alexhokl/file-server/storage"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// rotateMasterKey wraps the data keys of all users with the master key in
+// the file specified in the arguments. The current master key is the one
+// configured and the configuration has to point to the new file once the
+// keys are rotated. Files are not re-encrypted.
+func rotateMasterKey(dbConn *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: file-server rotate-keys <new master key file>")
+	}
+	pathMasterKey := viper.GetString("encryption_master_key_file")
+	if pathMasterKey == "" {
+		return fmt.Errorf("encryption master key file is not set")
+	}
+	oldMasterKey, err := storage.LoadMasterKey(pathMasterKey)
+	if err != nil {
+		return err
+	}
+	newMasterKey, err := storage.LoadMasterKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	count, err := storage.RotateDataKeys(dbConn, oldMasterKey, newMasterKey)
+	if err != nil {
+		return err
+	}
+	slog.Info(
+		"data keys rotated",
+		slog.Int("count", count),
+		slog.String("master_key_file", args[0]),
+	)
+	return nil
+}
@@ -0,0 +1,448 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+const (
+	signatureAlgorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat          = "20060102T150405Z"
+	maxRequestTimeSkew     = 15 * time.Minute
+	maxPresignedExpiry     = 7 * 24 * time.Hour
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256            = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxChunkSize           = 16 << 20
+)
+
+// authentication is the result of verifying the signature of a request.
+type authentication struct {
+	username string
+
+	// body is the request body to be read in place of the original body. It
+	// decodes chunked payloads and verifies the payload hash when the body
+	// is fully read.
+	body io.ReadCloser
+}
+
+// signature holds the parsed AWS signature version 4 of a request.
+type signature struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       time.Time
+	presigned     bool
+}
+
+// authenticate verifies AWS signature version 4 of a request, either in the
+// Authorization header or in the query string of a presigned URL.
+func (s *Server) authenticate(r *http.Request) (*authentication, error) {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessKey db.AccessKey
+	if err := s.dbConn.Where("access_key_id = ?", sig.accessKeyID).First(&accessKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidAccessKeyID
+		}
+		return nil, err
+	}
+
+	payloadHash := unsignedPayload
+	if !sig.presigned {
+		payloadHash = r.Header.Get("x-amz-content-sha256")
+		if payloadHash == "" {
+			payloadHash = emptySHA256
+		}
+	}
+
+	signingKey := deriveSigningKey(accessKey.SecretAccessKey, sig.date, sig.region, sig.service)
+	canonicalRequest := buildCanonicalRequest(r, sig.signedHeaders, payloadHash, sig.presigned)
+	expected := hex.EncodeToString(hmacSHA256(signingKey, buildStringToSign(sig, canonicalRequest)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(sig.signature)) != 1 {
+		return nil, errSignatureDoesNotMatch
+	}
+
+	auth := &authentication{
+		username: accessKey.Username,
+		body:     r.Body,
+	}
+
+	switch payloadHash {
+	case unsignedPayload:
+	case streamingPayload:
+		auth.body = newChunkedReader(r.Body, &chunkSigner{
+			key:           signingKey,
+			amzDate:       sig.amzDate.Format(amzDateFormat),
+			scope:         sig.scope(),
+			prevSignature: sig.signature,
+		})
+	case streamingUnsignedTrail:
+		auth.body = newChunkedReader(r.Body, nil)
+	default:
+		if len(payloadHash) != sha256.Size*2 || strings.HasPrefix(payloadHash, "STREAMING-") {
+			return nil, errNotImplemented
+		}
+		auth.body = &verifyingReader{
+			ReadCloser: r.Body,
+			hash:       sha256.New(),
+			expected:   payloadHash,
+		}
+	}
+
+	return auth, nil
+}
+
+func parseSignature(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresignedSignature(r)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, errMissingSecurityHeader
+	}
+	algorithm, fields, ok := strings.Cut(authorization, " ")
+	if !ok || algorithm != signatureAlgorithm {
+		return nil, errUnsupportedSignature
+	}
+
+	sig := &signature{}
+	for _, field := range strings.Split(fields, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errInvalidArgument
+		}
+		switch name {
+		case "Credential":
+			if err := sig.parseCredential(value); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	if sig.accessKeyID == "" || len(sig.signedHeaders) == 0 || sig.signature == "" {
+		return nil, errInvalidArgument
+	}
+
+	t, err := time.Parse(amzDateFormat, r.Header.Get("x-amz-date"))
+	if err != nil {
+		return nil, errMissingSecurityHeader
+	}
+	sig.amzDate = t
+	if !sig.validScope() {
+		return nil, errMalformedAuthHeader
+	}
+	if skew := time.Since(t); skew > maxRequestTimeSkew || skew < -maxRequestTimeSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	return sig, nil
+}
+
+func parsePresignedSignature(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != signatureAlgorithm {
+		return nil, errUnsupportedSignature
+	}
+
+	sig := &signature{
+		signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     query.Get("X-Amz-Signature"),
+		presigned:     true,
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	if sig.signature == "" {
+		return nil, errInvalidArgument
+	}
+
+	t, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, errInvalidArgument
+	}
+	sig.amzDate = t
+	if !sig.validScope() {
+		return nil, errMalformedAuthQuery
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+		return nil, errInvalidArgument
+	}
+	if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredToken
+	}
+	if time.Until(t) > maxRequestTimeSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	return sig, nil
+}
+
+// parseCredential parses credential in the format of
+// <access key ID>/<date>/<region>/<service>/aws4_request.
+func (sig *signature) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return errInvalidArgument
+	}
+	sig.accessKeyID = parts[0]
+	sig.date = parts[1]
+	sig.region = parts[2]
+	sig.service = parts[3]
+	return nil
+}
+
+// validScope returns whether the host is signed and the date of the
+// credential scope is the day of the request, as AWS requires.
+func (sig *signature) validScope() bool {
+	return slices.Contains(sig.signedHeaders, "host") && sig.date == sig.amzDate.UTC().Format("20060102")
+}
+
+func (sig *signature) scope() string {
+	return strings.Join([]string{sig.date, sig.region, sig.service, "aws4_request"}, "/")
+}
+
+func buildCanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string, presigned bool) string {
+	var buf strings.Builder
+	buf.WriteString(r.Method)
+	buf.WriteByte('\n')
+	buf.WriteString(uriEncode(r.URL.Path, false))
+	buf.WriteByte('\n')
+	buf.WriteString(canonicalQueryString(r, presigned))
+	buf.WriteByte('\n')
+	for _, name := range signedHeaders {
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(canonicalHeaderValue(r, name))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(strings.Join(signedHeaders, ";"))
+	buf.WriteByte('\n')
+	buf.WriteString(payloadHash)
+	return buf.String()
+}
+
+func canonicalQueryString(r *http.Request, presigned bool) string {
+	var pairs []string
+	for name, values := range r.URL.Query() {
+		if presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" && r.ContentLength >= 0 {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	case "transfer-encoding":
+		if len(r.TransferEncoding) > 0 {
+			return strings.Join(r.TransferEncoding, ",")
+		}
+	}
+	values := r.Header.Values(name)
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+func buildStringToSign(sig *signature, canonicalRequest string) []byte {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	return []byte(strings.Join([]string{
+		signatureAlgorithm,
+		sig.amzDate.Format(amzDateFormat),
+		sig.scope(),
+		hex.EncodeToString(hashed[:]),
+	}, "\n"))
+}
+
+func deriveSigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// uriEncode encodes a string with the rules of AWS signature version 4
+// where only unreserved characters are kept as is.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// verifyingReader verifies the SHA-256 hash of the content once it is read
+// to the end.
+type verifyingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.expected {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkSigner verifies the signatures of the chunks of a streaming payload.
+type chunkSigner struct {
+	key           []byte
+	amzDate       string
+	scope         string
+	prevSignature string
+}
+
+func (s *chunkSigner) verify(data []byte, chunkSignature string) bool {
+	hashed := sha256.Sum256(data)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		s.amzDate,
+		s.scope,
+		s.prevSignature,
+		emptySHA256,
+		hex.EncodeToString(hashed[:]),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(s.key, []byte(stringToSign)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(chunkSignature)) != 1 {
+		return false
+	}
+	s.prevSignature = expected
+	return true
+}
+
+// chunkedReader decodes an aws-chunked payload. If signer is nil, chunk
+// signatures are not expected and trailing headers are discarded.
+type chunkedReader struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	signer *chunkSigner
+	chunk  []byte
+	done   bool
+}
+
+func newChunkedReader(body io.ReadCloser, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{
+		body:   body,
+		reader: bufio.NewReader(body),
+		signer: signer,
+	}
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *chunkedReader) readChunk() error {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return errIncompleteBody
+	}
+	line = strings.TrimRight(line, "\r\n")
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return errIncompleteBody
+	}
+
+	if r.signer != nil {
+		chunkSignature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok || !r.signer.verify(data, chunkSignature) {
+			return errSignatureDoesNotMatch
+		}
+	}
+
+	if size == 0 {
+		r.done = true
+		// trailing headers, if any, are not used
+		_, err := io.Copy(io.Discard, r.reader)
+		return err
+	}
+
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(r.reader, crlf); err != nil || !bytes.Equal(crlf, []byte("\r\n")) {
+		return errIncompleteBody
+	}
+	r.chunk = data
+	return nil
+}
+
+func (r *chunkedReader) Close() error {
+	return r.body.Close()
+}
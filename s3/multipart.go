@@ -0,0 +1,258 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+const (
+	maxPartNumber      = 10000
+	uploadMetadataFile = "upload.json"
+	partETagSuffix     = ".etag"
+)
+
+// multipartUpload is the metadata of a multipart upload in progress.
+type multipartUpload struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Initiated time.Time `json:"initiated"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, fileSystem *storage.FileSystem, bucket string, key string) error {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(buf)
+
+	metadata, err := json.Marshal(multipartUpload{
+		Bucket:    bucket,
+		Key:       key,
+		Initiated: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeStagedFile(fileSystem, path.Join(uploadID, uploadMetadataFile), metadata); err != nil {
+		return err
+	}
+
+	return writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    xmlNamespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, bucket string, key string, uploadID string, partNumberValue string) error {
+	partNumber, err := strconv.Atoi(partNumberValue)
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return errInvalidArgument
+	}
+	uploadDirectory, err := getUploadDirectory(fileSystem, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// the ETag of a part replaced is removed first so that the part cannot
+	// be completed until it is written in full
+	partPath := path.Join(uploadDirectory, partFileName(partNumber))
+	if err := fileSystem.RemoveStaged(partPath + partETagSuffix); err != nil {
+		return err
+	}
+	file, err := fileSystem.CreateStaged(partPath)
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), r.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyContentMD5(hash, r.Header.Get("Content-MD5"))
+	}
+	if err == nil {
+		err = writeStagedFile(fileSystem, partPath+partETagSuffix, []byte(hex.EncodeToString(hash.Sum(nil))))
+	}
+	if err != nil {
+		if removeErr := fileSystem.RemoveStaged(partPath); removeErr != nil {
+			return errors.Join(err, removeErr)
+		}
+		return err
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(hash.Sum(nil))))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, bucket string, key string, uploadID string) error {
+	uploadDirectory, err := getUploadDirectory(fileSystem, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var req completeMultipartUploadRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+	if len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	var partPaths []string
+	combinedHash := md5.New()
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		partPath := path.Join(uploadDirectory, partFileName(part.PartNumber))
+		etag, err := readStagedFile(fileSystem, partPath+partETagSuffix)
+		if err != nil {
+			if isNotExist(err) {
+				return errInvalidPart
+			}
+			return err
+		}
+		if strings.Trim(part.ETag, `"`) != string(etag) {
+			return errInvalidPart
+		}
+		sum, err := hex.DecodeString(string(etag))
+		if err != nil {
+			return err
+		}
+		combinedHash.Write(sum)
+		partPaths = append(partPaths, partPath)
+	}
+
+	parts := make([]io.Reader, 0, len(partPaths))
+	for _, partPath := range partPaths {
+		file, err := fileSystem.OpenStaged(partPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		parts = append(parts, file)
+	}
+	if _, err := writeObject(fileSystem, key, io.MultiReader(parts...), ""); err != nil {
+		return err
+	}
+
+	if err := fileSystem.RemoveStaged(uploadDirectory); err != nil {
+		return err
+	}
+
+	return writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:  xmlNamespace,
+		Bucket: bucket,
+		Key:    key,
+		ETag:   fmt.Sprintf("%q", fmt.Sprintf("%s-%d", hex.EncodeToString(combinedHash.Sum(nil)), len(partPaths))),
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, fileSystem *storage.FileSystem, bucket string, key string, uploadID string) error {
+	uploadDirectory, err := getUploadDirectory(fileSystem, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if err := fileSystem.RemoveStaged(uploadDirectory); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) listMultipartUploads(w http.ResponseWriter, fileSystem *storage.FileSystem, bucket string) error {
+	entries, err := fileSystem.ReadStagedDir("/")
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+
+	result := listMultipartUploadsResult{
+		Xmlns:  xmlNamespace,
+		Bucket: bucket,
+	}
+	for _, entry := range entries {
+		upload, err := readMultipartUpload(fileSystem, entry.Name())
+		if err != nil || upload.Bucket != bucket {
+			continue
+		}
+		result.Uploads = append(result.Uploads, multipartUploadInfo{
+			Key:       upload.Key,
+			UploadID:  entry.Name(),
+			Initiated: upload.Initiated.Format(time.RFC3339),
+		})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// getUploadDirectory returns the staged directory where the parts of the
+// specified upload are kept, if the upload belongs to the bucket and the key.
+func getUploadDirectory(fileSystem *storage.FileSystem, bucket string, key string, uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", errNoSuchUpload
+	}
+	upload, err := readMultipartUpload(fileSystem, uploadID)
+	if err != nil {
+		if isNotExist(err) {
+			return "", errNoSuchUpload
+		}
+		return "", err
+	}
+	if upload.Bucket != bucket || upload.Key != key {
+		return "", errNoSuchUpload
+	}
+	return uploadID, nil
+}
+
+func readMultipartUpload(fileSystem *storage.FileSystem, uploadDirectory string) (*multipartUpload, error) {
+	buf, err := readStagedFile(fileSystem, path.Join(uploadDirectory, uploadMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var upload multipartUpload
+	if err := json.Unmarshal(buf, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func readStagedFile(fileSystem *storage.FileSystem, name string) ([]byte, error) {
+	file, err := fileSystem.OpenStaged(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func writeStagedFile(fileSystem *storage.FileSystem, name string, content []byte) error {
+	file, err := fileSystem.CreateStaged(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func partFileName(partNumber int) string {
+	return fmt.Sprintf("part-%05d", partNumber)
+}
@@ -0,0 +1,429 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+const (
+	defaultMaxKeys = 1000
+	storageClass   = "STANDARD"
+)
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request, username string) error {
+	fileSystem, err := storage.OpenUserFileSystem(s.dbConn, s.pathUsersDirectory, username)
+	if err != nil {
+		return err
+	}
+	info, err := fileSystem.Stat("/")
+	if err != nil {
+		return err
+	}
+
+	result := listAllMyBucketsResult{
+		Xmlns: xmlNamespace,
+		Owner: owner{
+			ID:          username,
+			DisplayName: username,
+		},
+		Buckets: []bucketInfo{
+			{
+				Name:         username,
+				CreationDate: info.ModTime().UTC().Format(time.RFC3339),
+			},
+		},
+	}
+	for _, name := range fileSystem.SharedFolderNames() {
+		// a shared folder of the same name as the user is hidden by the
+		// home directory
+		if name == username {
+			continue
+		}
+		folder, _ := fileSystem.SharedFolder(name)
+		info, err := folder.Stat("/")
+		if err != nil {
+			return err
+		}
+		result.Buckets = append(result.Buckets, bucketInfo{
+			Name:         name,
+			CreationDate: info.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// listedObject is an object or a directory found while listing a bucket.
+type listedObject struct {
+	key  string
+	info os.FileInfo
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, bucket string) error {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	startAfter := query.Get("start-after")
+	continuationToken := query.Get("continuation-token")
+	maxKeys := defaultMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		maxKeys = min(n, defaultMaxKeys)
+	}
+
+	marker := startAfter
+	if continuationToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(continuationToken)
+		if err != nil {
+			return errInvalidArgument
+		}
+		marker = string(decoded)
+	}
+
+	objects, err := collectObjects(fileSystem, prefix, delimiter == "/")
+	if err != nil {
+		return err
+	}
+
+	result := listBucketV2Result{
+		Xmlns:             xmlNamespace,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        startAfter,
+		ContinuationToken: continuationToken,
+		MaxKeys:           maxKeys,
+		EncodingType:      query.Get("encoding-type"),
+	}
+	encode := func(s string) string {
+		if result.EncodingType == "url" {
+			return uriEncode(s, false)
+		}
+		return s
+	}
+	result.Prefix = encode(prefix)
+	result.StartAfter = encode(startAfter)
+
+	seenPrefixes := map[string]bool{}
+	lastKey := ""
+	for _, object := range objects {
+		if object.key <= marker || !strings.HasPrefix(object.key, prefix) {
+			continue
+		}
+
+		entryKey := object.key
+		isPrefix := false
+		if delimiter != "" {
+			if i := strings.Index(object.key[len(prefix):], delimiter); i >= 0 {
+				entryKey = object.key[:len(prefix)+i+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if isPrefix && (seenPrefixes[entryKey] || entryKey <= marker) {
+			continue
+		}
+
+		if result.KeyCount >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if isPrefix {
+			seenPrefixes[entryKey] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(entryKey)})
+		} else {
+			result.Contents = append(result.Contents, objectInfo{
+				Key:          encode(object.key),
+				LastModified: object.info.ModTime().UTC().Format(time.RFC3339),
+				ETag:         getETag(object.info),
+				Size:         objectSize(object.info),
+				StorageClass: storageClass,
+			})
+		}
+		result.KeyCount++
+		lastKey = entryKey
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+	}
+
+	return writeXML(w, http.StatusOK, result)
+}
+
+// collectObjects returns the objects of a bucket which may match the
+// specified prefix, sorted by key. Directories are returned with a trailing
+// slash in their keys. If shallow is set, directories below the level of the
+// prefix are not traversed as they are rolled up into common prefixes.
+func collectObjects(fileSystem *storage.FileSystem, prefix string, shallow bool) ([]listedObject, error) {
+	startDirectory := "/"
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		startDirectory = "/" + prefix[:i]
+	}
+
+	var objects []listedObject
+	var walk func(directory string) error
+	walk = func(directory string) error {
+		entries, err := fileSystem.ReadDir(directory)
+		if err != nil {
+			if isNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			entryPath := path.Join(directory, entry.Name())
+			key := strings.TrimPrefix(entryPath, "/")
+			if !entry.IsDir() {
+				if entry.Mode().IsRegular() {
+					objects = append(objects, listedObject{key: key, info: entry})
+				}
+				continue
+			}
+
+			objects = append(objects, listedObject{key: key + "/", info: entry})
+			if shallow && !strings.HasPrefix(prefix, key+"/") {
+				continue
+			}
+			if err := walk(entryPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(storage.CleanPath(startDirectory)); err != nil {
+		return nil, err
+	}
+
+	// a directory is only listed as an object when it is empty
+	objects = removeNonEmptyDirectories(objects)
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].key < objects[j].key
+	})
+	return objects, nil
+}
+
+func removeNonEmptyDirectories(objects []listedObject) []listedObject {
+	nonEmpty := map[string]bool{}
+	for _, object := range objects {
+		parent := strings.TrimSuffix(object.key, "/")
+		for i := strings.LastIndex(parent, "/"); i >= 0; i = strings.LastIndex(parent, "/") {
+			parent = parent[:i]
+			nonEmpty[parent+"/"] = true
+		}
+	}
+
+	list := make([]listedObject, 0, len(objects))
+	for _, object := range objects {
+		if object.info.IsDir() && nonEmpty[object.key] {
+			continue
+		}
+		list = append(list, object)
+	}
+	return list
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, key string) error {
+	info, err := fileSystem.Stat(key)
+	if err != nil {
+		if isNotExist(err) {
+			return errNoSuchKey
+		}
+		return err
+	}
+	if info.IsDir() != strings.HasSuffix(key, "/") {
+		return errNoSuchKey
+	}
+
+	w.Header().Set("ETag", getETag(info))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if info.IsDir() {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	file, err := fileSystem.Open(key)
+	if err != nil {
+		if isNotExist(err) {
+			return errNoSuchKey
+		}
+		return err
+	}
+	defer file.Close()
+
+	http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, key string) error {
+	if strings.HasSuffix(key, "/") {
+		if err := fileSystem.MkdirAll(key, 0o755); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return err
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(md5.New().Sum(nil))))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	sum, err := writeObject(fileSystem, key, r.Body, r.Header.Get("Content-MD5"))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(sum)))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// writeObject writes the content into a temporary file next to the object
+// and renames it into place once the content is fully received and
+// verified, so that a failed upload never leaves a partial object behind.
+// It returns the MD5 checksum of the content.
+func writeObject(fileSystem *storage.FileSystem, key string, content io.Reader, contentMD5 string) ([]byte, error) {
+	objectPath := storage.CleanPath(key)
+	if err := fileSystem.MkdirAll(path.Dir(objectPath), 0o755); err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	tempPath := path.Join(path.Dir(objectPath), fmt.Sprintf(".%s.s3tmp-%s", path.Base(objectPath), hex.EncodeToString(suffix)))
+
+	file, err := fileSystem.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyContentMD5(hash, contentMD5)
+	}
+	if err == nil {
+		err = fileSystem.Rename(tempPath, objectPath)
+	}
+	if err != nil {
+		if removeErr := fileSystem.Remove(tempPath); removeErr != nil && !isNotExist(removeErr) {
+			return nil, errors.Join(err, removeErr)
+		}
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func verifyContentMD5(hash hash.Hash, contentMD5 string) error {
+	if contentMD5 == "" {
+		return nil
+	}
+	expected, err := base64.StdEncoding.DecodeString(contentMD5)
+	if err != nil {
+		return errInvalidArgument
+	}
+	if !strings.EqualFold(hex.EncodeToString(expected), hex.EncodeToString(hash.Sum(nil))) {
+		return errBadDigest
+	}
+	return nil
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, fileSystem *storage.FileSystem, key string) error {
+	if err := removeObject(fileSystem, key); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem) error {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+
+	result := deleteResult{
+		Xmlns: xmlNamespace,
+	}
+	for _, object := range req.Objects {
+		if err := removeObject(fileSystem, object.Key); err != nil {
+			e, ok := err.(*apiError)
+			if !ok {
+				e = errInternalError
+				if errors.Is(err, fs.ErrPermission) {
+					e = errAccessDenied
+				}
+			}
+			result.Errors = append(result.Errors, deleteError{
+				Key:     object.Key,
+				Code:    e.code,
+				Message: e.message,
+			})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+		}
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// removeObject removes an object. As in S3, removing an object which does
+// not exist is not an error.
+func removeObject(fileSystem *storage.FileSystem, key string) error {
+	if storage.CleanPath(key) == "/" {
+		return errInvalidArgument
+	}
+	info, err := fileSystem.Lstat(key)
+	if err != nil {
+		if isNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() != strings.HasSuffix(key, "/") {
+		return nil
+	}
+	if err := fileSystem.Remove(key); err != nil && !isNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// getETag returns an entity tag of a file derived from its size and
+// modification time. It is not the MD5 checksum of the content as it is not
+// known unless the file is read.
+func getETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), objectSize(info))
+}
+
+func objectSize(info os.FileInfo) int64 {
+	if info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
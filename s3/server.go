@@ -0,0 +1,197 @@
+package s3
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/alexhokl/file-server/storage"
+	"gorm.io/gorm"
+)
+
+// Server serves an S3-compatible API where the buckets of a user are the
+// home directory of the user and the shared folders of the groups of the
+// user. Only path-style requests are supported.
+type Server struct {
+	dbConn             *gorm.DB
+	pathUsersDirectory string
+}
+
+// NewServer returns an S3-compatible API server storing objects in the home
+// directories under the specified users directory. The parts of multipart
+// uploads are staged in the buckets they are uploaded to.
+func NewServer(dbConn *gorm.DB, pathUsersDirectory string) *Server {
+	return &Server{
+		dbConn:             dbConn,
+		pathUsersDirectory: pathUsersDirectory,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := slog.With(
+		slog.String("remote", r.RemoteAddr),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	)
+
+	auth, err := s.authenticate(r)
+	if err != nil {
+		logger.Warn(
+			"s3 authentication failed",
+			slog.String("error", err.Error()),
+		)
+		writeError(w, r, err)
+		return
+	}
+	logger = logger.With(slog.String("user", auth.username))
+	r.Body = auth.body
+
+	bucket, key := splitPath(r.URL.Path)
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		s.listBuckets(w, r, auth.username)
+		return
+	}
+
+	fileSystem, err := s.getBucketFileSystem(auth.username, bucket)
+	if err != nil {
+		if !errors.Is(err, errAccessDenied) && !errors.Is(err, errNoSuchBucket) {
+			logger.Error(
+				"unable to open bucket",
+				slog.String("error", err.Error()),
+				slog.String("bucket", bucket),
+			)
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	if key == "" {
+		err = s.serveBucket(w, r, fileSystem, bucket)
+	} else {
+		err = s.serveObject(w, r, fileSystem, bucket, key)
+	}
+	var violation *storage.PolicyViolationError
+	if errors.Is(err, fs.ErrPermission) {
+		err = errAccessDenied
+	} else if errors.Is(err, storage.ErrQuotaExceeded) {
+		err = errQuotaExceeded
+	} else if errors.As(err, &violation) {
+		err = &apiError{"AccessDenied", "Upload policy violation: " + violation.Reason, http.StatusForbidden}
+	} else if errors.Is(err, storage.ErrQuarantined) {
+		err = &apiError{"AccessDenied", "The object has been moved to quarantine by the malware scan.", http.StatusForbidden}
+	}
+	if err != nil {
+		if _, ok := err.(*apiError); !ok {
+			logger.Error(
+				"s3 request failed",
+				slog.String("error", err.Error()),
+				slog.String("bucket", bucket),
+				slog.String("key", key),
+			)
+		}
+		writeError(w, r, err)
+	}
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, bucket string) error {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodPut:
+		// buckets are home directories and shared folders which always
+		// exist
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodGet:
+		if query.Has("location") {
+			return writeXML(w, http.StatusOK, locationConstraint{})
+		}
+		if query.Has("uploads") {
+			return s.listMultipartUploads(w, fileSystem, bucket)
+		}
+		if query.Get("list-type") == "2" {
+			return s.listObjectsV2(w, r, fileSystem, bucket)
+		}
+		return errNotImplemented
+	case http.MethodPost:
+		if query.Has("delete") {
+			return s.deleteObjects(w, r, fileSystem)
+		}
+		return errNotImplemented
+	}
+	return errMethodNotAllowed
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, fileSystem *storage.FileSystem, bucket string, key string) error {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			return errNotImplemented
+		}
+		return s.getObject(w, r, fileSystem, key)
+	case http.MethodPut:
+		if uploadID != "" {
+			return s.uploadPart(w, r, fileSystem, bucket, key, uploadID, query.Get("partNumber"))
+		}
+		if r.Header.Get("x-amz-copy-source") != "" {
+			return errNotImplemented
+		}
+		return s.putObject(w, r, fileSystem, key)
+	case http.MethodPost:
+		if query.Has("uploads") {
+			return s.createMultipartUpload(w, fileSystem, bucket, key)
+		}
+		if uploadID != "" {
+			return s.completeMultipartUpload(w, r, fileSystem, bucket, key, uploadID)
+		}
+		return errNotImplemented
+	case http.MethodDelete:
+		if uploadID != "" {
+			return s.abortMultipartUpload(w, fileSystem, bucket, key, uploadID)
+		}
+		return s.deleteObject(w, fileSystem, key)
+	}
+	return errMethodNotAllowed
+}
+
+// getBucketFileSystem returns the file system of the specified bucket if
+// the authenticated user has access to it. The bucket of the username is the
+// home directory of the user and the other buckets of the user are the
+// shared folders of the groups of the user.
+func (s *Server) getBucketFileSystem(username string, bucket string) (*storage.FileSystem, error) {
+	fileSystem, err := storage.OpenUserFileSystem(s.dbConn, s.pathUsersDirectory, username)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == username {
+		return fileSystem, nil
+	}
+	if folder, ok := fileSystem.SharedFolder(bucket); ok {
+		return folder, nil
+	}
+	if _, err := storage.GetHomePath(s.pathUsersDirectory, bucket); err != nil {
+		return nil, errNoSuchBucket
+	}
+	return nil, errAccessDenied
+}
+
+// splitPath splits the path of a path-style request into bucket and key.
+func splitPath(requestPath string) (string, string) {
+	trimmed := strings.TrimPrefix(requestPath, "/")
+	bucket, key, _ := strings.Cut(trimmed, "/")
+	return bucket, key
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+// clamdChunkSize is the size of the chunks of a stream sent to clamd which
+// is well below the default StreamMaxLength of clamd.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans files with the clamd daemon of ClamAV listening on a
+// unix socket with the INSTREAM command.
+type ClamdScanner struct {
+	socketPath string
+}
+
+// NewClamdScanner returns a scanner connecting to clamd at the specified
+// unix socket.
+func NewClamdScanner(socketPath string) *ClamdScanner {
+	return &ClamdScanner{socketPath: socketPath}
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (storage.ScanResult, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.socketPath)
+	if err != nil {
+		return storage.ScanResult{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return storage.ScanResult{}, err
+		}
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return storage.ScanResult{}, err
+	}
+	buffer := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := r.Read(buffer[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buffer[:4], uint32(n))
+			if _, err := conn.Write(buffer[:4+n]); err != nil {
+				// clamd closes the connection once the stream is too long
+				// and the reply tells why
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return storage.ScanResult{}, err
+		}
+	}
+	// a chunk of zero length ends the stream
+	_, _ = conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return storage.ScanResult{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses a reply of clamd such as "stream: OK" or
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (storage.ScanResult, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return storage.ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return storage.ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return storage.ScanResult{}, fmt.Errorf("clamd: %s", reply)
+}
@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/alexhokl/file-server/storage"
+)
+
+// CommandScanner scans files with a local command reading the content of a
+// file from its standard input, such as "clamscan --no-summary -". The exit
+// code is zero for a clean file and one for an infected file, in which case
+// the output is taken as the name of the malware found.
+type CommandScanner struct {
+	command []string
+}
+
+// NewCommandScanner returns a scanner running the specified command with its
+// arguments.
+func NewCommandScanner(command []string) *CommandScanner {
+	return &CommandScanner{command: command}
+}
+
+func (s *CommandScanner) Scan(ctx context.Context, r io.Reader) (storage.ScanResult, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Env = []string{"PATH=/usr/bin:/bin"}
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return storage.ScanResult{}, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		return storage.ScanResult{}, fmt.Errorf("%s: %w: %s", s.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return storage.ScanResult{Infected: true, Signature: parseSignature(stdout.String())}, nil
+}
+
+// parseSignature returns the name of the malware in the output of a scanner
+// where an output like "stdin: Eicar-Signature FOUND" of clamscan is reduced
+// to the name.
+func parseSignature(output string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	if _, verdict, ok := strings.Cut(line, ": "); ok {
+		line = verdict
+	}
+	line = strings.TrimSpace(strings.TrimSuffix(line, " FOUND"))
+	if line == "" {
+		return "unknown"
+	}
+	return line
+}
@@ -0,0 +1,47 @@
+package scan
+
+import (
+	"log/slog"
+
+	"github.com/alexhokl/file-server/audit"
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"gorm.io/gorm"
+)
+
+// QuarantineHandler returns the handler keeping a record of the files moved
+// to quarantine for review and recording them in the audit log.
+func QuarantineHandler(dbConn *gorm.DB, auditLogger *audit.Logger) func(storage.QuarantinedFile) {
+	return func(file storage.QuarantinedFile) {
+		slog.Warn(
+			"file moved to quarantine",
+			slog.String("id", file.ID),
+			slog.String("user", file.Username),
+			slog.String("path", file.Path),
+			slog.String("signature", file.Signature),
+			slog.String("error", file.Error),
+		)
+		record := db.QuarantinedFile{
+			ID:        file.ID,
+			Username:  file.Username,
+			Path:      file.Path,
+			Size:      file.Size,
+			Signature: file.Signature,
+			Error:     file.Error,
+		}
+		if err := dbConn.Create(&record).Error; err != nil {
+			slog.Error(
+				"unable to record quarantined file",
+				slog.String("error", err.Error()),
+				slog.String("id", file.ID),
+			)
+		}
+		auditLogger.Record(db.AuditEvent{
+			Username:  file.Username,
+			Protocol:  "scan",
+			Operation: "quarantine",
+			Path:      file.Path,
+			Bytes:     file.Size,
+		})
+	}
+}
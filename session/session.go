@@ -0,0 +1,274 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of sessions.
+const (
+	// KindConnection is an SSH connection
+	KindConnection = "connection"
+
+	// KindSFTP is an SFTP subsystem of an SSH connection
+	KindSFTP = "sftp"
+
+	// KindCommand is a command, such as scp or rsync, executed over an SSH
+	// connection
+	KindCommand = "command"
+
+	// KindFTPS is an FTPS control connection along with its data
+	// connections
+	KindFTPS = "ftps"
+)
+
+// ErrNotFound is returned when a session is not active.
+var ErrNotFound = errors.New("session not found")
+
+// Session is an active connection or session of a user which is tracked in
+// memory until it ends.
+type Session struct {
+	id            string
+	kind          string
+	connectionID  string
+	remoteAddress string
+	startedAt     time.Time
+	closer        io.Closer
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mutex     sync.Mutex
+	username  string
+	command   string
+	openFiles []string
+}
+
+// Info is a snapshot of a session.
+type Info struct {
+	ID            string
+	Kind          string
+	ConnectionID  string
+	Username      string
+	RemoteAddress string
+	Command       string
+	StartedAt     time.Time
+	BytesIn       int64
+	BytesOut      int64
+	OpenFiles     []string
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]*Session{}
+)
+
+// Register tracks a new session until Unregister is called. A session
+// closed through Close is closed with the closer. The connection ID is the
+// ID of the connection of the session, if any.
+func Register(kind string, connectionID string, username string, remoteAddress string, closer io.Closer) *Session {
+	s := &Session{
+		id:            newID(),
+		kind:          kind,
+		connectionID:  connectionID,
+		username:      username,
+		remoteAddress: remoteAddress,
+		startedAt:     time.Now().UTC(),
+		closer:        closer,
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[s.id] = s
+	return s
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// the time is unique enough as a fallback
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Unregister stops tracking the session.
+func (s *Session) Unregister() {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	delete(registry, s.id)
+}
+
+// ID returns the ID of the session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// SetUsername sets the user of a connection once the user is
+// authenticated.
+func (s *Session) SetUsername(username string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.username = username
+}
+
+// SetCommand sets the command executed by the session.
+func (s *Session) SetCommand(command string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.command = command
+}
+
+// AddBytesIn adds bytes received from the client.
+func (s *Session) AddBytesIn(n int) {
+	s.bytesIn.Add(int64(n))
+}
+
+// AddBytesOut adds bytes sent to the client.
+func (s *Session) AddBytesOut(n int) {
+	s.bytesOut.Add(int64(n))
+}
+
+// OpenFile adds a file opened by the session.
+func (s *Session) OpenFile(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.openFiles = append(s.openFiles, name)
+}
+
+// CloseFile removes a file opened by the session.
+func (s *Session) CloseFile(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i := slices.Index(s.openFiles, name); i >= 0 {
+		s.openFiles = slices.Delete(s.openFiles, i, i+1)
+	}
+}
+
+// Info returns a snapshot of the session.
+func (s *Session) Info() Info {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return Info{
+		ID:            s.id,
+		Kind:          s.kind,
+		ConnectionID:  s.connectionID,
+		Username:      s.username,
+		RemoteAddress: s.remoteAddress,
+		Command:       s.command,
+		StartedAt:     s.startedAt,
+		BytesIn:       s.bytesIn.Load(),
+		BytesOut:      s.bytesOut.Load(),
+		OpenFiles:     slices.Clone(s.openFiles),
+	}
+}
+
+// List returns the snapshots of the active sessions in the order they
+// started.
+func List() []Info {
+	registryMutex.Lock()
+	sessions := make([]*Session, 0, len(registry))
+	for _, s := range registry {
+		sessions = append(sessions, s)
+	}
+	registryMutex.Unlock()
+
+	list := make([]Info, len(sessions))
+	for i, s := range sessions {
+		list[i] = s.Info()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].StartedAt.Equal(list[j].StartedAt) {
+			return list[i].StartedAt.Before(list[j].StartedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Close closes the specified session forcibly. Closing a connection ends
+// all the sessions of the connection.
+func Close(id string) error {
+	registryMutex.Lock()
+	s, ok := registry[id]
+	registryMutex.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return s.closer.Close()
+}
+
+// CloseUser closes all the sessions of the specified user forcibly and
+// returns the number of sessions closed. The sessions of a connection closed
+// end along with the connection.
+func CloseUser(username string) (int, error) {
+	registryMutex.Lock()
+	sessions := make([]*Session, 0, len(registry))
+	for _, s := range registry {
+		sessions = append(sessions, s)
+	}
+	registryMutex.Unlock()
+
+	var connections, others []*Session
+	for _, s := range sessions {
+		switch info := s.Info(); {
+		case info.Username != username:
+		case info.Kind == KindConnection:
+			connections = append(connections, s)
+		default:
+			others = append(others, s)
+		}
+	}
+
+	var errs []error
+	closed := map[string]bool{}
+	for _, s := range connections {
+		if err := s.closer.Close(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		closed[s.id] = true
+	}
+	count := len(closed)
+	for _, s := range others {
+		if closed[s.connectionID] {
+			continue
+		}
+		if err := s.closer.Close(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count++
+	}
+	return count, errors.Join(errs...)
+}
+
+// stream counts the bytes transferred through the connection of a session.
+type stream struct {
+	io.ReadWriteCloser
+	session *Session
+}
+
+// NewStream returns the connection of a session where the bytes read and
+// written are counted as bytes in and out of the session respectively.
+func NewStream(conn io.ReadWriteCloser, s *Session) io.ReadWriteCloser {
+	return &stream{ReadWriteCloser: conn, session: s}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	s.session.AddBytesIn(n)
+	return n, err
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	s.session.AddBytesOut(n)
+	return n, err
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// tempFilePrefix is the prefix of the names of the hidden temporary files
+// written by atomic uploads, which is followed by a random hex suffix.
+const tempFilePrefix = ".upload-"
+
+// tempFileSuffixLength is the number of random bytes of the name of a
+// temporary file.
+const tempFileSuffixLength = 8
+
+// isTempFile returns whether the name is the name of a temporary file of an
+// atomic upload.
+func isTempFile(name string) bool {
+	suffix, ok := strings.CutPrefix(path.Base(name), tempFilePrefix)
+	if !ok || len(suffix) != hex.EncodedLen(tempFileSuffixLength) {
+		return false
+	}
+	_, err := hex.DecodeString(suffix)
+	return err == nil
+}
+
+// tempPath returns a new path of a temporary file in the directory of the
+// specified file.
+func tempPath(name string) (string, error) {
+	suffix := make([]byte, tempFileSuffixLength)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(CleanPath(name)), tempFilePrefix+hex.EncodeToString(suffix)), nil
+}
+
+// withoutTempFiles removes the temporary files of atomic uploads from the
+// entries of a directory.
+func withoutTempFiles(entries []os.FileInfo) []os.FileInfo {
+	list := entries[:0]
+	for _, entry := range entries {
+		if !isTempFile(entry.Name()) {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// OpenAtomic opens the specified file with the flags of os.OpenFile where a
+// file written from scratch is written to a hidden temporary file in the
+// same directory instead. The temporary file is renamed into place when the
+// file is closed, or removed if the file is closed after Abort is called or
+// if the file breaks a policy, so that the file is never seen half written.
+// Files appended to or written in place are opened as OpenFile does.
+func (fs *FileSystem) OpenAtomic(name string, flag int, perm os.FileMode) (File, error) {
+	if fs.isSharedDirectory(name) {
+		return fs.OpenFile(name, flag, perm)
+	}
+	mount, inner, err := fs.locate("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if mount != fs {
+		return mount.OpenAtomic(inner, flag, perm)
+	}
+	if err := fs.checkSymlinks("open", name); err != nil {
+		return nil, err
+	}
+
+	name = CleanPath(name)
+	info, err := fs.backend.Lstat(name)
+	replacing := err == nil && info.Mode().IsRegular() && flag&(os.O_TRUNC|os.O_EXCL) == os.O_TRUNC
+	creating := errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0
+	if name == "/" || flag&os.O_APPEND != 0 || (!replacing && !creating) {
+		return fs.OpenFile(name, flag, perm)
+	}
+	if replacing {
+		perm = info.Mode().Perm()
+	}
+	temp, err := tempPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.openFile(temp, name, flag&^os.O_TRUNC|os.O_CREATE|os.O_EXCL, perm)
+}
+
+// commitTemp renames the specified temporary file into place of the target
+// which, like Rename, keeps a version of the file replaced.
+func (fs *FileSystem) commitTemp(temp string, target string) error {
+	return fs.rename(temp, target)
+}
+
+// removeTemp removes the specified temporary file if it still exists.
+func (fs *FileSystem) removeTemp(temp string) error {
+	info, err := fs.backend.Lstat(CleanPath(temp))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := fs.backend.Remove(CleanPath(temp)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fs.release(info.Size(), 1)
+	return nil
+}
+
+// removeStaleTempFiles removes the temporary files which have not been
+// changed since the cutoff, such as the ones left by a server stopped in the
+// middle of an upload.
+func (fs *FileSystem) removeStaleTempFiles(cutoff time.Time) error {
+	info, err := fs.backend.Lstat("/")
+	if err != nil {
+		return err
+	}
+	var stale []string
+	err = fs.walk("/", info, func(name string, info os.FileInfo) {
+		if info.Mode().IsRegular() && isTempFile(name) && info.ModTime().Before(cutoff) {
+			stale = append(stale, name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range stale {
+		if err := fs.removeTemp(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupTempFiles removes the temporary files of atomic uploads which have
+// not been changed for the specified duration from the home directories and
+// the shared folders of all users.
+func CleanupTempFiles(dbConn *gorm.DB, pathUsersDirectory string, maxAge time.Duration) error {
+	var users []db.User
+	if err := dbConn.Order("username ASC").Find(&users).Error; err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var errs []error
+	cleaned := map[*usageCounter]bool{}
+	for _, user := range users {
+		fileSystem, err := OpenUserFileSystem(dbConn, pathUsersDirectory, user.Username)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fileSystems := []*FileSystem{fileSystem}
+		for _, mount := range fileSystem.mounts {
+			fileSystems = append(fileSystems, mount)
+		}
+		for _, fileSystem := range fileSystems {
+			// a shared folder is mounted for every member
+			if cleaned[fileSystem.usage] {
+				continue
+			}
+			cleaned[fileSystem.usage] = true
+			if err := fileSystem.removeStaleTempFiles(cutoff); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
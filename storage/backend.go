@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/helper/iohelper"
+)
+
+// Backend stores the files of a FileSystem. Paths given to a backend are
+// clean absolute paths, as returned by CleanPath, relative to the root of the
+// backend. Access control, quotas and shared folders are handled by
+// FileSystem and a backend only stores files.
+type Backend interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldName string, newName string) error
+	Link(oldName string, newName string) error
+
+	// Symlink creates name as a symbolic link to target where an absolute
+	// target is a path of the backend.
+	Symlink(target string, name string) error
+
+	// Readlink returns the target of a symbolic link where a target inside
+	// the backend is returned as an absolute path of the backend.
+	Readlink(name string) (string, error)
+
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, accessTime time.Time, modificationTime time.Time) error
+	Truncate(name string, size int64) error
+
+	// DiskSpace returns the capacity of the storage of the backend or
+	// errors.ErrUnsupported if it is unknown.
+	DiskSpace() (DiskSpace, error)
+}
+
+const (
+	BackendLocal       = "local"
+	BackendObjectStore = "s3"
+)
+
+// BackendConfig selects the backends of users.
+type BackendConfig struct {
+	// Default is the backend of users without a backend of their own
+	Default string
+
+	// ObjectStore is the S3-compatible object store used by the s3 backend
+	ObjectStore ObjectStoreConfig
+}
+
+// backendConfig is the configuration of backends of the deployment.
+var backendConfig = BackendConfig{Default: BackendLocal}
+
+// SetBackendConfig sets the configuration of backends. It is expected to be
+// called once before the servers are started.
+func SetBackendConfig(config BackendConfig) {
+	if config.Default == "" {
+		config.Default = BackendLocal
+	}
+	backendConfig = config
+}
+
+// UserBackend returns the backend storing the files of the user.
+func UserBackend(user db.User) string {
+	if user.StorageBackend == "" {
+		return backendConfig.Default
+	}
+	return user.StorageBackend
+}
+
+// ValidateBackend checks if the backend is known and configured. A
+// MemoryBackend is not one of them as it is only meant for tests through
+// NewBackendFileSystem.
+func ValidateBackend(backend string) error {
+	switch backend {
+	case BackendLocal:
+		return nil
+	case BackendObjectStore:
+		if backendConfig.ObjectStore.Endpoint == "" {
+			return fmt.Errorf("s3 backend is not configured")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown storage backend: %s", backend)
+}
+
+// openBackend returns the backend of the specified kind for the home
+// directory of the user along with the key identifying its storage.
+func openBackend(kind string, pathUsersDirectory string, username string) (Backend, string, error) {
+	if kind == "" {
+		kind = backendConfig.Default
+	}
+	if err := ValidateBackend(kind); err != nil {
+		return nil, "", err
+	}
+
+	if kind == BackendObjectStore {
+		backend := NewObjectStoreBackend(backendConfig.ObjectStore, username)
+		return backend, "s3:" + backend.prefix, nil
+	}
+
+	root, err := GetHomePath(pathUsersDirectory, username)
+	if err != nil {
+		return nil, "", err
+	}
+	if !iohelper.IsDirectoryExist(root) {
+		if err := createHomeDirectory(root); err != nil {
+			return nil, "", fmt.Errorf("unable to create user directory: %w", err)
+		}
+	}
+	return NewLocalBackend(root), root, nil
+}
@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Encrypted files start with a header of a magic number and a random file
+// ID followed by chunks of content. Every chunk is sealed with AES-GCM
+// separately, with a random nonce of its own and the file ID and the index
+// of the chunk authenticated, so that any part of a file can be read or
+// rewritten without processing the whole file and chunks cannot be swapped.
+// Whether a chunk is the last one is authenticated as well, and an empty
+// file has an empty last chunk, so that a file cut short at a chunk boundary
+// is detected rather than taken for a shorter file.
+const (
+	encryptedMagic      = "FSENC1\x00\x00"
+	encryptedFileIDSize = 16
+	encryptedHeaderSize = len(encryptedMagic) + encryptedFileIDSize
+	encryptedChunkSize  = 64 * 1024
+	encryptedNonceSize  = 12
+	encryptedOverhead   = encryptedNonceSize + 16
+)
+
+// ErrDecryption is returned when the content of a file cannot be decrypted
+// as it is not encrypted with the key of the user or it has been changed.
+var ErrDecryption = errors.New("unable to decrypt file")
+
+// EncryptedBackend encrypts the content of files stored in another backend.
+// Names, directories and symbolic links are not encrypted. Sizes reported
+// are the sizes of the content before encryption.
+type EncryptedBackend struct {
+	backend Backend
+	aead    cipher.AEAD
+}
+
+// NewEncryptedBackend returns a backend encrypting files stored in the
+// specified backend with a 256-bit data key.
+func NewEncryptedBackend(backend Backend, dataKey []byte) (*EncryptedBackend, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedBackend{backend: backend, aead: aead}, nil
+}
+
+// plainSize returns the size of the content of an encrypted file of the
+// specified size.
+func plainSize(size int64) int64 {
+	size -= int64(encryptedHeaderSize)
+	if size <= 0 {
+		return 0
+	}
+	chunks := size / (encryptedChunkSize + encryptedOverhead)
+	rest := size % (encryptedChunkSize + encryptedOverhead)
+	return chunks*encryptedChunkSize + max(rest-encryptedOverhead, 0)
+}
+
+// lastChunk returns the index of the last chunk of content of the specified
+// size. Content is never without a last chunk even if it is empty.
+func lastChunk(size int64) int64 {
+	if size == 0 {
+		return 0
+	}
+	return (size - 1) / encryptedChunkSize
+}
+
+// plainInfo is the information of an encrypted file with the size of its
+// content.
+type plainInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i plainInfo) Size() int64 {
+	return i.size
+}
+
+func toPlainInfo(info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	return plainInfo{FileInfo: info, size: plainSize(info.Size())}
+}
+
+func (b *EncryptedBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	// writes read the chunks they change and appends are done by the
+	// encrypted file as the content is not appended as is
+	backendFlag := flag &^ os.O_APPEND
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		backendFlag = backendFlag&^os.O_WRONLY | os.O_RDWR
+	}
+	file, err := b.backend.OpenFile(name, backendFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return &encryptedDirectory{File: file}, nil
+	}
+
+	encrypted := &encryptedFile{
+		file:     file,
+		aead:     b.aead,
+		readable: flag&os.O_WRONLY == 0,
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+		append:   flag&os.O_APPEND != 0,
+	}
+	if err := encrypted.readHeader(info.Size()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+func (b *EncryptedBackend) Stat(name string) (os.FileInfo, error) {
+	info, err := b.backend.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return toPlainInfo(info), nil
+}
+
+func (b *EncryptedBackend) Lstat(name string) (os.FileInfo, error) {
+	info, err := b.backend.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return toPlainInfo(info), nil
+}
+
+func (b *EncryptedBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := b.backend.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		entries[i] = toPlainInfo(entry)
+	}
+	return entries, nil
+}
+
+func (b *EncryptedBackend) Mkdir(name string, perm os.FileMode) error {
+	return b.backend.Mkdir(name, perm)
+}
+
+func (b *EncryptedBackend) MkdirAll(name string, perm os.FileMode) error {
+	return b.backend.MkdirAll(name, perm)
+}
+
+func (b *EncryptedBackend) Remove(name string) error {
+	return b.backend.Remove(name)
+}
+
+func (b *EncryptedBackend) RemoveAll(name string) error {
+	return b.backend.RemoveAll(name)
+}
+
+func (b *EncryptedBackend) Rename(oldName string, newName string) error {
+	return b.backend.Rename(oldName, newName)
+}
+
+func (b *EncryptedBackend) Link(oldName string, newName string) error {
+	return b.backend.Link(oldName, newName)
+}
+
+func (b *EncryptedBackend) Symlink(target string, name string) error {
+	return b.backend.Symlink(target, name)
+}
+
+func (b *EncryptedBackend) Readlink(name string) (string, error) {
+	return b.backend.Readlink(name)
+}
+
+func (b *EncryptedBackend) Chmod(name string, mode os.FileMode) error {
+	return b.backend.Chmod(name, mode)
+}
+
+func (b *EncryptedBackend) Chtimes(name string, accessTime time.Time, modificationTime time.Time) error {
+	return b.backend.Chtimes(name, accessTime, modificationTime)
+}
+
+func (b *EncryptedBackend) Truncate(name string, size int64) error {
+	file, err := b.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (b *EncryptedBackend) DiskSpace() (DiskSpace, error) {
+	return b.backend.DiskSpace()
+}
+
+// encryptedDirectory is a directory opened where the sizes of the files
+// listed are the sizes of their content.
+type encryptedDirectory struct {
+	File
+}
+
+func (d *encryptedDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	entries, err := d.File.Readdir(count)
+	for i, entry := range entries {
+		entries[i] = toPlainInfo(entry)
+	}
+	return entries, err
+}
+
+// encryptedFile is an encrypted file opened. It is safe for concurrent use
+// as SFTP clients read and write chunks of a file in parallel.
+type encryptedFile struct {
+	mu       sync.Mutex
+	file     File
+	aead     cipher.AEAD
+	fileID   []byte
+	size     int64
+	offset   int64
+	readable bool
+	writable bool
+	append   bool
+}
+
+// readHeader reads the header of the file, or writes the header of an empty
+// file opened for writing.
+func (f *encryptedFile) readHeader(size int64) error {
+	if size == 0 {
+		if !f.writable {
+			// an empty file has no content to decrypt
+			return nil
+		}
+		f.fileID = make([]byte, encryptedFileIDSize)
+		if _, err := rand.Read(f.fileID); err != nil {
+			return err
+		}
+		header := append([]byte(encryptedMagic), f.fileID...)
+		if _, err := f.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+		return f.writeChunk(0, nil, true)
+	}
+
+	// a file without its last chunk has been cut short
+	if size < int64(encryptedHeaderSize+encryptedOverhead) {
+		return &os.PathError{Op: "open", Path: f.file.Name(), Err: ErrDecryption}
+	}
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := f.file.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, []byte(encryptedMagic)) {
+		return &os.PathError{Op: "open", Path: f.file.Name(), Err: ErrDecryption}
+	}
+	f.fileID = header[len(encryptedMagic):]
+	f.size = plainSize(size)
+	return nil
+}
+
+// check returns the error of an operation which is not allowed on the file.
+func (f *encryptedFile) check(op string, write bool) error {
+	if write && !f.writable || !write && !f.readable {
+		return &os.PathError{Op: op, Path: f.file.Name(), Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func chunkOffset(index int64) int64 {
+	return int64(encryptedHeaderSize) + index*(encryptedChunkSize+encryptedOverhead)
+}
+
+func (f *encryptedFile) additionalData(index int64, last bool) []byte {
+	data := binary.BigEndian.AppendUint64(append([]byte{}, f.fileID...), uint64(index))
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// readChunk returns the decrypted content of a chunk or nothing if the chunk
+// is beyond the end of the file.
+func (f *encryptedFile) readChunk(index int64) ([]byte, error) {
+	return f.openChunk(index, f.size)
+}
+
+// openChunk returns the decrypted content of a chunk of the file as the file
+// was when its content was of the specified size, which decides the length
+// of the chunk and whether it is the last one.
+func (f *encryptedFile) openChunk(index int64, size int64) ([]byte, error) {
+	// an empty file without a header has no chunks
+	if f.fileID == nil || index > lastChunk(size) {
+		return nil, nil
+	}
+	length := min(size-index*encryptedChunkSize, encryptedChunkSize)
+	sealed := make([]byte, length+encryptedOverhead)
+	if n, err := f.file.ReadAt(sealed, chunkOffset(index)); n < len(sealed) {
+		if err == nil || err == io.EOF {
+			err = ErrDecryption
+		}
+		return nil, &os.PathError{Op: "read", Path: f.file.Name(), Err: err}
+	}
+	content, err := f.aead.Open(nil, sealed[:encryptedNonceSize], sealed[encryptedNonceSize:], f.additionalData(index, index == lastChunk(size)))
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.file.Name(), Err: ErrDecryption}
+	}
+	return content, nil
+}
+
+// writeChunk encrypts the content of a chunk with a new nonce and writes it.
+func (f *encryptedFile) writeChunk(index int64, content []byte, last bool) error {
+	nonce := make([]byte, encryptedNonceSize, encryptedOverhead+len(content))
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := f.aead.Seal(nonce, nonce, content, f.additionalData(index, last))
+	_, err := f.file.WriteAt(sealed, chunkOffset(index))
+	return err
+}
+
+func (f *encryptedFile) readAt(p []byte, off int64) (int, error) {
+	total := 0
+	readLast := false
+	for total < len(p) && off < f.size {
+		index := off / encryptedChunkSize
+		content, err := f.readChunk(index)
+		if err != nil {
+			return total, err
+		}
+		n := copy(p[total:], content[off-index*encryptedChunkSize:])
+		total += n
+		off += int64(n)
+		readLast = index == lastChunk(f.size)
+	}
+	if total < len(p) {
+		// the end of the file is only reported once the last chunk is
+		// authenticated as the last one
+		if !readLast {
+			if _, err := f.readChunk(lastChunk(f.size)); err != nil {
+				return total, err
+			}
+		}
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (f *encryptedFile) writeAt(p []byte, off int64) (int, error) {
+	// the gap between the end of the file and the offset is filled with
+	// zeros as the content of a chunk cannot be sparse
+	for f.size < off {
+		gap := min(off-f.size, encryptedChunkSize-f.size%encryptedChunkSize)
+		if _, err := f.writeAt(make([]byte, gap), f.size); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	previousSize := f.size
+	previousLast := lastChunk(previousSize)
+	first := off / encryptedChunkSize
+	size := max(f.size, off+int64(len(p)))
+	written := 0
+	for written < len(p) {
+		index := off / encryptedChunkSize
+		start := int(off - index*encryptedChunkSize)
+		n := min(len(p)-written, encryptedChunkSize-start)
+		content, err := f.readChunk(index)
+		if err != nil {
+			return written, err
+		}
+		if len(content) < start+n {
+			content = append(content, make([]byte, start+n-len(content))...)
+		}
+		copy(content[start:], p[written:written+n])
+		if err := f.writeChunk(index, content, index == lastChunk(size)); err != nil {
+			return written, err
+		}
+		written += n
+		off += int64(n)
+		f.size = max(f.size, off)
+	}
+
+	// the previous last chunk is no longer the last one if the content is
+	// appended after it
+	if first > previousLast {
+		content, err := f.openChunk(previousLast, previousSize)
+		if err != nil {
+			return written, err
+		}
+		if err := f.writeChunk(previousLast, content, false); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (f *encryptedFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *encryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.file.Name(), Err: syscall.EINVAL}
+	}
+	return f.readAt(p, off)
+}
+
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.append {
+		f.offset = f.size
+	}
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *encryptedFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.append {
+		return 0, errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.file.Name(), Err: syscall.EINVAL}
+	}
+	return f.writeAt(p, off)
+}
+
+func (f *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.file.Name(), Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *encryptedFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.file.Name(), Err: syscall.EINVAL}
+	}
+	if size >= f.size {
+		_, err := f.writeAt(nil, size)
+		return err
+	}
+
+	// the chunk where the content ends becomes the last one
+	index := lastChunk(size)
+	length := size - index*encryptedChunkSize
+	content, err := f.readChunk(index)
+	if err != nil {
+		return err
+	}
+	if err := f.writeChunk(index, content[:length], true); err != nil {
+		return err
+	}
+	if err := f.file.Truncate(chunkOffset(index) + length + encryptedOverhead); err != nil {
+		return err
+	}
+	f.size = size
+	return nil
+}
+
+func (f *encryptedFile) Stat() (os.FileInfo, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return plainInfo{FileInfo: info, size: f.size}, nil
+}
+
+func (f *encryptedFile) Close() error {
+	return f.file.Close()
+}
+
+func (f *encryptedFile) Name() string {
+	return f.file.Name()
+}
+
+func (f *encryptedFile) Readdir(count int) ([]os.FileInfo, error) {
+	return f.file.Readdir(count)
+}
+
+func (f *encryptedFile) Sync() error {
+	return f.file.Sync()
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"testing"
+)
+
+func newTestEncryptedBackend(t *testing.T) (*EncryptedBackend, *MemoryBackend) {
+	t.Helper()
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryBackend()
+	backend, err := NewEncryptedBackend(memory, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, memory
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func randomInt(t *testing.T, limit int) int {
+	t.Helper()
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(limit)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(n.Int64())
+}
+
+func writeBackendFile(t *testing.T, backend Backend, name string, content []byte) {
+	t.Helper()
+	file, err := backend.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		t.Fatalf("write %s: %v", name, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close %s: %v", name, err)
+	}
+}
+
+// readBackendFile returns the content of a file or the error of opening or
+// reading it.
+func readBackendFile(backend Backend, name string) ([]byte, error) {
+	file, err := backend.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func TestEncryptedBackendRoundTrip(t *testing.T) {
+	sizes := []int{
+		0,
+		1,
+		encryptedChunkSize - 1,
+		encryptedChunkSize,
+		encryptedChunkSize + 1,
+		3*encryptedChunkSize + 5,
+	}
+	for _, size := range sizes {
+		backend, memory := newTestEncryptedBackend(t)
+		content := randomBytes(t, size)
+		writeBackendFile(t, backend, "/a", content)
+
+		got, err := readBackendFile(backend, "/a")
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("size %d: content read differs from content written", size)
+		}
+		info, err := backend.Stat("/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(size) {
+			t.Errorf("size %d: stat = %d", size, info.Size())
+		}
+
+		stored, err := readBackendFile(memory, "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > 0 && bytes.Contains(stored, content) {
+			t.Errorf("size %d: content stored in plain", size)
+		}
+	}
+}
+
+func TestEncryptedBackendRandomAccess(t *testing.T) {
+	backend, _ := newTestEncryptedBackend(t)
+	size := 3*encryptedChunkSize + 100
+	want := make([]byte, size)
+
+	file, err := backend.OpenFile("/a", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// the second half is written first so that the gap is filled with zeros
+	half := randomBytes(t, size/2)
+	if _, err := file.WriteAt(half, int64(size-len(half))); err != nil {
+		t.Fatal(err)
+	}
+	copy(want[size-len(half):], half)
+	for range 50 {
+		off := randomInt(t, size)
+		part := randomBytes(t, randomInt(t, size-off)+1)
+		if _, err := file.WriteAt(part, int64(off)); err != nil {
+			t.Fatal(err)
+		}
+		copy(want[off:], part)
+	}
+
+	for range 50 {
+		off := randomInt(t, size)
+		buf := make([]byte, randomInt(t, encryptedChunkSize*2)+1)
+		n, err := file.ReadAt(buf, int64(off))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if wantN := min(len(buf), size-off); n != wantN || (err == io.EOF) != (wantN < len(buf)) {
+			t.Fatalf("read of %d bytes at %d = %d, %v", len(buf), off, n, err)
+		}
+		if !bytes.Equal(buf[:n], want[off:off+n]) {
+			t.Fatalf("read of %d bytes at %d differs from content written", len(buf), off)
+		}
+	}
+
+	for _, truncated := range []int{size - 10, 2 * encryptedChunkSize, encryptedChunkSize + 1, 0, encryptedChunkSize + 7} {
+		if err := file.Truncate(int64(truncated)); err != nil {
+			t.Fatal(err)
+		}
+		if truncated <= len(want) {
+			want = want[:truncated]
+		} else {
+			want = append(want, make([]byte, truncated-len(want))...)
+		}
+		got, err := readBackendFile(backend, "/a")
+		if err != nil {
+			t.Fatalf("truncate to %d: %v", truncated, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("truncate to %d: content differs", truncated)
+		}
+	}
+}
+
+func TestEncryptedBackendTampering(t *testing.T) {
+	header := int64(encryptedHeaderSize)
+	chunk := int64(encryptedChunkSize + encryptedOverhead)
+	tests := []struct {
+		name   string
+		size   int
+		tamper func(t *testing.T, memory *MemoryBackend)
+	}{
+		{
+			name: "last chunk removed",
+			size: 2*encryptedChunkSize + 10,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				truncateBackendFile(t, memory, header+2*chunk)
+			},
+		},
+		{
+			name: "last full chunk removed",
+			size: 2 * encryptedChunkSize,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				truncateBackendFile(t, memory, header+chunk)
+			},
+		},
+		{
+			name: "cut to the header",
+			size: 10,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				truncateBackendFile(t, memory, header)
+			},
+		},
+		{
+			name: "empty file cut to the header",
+			size: 0,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				truncateBackendFile(t, memory, header)
+			},
+		},
+		{
+			name: "last chunk shortened",
+			size: encryptedChunkSize + 10,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				truncateBackendFile(t, memory, header+chunk+encryptedOverhead+5)
+			},
+		},
+		{
+			name: "byte flipped",
+			size: 100,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				stored := readStoredFile(t, memory)
+				stored[header+encryptedNonceSize+50] ^= 1
+				writeBackendFile(t, memory, "/a", stored)
+			},
+		},
+		{
+			name: "chunks swapped",
+			size: 2 * encryptedChunkSize,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				stored := readStoredFile(t, memory)
+				swapped := append([]byte{}, stored[:header]...)
+				swapped = append(swapped, stored[header+chunk:]...)
+				swapped = append(swapped, stored[header:header+chunk]...)
+				writeBackendFile(t, memory, "/a", swapped)
+			},
+		},
+		{
+			name: "chunk of another file",
+			size: 100,
+			tamper: func(t *testing.T, memory *MemoryBackend) {
+				stored := readStoredFile(t, memory)
+				other, err := readBackendFile(memory, "/b")
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeBackendFile(t, memory, "/a", append(stored[:header], other[header:]...))
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, memory := newTestEncryptedBackend(t)
+			writeBackendFile(t, backend, "/a", randomBytes(t, test.size))
+			writeBackendFile(t, backend, "/b", randomBytes(t, test.size))
+			test.tamper(t, memory)
+
+			if _, err := readBackendFile(backend, "/a"); !errors.Is(err, ErrDecryption) {
+				t.Errorf("read: got %v, want %v", err, ErrDecryption)
+			}
+		})
+	}
+}
+
+func readStoredFile(t *testing.T, memory *MemoryBackend) []byte {
+	t.Helper()
+	stored, err := readBackendFile(memory, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func truncateBackendFile(t *testing.T, memory *MemoryBackend, size int64) {
+	t.Helper()
+	if err := memory.Truncate("/a", size); err != nil {
+		t.Fatal(err)
+	}
+}
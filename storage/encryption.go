@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// encryptionKeySize is the size of both the master key and the data keys
+// which are AES-256 keys.
+const encryptionKeySize = 32
+
+// ErrMasterKeyMismatch is returned when a data key is not wrapped by the
+// master key used to unwrap it.
+var ErrMasterKeyMismatch = errors.New("data key is not wrapped by the master key")
+
+// masterKey wraps the data keys of users. Files of new users are encrypted
+// only if it is set.
+var masterKey []byte
+
+// SetMasterKey sets the master key wrapping the data keys of users. It is
+// expected to be called once before the servers are started.
+func SetMasterKey(key []byte) {
+	masterKey = key
+}
+
+// EncryptionEnabled returns whether files of new users are encrypted.
+func EncryptionEnabled() bool {
+	return len(masterKey) > 0
+}
+
+// LoadMasterKey reads a master key from a file which contains a 256-bit key
+// encoded in hex, such as the output of openssl rand -hex 32.
+func LoadMasterKey(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("master key is not a 256-bit key encoded in hex: %s", file)
+	}
+	return key, nil
+}
+
+// masterKeyID returns an identifier of the master key which does not reveal
+// the key.
+func masterKeyID(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:8])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey encrypts a data key with the master key where the username is
+// authenticated so that a wrapped key cannot be moved to another user.
+func wrapKey(master []byte, dataKey []byte, username string) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(username)), nil
+}
+
+// unwrapKey decrypts a data key wrapped by wrapKey.
+func unwrapKey(master []byte, wrapped []byte, username string) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped data key of user %s is invalid", username)
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(username))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key of user %s: %w", username, err)
+	}
+	return dataKey, nil
+}
+
+// CreateDataKey generates the data key of a new user and stores it wrapped
+// by the master key. The data key of a home directory kept from a user
+// deleted earlier with the same username is kept instead so that the files
+// remain readable.
+func CreateDataKey(dbConn *gorm.DB, username string) error {
+	if !EncryptionEnabled() {
+		return errors.New("encryption master key is not configured")
+	}
+	var keys []db.UserDataKey
+	if err := dbConn.Where("username = ?", username).Limit(1).Find(&keys).Error; err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return nil
+	}
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := wrapKey(masterKey, dataKey, username)
+	if err != nil {
+		return err
+	}
+	return dbConn.Create(&db.UserDataKey{
+		Username:    username,
+		WrappedKey:  wrapped,
+		MasterKeyID: masterKeyID(masterKey),
+	}).Error
+}
+
+// loadDataKey returns the data key of the user or nil if files of the user
+// are not encrypted.
+func loadDataKey(dbConn *gorm.DB, username string) ([]byte, error) {
+	var keys []db.UserDataKey
+	if err := dbConn.Where("username = ?", username).Limit(1).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if !EncryptionEnabled() {
+		return nil, fmt.Errorf("files of user %s are encrypted but encryption master key is not configured", username)
+	}
+	if keys[0].MasterKeyID != masterKeyID(masterKey) {
+		return nil, fmt.Errorf("%w: user %s", ErrMasterKeyMismatch, username)
+	}
+	return unwrapKey(masterKey, keys[0].WrappedKey, username)
+}
+
+// RotateDataKeys wraps the data keys of all users with a new master key and
+// returns the number of keys rewrapped. Keys already wrapped by the new
+// master key are skipped so that an interrupted rotation can be run again.
+// Files are not re-encrypted as the data keys do not change.
+func RotateDataKeys(dbConn *gorm.DB, oldMasterKey []byte, newMasterKey []byte) (int, error) {
+	oldID := masterKeyID(oldMasterKey)
+	newID := masterKeyID(newMasterKey)
+	count := 0
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		var keys []db.UserDataKey
+		if err := tx.Order("username ASC").Find(&keys).Error; err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key.MasterKeyID == newID {
+				continue
+			}
+			if key.MasterKeyID != oldID {
+				return fmt.Errorf("%w: user %s", ErrMasterKeyMismatch, key.Username)
+			}
+			dataKey, err := unwrapKey(oldMasterKey, key.WrappedKey, key.Username)
+			if err != nil {
+				return err
+			}
+			wrapped, err := wrapKey(newMasterKey, dataKey, key.Username)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&db.UserDataKey{}).
+				Where("username = ?", key.Username).
+				Updates(map[string]any{"wrapped_key": wrapped, "master_key_id": newID}).
+				Error
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+// Types of the events of files.
+const (
+	// FileEventUploaded is raised when a file written is closed
+	FileEventUploaded = "file.uploaded"
+
+	// FileEventDeleted is raised when a file or directory is removed
+	FileEventDeleted = "file.deleted"
+)
+
+// FileEvent is a change of a file by a user.
+type FileEvent struct {
+	// Type is one of the types of the events of files
+	Type string
+
+	// Username is the user making the change
+	Username string
+
+	// Path is the virtual path of the file as seen by the user
+	Path string
+
+	// Size is the size of an uploaded file
+	Size int64
+}
+
+// fileEventHandler is called with the changes of files of users.
+var fileEventHandler func(FileEvent)
+
+// SetFileEventHandler sets the function called with the changes of files of
+// users. It is expected to be called once before the servers are started and
+// the function is called synchronously by the operations changing files.
+func SetFileEventHandler(handler func(FileEvent)) {
+	fileEventHandler = handler
+}
+
+// notify raises an event of the specified file unless the file system is
+// not opened for a user.
+func (fs *FileSystem) notify(eventType string, name string, size int64) {
+	if fileEventHandler == nil || fs.username == "" {
+		return
+	}
+	fileEventHandler(FileEvent{
+		Type:     eventType,
+		Username: fs.username,
+		Path:     fs.virtualPath(name),
+		Size:     size,
+	})
+}
@@ -0,0 +1,770 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// File is an open file of a FileSystem.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Readdir(count int) ([]os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileSystem provides access to the home directory of a user where every
+// path is interpreted relative to the home directory and is not allowed to
+// escape from it. This is the jail shared by all the protocols served.
+type FileSystem struct {
+	backend Backend
+
+	// username is the user the file system is opened for which is empty if
+	// the file system is not opened for a user
+	username string
+
+	// accessMode restricts the operations allowed and it is one of the
+	// access modes of users in the database. An empty access mode allows
+	// everything.
+	accessMode string
+
+	// quota limits the storage used by the home directory where usage is
+	// the usage shared by all the file systems of the home directory
+	quota Quota
+	usage *usageCounter
+
+	// mounts are the file systems of the shared folders of the user by the
+	// names of the folders and mountPath is the virtual path where a file
+	// system of a shared folder is mounted
+	mounts    map[string]*FileSystem
+	mountPath string
+
+	// versioning is whether versions of files are kept in the versions
+	// directory when files are overwritten, renamed over or removed
+	versioning bool
+
+	// uploadPolicies restrict the files written where a file has to be
+	// allowed by all of them
+	uploadPolicies []UploadPolicy
+
+	// symlinkPolicy is how symbolic links are created, read and followed
+	// where an empty policy allows them like SymlinkAllow
+	symlinkPolicy SymlinkPolicy
+}
+
+// NewFileSystem returns the file system of the specified user on the local
+// disk and creates the home directory of the user if it does not exist yet.
+func NewFileSystem(pathUsersDirectory string, username string) (*FileSystem, error) {
+	return newFileSystem(BackendLocal, pathUsersDirectory, username)
+}
+
+// NewBackendFileSystem returns a file system without restrictions storing
+// files in the specified backend, such as a MemoryBackend in tests.
+func NewBackendFileSystem(backend Backend) *FileSystem {
+	return &FileSystem{backend: backend, usage: &usageCounter{}}
+}
+
+func newFileSystem(kind string, pathUsersDirectory string, username string) (*FileSystem, error) {
+	backend, key, err := openBackend(kind, pathUsersDirectory, username)
+	if err != nil {
+		return nil, err
+	}
+	fileSystem := &FileSystem{
+		backend:       backend,
+		usage:         getUsageCounter(key),
+		symlinkPolicy: symlinkPolicy,
+		versioning:    versioningConfig.Enabled,
+	}
+	return fileSystem, nil
+}
+
+// OpenUserFileSystem returns the file system of the specified user with the
+// restrictions of the user in the database applied and the shared folders of
+// the groups of the user mounted.
+func OpenUserFileSystem(dbConn *gorm.DB, pathUsersDirectory string, username string) (*FileSystem, error) {
+	var user db.User
+	if err := dbConn.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	fileSystem, err := newFileSystem(user.StorageBackend, pathUsersDirectory, username)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := loadDataKey(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey != nil {
+		if fileSystem.backend, err = NewEncryptedBackend(fileSystem.backend, dataKey); err != nil {
+			return nil, err
+		}
+	}
+	switch user.AccessMode {
+	case db.AccessModeReadWrite, db.AccessModeReadOnly, db.AccessModeWriteOnly:
+		fileSystem.accessMode = user.AccessMode
+	default:
+		return nil, fmt.Errorf("invalid access mode of user %s: %s", username, user.AccessMode)
+	}
+	fileSystem.username = user.Username
+	fileSystem.quota = UserQuota(user)
+	if fileSystem.uploadPolicies, err = loadUploadPolicies(dbConn, username); err != nil {
+		return nil, err
+	}
+	if err := fileSystem.mountSharedFolders(dbConn, pathUsersDirectory, user); err != nil {
+		return nil, err
+	}
+	return fileSystem, nil
+}
+
+// GetHomePath returns the path of the home directory of the specified user.
+func GetHomePath(pathUsersDirectory string, username string) (string, error) {
+	if err := ValidateUsername(username); err != nil {
+		return "", err
+	}
+	return filepath.Clean(filepath.Join(pathUsersDirectory, username)), nil
+}
+
+// ValidateUsername checks if the username can be used as the name of a home
+// directory. Usernames starting with a dot are rejected as such names are
+// reserved for the use of the server in the users directory.
+func ValidateUsername(username string) error {
+	if username == "" || strings.HasPrefix(username, ".") || strings.ContainsAny(username, `/\`) {
+		return fmt.Errorf("invalid username: %s", username)
+	}
+	return nil
+}
+
+// Root returns the path of the home directory on the local disk or an empty
+// string if the files are not stored on the local disk.
+func (fs *FileSystem) Root() string {
+	if backend, ok := fs.backend.(*LocalBackend); ok {
+		return backend.Root()
+	}
+	return ""
+}
+
+// CanRead returns whether files can be read and directories can be listed.
+func (fs *FileSystem) CanRead() bool {
+	return fs.accessMode != db.AccessModeWriteOnly
+}
+
+// CanWrite returns whether files and directories can be created, changed
+// and removed.
+func (fs *FileSystem) CanWrite() bool {
+	return fs.accessMode != db.AccessModeReadOnly
+}
+
+func (fs *FileSystem) checkRead(op string, name string) error {
+	if !fs.CanRead() {
+		return &os.PathError{Op: op, Path: fs.virtualPath(name), Err: syscall.EACCES}
+	}
+	return nil
+}
+
+func (fs *FileSystem) checkWrite(op string, name string) error {
+	if !fs.CanWrite() {
+		return &os.PathError{Op: op, Path: fs.virtualPath(name), Err: syscall.EACCES}
+	}
+	return nil
+}
+
+// virtualPath returns the path of the user view of the specified path which
+// is different if the file system is mounted.
+func (fs *FileSystem) virtualPath(name string) string {
+	return path.Join(fs.mountPath, CleanPath(name))
+}
+
+// Encrypted returns whether the content of files is encrypted.
+func (fs *FileSystem) Encrypted() bool {
+	_, ok := fs.backend.(*EncryptedBackend)
+	return ok
+}
+
+// Resolve returns the path on the local disk of the specified virtual path
+// or an empty string if the files are not stored on the local disk. The
+// virtual path is always interpreted from the root of the home directory so
+// that ".." cannot go above it.
+func (fs *FileSystem) Resolve(name string) string {
+	if backend, ok := fs.backend.(*LocalBackend); ok {
+		return backend.resolve(name)
+	}
+	return ""
+}
+
+// DiskSpace returns the capacity of the storage where the home directory is
+// stored.
+func (fs *FileSystem) DiskSpace() (DiskSpace, error) {
+	return fs.backend.DiskSpace()
+}
+
+// CleanPath returns the shortest absolute virtual path equivalent to the
+// specified path.
+func CleanPath(name string) string {
+	return path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))
+}
+
+// OpenFile opens the specified file with the flags of os.OpenFile.
+func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if fs.isSharedDirectory(name) && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return &sharedDirectoryFile{fileSystem: fs}, nil
+	}
+	mount, inner, err := fs.locate("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if mount != fs {
+		return mount.OpenFile(inner, flag, perm)
+	}
+	if err := fs.checkSymlinks("open", name); err != nil {
+		return nil, err
+	}
+	return fs.openFile(name, name, flag, perm)
+}
+
+// openFile opens the specified file of this file system where target is the
+// path the file is renamed to when it is closed, if it is a temporary file of
+// an atomic upload, or the path of the file itself. The checks apply to the
+// target.
+func (fs *FileSystem) openFile(name string, target string, flag int, perm os.FileMode) (File, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	// a truncated file has nothing to be read back
+	reading := flag&os.O_WRONLY == 0 && flag&os.O_TRUNC == 0
+	if writing {
+		if err := fs.checkWrite("open", target); err != nil {
+			return nil, err
+		}
+		if err := fs.checkUploadName("open", target); err != nil {
+			return nil, err
+		}
+	}
+	if reading {
+		if err := fs.checkRead("open", target); err != nil {
+			return nil, err
+		}
+	}
+	if !writing {
+		file, err := fs.backend.OpenFile(CleanPath(name), flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		// the entries of a directory are filtered like ReadDir does
+		if info, err := file.Stat(); err != nil || !info.IsDir() {
+			return file, nil
+		}
+		return &directoryFile{File: file, fileSystem: fs, name: CleanPath(name)}, nil
+	}
+
+	// a new file counts towards the quota and a truncated file no longer
+	// takes its size unless its content is kept as a version
+	var created int64
+	var truncated int64
+	var replaced int64
+	info, err := fs.backend.Lstat(CleanPath(name))
+	if err != nil && flag&os.O_CREATE != 0 {
+		created = 1
+	} else if err == nil && flag&os.O_TRUNC != 0 && info.Mode().IsRegular() {
+		truncated = info.Size()
+		if fs.versioning && truncated > 0 && flag&os.O_EXCL == 0 {
+			// the file is replaced by a new one as its content is kept as
+			// a version
+			if err := fs.charge("open", name, 0, 1); err != nil {
+				return nil, err
+			}
+			if err := fs.keepVersion(name); err != nil {
+				fs.release(0, 1)
+				return nil, err
+			}
+			flag |= os.O_CREATE
+			perm = info.Mode().Perm()
+			truncated = 0
+			replaced = 1
+		}
+	}
+	if err := fs.charge("open", target, 0, created); err != nil {
+		return nil, err
+	}
+	file, err := fs.backend.OpenFile(CleanPath(name), flag, perm)
+	if err != nil {
+		fs.release(0, created+replaced)
+		return nil, err
+	}
+	fs.release(truncated, 0)
+	if fs.HasUploadPolicy() {
+		file = &policyFile{
+			File:       file,
+			fileSystem: fs,
+			name:       CleanPath(name),
+			target:     CleanPath(target),
+			append:     flag&os.O_APPEND != 0,
+		}
+	}
+	quotaFile := &quotaFile{
+		file:       file,
+		fileSystem: fs,
+		name:       CleanPath(name),
+		target:     CleanPath(target),
+		append:     flag&os.O_APPEND != 0,
+	}
+	quotaFile.written.Store(created > 0 || flag&os.O_TRUNC != 0)
+	return quotaFile, nil
+}
+
+// Open opens the specified file for reading.
+func (fs *FileSystem) Open(name string) (File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// CreateExclusive creates the specified file for writing and fails if the
+// file exists already.
+func (fs *FileSystem) CreateExclusive(name string) (File, error) {
+	return fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+}
+
+// Stat returns the information of the specified file.
+func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
+	if fs.isSharedDirectory(name) {
+		return sharedDirectoryInfo{}, nil
+	}
+	mount, inner, err := fs.locate("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := mount.checkSymlinks("stat", inner); err != nil {
+		return nil, err
+	}
+	return mount.backend.Stat(inner)
+}
+
+// Lstat returns the information of the specified file without following
+// symbolic links.
+func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
+	if fs.isSharedDirectory(name) {
+		return sharedDirectoryInfo{}, nil
+	}
+	mount, inner, err := fs.locate("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := mount.checkParentSymlinks("lstat", inner); err != nil {
+		return nil, err
+	}
+	return mount.backend.Lstat(inner)
+}
+
+// ReadDir returns the entries of the specified directory.
+func (fs *FileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	if fs.isSharedDirectory(name) {
+		return fs.sharedFolders(), nil
+	}
+	mount, inner, err := fs.locate("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if mount != fs {
+		return mount.ReadDir(inner)
+	}
+	if err := fs.checkRead("readdir", name); err != nil {
+		return nil, err
+	}
+	if err := fs.checkSymlinks("readdir", name); err != nil {
+		return nil, err
+	}
+	entries, err := fs.readDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.withSharedDirectory(name, withoutTempFiles(entries)), nil
+}
+
+// readDir returns the entries of the directory without the versions and the
+// quarantine directories. The temporary files of atomic uploads are included
+// as they take storage.
+func (fs *FileSystem) readDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.backend.ReadDir(CleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return fs.withoutHiddenDirectories(name, entries), nil
+}
+
+// withoutHiddenDirectories removes the versions, the quarantine and the
+// staging directories from the entries of the root directory.
+func (fs *FileSystem) withoutHiddenDirectories(name string, entries []os.FileInfo) []os.FileInfo {
+	if CleanPath(name) != "/" {
+		return entries
+	}
+	list := entries[:0]
+	for _, entry := range entries {
+		if (fs.versioning && entry.Name() == path.Base(VersionsDirectory)) || entry.Name() == path.Base(QuarantineDirectory) ||
+			entry.Name() == path.Base(StagingDirectory) {
+			continue
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+// Mkdir creates the specified directory.
+func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
+	mount, inner, err := fs.locate("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Mkdir(inner, perm)
+	}
+	if err := fs.checkWrite("mkdir", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinks("mkdir", name); err != nil {
+		return err
+	}
+	if err := fs.charge("mkdir", name, 0, 1); err != nil {
+		return err
+	}
+	if err := fs.backend.Mkdir(CleanPath(name), perm); err != nil {
+		fs.release(0, 1)
+		return err
+	}
+	return nil
+}
+
+// MkdirAll creates the specified directory along with any missing parents.
+func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
+	mount, inner, err := fs.locate("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.MkdirAll(inner, perm)
+	}
+	if err := fs.checkWrite("mkdir", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinks("mkdir", name); err != nil {
+		return err
+	}
+	missing := fs.missingDirectories(name)
+	if err := fs.charge("mkdir", name, 0, missing); err != nil {
+		return err
+	}
+	if err := fs.backend.MkdirAll(CleanPath(name), perm); err != nil {
+		fs.release(0, missing)
+		return err
+	}
+	return nil
+}
+
+// Remove removes the specified file or empty directory.
+func (fs *FileSystem) Remove(name string) error {
+	mount, inner, err := fs.locate("remove", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Remove(inner)
+	}
+	if err := fs.checkWrite("remove", name); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("remove", name); err != nil {
+		return err
+	}
+	// the root of a shared folder is an empty directory at most
+	if CleanPath(name) == "/" {
+		return os.ErrPermission
+	}
+	if fs.versioning {
+		// the file kept as a version still counts towards the quota
+		if info, err := fs.backend.Lstat(CleanPath(name)); err == nil && info.Mode().IsRegular() {
+			if err := fs.keepVersion(name); err != nil {
+				return err
+			}
+			fs.notify(FileEventDeleted, name, 0)
+			return nil
+		}
+	}
+	removed := fs.storedUsage(name)
+	if err := fs.backend.Remove(CleanPath(name)); err != nil {
+		return err
+	}
+	fs.release(removed.Bytes, removed.Files)
+	fs.notify(FileEventDeleted, name, 0)
+	return nil
+}
+
+// RemoveAll removes the specified path and any children it contains. The
+// root of the file system cannot be removed.
+func (fs *FileSystem) RemoveAll(name string) error {
+	mount, inner, err := fs.locate("remove", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.RemoveAll(inner)
+	}
+	if err := fs.checkWrite("remove", name); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("remove", name); err != nil {
+		return err
+	}
+	if CleanPath(name) == "/" {
+		return os.ErrPermission
+	}
+	_, existErr := fs.backend.Lstat(CleanPath(name))
+	if fs.versioning {
+		if err := fs.keepVersions(name); err != nil {
+			return errors.Join(err, fs.RefreshUsage())
+		}
+	}
+	// the files kept as versions still count towards the quota
+	removed := fs.storedUsage(name)
+	err = fs.backend.RemoveAll(CleanPath(name))
+	if err != nil {
+		// some of the files may have been removed
+		return errors.Join(err, fs.RefreshUsage())
+	}
+	fs.release(removed.Bytes, removed.Files)
+	if existErr == nil {
+		fs.notify(FileEventDeleted, name, 0)
+	}
+	return nil
+}
+
+// Rename renames the specified file and replaces the new path if it exists.
+func (fs *FileSystem) Rename(oldName string, newName string) error {
+	mount, oldInner, newInner, err := fs.locatePair("rename", oldName, newName)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Rename(oldInner, newInner)
+	}
+	if err := fs.checkWrite("rename", oldName); err != nil {
+		return err
+	}
+	if CleanPath(oldName) == "/" || CleanPath(newName) == "/" {
+		return os.ErrPermission
+	}
+	if err := fs.checkParentSymlinks("rename", oldName); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("rename", newName); err != nil {
+		return err
+	}
+	if err := fs.checkStoredUpload("rename", oldName, newName); err != nil {
+		return err
+	}
+	return fs.rename(oldName, newName)
+}
+
+// rename renames the specified file of this file system without the checks
+// of Rename.
+func (fs *FileSystem) rename(oldName string, newName string) error {
+	// a replaced file no longer takes storage unless it is kept as a version
+	var replaced Usage
+	oldInfo, oldErr := fs.backend.Lstat(CleanPath(oldName))
+	newInfo, newErr := fs.backend.Lstat(CleanPath(newName))
+	if oldErr == nil && newErr == nil && !os.SameFile(oldInfo, newInfo) {
+		if fs.versioning && newInfo.Mode().IsRegular() && !oldInfo.IsDir() {
+			if err := fs.keepVersion(newName); err != nil {
+				return err
+			}
+		} else {
+			replaced = fs.storedUsage(newName)
+		}
+	}
+	if err := fs.backend.Rename(CleanPath(oldName), CleanPath(newName)); err != nil {
+		return err
+	}
+	fs.release(replaced.Bytes, replaced.Files)
+	return nil
+}
+
+// Link creates newName as a hard link to oldName.
+func (fs *FileSystem) Link(oldName string, newName string) error {
+	mount, oldInner, newInner, err := fs.locatePair("link", oldName, newName)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Link(oldInner, newInner)
+	}
+	if err := fs.checkWrite("link", newName); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("link", oldName); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("link", newName); err != nil {
+		return err
+	}
+	if err := fs.checkStoredUpload("link", oldName, newName); err != nil {
+		return err
+	}
+	// every link is counted with the size of the file like DiskUsage does
+	var size int64
+	if info, err := fs.backend.Lstat(CleanPath(oldName)); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	if err := fs.charge("link", newName, size, 1); err != nil {
+		return err
+	}
+	if err := fs.backend.Link(CleanPath(oldName), CleanPath(newName)); err != nil {
+		fs.release(size, 1)
+		return err
+	}
+	return nil
+}
+
+// Symlink creates newName as a symbolic link to target. An absolute target
+// is interpreted as a virtual path of this file system and it cannot be in a
+// different shared folder.
+func (fs *FileSystem) Symlink(target string, newName string) error {
+	mount, inner, err := fs.locate("symlink", newName)
+	if err != nil {
+		return err
+	}
+	if path.IsAbs(target) {
+		targetMount, targetInner, err := fs.locate("symlink", target)
+		if err != nil {
+			return err
+		}
+		if targetMount != mount {
+			return &os.LinkError{Op: "symlink", Old: CleanPath(target), New: CleanPath(newName), Err: syscall.EXDEV}
+		}
+		target = targetInner
+	}
+	return mount.symlink(target, inner)
+}
+
+// symlink creates name as a symbolic link to the target which is a path of
+// this file system if it is absolute and the symlink policy allows the link.
+func (fs *FileSystem) symlink(target string, name string) error {
+	if err := fs.checkWrite("symlink", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinkCreation(target, name); err != nil {
+		return err
+	}
+	if err := fs.charge("symlink", name, 0, 1); err != nil {
+		return err
+	}
+	if err := fs.backend.Symlink(target, CleanPath(name)); err != nil {
+		fs.release(0, 1)
+		return err
+	}
+	return nil
+}
+
+// Readlink returns the target of the specified symbolic link. A target
+// inside the home directory, or the shared folder of the link, is returned as
+// a virtual path.
+func (fs *FileSystem) Readlink(name string) (string, error) {
+	mount, inner, err := fs.locate("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if mount != fs {
+		target, err := mount.Readlink(inner)
+		if err != nil || !path.IsAbs(target) {
+			return target, err
+		}
+		return path.Join(mount.mountPath, target), nil
+	}
+	if err := fs.checkRead("readlink", name); err != nil {
+		return "", err
+	}
+	if err := fs.checkSymlinks("readlink", name); err != nil {
+		return "", err
+	}
+	return fs.backend.Readlink(CleanPath(name))
+}
+
+// Chmod changes the mode of the specified file.
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	mount, inner, err := fs.locate("chmod", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Chmod(inner, mode)
+	}
+	if err := fs.checkWrite("chmod", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinks("chmod", name); err != nil {
+		return err
+	}
+	return fs.backend.Chmod(CleanPath(name), mode)
+}
+
+// Chtimes changes the access and modification times of the specified file.
+func (fs *FileSystem) Chtimes(name string, accessTime time.Time, modificationTime time.Time) error {
+	mount, inner, err := fs.locate("chtimes", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Chtimes(inner, accessTime, modificationTime)
+	}
+	if err := fs.checkWrite("chtimes", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinks("chtimes", name); err != nil {
+		return err
+	}
+	return fs.backend.Chtimes(CleanPath(name), accessTime, modificationTime)
+}
+
+// Truncate changes the size of the specified file.
+func (fs *FileSystem) Truncate(name string, size int64) error {
+	mount, inner, err := fs.locate("truncate", name)
+	if err != nil {
+		return err
+	}
+	if mount != fs {
+		return mount.Truncate(inner, size)
+	}
+	if err := fs.checkWrite("truncate", name); err != nil {
+		return err
+	}
+	if err := fs.checkSymlinks("truncate", name); err != nil {
+		return err
+	}
+	info, err := fs.backend.Stat(CleanPath(name))
+	if err != nil {
+		return err
+	}
+	var delta int64
+	if info.Mode().IsRegular() {
+		delta = size - info.Size()
+	}
+	if err := fs.charge("truncate", name, delta, 0); err != nil {
+		return err
+	}
+	if err := fs.backend.Truncate(CleanPath(name), size); err != nil {
+		fs.release(delta, 0)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/alexhokl/file-server/db"
+)
+
+func newTestFileSystem(t *testing.T) *FileSystem {
+	t.Helper()
+	return NewBackendFileSystem(NewMemoryBackend())
+}
+
+func writeTestFile(t *testing.T, fs *FileSystem, name string, content string) {
+	t.Helper()
+	file, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	if _, err := io.WriteString(file, content); err != nil {
+		file.Close()
+		t.Fatalf("write %s: %v", name, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close %s: %v", name, err)
+	}
+}
+
+func readTestFile(t *testing.T, fs *FileSystem, name string) string {
+	t.Helper()
+	file, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(content)
+}
+
+func entryNames(entries []os.FileInfo) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileSystemReadWrite(t *testing.T) {
+	fs := newTestFileSystem(t)
+
+	writeTestFile(t, fs, "/a.txt", "hello")
+	if got := readTestFile(t, fs, "/a.txt"); got != "hello" {
+		t.Errorf("content = %q, want %q", got, "hello")
+	}
+	info, err := fs.Stat("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 || info.IsDir() {
+		t.Errorf("stat = size %d, directory %v, want size 5 of a file", info.Size(), info.IsDir())
+	}
+
+	if _, err := fs.CreateExclusive("/a.txt"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("create exclusive of an existing file: got %v, want %v", err, os.ErrExist)
+	}
+	if _, err := fs.Open("/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("open of a missing file: got %v, want %v", err, os.ErrNotExist)
+	}
+
+	file, err := fs.OpenFile("/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, " world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "/a.txt"); got != "hello world" {
+		t.Errorf("content after append = %q, want %q", got, "hello world")
+	}
+
+	file, err = fs.OpenFile("/a.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("J"), 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := file.ReadAt(buf, 6); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "world" {
+		t.Errorf("read at 6 = %q, want %q", buf, "world")
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Truncate("/a.txt", 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "/a.txt"); got != "Jello" {
+		t.Errorf("content after truncate = %q, want %q", got, "Jello")
+	}
+}
+
+func TestFileSystemPathsStayInside(t *testing.T) {
+	fs := newTestFileSystem(t)
+
+	if _, err := fs.OpenFile("../../etc/passwd", os.O_WRONLY|os.O_CREATE, 0o644); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("create in a missing directory: got %v, want %v", err, os.ErrNotExist)
+	}
+	if err := fs.MkdirAll("/etc", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "../../etc/passwd", "x")
+	if got := readTestFile(t, fs, "/etc/passwd"); got != "x" {
+		t.Errorf("content = %q, want %q", got, "x")
+	}
+	if err := fs.Remove("/"); err == nil {
+		t.Error("root directory removed")
+	}
+}
+
+func TestFileSystemDirectories(t *testing.T) {
+	fs := newTestFileSystem(t)
+
+	if err := fs.MkdirAll("/a/b/c", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/a", 0o755); !errors.Is(err, os.ErrExist) {
+		t.Errorf("mkdir of an existing directory: got %v, want %v", err, os.ErrExist)
+	}
+	writeTestFile(t, fs, "/a/z.txt", "z")
+	writeTestFile(t, fs, "/a/b/y.txt", "y")
+
+	entries, err := fs.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryNames(entries), []string{"b", "z.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	if _, err := fs.ReadDir("/a/z.txt"); err == nil {
+		t.Error("file read as a directory")
+	}
+
+	if err := fs.Remove("/a/b"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("remove of a directory which is not empty: got %v, want %v", err, syscall.ENOTEMPTY)
+	}
+	if err := fs.Remove("/a/b/c"); err != nil {
+		t.Errorf("remove of an empty directory: %v", err)
+	}
+	if err := fs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of a directory removed: got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestFileSystemRename(t *testing.T) {
+	fs := newTestFileSystem(t)
+
+	writeTestFile(t, fs, "/a.txt", "a")
+	writeTestFile(t, fs, "/b.txt", "b")
+	if err := fs.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "/b.txt"); got != "a" {
+		t.Errorf("content of the file replaced = %q, want %q", got, "a")
+	}
+	if _, err := fs.Stat("/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of the file renamed: got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err := fs.MkdirAll("/d/e", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/d/e/f.txt", "f")
+	if err := fs.Rename("/d", "/g"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "/g/e/f.txt"); got != "f" {
+		t.Errorf("content in the directory renamed = %q, want %q", got, "f")
+	}
+	if err := fs.Rename("/g", "/g/e/h"); err == nil {
+		t.Error("directory renamed into itself")
+	}
+}
+
+func TestFileSystemQuota(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.quota = Quota{Bytes: 10, Files: 3}
+
+	writeTestFile(t, fs, "/a.txt", "12345678")
+	file, err := fs.OpenFile("/b.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "12345"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("write over the quota of bytes: got %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Mkdir("/c", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/d", 0o755); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("mkdir over the quota of files: got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	usage, err := fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Bytes: 8, Files: 3}); usage != want {
+		t.Errorf("usage = %+v, want %+v", usage, want)
+	}
+
+	if err := fs.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/b.txt", "1234567890")
+	usage, err = fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Bytes: 10, Files: 2}); usage != want {
+		t.Errorf("usage after remove = %+v, want %+v", usage, want)
+	}
+	if err := fs.RefreshUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed, _ := fs.QuotaUsage(); refreshed != usage {
+		t.Errorf("usage refreshed = %+v, want %+v", refreshed, usage)
+	}
+}
+
+func TestFileSystemAccessMode(t *testing.T) {
+	fs := newTestFileSystem(t)
+	writeTestFile(t, fs, "/a.txt", "a")
+
+	fs.accessMode = db.AccessModeReadOnly
+	if got := readTestFile(t, fs, "/a.txt"); got != "a" {
+		t.Errorf("content = %q, want %q", got, "a")
+	}
+	if _, err := fs.OpenFile("/b.txt", os.O_WRONLY|os.O_CREATE, 0o644); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("create by a read-only user: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := fs.Remove("/a.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("remove by a read-only user: got %v, want %v", err, os.ErrPermission)
+	}
+
+	fs.accessMode = db.AccessModeWriteOnly
+	if _, err := fs.Open("/a.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("open by a write-only user: got %v, want %v", err, os.ErrPermission)
+	}
+	writeTestFile(t, fs, "/b.txt", "b")
+}
+
+func TestFileSystemSymlinkPolicy(t *testing.T) {
+	tests := []struct {
+		policy  SymlinkPolicy
+		allowed bool
+	}{
+		{policy: SymlinkDeny, allowed: false},
+		{policy: SymlinkJail, allowed: true},
+		{policy: SymlinkAllow, allowed: true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			fs := newTestFileSystem(t)
+			writeTestFile(t, fs, "/a.txt", "a")
+			// a link left from before the policy applies
+			if err := fs.backend.Symlink("/a.txt", "/old"); err != nil {
+				t.Fatal(err)
+			}
+			fs.symlinkPolicy = test.policy
+
+			err := fs.Symlink("/a.txt", "/new")
+			if test.allowed && err != nil {
+				t.Errorf("symlink: %v", err)
+			}
+			if !test.allowed && !errors.Is(err, os.ErrPermission) {
+				t.Errorf("symlink: got %v, want %v", err, os.ErrPermission)
+			}
+
+			_, err = fs.Open("/old")
+			if test.allowed && err != nil {
+				t.Errorf("open through a link: %v", err)
+			}
+			if !test.allowed && !errors.Is(err, os.ErrPermission) {
+				t.Errorf("open through a link: got %v, want %v", err, os.ErrPermission)
+			}
+
+			// a link can always be removed
+			if err := fs.Remove("/old"); err != nil {
+				t.Errorf("remove of a link: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileSystemVersions(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.versioning = true
+
+	if err := fs.Mkdir("/a", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/a/b.txt", "first")
+	writeTestFile(t, fs, "/a/b.txt", "second!")
+	versions, err := fs.ListVersions("/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Size != 5 || versions[0].Deleted {
+		t.Fatalf("versions = %+v, want one version of 5 bytes", versions)
+	}
+
+	// versions count towards the quota but they cannot be accessed
+	usage, err := fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Bytes: 12, Files: 3}); usage != want {
+		t.Errorf("usage = %+v, want %+v", usage, want)
+	}
+	if _, err := fs.Stat(VersionsDirectory); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat of the versions directory: got %v, want %v", err, os.ErrPermission)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryNames(entries), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries of the root = %v, want %v", got, want)
+	}
+
+	if err := fs.RestoreVersion("/a/b.txt", versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "/a/b.txt"); got != "first" {
+		t.Errorf("content restored = %q, want %q", got, "first")
+	}
+
+	if err := fs.Remove("/a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	trash, err := fs.ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 2 || trash[0].Path != "/a/b.txt" || !trash[0].Deleted {
+		t.Fatalf("trash = %+v, want the two versions of /a/b.txt", trash)
+	}
+	for _, version := range trash {
+		if err := fs.DeleteVersion(version.Path, version.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, err = fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Bytes: 0, Files: 1}); usage != want {
+		t.Errorf("usage after the versions are deleted = %+v, want %+v", usage, want)
+	}
+}
+
+func TestFileSystemStaged(t *testing.T) {
+	fs := newTestFileSystem(t)
+	fs.quota = Quota{Bytes: 10}
+
+	file, err := fs.CreateStaged("upload/part-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "12345678"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err = fs.CreateStaged("upload/part-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "12345"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("staged write over the quota: got %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat(StagingDirectory); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat of the staging directory: got %v, want %v", err, os.ErrPermission)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("entries of the root = %v, want none", entryNames(entries))
+	}
+
+	if err := fs.RemoveStaged("upload"); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Usage{Bytes: 0, Files: 0}); usage != want {
+		t.Errorf("usage after the staged files are removed = %+v, want %+v", usage, want)
+	}
+}
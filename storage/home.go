@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// HomeConfig is the configuration of the home directories of users.
+type HomeConfig struct {
+	// SkeletonDirectory is the directory on the local disk copied into the
+	// home directory of a user created where empty means nothing is copied
+	SkeletonDirectory string
+
+	// DirectoryMode is the mode of the home directories and the directories
+	// copied from the skeleton directory
+	DirectoryMode os.FileMode
+
+	// UID and GID are the owner of the home directories on the local disk
+	// and the files copied into them where -1 keeps the owner as the user
+	// running the server
+	UID int
+	GID int
+
+	// ArchiveDirectory is the directory on the local disk where the home
+	// directories of users deleted are archived
+	ArchiveDirectory string
+
+	// PurgeGracePeriod is how long the home directory of a user deleted is
+	// kept before it is purged where zero purges it immediately
+	PurgeGracePeriod time.Duration
+}
+
+// homeConfig is the configuration of home directories of the deployment.
+var homeConfig = HomeConfig{DirectoryMode: 0o755, UID: -1, GID: -1}
+
+// SetHomeConfig sets the configuration of home directories. It is expected
+// to be called once before the servers are started.
+func SetHomeConfig(config HomeConfig) {
+	homeConfig = config
+}
+
+// createHomeDirectory creates the specified home directory on the local disk
+// with the mode and the owner of home directories.
+func createHomeDirectory(root string) error {
+	if err := os.MkdirAll(root, homeConfig.DirectoryMode); err != nil {
+		return err
+	}
+	// the mode given to MkdirAll is masked by the umask
+	if err := os.Chmod(root, homeConfig.DirectoryMode); err != nil {
+		return err
+	}
+	return os.Lchown(root, homeConfig.UID, homeConfig.GID)
+}
+
+// ProvisionHome creates the home directory of the specified user and copies
+// the skeleton directory into it. Files copied are counted towards the quota
+// of the user but they are neither scanned nor raised as upload events.
+// Nothing is left behind if the provisioning fails. A home directory kept
+// from a user deleted earlier with the same username is left as it is.
+func ProvisionHome(dbConn *gorm.DB, pathUsersDirectory string, username string) error {
+	fileSystem, err := OpenUserFileSystem(dbConn, pathUsersDirectory, username)
+	if err != nil {
+		return err
+	}
+	if homeConfig.SkeletonDirectory == "" {
+		return nil
+	}
+	entries, err := fileSystem.backend.ReadDir("/")
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return nil
+	}
+	if err := fileSystem.copySkeleton(homeConfig.SkeletonDirectory); err != nil {
+		return errors.Join(err, fileSystem.purge())
+	}
+	// the files copied are not charged as they are written
+	return fileSystem.RefreshUsage()
+}
+
+// copySkeleton copies the files, directories and symbolic links of the
+// specified directory on the local disk into the root of the file system.
+func (fs *FileSystem) copySkeleton(skeleton string) error {
+	return filepath.WalkDir(skeleton, func(pathFile string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(skeleton, pathFile)
+		if err != nil {
+			return err
+		}
+		name := CleanPath(filepath.ToSlash(relative))
+		if name == "/" {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			if err := fs.backend.Mkdir(name, homeConfig.DirectoryMode); err != nil {
+				return err
+			}
+			if err := fs.backend.Chmod(name, homeConfig.DirectoryMode); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(pathFile)
+			if err != nil {
+				return err
+			}
+			if err := fs.backend.Symlink(target, name); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := fs.copySkeletonFile(pathFile, name, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			// devices, sockets and pipes are not copied
+			return nil
+		}
+		if pathLocal := fs.localPath(name); pathLocal != "" {
+			return os.Lchown(pathLocal, homeConfig.UID, homeConfig.GID)
+		}
+		return nil
+	})
+}
+
+// localPath returns the path on the local disk where the specified file is
+// stored, whether it is encrypted or not, or an empty string if the files
+// are not stored on the local disk.
+func (fs *FileSystem) localPath(name string) string {
+	backend := fs.backend
+	if encrypted, ok := backend.(*EncryptedBackend); ok {
+		backend = encrypted.backend
+	}
+	if local, ok := backend.(*LocalBackend); ok {
+		return local.resolve(name)
+	}
+	return ""
+}
+
+func (fs *FileSystem) copySkeletonFile(pathFile string, name string, perm os.FileMode) error {
+	source, err := os.Open(pathFile)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := fs.backend.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destination, source)
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ArchiveHome writes the files of the home directory of the specified user
+// into a gzipped tarball in the archive directory and returns the path of
+// the tarball. The files of encrypted users are archived decrypted and the
+// shared folders of the user are not included.
+func ArchiveHome(dbConn *gorm.DB, pathUsersDirectory string, username string) (string, error) {
+	fileSystem, err := OpenUserFileSystem(dbConn, pathUsersDirectory, username)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(homeConfig.ArchiveDirectory, 0o700); err != nil {
+		return "", err
+	}
+	pathArchive := filepath.Join(homeConfig.ArchiveDirectory, fmt.Sprintf("%s-%s.tar.gz", username, time.Now().UTC().Format("20060102T150405Z")))
+	file, err := os.OpenFile(pathArchive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = fileSystem.archive(file, username)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Join(err, os.Remove(pathArchive))
+	}
+	return pathArchive, nil
+}
+
+// archive writes the files of the file system into a gzipped tarball where
+// the paths are below the specified directory.
+func (fs *FileSystem) archive(w io.Writer, directory string) error {
+	info, err := fs.backend.Lstat("/")
+	if err != nil {
+		return err
+	}
+	var names []string
+	var infos []os.FileInfo
+	err = fs.walk("/", info, func(name string, info os.FileInfo) {
+		// temporary files of uploads are incomplete
+		if !isTempFile(name) {
+			names = append(names, name)
+			infos = append(infos, info)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	compressor := gzip.NewWriter(w)
+	writer := tar.NewWriter(compressor)
+	for i, name := range names {
+		if err := fs.archiveFile(writer, path.Join(directory, name), name, infos[i]); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func (fs *FileSystem) archiveFile(writer *tar.Writer, archiveName string, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = fs.backend.Readlink(name); err != nil {
+			return err
+		}
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() && link == "" {
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = archiveName
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	file, err := fs.backend.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// ScheduleHomePurge records the home directory of the specified user, who
+// is being deleted, to be purged once the grace period has passed and
+// returns the time of the purge. It is expected to be called in the
+// transaction deleting the user.
+func ScheduleHomePurge(tx *gorm.DB, user db.User) (time.Time, error) {
+	var home db.DeletedHome
+	result := tx.Where("username = ?", user.Username).Limit(1).Find(&home)
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	home.StorageBackend = user.StorageBackend
+	home.PurgeAt = time.Now().UTC().Add(homeConfig.PurgeGracePeriod)
+	if result.RowsAffected == 0 {
+		home.Username = user.Username
+		result = tx.Create(&home)
+	} else {
+		result = tx.Save(&home)
+	}
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	return home.PurgeAt, nil
+}
+
+// PurgeDeletedHome purges the home directory of the specified user deleted
+// along with the data key of the user regardless of the grace period, if it
+// is waiting to be purged.
+func PurgeDeletedHome(dbConn *gorm.DB, pathUsersDirectory string, username string) error {
+	var homes []db.DeletedHome
+	if err := dbConn.Where("username = ?", username).Limit(1).Find(&homes).Error; err != nil {
+		return err
+	}
+	if len(homes) == 0 {
+		return nil
+	}
+	return purgeDeletedHome(dbConn, pathUsersDirectory, homes[0])
+}
+
+// PurgeDeletedHomes purges the home directories of users deleted whose grace
+// period has passed.
+func PurgeDeletedHomes(dbConn *gorm.DB, pathUsersDirectory string) error {
+	var homes []db.DeletedHome
+	if err := dbConn.Where("purge_at <= ?", time.Now().UTC()).Order("purge_at ASC").Find(&homes).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, home := range homes {
+		if err := purgeDeletedHome(dbConn, pathUsersDirectory, home); err != nil {
+			errs = append(errs, fmt.Errorf("unable to purge home directory of user %s: %w", home.Username, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func purgeDeletedHome(dbConn *gorm.DB, pathUsersDirectory string, home db.DeletedHome) error {
+	if err := PurgeHome(pathUsersDirectory, home); err != nil {
+		return err
+	}
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		_, err := TakeDeletedHome(tx, home.Username)
+		return err
+	})
+}
+
+// TakeDeletedHome forgets the home directory of the specified user deleted
+// earlier, if it is waiting to be purged, along with the data key of the
+// user and returns it to be purged with PurgeHome, or nil. It is expected to
+// be called in the transaction creating a user with the same username so
+// that the home directory of the new user is not purged in the background,
+// and the home directory is purged once the transaction is committed.
+func TakeDeletedHome(tx *gorm.DB, username string) (*db.DeletedHome, error) {
+	var homes []db.DeletedHome
+	if err := tx.Where("username = ?", username).Limit(1).Find(&homes).Error; err != nil {
+		return nil, err
+	}
+	if len(homes) == 0 {
+		return nil, nil
+	}
+	if err := tx.Where("username = ?", username).Delete(&db.UserDataKey{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("username = ?", username).Delete(&db.DeletedHome{}).Error; err != nil {
+		return nil, err
+	}
+	return &homes[0], nil
+}
+
+// PurgeHome removes the files of the specified home directory deleted.
+func PurgeHome(pathUsersDirectory string, home db.DeletedHome) error {
+	// the files are removed without being decrypted
+	fileSystem, err := newFileSystem(home.StorageBackend, pathUsersDirectory, home.Username)
+	if err != nil {
+		return err
+	}
+	return fileSystem.purge()
+}
+
+// purge removes everything stored by the file system and forgets its usage.
+func (fs *FileSystem) purge() error {
+	if err := fs.backend.RemoveAll("/"); err != nil {
+		return err
+	}
+	usageCountersMu.Lock()
+	defer usageCountersMu.Unlock()
+	for key, counter := range usageCounters {
+		if counter == fs.usage {
+			delete(usageCounters, key)
+		}
+	}
+	return nil
+}
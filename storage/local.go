@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalBackend stores files in a directory of the local disk.
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend returns a backend storing files in the specified
+// directory.
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: root}
+}
+
+// Root returns the directory where files are stored.
+func (b *LocalBackend) Root() string {
+	return b.root
+}
+
+// resolve returns the path on the local disk of the specified path. The path
+// is always interpreted from the root so that ".." cannot go above it.
+func (b *LocalBackend) resolve(name string) string {
+	return filepath.Join(b.root, filepath.FromSlash(CleanPath(name)))
+}
+
+func (b *LocalBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(b.resolve(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (b *LocalBackend) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(b.resolve(name))
+}
+
+func (b *LocalBackend) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(b.resolve(name))
+}
+
+func (b *LocalBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(b.resolve(name))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func (b *LocalBackend) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(b.resolve(name), perm)
+}
+
+func (b *LocalBackend) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(b.resolve(name), perm)
+}
+
+func (b *LocalBackend) Remove(name string) error {
+	return os.Remove(b.resolve(name))
+}
+
+func (b *LocalBackend) RemoveAll(name string) error {
+	return os.RemoveAll(b.resolve(name))
+}
+
+func (b *LocalBackend) Rename(oldName string, newName string) error {
+	return os.Rename(b.resolve(oldName), b.resolve(newName))
+}
+
+func (b *LocalBackend) Link(oldName string, newName string) error {
+	return os.Link(b.resolve(oldName), b.resolve(newName))
+}
+
+func (b *LocalBackend) Symlink(target string, name string) error {
+	if strings.HasPrefix(target, "/") {
+		target = b.resolve(target)
+	}
+	return os.Symlink(target, b.resolve(name))
+}
+
+func (b *LocalBackend) Readlink(name string) (string, error) {
+	target, err := os.Readlink(b.resolve(name))
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		return filepath.ToSlash(target), nil
+	}
+	relative, err := filepath.Rel(b.root, target)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(target), nil
+	}
+	return CleanPath(filepath.ToSlash(relative)), nil
+}
+
+func (b *LocalBackend) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(b.resolve(name), mode)
+}
+
+func (b *LocalBackend) Chtimes(name string, accessTime time.Time, modificationTime time.Time) error {
+	return os.Chtimes(b.resolve(name), accessTime, modificationTime)
+}
+
+func (b *LocalBackend) Truncate(name string, size int64) error {
+	return os.Truncate(b.resolve(name), size)
+}
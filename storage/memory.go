@@ -0,0 +1,687 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSymlinkHops is the number of symbolic links followed when resolving a
+// path before giving up with ELOOP, as Linux does.
+const maxSymlinkHops = 40
+
+// MemoryBackend stores files in memory. Files are lost when the process
+// exits and it is only meant for tests through NewBackendFileSystem.
+type MemoryBackend struct {
+	mu   sync.Mutex
+	root *memoryNode
+}
+
+// memoryNode is a file, a directory or a symbolic link. A node is shared by
+// all the hard links to it.
+type memoryNode struct {
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memoryNode
+	target   string
+}
+
+// NewMemoryBackend returns an empty backend storing files in memory.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{root: newMemoryDirectory(0o755)}
+}
+
+func newMemoryDirectory(perm os.FileMode) *memoryNode {
+	return &memoryNode{
+		mode:     os.ModeDir | perm&os.ModePerm,
+		modTime:  time.Now(),
+		children: map[string]*memoryNode{},
+	}
+}
+
+// lookup finds the specified path and returns the directory containing it,
+// its name in the directory and the node if it exists. The last element of
+// the path is only followed if it is a symbolic link and follow is set. The
+// lock must be held.
+func (b *MemoryBackend) lookup(op string, name string, follow bool) (*memoryNode, string, *memoryNode, error) {
+	hops := 0
+	return b.walk(op, CleanPath(name), CleanPath(name), follow, &hops)
+}
+
+func (b *MemoryBackend) walk(op string, original string, name string, follow bool, hops *int) (*memoryNode, string, *memoryNode, error) {
+	if name == "/" {
+		return nil, "", b.root, nil
+	}
+	directory := b.root
+	directoryPath := "/"
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, part := range parts {
+		last := i == len(parts)-1
+		child := directory.children[part]
+		if child != nil && child.mode&os.ModeSymlink != 0 && (!last || follow) {
+			*hops++
+			if *hops > maxSymlinkHops {
+				return nil, "", nil, &os.PathError{Op: op, Path: original, Err: syscall.ELOOP}
+			}
+			target := child.target
+			if !path.IsAbs(target) {
+				target = path.Join(directoryPath, target)
+			}
+			rest := path.Join(append([]string{target}, parts[i+1:]...)...)
+			return b.walk(op, original, CleanPath(rest), follow, hops)
+		}
+		if last {
+			return directory, part, child, nil
+		}
+		if child == nil {
+			return nil, "", nil, &os.PathError{Op: op, Path: original, Err: syscall.ENOENT}
+		}
+		if !child.mode.IsDir() {
+			return nil, "", nil, &os.PathError{Op: op, Path: original, Err: syscall.ENOTDIR}
+		}
+		directory = child
+		directoryPath = path.Join(directoryPath, part)
+	}
+	return nil, "", nil, &os.PathError{Op: op, Path: original, Err: syscall.ENOENT}
+}
+
+// find returns the existing node of the specified path. The lock must be
+// held.
+func (b *MemoryBackend) find(op string, name string, follow bool) (string, *memoryNode, error) {
+	_, base, node, err := b.lookup(op, name, follow)
+	if err != nil {
+		return "", nil, err
+	}
+	if node == nil {
+		return "", nil, &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOENT}
+	}
+	return base, node, nil
+}
+
+func (b *MemoryBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name = CleanPath(name)
+	parent, base, node, err := b.lookup("open", name, flag&(os.O_CREATE|os.O_EXCL) != os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case node != nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	case node == nil:
+		node = &memoryNode{mode: perm & os.ModePerm, modTime: time.Now()}
+		parent.children[base] = node
+		parent.modTime = node.modTime
+	case node.mode.IsDir() && writable:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case flag&os.O_TRUNC != 0 && writable:
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	if base == "" {
+		base = "/"
+	}
+	return &memoryFile{
+		backend:  b,
+		node:     node,
+		name:     name,
+		baseName: base,
+		readable: flag&os.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+func (b *MemoryBackend) Stat(name string) (os.FileInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	base, node, err := b.find("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(base), nil
+}
+
+func (b *MemoryBackend) Lstat(name string) (os.FileInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	base, node, err := b.find("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(base), nil
+}
+
+func (b *MemoryBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, node, err := b.find("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: CleanPath(name), Err: syscall.ENOTDIR}
+	}
+	return node.entries(), nil
+}
+
+func (b *MemoryBackend) Mkdir(name string, perm os.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.mkdir(name, perm)
+}
+
+// mkdir creates a directory. The lock must be held.
+func (b *MemoryBackend) mkdir(name string, perm os.FileMode) error {
+	parent, base, node, err := b.lookup("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		return &os.PathError{Op: "mkdir", Path: CleanPath(name), Err: syscall.EEXIST}
+	}
+	directory := newMemoryDirectory(perm)
+	parent.children[base] = directory
+	parent.modTime = directory.modTime
+	return nil
+}
+
+func (b *MemoryBackend) MkdirAll(name string, perm os.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(CleanPath(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+		_, _, node, err := b.lookup("mkdir", current, true)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			if err := b.mkdir(current, perm); err != nil {
+				return err
+			}
+			continue
+		}
+		if !node.mode.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, base, node, err := b.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		return &os.PathError{Op: "remove", Path: "/", Err: syscall.EBUSY}
+	}
+	if node == nil {
+		return &os.PathError{Op: "remove", Path: CleanPath(name), Err: syscall.ENOENT}
+	}
+	if node.mode.IsDir() && len(node.children) > 0 {
+		return &os.PathError{Op: "remove", Path: CleanPath(name), Err: syscall.ENOTEMPTY}
+	}
+	delete(parent.children, base)
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (b *MemoryBackend) RemoveAll(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, base, node, err := b.lookup("unlinkat", name, false)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) && pathErr.Err == syscall.ENOENT {
+			return nil
+		}
+		return err
+	}
+	if node == nil {
+		return nil
+	}
+	if parent == nil {
+		// the root itself is kept as it is the root of the backend
+		node.children = map[string]*memoryNode{}
+		node.modTime = time.Now()
+		return nil
+	}
+	delete(parent.children, base)
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (b *MemoryBackend) Rename(oldName string, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldName = CleanPath(oldName)
+	newName = CleanPath(newName)
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	oldParent, oldBase, node, err := b.lookup("rename", oldName, false)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return linkError(syscall.ENOENT)
+	}
+	if oldParent == nil {
+		return linkError(syscall.EBUSY)
+	}
+	newParent, newBase, existing, err := b.lookup("rename", newName, false)
+	if err != nil {
+		return err
+	}
+	if newParent == nil {
+		return linkError(syscall.EBUSY)
+	}
+	if existing == node {
+		return nil
+	}
+	if node.mode.IsDir() && strings.HasPrefix(newName, oldName+"/") {
+		return linkError(syscall.EINVAL)
+	}
+	if existing != nil {
+		switch {
+		case node.mode.IsDir() && !existing.mode.IsDir():
+			return linkError(syscall.ENOTDIR)
+		case !node.mode.IsDir() && existing.mode.IsDir():
+			return linkError(syscall.EISDIR)
+		case existing.mode.IsDir() && len(existing.children) > 0:
+			return linkError(syscall.ENOTEMPTY)
+		}
+	}
+	delete(oldParent.children, oldBase)
+	newParent.children[newBase] = node
+	now := time.Now()
+	oldParent.modTime = now
+	newParent.modTime = now
+	return nil
+}
+
+func (b *MemoryBackend) Link(oldName string, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "link", Old: CleanPath(oldName), New: CleanPath(newName), Err: err}
+	}
+	_, _, node, err := b.lookup("link", oldName, false)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return linkError(syscall.ENOENT)
+	}
+	if node.mode.IsDir() {
+		return linkError(syscall.EPERM)
+	}
+	parent, base, existing, err := b.lookup("link", newName, false)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return linkError(syscall.EEXIST)
+	}
+	parent.children[base] = node
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (b *MemoryBackend) Symlink(target string, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent, base, existing, err := b.lookup("symlink", name, false)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: CleanPath(name), Err: syscall.EEXIST}
+	}
+	node := &memoryNode{mode: os.ModeSymlink | 0o777, modTime: time.Now(), target: target}
+	parent.children[base] = node
+	parent.modTime = node.modTime
+	return nil
+}
+
+func (b *MemoryBackend) Readlink(name string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, node, err := b.find("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: CleanPath(name), Err: syscall.EINVAL}
+	}
+	return node.target, nil
+}
+
+func (b *MemoryBackend) Chmod(name string, mode os.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, node, err := b.find("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode&^os.ModePerm | mode&os.ModePerm
+	return nil
+}
+
+func (b *MemoryBackend) Chtimes(name string, accessTime time.Time, modificationTime time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, node, err := b.find("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	// access times are not kept
+	node.modTime = modificationTime
+	return nil
+}
+
+func (b *MemoryBackend) Truncate(name string, size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, node, err := b.find("truncate", name, true)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() {
+		return &os.PathError{Op: "truncate", Path: CleanPath(name), Err: syscall.EISDIR}
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: CleanPath(name), Err: syscall.EINVAL}
+	}
+	node.truncate(size)
+	return nil
+}
+
+// DiskSpace is not supported as the memory of the process is not reserved
+// for files.
+func (b *MemoryBackend) DiskSpace() (DiskSpace, error) {
+	return DiskSpace{}, errors.ErrUnsupported
+}
+
+// truncate changes the size of the file. The lock must be held.
+func (n *memoryNode) truncate(size int64) {
+	if size <= int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+	n.modTime = time.Now()
+}
+
+// info returns a snapshot of the information of the node. The lock must be
+// held.
+func (n *memoryNode) info(name string) os.FileInfo {
+	if name == "" {
+		name = "/"
+	}
+	size := int64(len(n.data))
+	if n.mode&os.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return memoryFileInfo{name: name, size: size, mode: n.mode, modTime: n.modTime}
+}
+
+// entries returns the information of the entries of the directory sorted
+// by name. The lock must be held.
+func (n *memoryNode) entries() []os.FileInfo {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		list = append(list, n.children[name].info(name))
+	}
+	return list
+}
+
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memoryFileInfo) Name() string {
+	return i.name
+}
+
+func (i memoryFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i memoryFileInfo) Mode() os.FileMode {
+	return i.mode
+}
+
+func (i memoryFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i memoryFileInfo) IsDir() bool {
+	return i.mode.IsDir()
+}
+
+func (i memoryFileInfo) Sys() any {
+	return nil
+}
+
+// memoryFile is a file of a MemoryBackend opened. It keeps working after
+// the file is removed or renamed, as files on disk do.
+type memoryFile struct {
+	backend   *MemoryBackend
+	node      *memoryNode
+	name      string
+	baseName  string
+	readable  bool
+	writable  bool
+	append    bool
+	offset    int64
+	dirOffset int
+	closed    bool
+}
+
+// check returns the error of an operation which is not allowed on the file.
+// The lock must be held.
+func (f *memoryFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	case f.node.mode.IsDir():
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	case write && !f.writable, !write && !f.readable:
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *memoryFile) Read(p []byte) (int, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	n, err := f.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readAt reads from the offset. The lock must be held.
+func (f *memoryFile) readAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, f.node.data[off:]), nil
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.append {
+		return 0, errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: syscall.EINVAL}
+	}
+	return f.writeAt(p, off)
+}
+
+// writeAt writes at the offset and grows the file as needed. The lock must
+// be held.
+func (f *memoryFile) writeAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.truncate(end)
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.closed {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	if f.node.mode.IsDir() && offset == 0 {
+		f.dirOffset = 0
+	}
+	return offset, nil
+}
+
+func (f *memoryFile) Close() error {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memoryFile) Name() string {
+	return f.name
+}
+
+func (f *memoryFile) Stat() (os.FileInfo, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.closed {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.node.info(f.baseName), nil
+}
+
+func (f *memoryFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if f.closed {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	entries := f.node.entries()
+	if f.dirOffset > len(entries) {
+		f.dirOffset = len(entries)
+	}
+	entries = entries[f.dirOffset:]
+	if count <= 0 {
+		f.dirOffset += len(entries)
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	entries = entries[:min(count, len(entries))]
+	f.dirOffset += len(entries)
+	return entries, nil
+}
+
+func (f *memoryFile) Sync() error {
+	return nil
+}
+
+func (f *memoryFile) Truncate(size int64) error {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.node.truncate(size)
+	return nil
+}
@@ -0,0 +1,1029 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ObjectStoreConfig is the configuration of an S3-compatible object store.
+type ObjectStoreConfig struct {
+	// Endpoint is the URL of the object store, such as
+	// http://localhost:9000, and requests are made in path style
+	Endpoint string
+
+	// Bucket is the bucket where the files of all users are stored
+	Bucket string
+
+	// Region is the region used in request signatures
+	Region string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Prefix is prepended to the keys of all objects
+	Prefix string
+}
+
+const (
+	objectStoreDefaultRegion = "us-east-1"
+	objectStoreDateFormat    = "20060102T150405Z"
+	objectStoreListPageSize  = 1000
+)
+
+// ObjectStoreBackend stores files as objects of an S3-compatible object
+// store where the files of a user are stored under a prefix named after the
+// user. Directories are stored as empty objects with a trailing slash in
+// their keys, as the S3 console does. Symbolic links, hard links and
+// permissions are not supported.
+//
+// Files opened for writing are staged in a temporary file on the local disk
+// and uploaded when they are closed, as objects cannot be changed in place.
+type ObjectStoreBackend struct {
+	config ObjectStoreConfig
+	prefix string
+	client *http.Client
+}
+
+// NewObjectStoreBackend returns a backend storing the files of the
+// specified user in the object store.
+func NewObjectStoreBackend(config ObjectStoreConfig, username string) *ObjectStoreBackend {
+	if config.Region == "" {
+		config.Region = objectStoreDefaultRegion
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &ObjectStoreBackend{
+		config: config,
+		prefix: strings.Trim(path.Join(config.Prefix, username), "/"),
+		client: http.DefaultClient,
+	}
+}
+
+// key returns the key of the object of a file.
+func (b *ObjectStoreBackend) key(name string) string {
+	return b.prefix + CleanPath(name)
+}
+
+// directoryKey returns the key of the object of a directory which is also
+// the prefix of the keys of the files in the directory.
+func (b *ObjectStoreBackend) directoryKey(name string) string {
+	name = CleanPath(name)
+	if name == "/" {
+		return b.prefix + "/"
+	}
+	return b.prefix + name + "/"
+}
+
+// objectStoreError is an error response of the object store.
+type objectStoreError struct {
+	method     string
+	key        string
+	statusCode int
+}
+
+func (e *objectStoreError) Error() string {
+	return fmt.Sprintf("object store: %s %s: %s", e.method, e.key, http.StatusText(e.statusCode))
+}
+
+// toPathError converts an error of a request into an error of the specified
+// path so that missing objects and denied requests are reported as they are
+// on a local disk.
+func toPathError(op string, name string, err error) error {
+	var storeErr *objectStoreError
+	if errors.As(err, &storeErr) {
+		switch storeErr.statusCode {
+		case http.StatusNotFound:
+			return &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOENT}
+		case http.StatusForbidden:
+			return &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.EACCES}
+		}
+	}
+	return &os.PathError{Op: op, Path: CleanPath(name), Err: err}
+}
+
+// do sends a request signed with AWS signature version 4. The payload is not
+// signed so that content can be streamed. A response with an error status is
+// returned as an objectStoreError.
+func (b *ObjectStoreBackend) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	rawQuery := objectStoreQueryString(query)
+	endpoint, err := url.Parse(b.config.Endpoint + "/" + objectStoreEncode(b.config.Bucket+"/"+key, false))
+	if err != nil {
+		return nil, err
+	}
+	endpoint.RawQuery = rawQuery
+
+	req, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	b.sign(req, rawQuery)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &objectStoreError{method: method, key: key, statusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// sign adds the headers of AWS signature version 4 to the request.
+func (b *ObjectStoreBackend) sign(req *http.Request, rawQuery string) {
+	now := time.Now().UTC()
+	amzDate := now.Format(objectStoreDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		objectStoreEncode(req.URL.Path, false),
+		rawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join([]string{date, b.config.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := objectStoreHMAC([]byte("AWS4"+b.config.SecretAccessKey), date)
+	key = objectStoreHMAC(key, b.config.Region)
+	key = objectStoreHMAC(key, "s3")
+	key = objectStoreHMAC(key, "aws4_request")
+	signature := hex.EncodeToString(objectStoreHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func objectStoreHMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// objectStoreEncode encodes a string with the rules of AWS signature version
+// 4 where only unreserved characters are kept as is.
+func objectStoreEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// objectStoreQueryString returns the query string in the canonical form of
+// AWS signature version 4 which is also sent as is.
+func objectStoreQueryString(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, objectStoreEncode(name, true)+"="+objectStoreEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// objectInfo is the information of an object or of a directory.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (i objectInfo) Name() string {
+	return i.name
+}
+
+func (i objectInfo) Size() int64 {
+	return i.size
+}
+
+func (i objectInfo) Mode() os.FileMode {
+	if i.isDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i objectInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i objectInfo) IsDir() bool {
+	return i.isDir
+}
+
+func (i objectInfo) Sys() any {
+	return nil
+}
+
+func baseName(name string) string {
+	name = CleanPath(name)
+	if name == "/" {
+		return name
+	}
+	return path.Base(name)
+}
+
+// head returns the information of the object of the specified key.
+func (b *ObjectStoreBackend) head(key string) (objectInfo, error) {
+	resp, err := b.do(http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// listResult is the response of ListObjectsV2.
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list calls the function with every page of the objects with the specified
+// prefix until the function returns false. Objects below a delimiter are
+// rolled up into common prefixes if delimiter is set.
+func (b *ObjectStoreBackend) list(prefix string, delimiter string, maxKeys int, fn func(result *listResult) bool) error {
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"max-keys":  {strconv.Itoa(maxKeys)},
+		}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !fn(&result) || !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// keys returns the keys of all the objects with the specified prefix.
+func (b *ObjectStoreBackend) keys(prefix string) ([]string, error) {
+	var keys []string
+	err := b.list(prefix, "", objectStoreListPageSize, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		return true
+	})
+	return keys, err
+}
+
+// hasChildren returns whether there is any object in the directory of the
+// specified key, not counting the directory itself.
+func (b *ObjectStoreBackend) hasChildren(directoryKey string) (bool, error) {
+	found := false
+	err := b.list(directoryKey, "", 2, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			if object.Key != directoryKey {
+				found = true
+			}
+		}
+		return false
+	})
+	return found, err
+}
+
+// stat returns the information of a file or a directory. A directory exists
+// if it has its own object or if there is any object in it.
+func (b *ObjectStoreBackend) stat(op string, name string) (objectInfo, error) {
+	name = CleanPath(name)
+	if name == "/" {
+		return objectInfo{name: "/", isDir: true}, nil
+	}
+	var storeErr *objectStoreError
+	info, err := b.head(b.key(name))
+	if err == nil {
+		info.name = path.Base(name)
+		return info, nil
+	}
+	if !errors.As(err, &storeErr) || storeErr.statusCode != http.StatusNotFound {
+		return objectInfo{}, toPathError(op, name, err)
+	}
+	info, err = b.head(b.directoryKey(name))
+	if err == nil {
+		return objectInfo{name: path.Base(name), modTime: info.modTime, isDir: true}, nil
+	}
+	if !errors.As(err, &storeErr) || storeErr.statusCode != http.StatusNotFound {
+		return objectInfo{}, toPathError(op, name, err)
+	}
+	found, err := b.hasChildren(b.directoryKey(name))
+	if err != nil {
+		return objectInfo{}, toPathError(op, name, err)
+	}
+	if !found {
+		return objectInfo{}, &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	return objectInfo{name: path.Base(name), isDir: true}, nil
+}
+
+// checkParent checks if the parent of the path is an existing directory.
+func (b *ObjectStoreBackend) checkParent(op string, name string) error {
+	parent := path.Dir(CleanPath(name))
+	info, err := b.stat(op, parent)
+	if err != nil {
+		return &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOENT}
+	}
+	if !info.isDir {
+		return &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// keepParent creates the object of the parent directory of the path, if it
+// does not have one, so that the directory is kept after its last file is
+// removed.
+func (b *ObjectStoreBackend) keepParent(name string) error {
+	parent := path.Dir(CleanPath(name))
+	if parent == "/" {
+		return nil
+	}
+	if _, err := b.head(b.directoryKey(parent)); err == nil {
+		return nil
+	}
+	return b.put(b.directoryKey(parent), nil, 0)
+}
+
+func (b *ObjectStoreBackend) put(key string, content io.Reader, size int64) error {
+	if content == nil {
+		content = strings.NewReader("")
+	}
+	resp, err := b.do(http.MethodPut, key, nil, nil, content, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *ObjectStoreBackend) delete(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		var storeErr *objectStoreError
+		if errors.As(err, &storeErr) && storeErr.statusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get returns the content of an object from the specified offset.
+func (b *ObjectStoreBackend) get(key string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := b.do(http.MethodGet, key, nil, header, nil, 0)
+	if err != nil {
+		var storeErr *objectStoreError
+		if errors.As(err, &storeErr) && storeErr.statusCode == http.StatusRequestedRangeNotSatisfiable {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// copy copies an object by downloading and uploading it again as not every
+// object store supports copying in place.
+func (b *ObjectStoreBackend) copy(oldKey string, newKey string) error {
+	resp, err := b.do(http.MethodGet, oldKey, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return b.put(newKey, resp.Body, resp.ContentLength)
+}
+
+func (b *ObjectStoreBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = CleanPath(name)
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	info, err := b.stat("open", name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	switch {
+	case exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	case exists && info.isDir && writable:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	file := &objectFile{
+		backend:  b,
+		name:     name,
+		info:     info,
+		readable: flag&os.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&os.O_APPEND != 0,
+	}
+	if !writable {
+		return file, nil
+	}
+
+	if !exists {
+		if err := b.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		// the file is visible as soon as it is created
+		if err := b.put(b.key(name), nil, 0); err != nil {
+			return nil, toPathError("open", name, err)
+		}
+	}
+	temp, err := os.CreateTemp("", "file-server-object-*")
+	if err != nil {
+		return nil, err
+	}
+	file.temp = temp
+	if exists && flag&os.O_TRUNC == 0 && info.size > 0 {
+		if err := file.download(); err != nil {
+			file.removeTemp()
+			return nil, toPathError("open", name, err)
+		}
+	}
+	file.dirty = exists && flag&os.O_TRUNC != 0
+	return file, nil
+}
+
+func (b *ObjectStoreBackend) Stat(name string) (os.FileInfo, error) {
+	return b.stat("stat", name)
+}
+
+func (b *ObjectStoreBackend) Lstat(name string) (os.FileInfo, error) {
+	return b.stat("lstat", name)
+}
+
+func (b *ObjectStoreBackend) ReadDir(name string) ([]os.FileInfo, error) {
+	info, err := b.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.isDir {
+		return nil, &os.PathError{Op: "readdirent", Path: CleanPath(name), Err: syscall.ENOTDIR}
+	}
+
+	prefix := b.directoryKey(name)
+	var list []os.FileInfo
+	err = b.list(prefix, "/", objectStoreListPageSize, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			entryName := strings.TrimPrefix(object.Key, prefix)
+			if entryName == "" || strings.Contains(entryName, "/") {
+				continue
+			}
+			modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+			list = append(list, objectInfo{name: entryName, size: object.Size, modTime: modTime})
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			entryName := strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/")
+			if entryName == "" {
+				continue
+			}
+			list = append(list, objectInfo{name: entryName, isDir: true})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, toPathError("readdirent", name, err)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list, nil
+}
+
+func (b *ObjectStoreBackend) Mkdir(name string, perm os.FileMode) error {
+	if _, err := b.stat("mkdir", name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: CleanPath(name), Err: syscall.EEXIST}
+	}
+	if err := b.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if err := b.put(b.directoryKey(name), nil, 0); err != nil {
+		return toPathError("mkdir", name, err)
+	}
+	return nil
+}
+
+func (b *ObjectStoreBackend) MkdirAll(name string, perm os.FileMode) error {
+	current := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(CleanPath(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+		info, err := b.stat("mkdir", current)
+		if err == nil {
+			if !info.isDir {
+				return &os.PathError{Op: "mkdir", Path: current, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := b.put(b.directoryKey(current), nil, 0); err != nil {
+			return toPathError("mkdir", current, err)
+		}
+	}
+	return nil
+}
+
+func (b *ObjectStoreBackend) Remove(name string) error {
+	if CleanPath(name) == "/" {
+		return &os.PathError{Op: "remove", Path: "/", Err: syscall.EBUSY}
+	}
+	info, err := b.stat("remove", name)
+	if err != nil {
+		return err
+	}
+	key := b.key(name)
+	if info.isDir {
+		key = b.directoryKey(name)
+		found, err := b.hasChildren(key)
+		if err != nil {
+			return toPathError("remove", name, err)
+		}
+		if found {
+			return &os.PathError{Op: "remove", Path: CleanPath(name), Err: syscall.ENOTEMPTY}
+		}
+	}
+	if err := b.keepParent(name); err != nil {
+		return toPathError("remove", name, err)
+	}
+	if err := b.delete(key); err != nil {
+		return toPathError("remove", name, err)
+	}
+	return nil
+}
+
+func (b *ObjectStoreBackend) RemoveAll(name string) error {
+	name = CleanPath(name)
+	keys, err := b.keys(b.directoryKey(name))
+	if err != nil {
+		return toPathError("unlinkat", name, err)
+	}
+	if name != "/" {
+		keys = append(keys, b.key(name))
+		if err := b.keepParent(name); err != nil {
+			return toPathError("unlinkat", name, err)
+		}
+	}
+	for _, key := range keys {
+		if err := b.delete(key); err != nil {
+			return toPathError("unlinkat", name, err)
+		}
+	}
+	return nil
+}
+
+func (b *ObjectStoreBackend) Rename(oldName string, newName string) error {
+	oldName = CleanPath(oldName)
+	newName = CleanPath(newName)
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	if oldName == "/" || newName == "/" {
+		return linkError(syscall.EBUSY)
+	}
+	info, err := b.stat("rename", oldName)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+	if info.isDir && strings.HasPrefix(newName, oldName+"/") {
+		return linkError(syscall.EINVAL)
+	}
+	if err := b.checkParent("rename", newName); err != nil {
+		return err
+	}
+	existing, err := b.stat("rename", newName)
+	if err == nil {
+		switch {
+		case info.isDir && !existing.isDir:
+			return linkError(syscall.ENOTDIR)
+		case !info.isDir && existing.isDir:
+			return linkError(syscall.EISDIR)
+		case existing.isDir:
+			found, err := b.hasChildren(b.directoryKey(newName))
+			if err != nil {
+				return linkError(err)
+			}
+			if found {
+				return linkError(syscall.ENOTEMPTY)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := b.keepParent(oldName); err != nil {
+		return linkError(err)
+	}
+	if !info.isDir {
+		if err := b.copy(b.key(oldName), b.key(newName)); err != nil {
+			return linkError(err)
+		}
+		if err := b.delete(b.key(oldName)); err != nil {
+			return linkError(err)
+		}
+		return nil
+	}
+
+	oldPrefix := b.directoryKey(oldName)
+	newPrefix := b.directoryKey(newName)
+	keys, err := b.keys(oldPrefix)
+	if err != nil {
+		return linkError(err)
+	}
+	if len(keys) == 0 || keys[0] != oldPrefix {
+		// a directory without its own object is given one
+		if err := b.put(newPrefix, nil, 0); err != nil {
+			return linkError(err)
+		}
+	}
+	for _, key := range keys {
+		if err := b.copy(key, newPrefix+strings.TrimPrefix(key, oldPrefix)); err != nil {
+			return linkError(err)
+		}
+	}
+	for _, key := range keys {
+		if err := b.delete(key); err != nil {
+			return linkError(err)
+		}
+	}
+	return nil
+}
+
+func (b *ObjectStoreBackend) Link(oldName string, newName string) error {
+	return &os.LinkError{Op: "link", Old: CleanPath(oldName), New: CleanPath(newName), Err: syscall.ENOTSUP}
+}
+
+func (b *ObjectStoreBackend) Symlink(target string, name string) error {
+	return &os.LinkError{Op: "symlink", Old: target, New: CleanPath(name), Err: syscall.ENOTSUP}
+}
+
+func (b *ObjectStoreBackend) Readlink(name string) (string, error) {
+	if _, err := b.stat("readlink", name); err != nil {
+		return "", err
+	}
+	return "", &os.PathError{Op: "readlink", Path: CleanPath(name), Err: syscall.EINVAL}
+}
+
+// Chmod only checks if the file exists as permissions are not stored.
+func (b *ObjectStoreBackend) Chmod(name string, mode os.FileMode) error {
+	_, err := b.stat("chmod", name)
+	return err
+}
+
+// Chtimes only checks if the file exists as the modification time of an
+// object is the time it is uploaded.
+func (b *ObjectStoreBackend) Chtimes(name string, accessTime time.Time, modificationTime time.Time) error {
+	_, err := b.stat("chtimes", name)
+	return err
+}
+
+func (b *ObjectStoreBackend) Truncate(name string, size int64) error {
+	file, err := b.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// DiskSpace is not supported as the capacity of an object store is unknown.
+func (b *ObjectStoreBackend) DiskSpace() (DiskSpace, error) {
+	return DiskSpace{}, errors.ErrUnsupported
+}
+
+// objectFile is a file or a directory of an ObjectStoreBackend opened. A
+// file opened for reading is read with ranged requests so that it can be
+// read from any offset. A file opened for writing is staged in a temporary
+// file.
+type objectFile struct {
+	backend  *ObjectStoreBackend
+	name     string
+	info     objectInfo
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+
+	// offset is the offset of the next read of a file opened for reading or
+	// the index of the next entry of a directory
+	offset int64
+
+	// body is the content being read from bodyOffset
+	body       io.ReadCloser
+	bodyOffset int64
+
+	// temp is the staged content of a file opened for writing and it is
+	// uploaded on close if it is dirty
+	temp  *os.File
+	dirty bool
+}
+
+// check returns the error of an operation which is not allowed on the file.
+func (f *objectFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	case f.info.isDir:
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	case write && !f.writable, !write && !f.readable:
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *objectFile) download() error {
+	body, err := f.backend.get(f.backend.key(f.name), 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if _, err := io.Copy(f.temp, body); err != nil {
+		return err
+	}
+	_, err = f.temp.Seek(0, io.SeekStart)
+	return err
+}
+
+func (f *objectFile) removeTemp() {
+	f.temp.Close()
+	os.Remove(f.temp.Name())
+}
+
+func (f *objectFile) closeBody() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+func (f *objectFile) Read(p []byte) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.temp != nil {
+		return f.temp.Read(p)
+	}
+	if f.body == nil || f.bodyOffset != f.offset {
+		f.closeBody()
+		body, err := f.backend.get(f.backend.key(f.name), f.offset)
+		if err != nil {
+			return 0, toPathError("read", f.name, err)
+		}
+		f.body = body
+		f.bodyOffset = f.offset
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+	return n, err
+}
+
+func (f *objectFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.temp != nil {
+		return f.temp.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)}}
+	resp, err := f.backend.do(http.MethodGet, f.backend.key(f.name), nil, header, nil, 0)
+	if err != nil {
+		var storeErr *objectStoreError
+		if errors.As(err, &storeErr) && storeErr.statusCode == http.StatusRequestedRangeNotSatisfiable {
+			return 0, io.EOF
+		}
+		return 0, toPathError("read", f.name, err)
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *objectFile) Write(p []byte) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.append {
+		if _, err := f.temp.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	f.dirty = true
+	return f.temp.Write(p)
+}
+
+func (f *objectFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.append {
+		return 0, errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	f.dirty = true
+	return f.temp.WriteAt(p, off)
+}
+
+func (f *objectFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.temp != nil {
+		return f.temp.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close uploads the content written to the file.
+func (f *objectFile) Close() error {
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	f.closeBody()
+	if f.temp == nil {
+		return nil
+	}
+	defer f.removeTemp()
+	if !f.dirty {
+		return nil
+	}
+	return f.upload()
+}
+
+func (f *objectFile) upload() error {
+	info, err := f.temp.Stat()
+	if err != nil {
+		return err
+	}
+	content := io.NewSectionReader(f.temp, 0, info.Size())
+	if err := f.backend.put(f.backend.key(f.name), content, info.Size()); err != nil {
+		return toPathError("close", f.name, err)
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *objectFile) Name() string {
+	return f.name
+}
+
+func (f *objectFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.temp == nil {
+		return f.info, nil
+	}
+	info, err := f.temp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return objectInfo{name: baseName(f.name), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (f *objectFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.info.isDir {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	entries, err := f.backend.ReadDir(f.name)
+	if err != nil {
+		return nil, err
+	}
+	offset := int(min(f.offset, int64(len(entries))))
+	entries = entries[offset:]
+	if count <= 0 {
+		f.offset += int64(len(entries))
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	entries = entries[:min(count, len(entries))]
+	f.offset += int64(len(entries))
+	return entries, nil
+}
+
+// Sync uploads the content written to the file so far.
+func (f *objectFile) Sync() error {
+	if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.temp == nil || !f.dirty {
+		return nil
+	}
+	return f.upload()
+}
+
+func (f *objectFile) Truncate(size int64) error {
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	f.dirty = true
+	return f.temp.Truncate(size)
+}
@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	testObjectStoreBucket      = "files"
+	testObjectStoreAccessKeyID = "test-key"
+)
+
+// objectStoreStub is an S3-compatible object store keeping objects in
+// memory which serves the requests made by ObjectStoreBackend.
+type objectStoreStub struct {
+	mu      sync.Mutex
+	objects map[string]stubObject
+
+	// maxKeys limits the size of a page of a listing, as object stores do,
+	// so that listings span pages with few objects
+	maxKeys int
+
+	// denied is whether every request is refused
+	denied bool
+}
+
+type stubObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newObjectStoreStub(t *testing.T) (*objectStoreStub, ObjectStoreConfig) {
+	t.Helper()
+	stub := &objectStoreStub{objects: map[string]stubObject{}, maxKeys: 1000}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	config := ObjectStoreConfig{
+		Endpoint:        server.URL,
+		Bucket:          testObjectStoreBucket,
+		AccessKeyID:     testObjectStoreAccessKeyID,
+		SecretAccessKey: "test-secret",
+	}
+	return stub, config
+}
+
+func (s *objectStoreStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authorization := r.Header.Get("Authorization")
+	if s.denied || !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential="+testObjectStoreAccessKeyID+"/") ||
+		r.Header.Get("x-amz-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testObjectStoreBucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if key == "" && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, r)
+			return
+		}
+		s.get(w, r, key)
+	case http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.Format(http.TimeFormat))
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = stubObject{data: data, modTime: time.Now().UTC()}
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *objectStoreStub) get(w http.ResponseWriter, r *http.Request, key string) {
+	object, ok := s.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rangeValue, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		w.Write(object.data)
+		return
+	}
+	first, last, _ := strings.Cut(rangeValue, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start >= len(object.data) {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	end := len(object.data) - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		end = min(end, len(object.data)-1)
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object.data)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(object.data[start : end+1])
+}
+
+type stubListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	Contents              []stubListObject
+	CommonPrefixes        []stubListPrefix
+}
+
+type stubListObject struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+type stubListPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// list serves ListObjectsV2 where the continuation token is the last key,
+// or common prefix, of the previous page.
+func (s *objectStoreStub) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	token := query.Get("continuation-token")
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	if err != nil || maxKeys <= 0 {
+		maxKeys = s.maxKeys
+	}
+	maxKeys = min(maxKeys, s.maxKeys)
+
+	// entries are the keys and the common prefixes in order
+	var entries []string
+	prefixes := map[string]bool{}
+	for key := range s.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				commonPrefix := prefix + rest[:i+len(delimiter)]
+				if !prefixes[commonPrefix] {
+					prefixes[commonPrefix] = true
+					entries = append(entries, commonPrefix)
+				}
+				continue
+			}
+		}
+		entries = append(entries, key)
+	}
+	sort.Strings(entries)
+
+	var result stubListResult
+	for _, entry := range entries {
+		if token != "" && entry <= token {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.NextContinuationToken = entry
+		if prefixes[entry] {
+			result.CommonPrefixes = append(result.CommonPrefixes, stubListPrefix{Prefix: entry})
+			continue
+		}
+		object := s.objects[entry]
+		result.Contents = append(result.Contents, stubListObject{
+			Key:          entry,
+			Size:         len(object.data),
+			LastModified: object.modTime.Format(time.RFC3339),
+		})
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// keys returns the keys of the objects stored.
+func (s *objectStoreStub) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestObjectStoreBackendFiles(t *testing.T) {
+	stub, config := newObjectStoreStub(t)
+	backend := NewObjectStoreBackend(config, "alice")
+
+	file, err := backend.OpenFile("/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stub.keys(), []string{"alice/a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	info, err := backend.Stat("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 11 || info.IsDir() || info.Name() != "a.txt" {
+		t.Errorf("stat = %s of size %d, directory %v, want a.txt of size 11", info.Name(), info.Size(), info.IsDir())
+	}
+
+	file, err = backend.OpenFile("/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := file.ReadAt(buf, 6); err != nil || string(buf[:n]) != "world" {
+		t.Errorf("read at 6 = %q, %v, want %q", buf[:n], err, "world")
+	}
+	if n, err := file.ReadAt(buf, 9); err != io.EOF || string(buf[:n]) != "ld" {
+		t.Errorf("read at 9 = %q, %v, want %q, %v", buf[:n], err, "ld", io.EOF)
+	}
+	if _, err := file.ReadAt(buf, 20); err != io.EOF {
+		t.Errorf("read beyond the end: got %v, want %v", err, io.EOF)
+	}
+	if _, err := file.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(file)
+	if err != nil || string(content) != "world" {
+		t.Errorf("read from 6 = %q, %v, want %q", content, err, "world")
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = backend.OpenFile("/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Truncate("/a.txt", 5); err != nil {
+		t.Fatal(err)
+	}
+	file, err = backend.OpenFile("/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = io.ReadAll(file)
+	file.Close()
+	if err != nil || string(content) != "hello" {
+		t.Errorf("content after append and truncate = %q, %v, want %q", content, err, "hello")
+	}
+
+	if _, err := backend.OpenFile("/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644); !errors.Is(err, os.ErrExist) {
+		t.Errorf("create exclusive of an existing file: got %v, want %v", err, os.ErrExist)
+	}
+	if _, err := backend.OpenFile("/missing/b.txt", os.O_WRONLY|os.O_CREATE, 0o644); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("create in a missing directory: got %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := backend.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of a missing file: got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestObjectStoreBackendDirectories(t *testing.T) {
+	stub, config := newObjectStoreStub(t)
+	backend := NewObjectStoreBackend(config, "alice")
+
+	if err := backend.MkdirAll("/a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Mkdir("/a", 0o755); !errors.Is(err, os.ErrExist) {
+		t.Errorf("mkdir of an existing directory: got %v, want %v", err, os.ErrExist)
+	}
+	for _, name := range []string{"/a/z.txt", "/a/b/y.txt"} {
+		file, err := backend.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := backend.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryNames(entries), []string{"b", "z.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	if !entries[0].IsDir() || entries[1].IsDir() {
+		t.Errorf("entries = %v, want a directory and a file", entries)
+	}
+
+	if err := backend.Remove("/a/b"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("remove of a directory which is not empty: got %v, want %v", err, syscall.ENOTEMPTY)
+	}
+	// a directory is kept after its last file is removed
+	if err := backend.Remove("/a/b/y.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := backend.Stat("/a/b"); err != nil || !info.IsDir() {
+		t.Errorf("stat of an empty directory = %v, %v, want a directory", info, err)
+	}
+	if err := backend.Remove("/a/b"); err != nil {
+		t.Errorf("remove of an empty directory: %v", err)
+	}
+
+	if err := backend.Rename("/a", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stub.keys(), []string{"alice/c/", "alice/c/z.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after rename = %v, want %v", got, want)
+	}
+	if err := backend.RemoveAll("/c"); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.keys(); len(got) != 0 {
+		t.Errorf("keys after remove all = %v, want none", got)
+	}
+}
+
+func TestObjectStoreBackendListsPages(t *testing.T) {
+	stub, config := newObjectStoreStub(t)
+	stub.maxKeys = 2
+	backend := NewObjectStoreBackend(config, "alice")
+
+	var want []string
+	for i := range 5 {
+		name := fmt.Sprintf("f%d", i)
+		want = append(want, name)
+		file, err := backend.OpenFile("/"+name, os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.MkdirAll("/g/h", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "g")
+
+	entries, err := backend.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryNames(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	if err := backend.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.keys(); len(got) != 0 {
+		t.Errorf("keys after remove all = %v, want none", got)
+	}
+}
+
+func TestObjectStoreBackendUsersArePrefixed(t *testing.T) {
+	_, config := newObjectStoreStub(t)
+	config.Prefix = "/homes/"
+	alice := NewObjectStoreBackend(config, "alice")
+	bob := NewObjectStoreBackend(config, "bob")
+
+	file, err := alice.OpenFile("/a.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if alice.key("/a.txt") != "homes/alice/a.txt" {
+		t.Errorf("key = %s, want %s", alice.key("/a.txt"), "homes/alice/a.txt")
+	}
+	if _, err := bob.Stat("/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of a file of another user: got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestObjectStoreBackendDenied(t *testing.T) {
+	stub, config := newObjectStoreStub(t)
+	stub.denied = true
+	backend := NewObjectStoreBackend(config, "alice")
+
+	if _, err := backend.Stat("/a.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat refused by the object store: got %v, want %v", err, os.ErrPermission)
+	}
+}
+
+func TestObjectStoreBackendFileSystem(t *testing.T) {
+	_, config := newObjectStoreStub(t)
+	fs := NewBackendFileSystem(NewObjectStoreBackend(config, "alice"))
+	fs.quota = Quota{Bytes: 10}
+
+	if err := fs.MkdirAll("/a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fs, "/a/b/c.txt", "12345678")
+	if got := readTestFile(t, fs, "/a/b/c.txt"); got != "12345678" {
+		t.Errorf("content = %q, want %q", got, "12345678")
+	}
+	file, err := fs.OpenFile("/a/d.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(file, "12345"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("write over the quota of bytes: got %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Rename("/a/b/c.txt", "/a/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := fs.QuotaUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RefreshUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed, _ := fs.QuotaUsage(); refreshed != usage || usage.Bytes != 8 {
+		t.Errorf("usage = %+v, refreshed %+v, want 8 bytes", usage, refreshed)
+	}
+	if err := fs.Symlink("/a/c.txt", "/e"); err == nil {
+		t.Error("symbolic link created in an object store")
+	}
+}
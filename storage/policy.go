@@ -0,0 +1,467 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// ErrPolicyViolation is returned when a file uploaded breaks an upload
+// policy applied to the user.
+var ErrPolicyViolation = errors.New("upload policy violation")
+
+// PolicyViolationError describes the rule of an upload policy a file breaks.
+type PolicyViolationError struct {
+	Reason string
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPolicyViolation, e.Reason)
+}
+
+func (e *PolicyViolationError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// sniffLength is the number of bytes the MIME type of a file is detected
+// from, which is all http.DetectContentType considers.
+const sniffLength = 512
+
+// UploadPolicy restricts the files users can upload where empty lists and a
+// zero size do not restrict anything. Extensions are matched at the end of
+// file names regardless of case and without the leading dot, such as "exe"
+// or "tar.gz". MIME types are detected from the first bytes of files and a
+// type can end with a wildcard, such as "image/*".
+type UploadPolicy struct {
+	AllowedExtensions []string
+	DeniedExtensions  []string
+	AllowedMimeTypes  []string
+	DeniedMimeTypes   []string
+
+	// MaxFileSize is the maximum size of a file in bytes
+	MaxFileSize int64
+}
+
+// defaultUploadPolicy applies to all the users on top of the policies of
+// their groups and their own.
+var defaultUploadPolicy UploadPolicy
+
+// SetDefaultUploadPolicy sets the upload policy applied to all the users. It
+// is expected to be called once before the servers are started.
+func SetDefaultUploadPolicy(policy UploadPolicy) {
+	defaultUploadPolicy = policy
+}
+
+// UploadPolicyFromRules returns the upload policy of the rules stored in the
+// database.
+func UploadPolicyFromRules(rules db.UploadRules) UploadPolicy {
+	return UploadPolicy{
+		AllowedExtensions: splitRuleList(rules.AllowedExtensions),
+		DeniedExtensions:  splitRuleList(rules.DeniedExtensions),
+		AllowedMimeTypes:  splitRuleList(rules.AllowedMimeTypes),
+		DeniedMimeTypes:   splitRuleList(rules.DeniedMimeTypes),
+		MaxFileSize:       rules.MaxFileSize,
+	}
+}
+
+// Rules returns the rules of the policy to be stored in the database.
+func (p UploadPolicy) Rules() db.UploadRules {
+	return db.UploadRules{
+		AllowedExtensions: strings.Join(p.AllowedExtensions, ","),
+		DeniedExtensions:  strings.Join(p.DeniedExtensions, ","),
+		AllowedMimeTypes:  strings.Join(p.AllowedMimeTypes, ","),
+		DeniedMimeTypes:   strings.Join(p.DeniedMimeTypes, ","),
+		MaxFileSize:       p.MaxFileSize,
+	}
+}
+
+func splitRuleList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// Validate checks if the extensions and MIME types of the policy are well
+// formed and the size is not negative.
+func (p UploadPolicy) Validate() error {
+	for _, extension := range slices.Concat(p.AllowedExtensions, p.DeniedExtensions) {
+		if normalizeExtension(extension) == "" || strings.ContainsAny(extension, `,/\`) {
+			return fmt.Errorf("invalid extension: %q", extension)
+		}
+	}
+	for _, mimeType := range slices.Concat(p.AllowedMimeTypes, p.DeniedMimeTypes) {
+		major, minor, ok := strings.Cut(mimeType, "/")
+		if !ok || major == "" || minor == "" || strings.ContainsAny(mimeType, ",; ") {
+			return fmt.Errorf("invalid MIME type: %q", mimeType)
+		}
+	}
+	if p.MaxFileSize < 0 {
+		return fmt.Errorf("invalid maximum file size: %d", p.MaxFileSize)
+	}
+	return nil
+}
+
+// IsEmpty returns whether the policy does not restrict anything.
+func (p UploadPolicy) IsEmpty() bool {
+	return len(p.AllowedExtensions) == 0 && len(p.DeniedExtensions) == 0 &&
+		len(p.AllowedMimeTypes) == 0 && len(p.DeniedMimeTypes) == 0 &&
+		p.MaxFileSize == 0
+}
+
+// checkName returns the reason the name of a file is not allowed or an empty
+// string if it is allowed.
+func (p UploadPolicy) checkName(name string) string {
+	base := strings.ToLower(path.Base(CleanPath(name)))
+	for _, extension := range p.DeniedExtensions {
+		if hasExtension(base, extension) {
+			return fmt.Sprintf("extension .%s is not allowed", normalizeExtension(extension))
+		}
+	}
+	if len(p.AllowedExtensions) == 0 {
+		return ""
+	}
+	for _, extension := range p.AllowedExtensions {
+		if hasExtension(base, extension) {
+			return ""
+		}
+	}
+	allowed := make([]string, len(p.AllowedExtensions))
+	for i, extension := range p.AllowedExtensions {
+		allowed[i] = "." + normalizeExtension(extension)
+	}
+	return fmt.Sprintf("only files with extensions %s are allowed", strings.Join(allowed, ", "))
+}
+
+// checkSize returns the reason a file of the specified size is not allowed
+// or an empty string if it is allowed.
+func (p UploadPolicy) checkSize(size int64) string {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return fmt.Sprintf("file size exceeds the limit of %d bytes", p.MaxFileSize)
+	}
+	return ""
+}
+
+// checkContent returns the reason a file starting with the specified bytes
+// is not allowed or an empty string if it is allowed.
+func (p UploadPolicy) checkContent(head []byte) string {
+	if len(p.AllowedMimeTypes) == 0 && len(p.DeniedMimeTypes) == 0 {
+		return ""
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	for _, pattern := range p.DeniedMimeTypes {
+		if matchMimeType(mimeType, pattern) {
+			return fmt.Sprintf("content type %s is not allowed", mimeType)
+		}
+	}
+	if len(p.AllowedMimeTypes) == 0 {
+		return ""
+	}
+	for _, pattern := range p.AllowedMimeTypes {
+		if matchMimeType(mimeType, pattern) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("content type %s is not allowed", mimeType)
+}
+
+func normalizeExtension(extension string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+}
+
+// hasExtension returns whether the lower-case file name ends with the
+// extension.
+func hasExtension(name string, extension string) bool {
+	return strings.HasSuffix(name, "."+normalizeExtension(extension))
+}
+
+func matchMimeType(mimeType string, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return mimeType == pattern
+}
+
+// loadUploadPolicies returns the upload policies applied to the specified
+// user, which are the default policy along with the policies of the groups
+// of the user and of the user.
+func loadUploadPolicies(dbConn *gorm.DB, username string) ([]UploadPolicy, error) {
+	var policies []UploadPolicy
+	if !defaultUploadPolicy.IsEmpty() {
+		policies = append(policies, defaultUploadPolicy)
+	}
+
+	var groupPolicies []db.GroupUploadPolicy
+	err := dbConn.
+		Select("group_upload_policies.*").
+		Joins("JOIN group_members ON group_members.group_name = group_upload_policies.group_name").
+		Where("group_members.username = ?", username).
+		Order("group_upload_policies.group_name ASC").
+		Find(&groupPolicies).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range groupPolicies {
+		policies = append(policies, UploadPolicyFromRules(policy.UploadRules))
+	}
+
+	var userPolicies []db.UserUploadPolicy
+	if err := dbConn.Where("username = ?", username).Find(&userPolicies).Error; err != nil {
+		return nil, err
+	}
+	for _, policy := range userPolicies {
+		policies = append(policies, UploadPolicyFromRules(policy.UploadRules))
+	}
+	return policies, nil
+}
+
+// HasUploadPolicy returns whether files uploaded are checked against upload
+// policies.
+func (fs *FileSystem) HasUploadPolicy() bool {
+	return len(fs.uploadPolicies) > 0
+}
+
+// sniffsUploads returns whether the MIME types of files uploaded are
+// checked.
+func (fs *FileSystem) sniffsUploads() bool {
+	for _, policy := range fs.uploadPolicies {
+		if len(policy.AllowedMimeTypes) > 0 || len(policy.DeniedMimeTypes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUploadName fails with ErrPolicyViolation if a file of the specified
+// name cannot be uploaded.
+func (fs *FileSystem) checkUploadName(op string, name string) error {
+	for _, policy := range fs.uploadPolicies {
+		if reason := policy.checkName(name); reason != "" {
+			return fs.policyError(op, name, reason)
+		}
+	}
+	return nil
+}
+
+// checkUploadSize fails with ErrPolicyViolation if the specified file cannot
+// grow to the size.
+func (fs *FileSystem) checkUploadSize(op string, name string, size int64) error {
+	for _, policy := range fs.uploadPolicies {
+		if reason := policy.checkSize(size); reason != "" {
+			return fs.policyError(op, name, reason)
+		}
+	}
+	return nil
+}
+
+// checkUploadContent fails with ErrPolicyViolation if the specified file
+// cannot start with the bytes. An empty file has no type to check.
+func (fs *FileSystem) checkUploadContent(op string, name string, head []byte) error {
+	if len(head) == 0 {
+		return nil
+	}
+	for _, policy := range fs.uploadPolicies {
+		if reason := policy.checkContent(head); reason != "" {
+			return fs.policyError(op, name, reason)
+		}
+	}
+	return nil
+}
+
+// checkStoredUpload fails with ErrPolicyViolation if the stored file of the
+// source path cannot be uploaded as the target path, which prevents renames
+// and links from bypassing the policies. Paths other than regular files are
+// not checked.
+func (fs *FileSystem) checkStoredUpload(op string, source string, target string) error {
+	if !fs.HasUploadPolicy() {
+		return nil
+	}
+	info, err := fs.backend.Lstat(CleanPath(source))
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if err := fs.checkUploadName(op, target); err != nil {
+		return err
+	}
+	if err := fs.checkUploadSize(op, target, info.Size()); err != nil {
+		return err
+	}
+	if !fs.sniffsUploads() {
+		return nil
+	}
+	head, err := fs.storedHead(source)
+	if err != nil {
+		return err
+	}
+	return fs.checkUploadContent(op, target, head)
+}
+
+// storedHead returns the first bytes of the stored file the MIME type is
+// detected from.
+func (fs *FileSystem) storedHead(name string) ([]byte, error) {
+	file, err := fs.backend.OpenFile(CleanPath(name), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// removeRejected removes a file breaking the upload policies.
+func (fs *FileSystem) removeRejected(name string) error {
+	info, err := fs.backend.Lstat(CleanPath(name))
+	if err != nil {
+		return err
+	}
+	if err := fs.backend.Remove(CleanPath(name)); err != nil {
+		return err
+	}
+	fs.release(info.Size(), 1)
+	return nil
+}
+
+func (fs *FileSystem) policyError(op string, name string, reason string) error {
+	return &os.PathError{Op: op, Path: fs.virtualPath(name), Err: &PolicyViolationError{Reason: reason}}
+}
+
+// policyFile is a file opened for writing which is checked against the
+// upload policies of the file system as it is written. The MIME type is
+// detected as soon as the first bytes are written and, if the beginning of
+// the file is not written in order, from the stored file when it is closed.
+// Writes breaking a policy are refused and the file is removed when it is
+// closed.
+type policyFile struct {
+	File
+	fileSystem *FileSystem
+	name       string
+	append     bool
+
+	// target is the path the file is uploaded as which is different from
+	// the name if the file is a temporary file of an atomic upload
+	target string
+
+	mutex sync.Mutex
+
+	// head is the beginning of the file written so far
+	head []byte
+
+	// sniffed is whether the MIME type has been checked with a complete
+	// head
+	sniffed bool
+
+	// written is whether the file has been written or truncated
+	written bool
+
+	// violation is the first policy broken by the writes
+	violation error
+}
+
+func (f *policyFile) Write(p []byte) (int, error) {
+	offset, err := f.offset()
+	if err != nil {
+		return 0, err
+	}
+	if err := f.check(offset, p); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *policyFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.check(off, p); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *policyFile) Truncate(size int64) error {
+	f.mutex.Lock()
+	if f.violation == nil {
+		f.violation = f.fileSystem.checkUploadSize("truncate", f.target, size)
+	}
+	if f.violation != nil {
+		f.mutex.Unlock()
+		return f.violation
+	}
+	f.written = true
+	if size < int64(len(f.head)) {
+		f.head = f.head[:size]
+		f.sniffed = false
+	}
+	f.mutex.Unlock()
+	return f.File.Truncate(size)
+}
+
+// Close closes the file and removes it if it breaks a policy.
+func (f *policyFile) Close() error {
+	err := f.File.Close()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.violation == nil && err == nil && f.written && !f.sniffed && f.fileSystem.sniffsUploads() {
+		head, headErr := f.fileSystem.storedHead(f.name)
+		if headErr != nil {
+			return headErr
+		}
+		f.violation = f.fileSystem.checkUploadContent("close", f.target, head)
+	}
+	if f.violation == nil {
+		return err
+	}
+	return errors.Join(f.violation, err, f.fileSystem.removeRejected(f.name))
+}
+
+// offset returns the offset of the next write of the file where writes of
+// an appending file go to the end.
+func (f *policyFile) offset() (int64, error) {
+	if f.append {
+		info, err := f.File.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	return f.File.Seek(0, io.SeekCurrent)
+}
+
+// check fails with ErrPolicyViolation if writing the bytes at the offset
+// breaks a policy. The MIME type is checked once the head is complete.
+func (f *policyFile) check(offset int64, p []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.violation != nil {
+		return f.violation
+	}
+	if f.violation = f.fileSystem.checkUploadSize("write", f.target, offset+int64(len(p))); f.violation != nil {
+		return f.violation
+	}
+	f.written = true
+	if !f.fileSystem.sniffsUploads() || offset >= sniffLength || offset > int64(len(f.head)) {
+		return nil
+	}
+	end := int(min(offset+int64(len(p)), sniffLength))
+	if end > len(f.head) {
+		f.head = append(f.head, make([]byte, end-len(f.head))...)
+	}
+	copy(f.head[offset:end], p)
+	if len(f.head) < sniffLength {
+		return nil
+	}
+	f.sniffed = true
+	f.violation = f.fileSystem.checkUploadContent("write", f.target, f.head)
+	return f.violation
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alexhokl/file-server/db"
+)
+
+// ErrQuotaExceeded is returned when an operation would make a user use more
+// storage than the quota of the user allows.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota is the limit of storage of a user where zero means unlimited.
+type Quota struct {
+	// Bytes is the maximum total size of files
+	Bytes int64
+
+	// Files is the maximum number of files and directories
+	Files int64
+}
+
+// defaultQuota applies to users without a quota of their own.
+var defaultQuota Quota
+
+// SetDefaultQuota sets the quota of users without a quota of their own. It
+// is expected to be called once before the servers are started.
+func SetDefaultQuota(quota Quota) {
+	defaultQuota = quota
+}
+
+// UserQuota returns the quota applied to the specified user where the limits
+// not set for the user are taken from the default quota.
+func UserQuota(user db.User) Quota {
+	quota := defaultQuota
+	if user.QuotaBytes != nil {
+		quota.Bytes = *user.QuotaBytes
+	}
+	if user.QuotaFiles != nil {
+		quota.Files = *user.QuotaFiles
+	}
+	return quota
+}
+
+// usageCounter keeps the usage of a home directory up to date as files are
+// changed so that the home directory is not walked for every write. It is
+// shared by all the file systems opened for the same home directory. The
+// usage is calculated the first time it is needed.
+type usageCounter struct {
+	mu     sync.Mutex
+	loaded bool
+	usage  Usage
+}
+
+var (
+	usageCountersMu sync.Mutex
+	usageCounters   = map[string]*usageCounter{}
+)
+
+func getUsageCounter(root string) *usageCounter {
+	usageCountersMu.Lock()
+	defer usageCountersMu.Unlock()
+	counter, ok := usageCounters[root]
+	if !ok {
+		counter = &usageCounter{}
+		usageCounters[root] = counter
+	}
+	return counter
+}
+
+// Capacity is the storage of a user which is limited by both the quota of
+// the user and the disk.
+type Capacity struct {
+	// Usage is the storage used by the user
+	Usage
+
+	// TotalBytes is the quota of the user or, without a quota, the usage
+	// and the free space of the disk combined
+	TotalBytes int64
+
+	// FreeBytes is the number of bytes the user can still write
+	FreeBytes int64
+
+	// TotalFiles is the quota of the user or, without a quota, the usage
+	// and the files the disk can still hold combined
+	TotalFiles int64
+
+	// FreeFiles is the number of files the user can still create
+	FreeFiles int64
+}
+
+// Quota returns the quota applied to the file system.
+func (fs *FileSystem) Quota() Quota {
+	return fs.quota
+}
+
+// QuotaUsage returns the usage counted against the quota, which covers
+// everything below the root of the file system.
+func (fs *FileSystem) QuotaUsage() (Usage, error) {
+	fs.usage.mu.Lock()
+	defer fs.usage.mu.Unlock()
+	if !fs.usage.loaded {
+		if err := fs.loadUsage(); err != nil {
+			return Usage{}, err
+		}
+	}
+	return fs.usage.usage, nil
+}
+
+// Capacity returns the storage used by the user and the storage left for the
+// user.
+func (fs *FileSystem) Capacity() (Capacity, error) {
+	usage, err := fs.QuotaUsage()
+	if err != nil {
+		return Capacity{}, err
+	}
+	// the storage is not a limit if its capacity is unknown
+	freeBytes := math.MaxInt64 - usage.Bytes
+	freeFiles := math.MaxInt64 - usage.Files
+	space, err := fs.DiskSpace()
+	if err == nil {
+		freeBytes = int64(min(space.FreeBytes, uint64(freeBytes)))
+		freeFiles = int64(min(space.FreeFiles, uint64(freeFiles)))
+	} else if !errors.Is(err, errors.ErrUnsupported) {
+		return Capacity{}, err
+	}
+
+	capacity := Capacity{
+		Usage:      usage,
+		TotalBytes: usage.Bytes + freeBytes,
+		FreeBytes:  freeBytes,
+		TotalFiles: usage.Files + freeFiles,
+		FreeFiles:  freeFiles,
+	}
+	if fs.quota.Bytes > 0 {
+		capacity.TotalBytes = fs.quota.Bytes
+		capacity.FreeBytes = min(max(fs.quota.Bytes-usage.Bytes, 0), freeBytes)
+	}
+	if fs.quota.Files > 0 {
+		capacity.TotalFiles = fs.quota.Files
+		capacity.FreeFiles = min(max(fs.quota.Files-usage.Files, 0), freeFiles)
+	}
+	return capacity, nil
+}
+
+// RefreshUsage recalculates the usage counted against the quota. It is
+// required after files are changed without going through the file system,
+// such as by an external program.
+func (fs *FileSystem) RefreshUsage() error {
+	fs.usage.mu.Lock()
+	defer fs.usage.mu.Unlock()
+	return fs.loadUsage()
+}
+
+// loadUsage calculates the usage by walking the home directory, including
+// the versions kept and the files staged. The lock of the counter must be held.
+func (fs *FileSystem) loadUsage() error {
+	usage, err := fs.DiskUsage("/")
+	if err != nil {
+		return err
+	}
+	// the root directory itself is not counted
+	usage.Files--
+	if fs.versioning {
+		versions, err := fs.versionsUsage()
+		if err != nil {
+			return err
+		}
+		usage.Bytes += versions.Bytes
+		usage.Files += versions.Files
+	}
+	staged, err := fs.stagedUsage(StagingDirectory)
+	if err != nil {
+		return err
+	}
+	usage.Bytes += staged.Bytes
+	usage.Files += staged.Files
+	fs.usage.usage = usage
+	fs.usage.loaded = true
+	return nil
+}
+
+// charge adds the specified amount of storage to the usage and fails with
+// ErrQuotaExceeded if the usage would go over the quota. Negative amounts
+// release storage and never fail.
+func (fs *FileSystem) charge(op string, name string, bytes int64, files int64) error {
+	fs.usage.mu.Lock()
+	defer fs.usage.mu.Unlock()
+
+	limited := (fs.quota.Bytes > 0 && bytes > 0) || (fs.quota.Files > 0 && files > 0)
+	if !fs.usage.loaded {
+		// the usage is only calculated when there is a limit to check and
+		// it is up to date by then
+		if !limited {
+			return nil
+		}
+		if err := fs.loadUsage(); err != nil {
+			return err
+		}
+	}
+	if limited {
+		if (fs.quota.Bytes > 0 && bytes > 0 && fs.usage.usage.Bytes+bytes > fs.quota.Bytes) ||
+			(fs.quota.Files > 0 && files > 0 && fs.usage.usage.Files+files > fs.quota.Files) {
+			return &os.PathError{Op: op, Path: fs.virtualPath(name), Err: ErrQuotaExceeded}
+		}
+	}
+	fs.usage.usage.Bytes += bytes
+	fs.usage.usage.Files += files
+	return nil
+}
+
+// release removes the specified amount of storage from the usage.
+func (fs *FileSystem) release(bytes int64, files int64) {
+	_ = fs.charge("", "", -bytes, -files)
+}
+
+// storedUsage returns the storage counted for the specified path, including
+// everything below it, or nothing if the path does not exist.
+func (fs *FileSystem) storedUsage(name string) Usage {
+	fs.usage.mu.Lock()
+	loaded := fs.usage.loaded
+	fs.usage.mu.Unlock()
+	if !loaded {
+		return Usage{}
+	}
+	usage, err := fs.DiskUsage(name)
+	if err != nil {
+		return Usage{}
+	}
+	return usage
+}
+
+// missingDirectories returns the number of directories MkdirAll has to
+// create for the specified path.
+func (fs *FileSystem) missingDirectories(name string) int64 {
+	var count int64
+	for name = CleanPath(name); name != "/"; name = path.Dir(name) {
+		if _, err := fs.backend.Lstat(name); err == nil {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// quotaFile is a file opened for writing where the growth of the file is
+// charged to the usage of the file system. The file is not embedded so that
+// optimised copies, such as ReadFrom, cannot bypass the checks.
+type quotaFile struct {
+	file       File
+	fileSystem *FileSystem
+	name       string
+	append     bool
+
+	// target is the path the file is renamed to when it is closed if the
+	// file is a temporary file of an atomic upload, or the name otherwise
+	target string
+
+	// written is whether the file has been created, truncated or written
+	written atomic.Bool
+
+	// aborted is whether the transfer to the file has failed
+	aborted atomic.Bool
+
+	// staged is whether the file is a staged file, which is neither scanned
+	// nor reported as an upload
+	staged bool
+}
+
+func (f *quotaFile) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+func (f *quotaFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.file.ReadAt(p, off)
+}
+
+func (f *quotaFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+// Close closes the file and, if the file has been written, scans it and
+// raises an upload event unless it is moved to quarantine. A temporary file
+// of an atomic upload is renamed into place once it passes the checks and it
+// is removed otherwise.
+func (f *quotaFile) Close() error {
+	var size int64
+	if info, err := f.file.Stat(); err == nil {
+		size = info.Size()
+	}
+	if err := f.file.Close(); err != nil {
+		return errors.Join(err, f.discard())
+	}
+	if f.aborted.Load() && f.temporary() {
+		return f.discard()
+	}
+	if !f.written.Load() || f.staged {
+		return nil
+	}
+	if err := f.fileSystem.scanUpload(f.name, f.target); err != nil {
+		return errors.Join(err, f.discard())
+	}
+	if f.temporary() {
+		if err := f.fileSystem.commitTemp(f.name, f.target); err != nil {
+			return errors.Join(err, f.discard())
+		}
+	}
+	f.fileSystem.notify(FileEventUploaded, f.target, size)
+	return nil
+}
+
+// Abort marks the transfer to the file as failed, such as when the
+// connection is lost, so that a temporary file of an atomic upload is
+// removed rather than renamed into place when the file is closed.
+func (f *quotaFile) Abort() {
+	f.aborted.Store(true)
+}
+
+// temporary returns whether the file is a temporary file of an atomic
+// upload.
+func (f *quotaFile) temporary() bool {
+	return f.name != f.target
+}
+
+// discard removes the file if it is a temporary file of an atomic upload.
+func (f *quotaFile) discard() error {
+	if !f.temporary() {
+		return nil
+	}
+	return f.fileSystem.removeTemp(f.name)
+}
+
+func (f *quotaFile) Name() string {
+	return f.file.Name()
+}
+
+func (f *quotaFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *quotaFile) Readdir(count int) ([]os.FileInfo, error) {
+	return f.file.Readdir(count)
+}
+
+func (f *quotaFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	var offset int64
+	if !f.append {
+		var err error
+		if offset, err = f.file.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	growth, err := f.chargeGrowth(offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.file.Write(p)
+	f.refund(growth, int64(len(p)-n))
+	if n > 0 {
+		f.written.Store(true)
+	}
+	return n, err
+}
+
+func (f *quotaFile) WriteAt(p []byte, off int64) (int, error) {
+	growth, err := f.chargeGrowth(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.file.WriteAt(p, off)
+	f.refund(growth, int64(len(p)-n))
+	if n > 0 {
+		f.written.Store(true)
+	}
+	return n, err
+}
+
+func (f *quotaFile) Truncate(size int64) error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	delta := size - info.Size()
+	if err := f.fileSystem.charge("truncate", f.target, delta, 0); err != nil {
+		return err
+	}
+	if err := f.file.Truncate(size); err != nil {
+		f.fileSystem.release(delta, 0)
+		return err
+	}
+	f.written.Store(true)
+	return nil
+}
+
+// chargeGrowth charges the bytes a write of the specified length at the
+// offset adds to the file and returns the number of bytes charged. An
+// offset of an appending file is ignored as writes go to the end.
+func (f *quotaFile) chargeGrowth(offset int64, length int64) (int64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if f.append {
+		offset = size
+	}
+	growth := max(offset+length-size, 0)
+	if growth == 0 {
+		return 0, nil
+	}
+	if err := f.fileSystem.charge("write", f.target, growth, 0); err != nil {
+		return 0, err
+	}
+	return growth, nil
+}
+
+// refund releases the bytes charged for the part of a write which was not
+// completed.
+func (f *quotaFile) refund(growth int64, unwritten int64) {
+	if unused := min(growth, unwritten); unused > 0 {
+		f.fileSystem.release(unused, 0)
+	}
+}
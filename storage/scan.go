@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrQuarantined is returned when a file uploaded is moved to quarantine as
+// it is found infected or it cannot be scanned.
+var ErrQuarantined = errors.New("file moved to quarantine")
+
+// ScanResult is the verdict of a scanner on the content of a file.
+type ScanResult struct {
+	// Infected is whether malware is found
+	Infected bool
+
+	// Signature is the name of the malware found
+	Signature string
+}
+
+// Scanner scans the content of files for malware.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanConfig is the configuration of the scanning of files uploaded.
+type ScanConfig struct {
+	// Scanner scans the files written by users where nil disables scanning
+	Scanner Scanner
+
+	// Timeout is how long the scan of a file can take
+	Timeout time.Duration
+}
+
+// scanConfig is the configuration of scanning of the deployment.
+var scanConfig ScanConfig
+
+// SetScanConfig sets the configuration of scanning. It is expected to be
+// called once before the servers are started.
+func SetScanConfig(config ScanConfig) {
+	scanConfig = config
+}
+
+// QuarantineDirectory is the hidden directory of a home directory, or of a
+// shared folder, where files found infected are kept in the storage of the
+// files, encrypted like the other files. It cannot be accessed by the user
+// and the files do not count towards the quota of the user.
+const QuarantineDirectory = "/.quarantine"
+
+// QuarantinedFile is a file uploaded which is moved to quarantine.
+type QuarantinedFile struct {
+	// ID is the name of the file in the quarantine directory
+	ID string
+
+	// Username is the user uploading the file
+	Username string
+
+	// Path is the virtual path of the file as seen by the user
+	Path string
+
+	// Size is the size of the file
+	Size int64
+
+	// Signature is the name of the malware found
+	Signature string
+
+	// Error is the reason the file cannot be scanned
+	Error string
+}
+
+// quarantineHandler is called with the files moved to quarantine.
+var quarantineHandler func(QuarantinedFile)
+
+// SetQuarantineHandler sets the function called with the files moved to
+// quarantine, such as to keep a record of them. It is expected to be called
+// once before the servers are started and the function is called
+// synchronously when a file is closed.
+func SetQuarantineHandler(handler func(QuarantinedFile)) {
+	quarantineHandler = handler
+}
+
+func isQuarantinePath(name string) bool {
+	name = CleanPath(name)
+	return name == QuarantineDirectory || strings.HasPrefix(name, QuarantineDirectory+"/")
+}
+
+// quarantinePath returns the path of the specified quarantined file.
+func quarantinePath(op string, id string) (string, error) {
+	if decoded, err := hex.DecodeString(id); err != nil || len(decoded) != 16 {
+		return "", &os.PathError{Op: op, Path: id, Err: fmt.Errorf("invalid quarantine ID")}
+	}
+	return path.Join(QuarantineDirectory, id), nil
+}
+
+// ScansUploads returns whether the files written are scanned for malware.
+func (fs *FileSystem) ScansUploads() bool {
+	return scanConfig.Scanner != nil && fs.username != ""
+}
+
+// RemoveQuarantined removes the specified quarantined file, uploaded to the
+// specified path, permanently.
+func (fs *FileSystem) RemoveQuarantined(id string, name string) error {
+	mount, _, err := fs.locate("remove", name)
+	if err != nil {
+		return err
+	}
+	pathFile, err := quarantinePath("remove", id)
+	if err != nil {
+		return err
+	}
+	return mount.backend.Remove(pathFile)
+}
+
+// ReleaseQuarantined moves the specified quarantined file back to the path
+// it is uploaded to without scanning it again. The file is counted towards
+// the quota again and it cannot replace an existing file.
+func (fs *FileSystem) ReleaseQuarantined(id string, name string) error {
+	mount, inner, err := fs.locate("release", name)
+	if err != nil {
+		return err
+	}
+	return mount.releaseQuarantined(id, inner)
+}
+
+func (fs *FileSystem) releaseQuarantined(id string, name string) error {
+	pathFile, err := quarantinePath("release", id)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkWrite("release", name); err != nil {
+		return err
+	}
+	if err := fs.checkParentSymlinks("release", name); err != nil {
+		return err
+	}
+	info, err := fs.backend.Lstat(pathFile)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.backend.Lstat(CleanPath(name)); err == nil {
+		return &os.PathError{Op: "release", Path: fs.virtualPath(name), Err: syscall.EEXIST}
+	}
+	if err := fs.checkStoredUpload("release", pathFile, name); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(path.Dir(CleanPath(name)), 0o755); err != nil {
+		return err
+	}
+	if err := fs.charge("release", name, info.Size(), 1); err != nil {
+		return err
+	}
+	if err := fs.backend.Rename(pathFile, CleanPath(name)); err != nil {
+		fs.release(info.Size(), 1)
+		return err
+	}
+	fs.notify(FileEventUploaded, name, info.Size())
+	return nil
+}
+
+// scanUpload scans the specified file written by the user and moves it to
+// quarantine if it is found infected or it cannot be scanned, in which case
+// ErrQuarantined is returned. The file is reported as the target path, which
+// is different from the name if the file is a temporary file of an atomic
+// upload.
+func (fs *FileSystem) scanUpload(name string, target string) error {
+	if !fs.ScansUploads() {
+		return nil
+	}
+	result, scanErr := fs.scan(name)
+	if scanErr == nil && !result.Infected {
+		return nil
+	}
+
+	quarantined := QuarantinedFile{
+		Username:  fs.username,
+		Path:      fs.virtualPath(target),
+		Signature: result.Signature,
+	}
+	reason := "infected with " + result.Signature
+	if scanErr != nil {
+		quarantined.Error = scanErr.Error()
+		reason = "unable to scan file"
+	}
+	id, size, err := fs.quarantine(name)
+	if err != nil {
+		return errors.Join(scanErr, err)
+	}
+	quarantined.ID = id
+	quarantined.Size = size
+	if quarantineHandler != nil {
+		quarantineHandler(quarantined)
+	}
+	return &os.PathError{Op: "close", Path: fs.virtualPath(target), Err: fmt.Errorf("%w: %s", ErrQuarantined, reason)}
+}
+
+func (fs *FileSystem) scan(name string) (ScanResult, error) {
+	file, err := fs.backend.OpenFile(CleanPath(name), os.O_RDONLY, 0)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer file.Close()
+
+	ctx := context.Background()
+	if scanConfig.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scanConfig.Timeout)
+		defer cancel()
+	}
+	return scanConfig.Scanner.Scan(ctx, file)
+}
+
+// quarantine moves the specified file to the quarantine directory and
+// returns the ID and the size of the quarantined file.
+func (fs *FileSystem) quarantine(name string) (string, int64, error) {
+	info, err := fs.backend.Lstat(CleanPath(name))
+	if err != nil {
+		return "", 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", 0, &os.PathError{Op: "quarantine", Path: fs.virtualPath(name), Err: syscall.EINVAL}
+	}
+
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", 0, err
+	}
+	id := hex.EncodeToString(bytes)
+	if err := fs.backend.MkdirAll(QuarantineDirectory, 0o700); err != nil {
+		return "", 0, err
+	}
+	if err := fs.backend.Rename(CleanPath(name), path.Join(QuarantineDirectory, id)); err != nil {
+		return "", 0, err
+	}
+	fs.release(info.Size(), 1)
+	return id, info.Size(), nil
+}
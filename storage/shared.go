@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/helper/iohelper"
+	"gorm.io/gorm"
+)
+
+// SharedDirectory is the virtual directory where the shared folders of the
+// groups of a user are mounted. A directory of the same name in the home
+// directory is hidden while the user has access to any shared folder.
+const SharedDirectory = "/shared"
+
+// sharedFoldersDirectory is the directory in the users directory where
+// shared folders are stored. It cannot clash with a home directory as
+// usernames cannot start with a dot.
+const sharedFoldersDirectory = ".shared"
+
+// ValidateSharedFolderName checks if the name can be used as the name of a
+// shared folder.
+func ValidateSharedFolderName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid shared folder name: %s", name)
+	}
+	return nil
+}
+
+// GetSharedFolderPath returns the path of the specified shared folder.
+func GetSharedFolderPath(pathUsersDirectory string, name string) (string, error) {
+	if err := ValidateSharedFolderName(name); err != nil {
+		return "", err
+	}
+	return filepath.Clean(filepath.Join(pathUsersDirectory, sharedFoldersDirectory, name)), nil
+}
+
+// CreateSharedFolder creates the directory of the specified shared folder if
+// it does not exist yet.
+func CreateSharedFolder(pathUsersDirectory string, name string) error {
+	root, err := GetSharedFolderPath(pathUsersDirectory, name)
+	if err != nil {
+		return err
+	}
+	if iohelper.IsDirectoryExist(root) {
+		return nil
+	}
+	return os.MkdirAll(root, 0o755)
+}
+
+// RemoveSharedFolder removes the specified shared folder along with its
+// files.
+func RemoveSharedFolder(pathUsersDirectory string, name string) error {
+	root, err := GetSharedFolderPath(pathUsersDirectory, name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(root)
+}
+
+// mountSharedFolders mounts the shared folders of the groups of the user
+// under SharedDirectory. A folder is read-only to read-only members and the
+// access mode of the user applies on top of the access of the member.
+func (fs *FileSystem) mountSharedFolders(dbConn *gorm.DB, pathUsersDirectory string, user db.User) error {
+	var folders []struct {
+		Name   string
+		Access string
+	}
+	err := dbConn.Model(&db.SharedFolder{}).
+		Select("shared_folders.name, group_members.access").
+		Joins("JOIN group_members ON group_members.group_name = shared_folders.group_name").
+		Where("group_members.username = ?", user.Username).
+		Scan(&folders).Error
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range folders {
+		accessMode := user.AccessMode
+		if folder.Access == db.GroupAccessReadOnly {
+			if accessMode == db.AccessModeWriteOnly {
+				continue
+			}
+			accessMode = db.AccessModeReadOnly
+		}
+		// a folder is shared by more than one group of the user
+		if mounted, ok := fs.mounts[folder.Name]; ok && mounted.accessMode != db.AccessModeReadOnly {
+			continue
+		}
+
+		root, err := GetSharedFolderPath(pathUsersDirectory, folder.Name)
+		if err != nil {
+			return err
+		}
+		if !iohelper.IsDirectoryExist(root) {
+			if err := os.MkdirAll(root, 0o755); err != nil {
+				return fmt.Errorf("unable to create shared folder: %w", err)
+			}
+		}
+		if fs.mounts == nil {
+			fs.mounts = map[string]*FileSystem{}
+		}
+		fs.mounts[folder.Name] = &FileSystem{
+			backend:    NewLocalBackend(root),
+			username:   user.Username,
+			mountPath:  path.Join(SharedDirectory, folder.Name),
+			accessMode: accessMode,
+			usage:      getUsageCounter(root),
+
+			uploadPolicies: fs.uploadPolicies,
+			symlinkPolicy:  fs.symlinkPolicy,
+		}
+	}
+	return nil
+}
+
+// SharedFolder returns the file system of the specified shared folder if it
+// is mounted for the user.
+func (fs *FileSystem) SharedFolder(name string) (*FileSystem, bool) {
+	mount, ok := fs.mounts[name]
+	return mount, ok
+}
+
+// SharedFolderNames returns the names of the shared folders mounted for the
+// user in order.
+func (fs *FileSystem) SharedFolderNames() []string {
+	names := make([]string, 0, len(fs.mounts))
+	for name := range fs.mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// locate returns the file system serving the specified path along with the
+// path in that file system. Paths below a shared folder are served by the
+// file system of the folder. The shared directory itself is virtual and it
+// cannot be changed.
+func (fs *FileSystem) locate(op string, name string) (*FileSystem, string, error) {
+	name = CleanPath(name)
+	if (fs.versioning && isVersionsPath(name)) || isQuarantinePath(name) || isStagingPath(name) {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.EACCES}
+	}
+	if len(fs.mounts) == 0 {
+		return fs, name, nil
+	}
+	if name == SharedDirectory {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.EACCES}
+	}
+	rest, ok := strings.CutPrefix(name, SharedDirectory+"/")
+	if !ok {
+		return fs, name, nil
+	}
+	folder, inner, _ := strings.Cut(rest, "/")
+	mount, ok := fs.mounts[folder]
+	if !ok {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	return mount, "/" + inner, nil
+}
+
+// locatePair locates the two paths of an operation such as rename which
+// cannot be done across file systems.
+func (fs *FileSystem) locatePair(op string, oldName string, newName string) (*FileSystem, string, string, error) {
+	oldTarget, oldInner, err := fs.locate(op, oldName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	newTarget, newInner, err := fs.locate(op, newName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if oldTarget != newTarget {
+		return nil, "", "", &os.LinkError{Op: op, Old: CleanPath(oldName), New: CleanPath(newName), Err: syscall.EXDEV}
+	}
+	return oldTarget, oldInner, newInner, nil
+}
+
+// isSharedDirectory returns whether the path is the virtual shared
+// directory.
+func (fs *FileSystem) isSharedDirectory(name string) bool {
+	return len(fs.mounts) > 0 && CleanPath(name) == SharedDirectory
+}
+
+// sharedFolders returns the information of the shared folders mounted.
+func (fs *FileSystem) sharedFolders() []os.FileInfo {
+	list := make([]os.FileInfo, 0, len(fs.mounts))
+	for _, mount := range fs.mounts {
+		info, err := os.Lstat(mount.Root())
+		if err != nil {
+			continue
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// withSharedDirectory adds the virtual shared directory to the entries of
+// the root directory, in place of a directory of the same name.
+func (fs *FileSystem) withSharedDirectory(name string, entries []os.FileInfo) []os.FileInfo {
+	if len(fs.mounts) == 0 || CleanPath(name) != "/" {
+		return entries
+	}
+	list := make([]os.FileInfo, 0, len(entries)+1)
+	for _, entry := range entries {
+		if entry.Name() != path.Base(SharedDirectory) {
+			list = append(list, entry)
+		}
+	}
+	return append(list, sharedDirectoryInfo{})
+}
+
+// sharedDirectoryInfo is the information of the virtual shared directory
+// which is always read-only.
+type sharedDirectoryInfo struct{}
+
+func (sharedDirectoryInfo) Name() string {
+	return path.Base(SharedDirectory)
+}
+
+func (sharedDirectoryInfo) Size() int64 {
+	return 0
+}
+
+func (sharedDirectoryInfo) Mode() os.FileMode {
+	return os.ModeDir | 0o555
+}
+
+// ModTime returns the current time as the virtual directory is not stored.
+func (sharedDirectoryInfo) ModTime() time.Time {
+	return time.Now()
+}
+
+func (sharedDirectoryInfo) IsDir() bool {
+	return true
+}
+
+func (sharedDirectoryInfo) Sys() any {
+	return nil
+}
+
+// sharedDirectoryFile is the virtual shared directory opened for reading.
+type sharedDirectoryFile struct {
+	fileSystem *FileSystem
+	offset     int
+}
+
+func (f *sharedDirectoryFile) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: SharedDirectory, Err: syscall.EISDIR}
+}
+
+func (f *sharedDirectoryFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: SharedDirectory, Err: syscall.EISDIR}
+}
+
+func (f *sharedDirectoryFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: SharedDirectory, Err: syscall.EISDIR}
+}
+
+func (f *sharedDirectoryFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: SharedDirectory, Err: syscall.EISDIR}
+}
+
+func (f *sharedDirectoryFile) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		f.offset = 0
+		return 0, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: SharedDirectory, Err: syscall.EISDIR}
+}
+
+func (f *sharedDirectoryFile) Close() error {
+	return nil
+}
+
+func (f *sharedDirectoryFile) Name() string {
+	return SharedDirectory
+}
+
+func (f *sharedDirectoryFile) Stat() (os.FileInfo, error) {
+	return sharedDirectoryInfo{}, nil
+}
+
+func (f *sharedDirectoryFile) Readdir(count int) ([]os.FileInfo, error) {
+	entries := f.fileSystem.sharedFolders()
+	if f.offset > len(entries) {
+		f.offset = len(entries)
+	}
+	entries = entries[f.offset:]
+	if count <= 0 {
+		f.offset += len(entries)
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	entries = entries[:min(count, len(entries))]
+	f.offset += len(entries)
+	return entries, nil
+}
+
+func (f *sharedDirectoryFile) Sync() error {
+	return nil
+}
+
+func (f *sharedDirectoryFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: SharedDirectory, Err: syscall.EISDIR}
+}
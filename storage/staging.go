@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// StagingDirectory is the hidden directory of a home directory, or of a
+// shared folder, where uploads in progress are staged, such as the parts of
+// multipart uploads of the S3 API. Staged files are stored like the other
+// files, encrypted if the files of the user are encrypted, and they count
+// towards the quota of the user. It cannot be accessed by the user.
+const StagingDirectory = "/.staging"
+
+func isStagingPath(name string) bool {
+	name = CleanPath(name)
+	return name == StagingDirectory || strings.HasPrefix(name, StagingDirectory+"/")
+}
+
+// stagedPath returns the path of the specified staged file.
+func stagedPath(name string) string {
+	return path.Join(StagingDirectory, CleanPath(name))
+}
+
+// CreateStaged creates, or truncates, the specified staged file for writing
+// along with its parent directories. Staged files are neither checked
+// against the upload policies nor scanned as they are checked when they are
+// written to their final paths.
+func (fs *FileSystem) CreateStaged(name string) (File, error) {
+	if err := fs.checkWrite("open", stagedPath(name)); err != nil {
+		return nil, err
+	}
+	name = stagedPath(name)
+	if err := fs.backend.MkdirAll(path.Dir(name), 0o700); err != nil {
+		return nil, err
+	}
+	var created int64
+	var truncated int64
+	info, err := fs.backend.Lstat(name)
+	if err != nil {
+		created = 1
+	} else if info.Mode().IsRegular() {
+		truncated = info.Size()
+	}
+	if err := fs.charge("open", name, 0, created); err != nil {
+		return nil, err
+	}
+	file, err := fs.backend.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		fs.release(0, created)
+		return nil, err
+	}
+	fs.release(truncated, 0)
+	staged := &quotaFile{
+		file:       file,
+		fileSystem: fs,
+		name:       name,
+		target:     name,
+		staged:     true,
+	}
+	staged.written.Store(true)
+	return staged, nil
+}
+
+// OpenStaged opens the specified staged file for reading.
+func (fs *FileSystem) OpenStaged(name string) (File, error) {
+	return fs.backend.OpenFile(stagedPath(name), os.O_RDONLY, 0)
+}
+
+// StatStaged returns the information of the specified staged file.
+func (fs *FileSystem) StatStaged(name string) (os.FileInfo, error) {
+	return fs.backend.Stat(stagedPath(name))
+}
+
+// ReadStagedDir returns the entries of the specified staged directory.
+func (fs *FileSystem) ReadStagedDir(name string) ([]os.FileInfo, error) {
+	return fs.backend.ReadDir(stagedPath(name))
+}
+
+// RemoveStaged removes the specified staged file or directory along with
+// everything below it.
+func (fs *FileSystem) RemoveStaged(name string) error {
+	if CleanPath(name) == "/" {
+		return os.ErrPermission
+	}
+	name = stagedPath(name)
+	removed, err := fs.stagedUsage(name)
+	if err != nil {
+		return err
+	}
+	if err := fs.backend.RemoveAll(name); err != nil {
+		// some of the files may have been removed
+		return errors.Join(err, fs.RefreshUsage())
+	}
+	fs.release(removed.Bytes, removed.Files)
+	return nil
+}
+
+// stagedUsage returns the storage taken by the staged files of the specified
+// path of the staging directory. Only the files are counted, rather than the
+// directories keeping them, like the versions.
+func (fs *FileSystem) stagedUsage(name string) (Usage, error) {
+	info, err := fs.backend.Lstat(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Usage{}, nil
+		}
+		return Usage{}, err
+	}
+	var usage Usage
+	err = fs.walk(name, info, func(_ string, info os.FileInfo) {
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+			usage.Files++
+		}
+	})
+	return usage, err
+}
+
+// removeStaleStaged removes the entries of the staging directory, such as
+// the directories of multipart uploads, where nothing has been changed since
+// the cutoff.
+func (fs *FileSystem) removeStaleStaged(cutoff time.Time) error {
+	entries, err := fs.backend.ReadDir(StagingDirectory)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		name := path.Join(StagingDirectory, entry.Name())
+		stale := true
+		err := fs.walk(name, entry, func(_ string, info os.FileInfo) {
+			if !info.ModTime().Before(cutoff) {
+				stale = false
+			}
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if stale {
+			if err := fs.RemoveStaged(entry.Name()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupStaged removes the staged uploads which have not been changed for
+// the specified duration from the home directories and the shared folders
+// of all users.
+func CleanupStaged(dbConn *gorm.DB, pathUsersDirectory string, maxAge time.Duration) error {
+	var users []db.User
+	if err := dbConn.Order("username ASC").Find(&users).Error; err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var errs []error
+	cleaned := map[*usageCounter]bool{}
+	for _, user := range users {
+		fileSystem, err := OpenUserFileSystem(dbConn, pathUsersDirectory, user.Username)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fileSystems := []*FileSystem{fileSystem}
+		for _, mount := range fileSystem.mounts {
+			fileSystems = append(fileSystems, mount)
+		}
+		for _, fileSystem := range fileSystems {
+			// a shared folder is mounted for every member
+			if cleaned[fileSystem.usage] {
+				continue
+			}
+			cleaned[fileSystem.usage] = true
+			if err := fileSystem.removeStaleStaged(cutoff); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
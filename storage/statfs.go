@@ -0,0 +1,19 @@
+//go:build linux || darwin
+
+package storage
+
+import "syscall"
+
+// DiskSpace returns the capacity of the disk where the files are stored.
+func (b *LocalBackend) DiskSpace() (DiskSpace, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(b.root, &stat); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		TotalBytes: uint64(stat.Blocks) * uint64(stat.Bsize),
+		FreeBytes:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		TotalFiles: uint64(stat.Files),
+		FreeFiles:  uint64(stat.Ffree),
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package storage
+
+import "errors"
+
+// DiskSpace returns the capacity of the disk where the files are stored.
+func (b *LocalBackend) DiskSpace() (DiskSpace, error) {
+	return DiskSpace{}, errors.ErrUnsupported
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SymlinkPolicy is how symbolic links are handled by file systems of users.
+type SymlinkPolicy string
+
+const (
+	// SymlinkDeny refuses to create symbolic links and to read or follow
+	// existing ones
+	SymlinkDeny SymlinkPolicy = "deny"
+
+	// SymlinkJail allows symbolic links which resolve inside the home
+	// directory, or the shared folder, of the links
+	SymlinkJail SymlinkPolicy = "jail"
+
+	// SymlinkAllow allows symbolic links pointing anywhere
+	SymlinkAllow SymlinkPolicy = "allow"
+)
+
+// ParseSymlinkPolicy parses a symlink policy where an empty string means
+// SymlinkJail.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(s); policy {
+	case "":
+		return SymlinkJail, nil
+	case SymlinkDeny, SymlinkJail, SymlinkAllow:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid symlink policy: %s", s)
+}
+
+// symlinkPolicy is the symlink policy of the deployment.
+var symlinkPolicy = SymlinkJail
+
+// SetSymlinkPolicy sets the symlink policy of the file systems of users. It
+// is expected to be called once before the servers are started.
+func SetSymlinkPolicy(policy SymlinkPolicy) {
+	symlinkPolicy = policy
+}
+
+// CheckSymlinks applies the symlink policy to an operation following the
+// symbolic links of the specified path, such as an operation of a program
+// working on the home directory on the local disk.
+func (fs *FileSystem) CheckSymlinks(op string, name string) error {
+	if fs.isSharedDirectory(name) {
+		return nil
+	}
+	mount, inner, err := fs.locate(op, name)
+	if err != nil {
+		return err
+	}
+	return mount.checkSymlinks(op, inner)
+}
+
+// checkSymlinks applies the symlink policy to an operation of this file
+// system following the symbolic links of the specified path.
+func (fs *FileSystem) checkSymlinks(op string, name string) error {
+	if fs.symlinkPolicy == "" || fs.symlinkPolicy == SymlinkAllow {
+		return nil
+	}
+	linked, inside, err := fs.resolveSymlinks(name)
+	if err != nil {
+		return err
+	}
+	if linked && (fs.symlinkPolicy == SymlinkDeny || !inside) {
+		return &os.PathError{Op: op, Path: fs.virtualPath(name), Err: syscall.EACCES}
+	}
+	return nil
+}
+
+// checkParentSymlinks applies the symlink policy to an operation which does
+// not follow the specified path itself if it is a symbolic link, such as
+// removing it.
+func (fs *FileSystem) checkParentSymlinks(op string, name string) error {
+	return fs.checkSymlinks(op, path.Dir(CleanPath(name)))
+}
+
+// checkSymlinkCreation applies the symlink policy to creating name as a
+// symbolic link to the target which is a path of this file system if it is
+// absolute. Refusals are path errors, rather than link errors, so that
+// clients are told the permission is denied.
+func (fs *FileSystem) checkSymlinkCreation(target string, name string) error {
+	switch fs.symlinkPolicy {
+	case SymlinkDeny:
+		return &os.PathError{Op: "symlink", Path: fs.virtualPath(name), Err: syscall.EACCES}
+	case SymlinkJail:
+		if err := fs.checkParentSymlinks("symlink", name); err != nil {
+			return err
+		}
+		inside, err := fs.symlinkInside(target, name)
+		if err != nil {
+			return err
+		}
+		if !inside {
+			return &os.PathError{Op: "symlink", Path: fs.virtualPath(name), Err: syscall.EACCES}
+		}
+	}
+	return nil
+}
+
+// resolveSymlinks reports whether the specified file is reached through
+// symbolic links, in any component of its path, and whether it resolves
+// inside the root of this file system. Paths which do not exist are resolved
+// as far as they exist so that dangling links are judged by their targets.
+// Backends other than the local disk keep symbolic links inside themselves.
+func (fs *FileSystem) resolveSymlinks(name string) (bool, bool, error) {
+	root := fs.localPath("/")
+	if root == "" {
+		info, err := fs.backend.Lstat(CleanPath(name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return false, true, nil
+			}
+			return false, false, err
+		}
+		return info.Mode()&os.ModeSymlink != 0, true, nil
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false, false, err
+	}
+	resolved, err := evalSymlinks(fs.localPath(name), maxSymlinkHops)
+	if err != nil {
+		return false, false, err
+	}
+	lexical := filepath.Join(realRoot, filepath.FromSlash(CleanPath(name)))
+	return resolved != lexical, isInside(realRoot, resolved), nil
+}
+
+// symlinkInside reports whether a symbolic link created as the specified
+// name pointing to the target, which is a path of this file system if it is
+// absolute, would resolve inside the root of this file system.
+func (fs *FileSystem) symlinkInside(target string, name string) (bool, error) {
+	root := fs.localPath("/")
+	if root == "" {
+		return true, nil
+	}
+	if path.IsAbs(target) {
+		target = fs.localPath(target)
+	} else {
+		// a relative target is not confined by the root of the virtual paths
+		target = filepath.Join(filepath.Dir(fs.localPath(name)), filepath.FromSlash(target))
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false, err
+	}
+	resolved, err := evalSymlinks(target, maxSymlinkHops)
+	if err != nil {
+		return false, err
+	}
+	return isInside(realRoot, resolved), nil
+}
+
+// evalSymlinks returns the path on the local disk with the symbolic links
+// evaluated like filepath.EvalSymlinks except that the part of the path
+// which does not exist is kept as it is and dangling links are followed.
+func evalSymlinks(pathFile string, hops int) (string, error) {
+	resolved, err := filepath.EvalSymlinks(pathFile)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return resolved, err
+	}
+	parent, err := evalSymlinks(filepath.Dir(pathFile), hops)
+	if err != nil {
+		return "", err
+	}
+	pathFile = filepath.Join(parent, filepath.Base(pathFile))
+	target, err := os.Readlink(pathFile)
+	if err != nil {
+		// nothing exists at the path
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EINVAL) {
+			return pathFile, nil
+		}
+		return "", err
+	}
+	if hops == 0 {
+		return "", &os.PathError{Op: "readlink", Path: pathFile, Err: syscall.ELOOP}
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(parent, target)
+	}
+	return evalSymlinks(target, hops-1)
+}
+
+// isInside returns whether the path on the local disk is the root or a path
+// below it.
+func isInside(root string, pathFile string) bool {
+	relative, err := filepath.Rel(root, pathFile)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
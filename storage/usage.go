@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// Usage is the amount of storage used by files.
+type Usage struct {
+	// Bytes is the total size of the files
+	Bytes int64
+
+	// Files is the number of files and directories
+	Files int64
+}
+
+// DiskSpace is the capacity of the disk where the home directory is stored.
+type DiskSpace struct {
+	// TotalBytes is the size of the disk
+	TotalBytes uint64
+
+	// FreeBytes is the number of bytes available to the server
+	FreeBytes uint64
+
+	// TotalFiles is the number of files the disk can hold
+	TotalFiles uint64
+
+	// FreeFiles is the number of files which can still be created
+	FreeFiles uint64
+}
+
+// DiskUsage returns the usage of the specified path including everything
+// below it if it is a directory. Symbolic links are not followed and shared
+// folders are only included if the path is in the shared directory. The
+// usage is calculated regardless of the access mode as it does not reveal
+// the names or the contents of files.
+func (fs *FileSystem) DiskUsage(name string) (Usage, error) {
+	if fs.isSharedDirectory(name) {
+		usage := Usage{Files: 1}
+		for _, mount := range fs.mounts {
+			mountUsage, err := mount.DiskUsage("/")
+			if err != nil {
+				return Usage{}, err
+			}
+			usage.Bytes += mountUsage.Bytes
+			usage.Files += mountUsage.Files
+		}
+		return usage, nil
+	}
+	mount, name, err := fs.locate("stat", name)
+	if err != nil {
+		return Usage{}, err
+	}
+	if mount != fs {
+		return mount.DiskUsage(name)
+	}
+	info, err := fs.backend.Lstat(CleanPath(name))
+	if err != nil {
+		return Usage{}, err
+	}
+	var usage Usage
+	err = fs.walk(name, info, func(_ string, info os.FileInfo) {
+		usage.Files++
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+		}
+	})
+	return usage, err
+}
+
+// walk calls fn for the specified path and, if it is a directory, for every
+// path below it.
+func (fs *FileSystem) walk(name string, info os.FileInfo, fn func(name string, info os.FileInfo)) error {
+	fn(name, info)
+	if !info.IsDir() {
+		return nil
+	}
+	entries, err := fs.readDir(name)
+	if err != nil {
+		// files removed while walking are skipped
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := fs.walk(path.Join(name, entry.Name()), entry, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+)
+
+// VersioningConfig is the configuration of the versions of files kept when
+// files are overwritten, renamed over or removed.
+type VersioningConfig struct {
+	// Enabled is whether versions are kept
+	Enabled bool
+
+	// MaxVersions is the number of versions kept for a file where zero
+	// means unlimited
+	MaxVersions int
+
+	// MaxAge is how long a version is kept where zero means forever
+	MaxAge time.Duration
+}
+
+// versioningConfig is the configuration of versioning of the deployment.
+var versioningConfig VersioningConfig
+
+// SetVersioningConfig sets the configuration of versioning. It is expected
+// to be called once before the servers are started.
+func SetVersioningConfig(config VersioningConfig) {
+	versioningConfig = config
+}
+
+// VersionsDirectory is the hidden directory of a home directory where the
+// versions of files are kept. It cannot be accessed by the user and the
+// versions count towards the quota of the user like the files they were.
+const VersionsDirectory = "/.versions"
+
+// versionIDFormat is the format of the time a version is kept which is used
+// as the ID of the version.
+const versionIDFormat = "20060102T150405.000000000Z"
+
+// Version is a previous content of a file.
+type Version struct {
+	// Path is the path of the file
+	Path string
+
+	// ID identifies the version among the versions of the file
+	ID string
+
+	// Size is the size of the content
+	Size int64
+
+	// CreatedAt is the time the version is kept
+	CreatedAt time.Time
+
+	// Deleted is whether the file no longer exists, in which case the
+	// version is in the recycle bin
+	Deleted bool
+}
+
+// Versioning returns whether versions of files are kept.
+func (fs *FileSystem) Versioning() bool {
+	return fs.versioning
+}
+
+func isVersionsPath(name string) bool {
+	name = CleanPath(name)
+	return name == VersionsDirectory || strings.HasPrefix(name, VersionsDirectory+"/")
+}
+
+// fileVersionsDirectory returns the directory keeping the versions of the
+// file of the specified path. The directory tree of the home directory is
+// mirrored in the versions directory so that the names are as long as the
+// names of the files. The versions of a file are regular files while the
+// files below a directory of the same path at a different time are kept in
+// directories.
+func fileVersionsDirectory(name string) string {
+	return path.Join(VersionsDirectory, CleanPath(name))
+}
+
+// versionPath returns the path of a version.
+func versionPath(name string, id string) string {
+	return path.Join(fileVersionsDirectory(name), id)
+}
+
+// parseVersion returns the version of the file of the specified path for an
+// entry of the directory keeping its versions.
+func parseVersion(name string, info os.FileInfo) (Version, bool) {
+	if !info.Mode().IsRegular() {
+		return Version{}, false
+	}
+	createdAt, err := time.Parse(versionIDFormat, info.Name())
+	if err != nil {
+		return Version{}, false
+	}
+	return Version{Path: CleanPath(name), ID: info.Name(), Size: info.Size(), CreatedAt: createdAt}, true
+}
+
+// keepVersion moves the regular file at the specified path into the
+// versions directory where it still counts towards the quota. Nothing is
+// kept if there is no such file.
+func (fs *FileSystem) keepVersion(name string) error {
+	name = CleanPath(name)
+	info, err := fs.backend.Lstat(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if err := fs.backend.MkdirAll(fileVersionsDirectory(name), 0o700); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := fs.backend.Rename(name, versionPath(name, now.UTC().Format(versionIDFormat))); err != nil {
+		return err
+	}
+	// a failure to remove old versions does not fail the operation as they
+	// are removed by the cleanup job later
+	_ = fs.pruneVersions(name, now)
+	return nil
+}
+
+// keepVersions keeps versions of all the regular files below the specified
+// path.
+func (fs *FileSystem) keepVersions(name string) error {
+	info, err := fs.backend.Lstat(CleanPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var files []string
+	err = fs.walk(name, info, func(name string, info os.FileInfo) {
+		if info.Mode().IsRegular() {
+			files = append(files, name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fs.keepVersion(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionsUsage returns the storage taken by the versions kept. Only the
+// versions are counted, as they are the files they were, rather than the
+// directories keeping them.
+func (fs *FileSystem) versionsUsage() (Usage, error) {
+	info, err := fs.backend.Lstat(VersionsDirectory)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Usage{}, nil
+		}
+		return Usage{}, err
+	}
+	var usage Usage
+	err = fs.walk(VersionsDirectory, info, func(_ string, info os.FileInfo) {
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+			usage.Files++
+		}
+	})
+	return usage, err
+}
+
+// versions returns the versions of the file of the specified path, or of
+// all files if the path is empty, with the newest version first.
+func (fs *FileSystem) versions(name string) ([]Version, error) {
+	var list []Version
+	if name != "" {
+		entries, err := fs.backend.ReadDir(fileVersionsDirectory(name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if version, ok := parseVersion(name, entry); ok {
+				list = append(list, version)
+			}
+		}
+	} else {
+		info, err := fs.backend.Lstat(VersionsDirectory)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		err = fs.walk(VersionsDirectory, info, func(pathVersion string, info os.FileInfo) {
+			if version, ok := parseVersion(strings.TrimPrefix(path.Dir(pathVersion), VersionsDirectory), info); ok {
+				list = append(list, version)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deleted := map[string]bool{}
+	for i, version := range list {
+		isDeleted, ok := deleted[version.Path]
+		if !ok {
+			_, err := fs.backend.Lstat(version.Path)
+			isDeleted = errors.Is(err, os.ErrNotExist)
+			deleted[version.Path] = isDeleted
+		}
+		list[i].Deleted = isDeleted
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+// ListVersions returns the versions of the file of the specified path, or
+// of all files if the path is empty, with the newest version of a file
+// first.
+func (fs *FileSystem) ListVersions(name string) ([]Version, error) {
+	return fs.versions(name)
+}
+
+// ListTrash returns the versions of files which no longer exist.
+func (fs *FileSystem) ListTrash() ([]Version, error) {
+	versions, err := fs.versions("")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Version, 0, len(versions))
+	for _, version := range versions {
+		if version.Deleted {
+			list = append(list, version)
+		}
+	}
+	return list, nil
+}
+
+// findVersion returns the specified version of a file.
+func (fs *FileSystem) findVersion(op string, name string, id string) (Version, error) {
+	info, err := fs.backend.Lstat(versionPath(name, id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Version{}, &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOENT}
+		}
+		return Version{}, err
+	}
+	version, ok := parseVersion(name, info)
+	if !ok {
+		return Version{}, &os.PathError{Op: op, Path: CleanPath(name), Err: syscall.ENOENT}
+	}
+	return version, nil
+}
+
+// RestoreVersion restores the specified version of a file. The current
+// content of the file, if any, is kept as a version in turn.
+func (fs *FileSystem) RestoreVersion(name string, id string) error {
+	if isVersionsPath(name) || CleanPath(name) == "/" {
+		return &os.PathError{Op: "restore", Path: CleanPath(name), Err: syscall.EACCES}
+	}
+	if err := fs.checkWrite("restore", name); err != nil {
+		return err
+	}
+	version, err := fs.findVersion("restore", name, id)
+	if err != nil {
+		return err
+	}
+
+	// the version and the current file, which is kept as a version unless
+	// it is a symbolic link, count towards the quota wherever they are
+	var replaced Usage
+	if info, err := fs.backend.Lstat(version.Path); err == nil {
+		if info.IsDir() {
+			return &os.PathError{Op: "restore", Path: version.Path, Err: syscall.EISDIR}
+		}
+		if !info.Mode().IsRegular() {
+			replaced = fs.storedUsage(version.Path)
+		}
+	}
+	if err := fs.MkdirAll(path.Dir(version.Path), 0o755); err != nil {
+		return err
+	}
+	if err := fs.keepVersion(version.Path); err != nil {
+		return err
+	}
+	if err := fs.backend.Rename(versionPath(version.Path, id), version.Path); err != nil {
+		return err
+	}
+	fs.release(replaced.Bytes, replaced.Files)
+	fs.removeEmptyVersionsDirectories(version.Path)
+	return nil
+}
+
+// DeleteVersion removes the specified version of a file permanently.
+func (fs *FileSystem) DeleteVersion(name string, id string) error {
+	version, err := fs.findVersion("remove", name, id)
+	if err != nil {
+		return err
+	}
+	if err := fs.backend.Remove(versionPath(name, id)); err != nil {
+		return err
+	}
+	fs.release(version.Size, 1)
+	fs.removeEmptyVersionsDirectories(name)
+	return nil
+}
+
+// removeEmptyVersionsDirectories removes the directories keeping the
+// versions of the file of the specified path, up to the versions directory,
+// which no longer keep anything.
+func (fs *FileSystem) removeEmptyVersionsDirectories(name string) {
+	for dir := fileVersionsDirectory(name); dir != VersionsDirectory; dir = path.Dir(dir) {
+		if err := fs.backend.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// pruneVersions removes the versions of the file of the specified path, or
+// of all files if the path is empty, which are beyond the retention.
+func (fs *FileSystem) pruneVersions(name string, now time.Time) error {
+	if versioningConfig.MaxVersions <= 0 && versioningConfig.MaxAge <= 0 {
+		return nil
+	}
+	versions, err := fs.versions(name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	kept := map[string]int{}
+	for _, version := range versions {
+		kept[version.Path]++
+		expired := versioningConfig.MaxAge > 0 && now.Sub(version.CreatedAt) > versioningConfig.MaxAge
+		exceeded := versioningConfig.MaxVersions > 0 && kept[version.Path] > versioningConfig.MaxVersions
+		if !expired && !exceeded {
+			continue
+		}
+		if err := fs.backend.Remove(versionPath(version.Path, version.ID)); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		fs.release(version.Size, 1)
+		fs.removeEmptyVersionsDirectories(version.Path)
+	}
+	return errors.Join(errs...)
+}
+
+// PruneVersions removes the versions of all files which are beyond the
+// retention.
+func (fs *FileSystem) PruneVersions() error {
+	return fs.pruneVersions("", time.Now())
+}
+
+// CleanupVersions removes the versions beyond the retention of the files of
+// all users.
+func CleanupVersions(dbConn *gorm.DB, pathUsersDirectory string) error {
+	if !versioningConfig.Enabled {
+		return nil
+	}
+	var users []db.User
+	if err := dbConn.Order("username ASC").Find(&users).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, user := range users {
+		fileSystem, err := OpenUserFileSystem(dbConn, pathUsersDirectory, user.Username)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := fileSystem.PruneVersions(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// directoryFile is a directory opened for reading where the entries are the
+// ones returned by ReadDir rather than the ones stored.
+type directoryFile struct {
+	File
+	fileSystem *FileSystem
+	name       string
+	entries    []os.FileInfo
+	offset     int
+}
+
+func (f *directoryFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.entries == nil {
+		entries, err := f.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		entries = withoutTempFiles(f.fileSystem.withoutHiddenDirectories(f.name, entries))
+		f.entries = f.fileSystem.withSharedDirectory(f.name, entries)
+	}
+	entries := f.entries[min(f.offset, len(f.entries)):]
+	if count <= 0 {
+		f.offset += len(entries)
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	entries = entries[:min(count, len(entries))]
+	f.offset += len(entries)
+	return entries, nil
+}
+
+func (f *directoryFile) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		f.entries = nil
+		f.offset = 0
+	}
+	return f.File.Seek(offset, whence)
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxWait is the longest a transfer sleeps before checking the rate of the
+// limiter again so that a change of the rate applies to waiting transfers.
+const maxWait = 100 * time.Millisecond
+
+// Limiter is a token bucket limiting the bytes per second of transfers. The
+// bucket holds up to a second of transfers and a transfer larger than that
+// waits for a full bucket and leaves the bucket in debt.
+type Limiter struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of the specified bytes per second where zero
+// means unlimited.
+func NewLimiter(rate int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(rate)
+	return l
+}
+
+// Rate returns the bytes per second of the limiter where zero means
+// unlimited.
+func (l *Limiter) Rate() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate
+}
+
+// SetRate changes the bytes per second of the limiter where zero means
+// unlimited. The change applies to the transfers in progress.
+func (l *Limiter) SetRate(rate int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.rate > 0 {
+		l.refill(now)
+	} else {
+		l.tokens = float64(rate)
+	}
+	l.rate = max(rate, 0)
+	l.tokens = min(l.tokens, float64(l.rate))
+	l.last = now
+}
+
+// WaitN waits until n bytes can be transferred or the context is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for {
+		wait, ok := l.take(n)
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(min(wait, maxWait))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take takes n bytes from the bucket if the bucket has enough of them, or is
+// full for a transfer larger than the bucket, and otherwise returns how long
+// it takes to have enough of them.
+func (l *Limiter) take(n int) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rate <= 0 || n <= 0 {
+		return 0, true
+	}
+	l.refill(time.Now())
+	needed := min(float64(n), float64(l.rate))
+	if l.tokens >= needed {
+		l.tokens -= float64(n)
+		return 0, true
+	}
+	return time.Duration((needed - l.tokens) / float64(l.rate) * float64(time.Second)), false
+}
+
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last)
+	l.last = now
+	if elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed.Seconds()*float64(l.rate), float64(l.rate))
+	}
+}
@@ -0,0 +1,138 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/alexhokl/file-server/db"
+)
+
+// writeChunkSize is the largest piece of a write waiting for the limiters at
+// a time so that large writes are spread evenly.
+const writeChunkSize = 32 * 1024
+
+// Limits are the bytes per second of uploads and downloads where zero means
+// unlimited.
+type Limits struct {
+	Upload   int64
+	Download int64
+}
+
+// userLimiters are the limiters shared by all the sessions of a user.
+type userLimiters struct {
+	upload   *Limiter
+	download *Limiter
+}
+
+var (
+	serverUpload   = NewLimiter(0)
+	serverDownload = NewLimiter(0)
+
+	usersMutex sync.Mutex
+	users      = map[string]*userLimiters{}
+)
+
+// SetServerLimits sets the limits shared by all the sessions of the server.
+func SetServerLimits(limits Limits) {
+	serverUpload.SetRate(limits.Upload)
+	serverDownload.SetRate(limits.Download)
+}
+
+// UserLimits returns the limits of the user in the database.
+func UserLimits(user db.User) Limits {
+	return Limits{
+		Upload:   user.UploadRateLimit,
+		Download: user.DownloadRateLimit,
+	}
+}
+
+// SetUserLimits sets the limits shared by all the sessions of the user which
+// applies to the sessions in progress as well.
+func SetUserLimits(username string, limits Limits) {
+	limiters := getUserLimiters(username)
+	limiters.upload.SetRate(limits.Upload)
+	limiters.download.SetRate(limits.Download)
+}
+
+// RemoveUserLimits forgets the limits of the user, such as a user deleted,
+// so that a user created later with the same username starts unlimited.
+func RemoveUserLimits(username string) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	delete(users, username)
+}
+
+func getUserLimiters(username string) *userLimiters {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	limiters, ok := users[username]
+	if !ok {
+		limiters = &userLimiters{
+			upload:   NewLimiter(0),
+			download: NewLimiter(0),
+		}
+		users[username] = limiters
+	}
+	return limiters
+}
+
+// stream is the connection of a session where data read is an upload and
+// data written is a download.
+type stream struct {
+	ctx      context.Context
+	conn     io.ReadWriteCloser
+	upload   []*Limiter
+	download []*Limiter
+}
+
+// NewStream returns the connection of a session of the user with the limits
+// of the user and of the server applied.
+func NewStream(ctx context.Context, conn io.ReadWriteCloser, username string) io.ReadWriteCloser {
+	limiters := getUserLimiters(username)
+	return &stream{
+		ctx:      ctx,
+		conn:     conn,
+		upload:   []*Limiter{limiters.upload, serverUpload},
+		download: []*Limiter{limiters.download, serverDownload},
+	}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.conn.Read(p)
+	if n > 0 {
+		if waitErr := wait(s.ctx, s.upload, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(written+writeChunkSize, len(p))]
+		if err := wait(s.ctx, s.download, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := s.conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (s *stream) Close() error {
+	return s.conn.Close()
+}
+
+func wait(ctx context.Context, limiters []*Limiter, n int) error {
+	for _, limiter := range limiters {
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
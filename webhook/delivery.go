@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxAttempts is the number of attempts to deliver an event before the
+	// delivery is given up
+	MaxAttempts = 8
+
+	// initialBackoff is the delay before the second attempt where the delay
+	// doubles with every failed attempt up to maxBackoff
+	initialBackoff = 30 * time.Second
+	maxBackoff     = time.Hour
+
+	// claimDuration is how long a delivery being attempted is hidden from
+	// other servers which is longer than the timeout of a request
+	claimDuration = 2 * time.Minute
+
+	requestTimeout = 30 * time.Second
+	batchSize      = 20
+
+	// maxResponseSize is the size of the response of a webhook read before
+	// the connection is reused
+	maxResponseSize = 64 * 1024
+)
+
+// Headers of a request to a webhook.
+const (
+	HeaderEvent     = "X-FileServer-Event"
+	HeaderDelivery  = "X-FileServer-Delivery"
+	HeaderTimestamp = "X-FileServer-Timestamp"
+	HeaderSignature = "X-FileServer-Signature"
+)
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Sign returns the signature of a payload sent at the specified Unix time
+// which is the HMAC-SHA256, encoded in hex, of the time and the payload
+// separated by a dot.
+func Sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverPending attempts the deliveries which are due until none is left.
+// Deliveries are claimed with row locks so that servers sharing the database
+// do not deliver the same event at the same time.
+func DeliverPending(ctx context.Context, dbConn *gorm.DB) error {
+	for ctx.Err() == nil {
+		deliveries, err := claim(dbConn)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		for _, delivery := range deliveries {
+			if err := deliver(ctx, dbConn, delivery); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claim returns a batch of the deliveries which are due and postpones them
+// until the claim expires.
+func claim(dbConn *gorm.DB) ([]db.WebhookDelivery, error) {
+	var deliveries []db.WebhookDelivery
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", db.WebhookDeliveryPending, now).
+			Order("next_attempt_at ASC, id ASC").
+			Limit(batchSize).
+			Find(&deliveries).
+			Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&db.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(claimDuration)).
+			Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// deliver attempts a delivery and records the result. A delivery
+// interrupted by the shutdown of the server is attempted again after the
+// claim expires.
+func deliver(ctx context.Context, dbConn *gorm.DB, delivery db.WebhookDelivery) error {
+	var webhook db.Webhook
+	if err := dbConn.Where("id = ?", delivery.WebhookID).First(&webhook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// the deliveries of a webhook are removed along with it
+			return nil
+		}
+		return err
+	}
+
+	statusCode, err := send(ctx, webhook, delivery)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	attempts := delivery.Attempts + 1
+	updates := map[string]any{
+		"attempts":         attempts,
+		"last_status_code": statusCode,
+		"last_error":       "",
+	}
+	switch {
+	case err == nil:
+		updates["status"] = db.WebhookDeliveryDelivered
+		updates["delivered_at"] = now
+	case attempts >= MaxAttempts:
+		updates["status"] = db.WebhookDeliveryFailed
+		updates["last_error"] = err.Error()
+	default:
+		updates["next_attempt_at"] = now.Add(backoff(attempts))
+		updates["last_error"] = err.Error()
+	}
+	if err != nil {
+		slog.Warn(
+			"unable to deliver webhook event",
+			slog.String("error", err.Error()),
+			slog.Uint64("webhook_id", uint64(webhook.ID)),
+			slog.Uint64("delivery_id", uint64(delivery.ID)),
+			slog.Int("attempts", attempts),
+		)
+	}
+	return dbConn.Model(&db.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+}
+
+// send posts the payload of a delivery to the webhook and returns the status
+// code of the response. Any status code other than 2xx is a failure.
+func send(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "file-server-webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt after the specified
+// number of failed attempts.
+func backoff(attempts int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
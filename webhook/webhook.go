@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/file-server/db"
+	"github.com/alexhokl/file-server/storage"
+	"gorm.io/gorm"
+)
+
+// Types of the events of users.
+const (
+	// EventUserCreated is raised when a user is created
+	EventUserCreated = "user.created"
+
+	// EventCredentialAdded is raised when a public key is added to a user
+	EventCredentialAdded = "credential.added"
+)
+
+// Events are the types of events webhooks can subscribe to.
+var Events = []string{
+	storage.FileEventUploaded,
+	storage.FileEventDeleted,
+	EventUserCreated,
+	EventCredentialAdded,
+}
+
+// payload is the body of a request to a webhook.
+type payload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// FileData is the data of the events of files.
+type FileData struct {
+	Username string `json:"username"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// UserData is the data of EventUserCreated.
+type UserData struct {
+	Username   string `json:"username"`
+	AccessMode string `json:"access_mode"`
+}
+
+// CredentialData is the data of EventCredentialAdded.
+type CredentialData struct {
+	Username     string `json:"username"`
+	CredentialID uint   `json:"credential_id"`
+	PublicKey    string `json:"public_key"`
+}
+
+// SplitEvents returns the event types of a webhook.
+func SplitEvents(webhook db.Webhook) []string {
+	return strings.Split(webhook.Events, ",")
+}
+
+// JoinEvents returns the event types of a webhook to be stored.
+func JoinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+// Enqueue queues an event for delivery to the webhooks subscribing to it.
+// The event is queued in the transaction of the change, if any, so that an
+// event is delivered only if the change is committed.
+func Enqueue(dbConn *gorm.DB, event string, data any) error {
+	var webhooks []db.Webhook
+	if err := dbConn.Order("id ASC").Find(&webhooks).Error; err != nil {
+		return err
+	}
+	var deliveries []db.WebhookDelivery
+	now := time.Now().UTC()
+	for _, webhook := range webhooks {
+		if !slices.Contains(SplitEvents(webhook), event) {
+			continue
+		}
+		deliveries = append(deliveries, db.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Status:        db.WebhookDeliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload{Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		deliveries[i].Payload = body
+	}
+	return dbConn.Create(&deliveries).Error
+}
+
+// FileEventHandler returns the handler of the events of files which queues
+// the events for delivery. A failure to queue an event is logged rather than
+// failing the operation of the user.
+func FileEventHandler(dbConn *gorm.DB) func(storage.FileEvent) {
+	return func(event storage.FileEvent) {
+		data := FileData{
+			Username: event.Username,
+			Path:     event.Path,
+			Size:     event.Size,
+		}
+		if err := Enqueue(dbConn, event.Type, data); err != nil {
+			slog.Error(
+				"unable to queue webhook event",
+				slog.String("error", err.Error()),
+				slog.String("event", event.Type),
+				slog.String("user", event.Username),
+				slog.String("path", event.Path),
+			)
+		}
+	}
+}